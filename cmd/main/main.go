@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"rory-pearson/controllers"
 	"rory-pearson/environment"
 	"rory-pearson/internal/background_remover"
 	"rory-pearson/internal/board"
 	"rory-pearson/internal/spotify"
+	"rory-pearson/internal/storage"
 	"rory-pearson/pkg/features"
 	"rory-pearson/pkg/log"
 	"rory-pearson/pkg/python"
@@ -18,6 +20,7 @@ import (
 	"rory-pearson/plugins"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -135,28 +138,48 @@ func initInternal(mainLogger log.Log) {
 		return
 	}
 
+	// Storage
+	backgroundRemoverPath := environment.CreateStorageDirectory("background_remover")
+	_, err = storage.Initialize(storage.Config{
+		Log: mainLogger, // Use logger.
+		Directories: []string{ // Directories swept for expired and stale files.
+			environment.CreateStorageDirectory("image_convert_storage"),
+			filepath.Join(backgroundRemoverPath, "temp"),
+			environment.GetRootTempDirectory(),
+		},
+	})
+	if err != nil {
+		// Log any initialization errors for storage and halt execution.
+		mainLogger.Error().Err(err).Msg("Failed to initialize storage")
+		return
+	}
+
 	// Background Remover
 	_, err = background_remover.Initialize(background_remover.Config{
 		Log:         mainLogger, // Pass the logger.
-		StoragePath: environment.CreateStorageDirectory("background_remover"),
+		StoragePath: backgroundRemoverPath,
 	})
 	if err != nil {
-		// Log any initialization errors for the background remover and halt execution.
+		// The background remover needs Python, without it only its routes are unavailable.
 		mainLogger.Error().Err(err).Msg("Failed to initialize background remover")
-		return
 	}
 
 	// Spotify
-	_ = spotify.Initialize(spotify.Config{
+	sm := spotify.Initialize(spotify.Config{
 		Log: mainLogger, // Use logger.
 	})
+	if sm != nil {
+		sm.StartSessionCleanup(time.Hour)                       // Prune expired sessions from memory and disk.
+		sm.StartHistoryRecorder(spotify.DefaultHistoryInterval) // Record listening history of opted in sessions.
+	}
 }
 
 func initServer(mainLogger log.Log) {
 	// Server
 	svr, err := server.New(server.Config{
-		Port: environment.Get().ServerPort, // Dynamically set port from environment variables.
-		Log:  mainLogger,                   // Pass server logger to the server.
+		Port:           environment.Get().ServerPort,                          // Dynamically set port from environment variables.
+		Log:            mainLogger,                                            // Pass server logger to the server.
+		AllowedOrigins: server.ParseOrigins(environment.Get().AllowedOrigins), // Origins allowed to call the API with cookies.
 	})
 	if err != nil {
 		// Log any server creation errors and stop execution.
@@ -1,8 +1,13 @@
 package background_remover
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"rory-pearson/controllers/image_convert"
 	"rory-pearson/internal/background_remover"
 	"rory-pearson/pkg/server"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +26,14 @@ func Initialize(server *server.Server) {
 			return
 		}
 
+		options, err := optionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		bg := background_remover.GetInstance()
 		if bg == nil {
 			c.JSON(500, gin.H{
@@ -29,7 +42,13 @@ func Initialize(server *server.Server) {
 			return
 		}
 
-		storedFile, err := bg.Trigger(formFile)
+		storedFile, err := bg.Trigger(formFile, options)
+		if errors.Is(err, background_remover.ErrorMaxConcurrentJobs) {
+			c.JSON(429, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
 		if err != nil {
 			c.JSON(500, gin.H{
 				"error": err.Error(),
@@ -37,7 +56,188 @@ func Initialize(server *server.Server) {
 			return
 		}
 
+		// The file is the response body, so the optimization is reported in headers
+		if result := storedFile.Optimization; result != nil {
+			c.Header("X-Original-Size", strconv.FormatInt(result.OriginalSize, 10))
+			c.Header("X-Optimized-Size", strconv.FormatInt(result.OptimizedSize, 10))
+			c.Header("X-Bytes-Saved", strconv.FormatInt(result.BytesSaved, 10))
+		}
+
 		c.File(*&storedFile.FilePath)
 		storedFile.RemoveFile()
 	})
+
+	server.Engine.POST("/api/background-remover/jobs", func(c *gin.Context) {
+		formFile, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		options, err := optionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		bg := background_remover.GetInstance()
+		if bg == nil {
+			c.JSON(500, gin.H{
+				"error": "background remover not initialized",
+			})
+			return
+		}
+
+		job, err := bg.StartJob(formFile, options)
+		if errors.Is(err, background_remover.ErrorMaxConcurrentJobs) {
+			c.JSON(429, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// Return the job ID so the client can follow its progress
+		c.JSON(202, gin.H{
+			"message": "Job started",
+			"job_id":  job.ID,
+		})
+	})
+
+	server.Engine.GET("/api/background-remover/jobs/:id", func(c *gin.Context) {
+		job, ok := getJob(c)
+		if !ok {
+			return
+		}
+
+		c.JSON(200, job.Snapshot())
+	})
+
+	server.Engine.GET("/api/background-remover/jobs/:id/events", func(c *gin.Context) {
+		job, ok := getJob(c)
+		if !ok {
+			return
+		}
+
+		events, unsubscribe := job.Subscribe()
+		defer unsubscribe()
+
+		// Stream progress events until the job finishes or the client disconnects
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					final := job.Snapshot()
+					c.SSEvent(string(final.Status), final)
+					return false
+				}
+
+				c.SSEvent("progress", event)
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	})
+
+	server.Engine.GET("/api/background-remover/jobs/:id/result", func(c *gin.Context) {
+		job, ok := getJob(c)
+		if !ok {
+			return
+		}
+
+		storedFile, err := job.Result()
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if storedFile == nil {
+			c.JSON(409, gin.H{
+				"error": "job is still running",
+			})
+			return
+		}
+
+		c.File(storedFile.FilePath)
+		background_remover.GetInstance().RemoveJob(job.ID)
+	})
+}
+
+// optionsFromForm reads the optional output steps from the request form.
+// Setting watermark or optimize enables that step, configured with the image convert fields.
+func optionsFromForm(c *gin.Context) (background_remover.Options, error) {
+	var options background_remover.Options
+
+	watermark, err := enabledFromForm(c, "watermark")
+	if err != nil {
+		return options, err
+	}
+	if watermark {
+		watermarkOptions, err := image_convert.WatermarkOptionsFromForm(c)
+		if err != nil {
+			return options, err
+		}
+		options.Watermark = &watermarkOptions
+	}
+
+	optimize, err := enabledFromForm(c, "optimize")
+	if err != nil {
+		return options, err
+	}
+	if optimize {
+		optimizeOptions, err := image_convert.OptimizeOptionsFromForm(c)
+		if err != nil {
+			return options, err
+		}
+		options.Optimize = &optimizeOptions
+	}
+
+	return options, nil
+}
+
+// enabledFromForm reads an optional boolean form field that is off by default.
+func enabledFromForm(c *gin.Context, field string) (bool, error) {
+	value := c.PostForm(field)
+	if value == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s parameter: %v", field, err)
+	}
+	return enabled, nil
+}
+
+// getJob looks up the job referenced by the request, writing an error response if it cannot be found.
+func getJob(c *gin.Context) (*background_remover.Job, bool) {
+	bg := background_remover.GetInstance()
+	if bg == nil {
+		c.JSON(500, gin.H{
+			"error": "background remover not initialized",
+		})
+		return nil, false
+	}
+
+	job, err := bg.GetJob(c.Param("id"))
+	if err != nil {
+		c.JSON(404, gin.H{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+
+	return job, true
 }
@@ -1,11 +1,20 @@
 package image_convert
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
 	"os"
 	"path/filepath"
 	"rory-pearson/environment"
 	"rory-pearson/internal/image_convert"
+	"rory-pearson/internal/storage"
+	"rory-pearson/pkg/exif"
 	"rory-pearson/pkg/server"
+	"rory-pearson/pkg/util"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -23,6 +32,61 @@ func Initialize(server *server.Server) {
 			return
 		}
 
+		tokenOptions, err := tokenOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		format, err := image_convert.ParseOutputFormat(c.PostForm("format"))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		pipeline, err := image_convert.ParsePipeline(c.PostForm("operations"))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		resize, err := resizeOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		hotspot, err := hotspotFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		stream, err := streamFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if stream && format != image_convert.OutputICOZip {
+			c.JSON(400, gin.H{
+				"error": "streaming is only supported for the ico-zip format",
+			})
+			return
+		}
+
 		// Save the file to a temp location
 		tempFilePath := filepath.Join(environment.GetRootTempDirectory(), formFile.Filename)
 		err = c.SaveUploadedFile(formFile, tempFilePath)
@@ -33,8 +97,25 @@ func Initialize(server *server.Server) {
 			return
 		}
 
-		// Convert the image to icons and compress them into a zip file
-		uuid, err := image_convert.Convert(tempFilePath)
+		// Stream the icons straight to the response instead of storing them for download
+		if stream {
+			defer os.Remove(tempFilePath)
+			streamZip(c, server, "icons.zip", func(z *util.ZipWriter) error {
+				return image_convert.WriteICOZip(z, tempFilePath, image_convert.ConvertOptions{
+					Resize:   resize,
+					Pipeline: pipeline,
+				})
+			})
+			return
+		}
+
+		// Convert the image to icons in the requested format
+		uuid, err := image_convert.Convert(tempFilePath, image_convert.ConvertOptions{
+			Format:   format,
+			Resize:   resize,
+			Hotspot:  hotspot,
+			Pipeline: pipeline,
+		})
 		if err != nil {
 			c.JSON(500, gin.H{
 				"error": err.Error(),
@@ -50,35 +131,988 @@ func Initialize(server *server.Server) {
 			return
 		}
 
-		// Return a UUID for the user to download later, no URL is returned now
-		c.JSON(200, gin.H{
-			"message":     "File uploaded and converted",
-			"download_id": uuid, // UUID for download use
-		})
+		// Return a download token for the user to download later, no URL is returned now
+		respondWithDownload(c, "File uploaded and converted", uuid, tokenOptions)
 	})
 
-	server.Engine.GET("/api/image-convert/download/:id", func(c *gin.Context) {
-		id := c.Param("id")
+	server.Engine.POST("/api/image-convert/favicon-bundle", func(c *gin.Context) {
+		tokenOptions, err := tokenOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
 
-		// Get the zip file path based on the provided ID
-		zipPath, err := image_convert.GetConvertedZipPath(id) // File should not be deleted yet
+		pipeline, err := image_convert.ParsePipeline(c.PostForm("operations"))
 		if err != nil {
-			c.JSON(404, gin.H{
+			c.JSON(400, gin.H{
 				"error": err.Error(),
 			})
 			return
 		}
 
-		// Serve the file directly to the user
-		c.File(zipPath)
+		resize, err := resizeOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		stream, err := streamFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// Save the file to a temp location under a unique name
+		tempFilePath, err := saveUploadedFile(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer os.Remove(tempFilePath)
+
+		// Generate the bundle, manifest fields come from the form
+		config := image_convert.FaviconBundleConfig{
+			Name:            c.PostForm("name"),
+			ShortName:       c.PostForm("short_name"),
+			ThemeColor:      c.PostForm("theme_color"),
+			BackgroundColor: c.PostForm("background_color"),
+			BasePath:        c.PostForm("base_path"),
+			Resize:          resize,
+			Pipeline:        pipeline,
+		}
+
+		if stream {
+			streamZip(c, server, "favicon.zip", func(z *util.ZipWriter) error {
+				return image_convert.WriteFaviconBundle(z, tempFilePath, config)
+			})
+			return
+		}
+
+		uuid, err := image_convert.GenerateFaviconBundle(tempFilePath, config)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		respondWithDownload(c, "Favicon bundle generated", uuid, tokenOptions)
+	})
+
+	server.Engine.POST("/api/image-convert/convert", func(c *gin.Context) {
+		format, err := image_convert.ParseImageFormat(c.PostForm("format"))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		tokenOptions, err := tokenOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		pipeline, err := image_convert.ParsePipeline(c.PostForm("operations"))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		options, err := encodeOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		stream, err := streamFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// Save every uploaded file to a temp location
+		files, err := saveUploadedFiles(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer removeInputFiles(files)
+
+		// Stream every converted file in a zip straight to the response when requested
+		if stream {
+			streamZip(c, server, "images.zip", func(z *util.ZipWriter) error {
+				return image_convert.WriteFormatZip(z, files, format, options, pipeline)
+			})
+			return
+		}
+
+		// Convert the images, batches are compressed into a zip file
+		uuid, err := image_convert.ConvertFormat(files, format, options, pipeline)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		respondWithDownload(c, "Files uploaded and converted", uuid, tokenOptions)
+	})
+
+	server.Engine.POST("/api/image-convert/process", func(c *gin.Context) {
+		pipeline, err := image_convert.ParsePipeline(c.PostForm("operations"))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		tokenOptions, err := tokenOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if len(pipeline) == 0 {
+			c.JSON(400, gin.H{
+				"error": "operations are required",
+			})
+			return
+		}
+
+		// Processed images are returned as PNG unless another format is requested
+		format := image_convert.FormatPNG
+		if f := c.PostForm("format"); f != "" {
+			format, err = image_convert.ParseImageFormat(f)
+			if err != nil {
+				c.JSON(400, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+
+		options, err := encodeOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		stream, err := streamFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// Save every uploaded file to a temp location
+		files, err := saveUploadedFiles(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer removeInputFiles(files)
+
+		// Stream every converted file in a zip straight to the response when requested
+		if stream {
+			streamZip(c, server, "images.zip", func(z *util.ZipWriter) error {
+				return image_convert.WriteFormatZip(z, files, format, options, pipeline)
+			})
+			return
+		}
+
+		uuid, err := image_convert.ConvertFormat(files, format, options, pipeline)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		respondWithDownload(c, "Files uploaded and processed", uuid, tokenOptions)
+	})
+
+	server.Engine.POST("/api/image-convert/atlas", func(c *gin.Context) {
+		options, err := atlasOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		tokenOptions, err := tokenOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		stream, err := streamFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		files, err := saveUploadedFiles(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer removeInputFiles(files)
+
+		if stream {
+			streamZip(c, server, "atlas.zip", func(z *util.ZipWriter) error {
+				return image_convert.WriteAtlasZip(z, files, options)
+			})
+			return
+		}
 
-		// Delete the file after serving it
-		if err := image_convert.DeleteConvertedZip(id); err != nil {
+		// Pack the sprites, the atlas, frame map and stylesheet are compressed into a zip file
+		uuid, err := image_convert.GenerateAtlas(files, options)
+		if errors.Is(err, image_convert.ErrorAtlasTooSmall) {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		} else if err != nil {
 			c.JSON(500, gin.H{
 				"error": err.Error(),
 			})
 			return
 		}
+
+		respondWithDownload(c, "Sprite atlas generated", uuid, tokenOptions)
 	})
 
+	server.Engine.POST("/api/image-convert/optimize", func(c *gin.Context) {
+		options, err := OptimizeOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		tokenOptions, err := tokenOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		files, err := saveUploadedFiles(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer removeInputFiles(files)
+
+		// Optimize the images, batches are compressed into a zip file
+		uuid, results, err := image_convert.OptimizeFiles(files, options)
+		if errors.Is(err, image_convert.ErrorUnsupportedOptimize) || errors.Is(err, image_convert.ErrorTargetSizeTooSmall) {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		} else if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		var saved int64
+		for _, result := range results {
+			saved += result.BytesSaved
+		}
+
+		respondWithDownloadFields(c, "Files optimized", uuid, tokenOptions, gin.H{
+			"files":       results,
+			"bytes_saved": saved,
+		})
+	})
+
+	server.Engine.POST("/api/image-convert/watermark", func(c *gin.Context) {
+		format := image_convert.FormatPNG
+		if value := c.PostForm("format"); value != "" {
+			var err error
+			if format, err = image_convert.ParseImageFormat(value); err != nil {
+				c.JSON(400, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+
+		options, err := encodeOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		tokenOptions, err := tokenOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		watermark, err := WatermarkOptionsFromForm(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		tempFilePath, err := saveUploadedFile(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer os.Remove(tempFilePath)
+
+		uuid, err := image_convert.WatermarkFile(tempFilePath, format, options, watermark)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		respondWithDownload(c, "Watermark applied", uuid, tokenOptions)
+	})
+
+	server.Engine.POST("/api/image-convert/palette", func(c *gin.Context) {
+		count, err := intFromForm(c, "colors")
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		method, err := image_convert.ParsePaletteMethod(c.PostForm("method"))
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// The palette is returned as JSON unless a swatch image is requested
+		format := c.DefaultPostForm("format", "json")
+		if format != "json" && format != "png" {
+			c.JSON(400, gin.H{
+				"error": "format must be json or png",
+			})
+			return
+		}
+
+		width, err := intFromForm(c, "width")
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		tempFilePath, err := saveUploadedFile(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer os.Remove(tempFilePath)
+
+		palette, err := image_convert.ExtractPalette(tempFilePath, count, method)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if format == "json" {
+			c.JSON(200, gin.H{
+				"colors": palette,
+			})
+			return
+		}
+
+		swatch := new(bytes.Buffer)
+		if err := image_convert.EncodeSwatch(swatch, palette, width); err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.Data(200, "image/png", swatch.Bytes())
+	})
+
+	server.Engine.POST("/api/image-convert/placeholder", func(c *gin.Context) {
+		var options image_convert.PlaceholderOptions
+		var err error
+
+		for field, value := range map[string]*int{
+			"components_x": &options.ComponentsX,
+			"components_y": &options.ComponentsY,
+			"size":         &options.LQIPSize,
+		} {
+			if *value, err = intFromForm(c, field); err != nil {
+				c.JSON(400, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+
+		tempFilePath, err := saveUploadedFile(c)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer os.Remove(tempFilePath)
+
+		placeholder, err := image_convert.GeneratePlaceholder(tempFilePath, options)
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.JSON(200, placeholder)
+	})
+
+	server.Engine.POST("/api/image-convert/metadata", func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": "No file is received",
+			})
+			return
+		}
+
+		fileData, err := file.Open()
+		if err != nil {
+			c.JSON(500, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer fileData.Close()
+
+		// Images without EXIF data are not an error, they simply have no metadata
+		metadata, err := exif.Decode(fileData)
+		if errors.Is(err, exif.ErrorNoExif) {
+			c.JSON(200, gin.H{
+				"exif": nil,
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		response := gin.H{
+			"exif": metadata,
+		}
+		if location, ok := metadata.Location(); ok {
+			response["location"] = location
+		}
+
+		c.JSON(200, response)
+	})
+
+	server.Engine.GET("/api/image-convert/download/:id", func(c *gin.Context) {
+		st := storage.GetInstance()
+		if st == nil {
+			c.JSON(500, gin.H{
+				"error": "storage not initialized",
+			})
+			return
+		}
+
+		// Redeem the download token, counting this download
+		download, err := st.Tokens.Redeem(c.Param("id"))
+		if err != nil {
+			c.JSON(404, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// Serve the file directly to the user
+		c.File(download.Path)
+
+		// Delete the file once its last download has been served. The response
+		// has already been written at this point, so failures are only logged
+		if download.Last {
+			if err := image_convert.DeleteConvertedFile(filepath.Base(download.Path)); err != nil {
+				server.Cfg.Log.Error().Err(err).Str("path", download.Path).Msg("Failed to delete converted file")
+			}
+		}
+	})
+
+}
+
+// resizeOptionsFromForm reads the resize options from the request form.
+// Supported fields are filter, fit, background, focal_x, focal_y and sharpen.
+func resizeOptionsFromForm(c *gin.Context) (image_convert.ResizeOptions, error) {
+	var options image_convert.ResizeOptions
+	var err error
+
+	options.Filter, err = image_convert.ParseResampleFilter(c.PostForm("filter"))
+	if err != nil {
+		return options, err
+	}
+
+	options.Fit, err = image_convert.ParseFitMode(c.PostForm("fit"))
+	if err != nil {
+		return options, err
+	}
+
+	if background := c.PostForm("background"); background != "" {
+		options.Background, err = image_convert.ParseHexColor(background)
+		if err != nil {
+			return options, err
+		}
+	}
+
+	focalX, focalY := c.PostForm("focal_x"), c.PostForm("focal_y")
+	if focalX != "" || focalY != "" {
+		focus := image_convert.FocalPoint{X: 0.5, Y: 0.5}
+		if focalX != "" {
+			if focus.X, err = parseFraction("focal_x", focalX); err != nil {
+				return options, err
+			}
+		}
+		if focalY != "" {
+			if focus.Y, err = parseFraction("focal_y", focalY); err != nil {
+				return options, err
+			}
+		}
+		options.Focus = &focus
+	}
+
+	if sharpen := c.PostForm("sharpen"); sharpen != "" {
+		options.Sharpen, err = strconv.ParseBool(sharpen)
+		if err != nil {
+			return options, fmt.Errorf("invalid sharpen parameter: %v", err)
+		}
+	}
+
+	return options, nil
+}
+
+// parseFraction parses a value between 0 and 1.
+func parseFraction(name, value string) (float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("invalid %s parameter: must be between 0 and 1", name)
+	}
+	return f, nil
+}
+
+// hotspotFromForm reads the cursor hotspot_x and hotspot_y fields, in pixels of the uploaded image.
+func hotspotFromForm(c *gin.Context) (image.Point, error) {
+	var hotspot image.Point
+	var err error
+
+	if x := c.PostForm("hotspot_x"); x != "" {
+		if hotspot.X, err = strconv.Atoi(x); err != nil || hotspot.X < 0 {
+			return hotspot, errors.New("invalid hotspot_x parameter")
+		}
+	}
+
+	if y := c.PostForm("hotspot_y"); y != "" {
+		if hotspot.Y, err = strconv.Atoi(y); err != nil || hotspot.Y < 0 {
+			return hotspot, errors.New("invalid hotspot_y parameter")
+		}
+	}
+
+	return hotspot, nil
+}
+
+// encodeOptionsFromForm reads the quality and colors fields from the request form.
+func encodeOptionsFromForm(c *gin.Context) (image_convert.EncodeOptions, error) {
+	var options image_convert.EncodeOptions
+	var err error
+
+	if quality := c.PostForm("quality"); quality != "" {
+		options.Quality, err = strconv.Atoi(quality)
+		if err != nil {
+			return options, fmt.Errorf("invalid quality parameter: %v", err)
+		}
+	}
+
+	if colors := c.PostForm("colors"); colors != "" {
+		options.Colors, err = strconv.Atoi(colors)
+		if err != nil {
+			return options, fmt.Errorf("invalid colors parameter: %v", err)
+		}
+	}
+
+	// Metadata is stripped from every output unless the colour profile is explicitly kept
+	if keep := c.PostForm("keep_color_profile"); keep != "" {
+		options.KeepColorProfile, err = strconv.ParseBool(keep)
+		if err != nil {
+			return options, fmt.Errorf("invalid keep_color_profile parameter: %v", err)
+		}
+	}
+
+	return options, nil
+}
+
+// atlasOptionsFromForm reads the sprite atlas options from the request form.
+// Supported fields are packing, padding, power_of_two and max_size.
+func atlasOptionsFromForm(c *gin.Context) (image_convert.AtlasOptions, error) {
+	var options image_convert.AtlasOptions
+	var err error
+
+	if options.Packing, err = image_convert.ParseAtlasPacking(c.PostForm("packing")); err != nil {
+		return options, err
+	}
+	if options.Padding, err = intFromForm(c, "padding"); err != nil {
+		return options, err
+	}
+	if options.MaxSize, err = intFromForm(c, "max_size"); err != nil {
+		return options, err
+	}
+
+	if options.PowerOfTwo, err = boolFromForm(c, "power_of_two", false); err != nil {
+		return options, err
+	}
+
+	return options, options.Validate()
+}
+
+// OptimizeOptionsFromForm reads the image optimization options from the request form.
+// Supported fields are colors, lossless, dither (on by default), quality and target_size in bytes.
+func OptimizeOptionsFromForm(c *gin.Context) (image_convert.OptimizeOptions, error) {
+	var options image_convert.OptimizeOptions
+	var err error
+
+	if options.Colors, err = intFromForm(c, "colors"); err != nil {
+		return options, err
+	}
+	if options.Quality, err = intFromForm(c, "quality"); err != nil {
+		return options, err
+	}
+	if options.Lossless, err = boolFromForm(c, "lossless", false); err != nil {
+		return options, err
+	}
+
+	dither, err := boolFromForm(c, "dither", true)
+	if err != nil {
+		return options, err
+	}
+	options.NoDither = !dither
+
+	if targetSize := c.PostForm("target_size"); targetSize != "" {
+		if options.TargetSize, err = strconv.ParseInt(targetSize, 10, 64); err != nil {
+			return options, fmt.Errorf("invalid target_size parameter: %v", err)
+		}
+	}
+
+	return options, options.Validate()
+}
+
+// WatermarkOptionsFromForm reads the watermark options from the request form. The watermark is
+// either a logo uploaded in the logo field, scaled by scale, or text set with text, font, font_size
+// and color. It is placed with anchor, opacity, margin and tile.
+func WatermarkOptionsFromForm(c *gin.Context) (image_convert.WatermarkOptions, error) {
+	var options image_convert.WatermarkOptions
+	var err error
+
+	options.Text = c.PostForm("text")
+	options.Font = c.PostForm("font")
+	if options.Anchor, err = image_convert.ParseAnchor(c.PostForm("anchor")); err != nil {
+		return options, err
+	}
+	if options.Margin, err = intFromForm(c, "margin"); err != nil {
+		return options, err
+	}
+	if options.Tile, err = boolFromForm(c, "tile", false); err != nil {
+		return options, err
+	}
+
+	if value := c.PostForm("color"); value != "" {
+		if options.Color, err = image_convert.ParseHexColor(value); err != nil {
+			return options, err
+		}
+	}
+	if value := c.PostForm("font_size"); value != "" {
+		if options.FontSize, err = strconv.ParseFloat(value, 64); err != nil {
+			return options, fmt.Errorf("invalid font_size parameter: %v", err)
+		}
+	}
+	if value := c.PostForm("opacity"); value != "" {
+		if options.Opacity, err = parseFraction("opacity", value); err != nil {
+			return options, err
+		}
+	}
+	if value := c.PostForm("scale"); value != "" {
+		if options.Scale, err = parseFraction("scale", value); err != nil {
+			return options, err
+		}
+	}
+
+	// The logo is decoded straight away so its upload does not have to be kept around
+	if formFile, err := c.FormFile("logo"); err == nil {
+		logoPath := filepath.Join(environment.GetRootTempDirectory(), util.GenerateUUIDv4()+util.GetFileExtension(formFile.Filename))
+		if err := c.SaveUploadedFile(formFile, logoPath); err != nil {
+			return options, err
+		}
+		defer os.Remove(logoPath)
+
+		if err := options.LoadLogo(logoPath); err != nil {
+			return options, err
+		}
+	}
+
+	return options, options.Validate()
+}
+
+// streamFromForm reads the stream field, which requests the result to be streamed
+// as a zip file in the response instead of being stored for a later download.
+func streamFromForm(c *gin.Context) (bool, error) {
+	stream := c.PostForm("stream")
+	if stream == "" {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(stream)
+	if err != nil {
+		return false, fmt.Errorf("invalid stream parameter: %v", err)
+	}
+	return value, nil
+}
+
+// streamZip writes a zip file straight to the response with chunked transfer encoding.
+// Errors before anything has been sent are returned as JSON, later ones can only be logged.
+func streamZip(c *gin.Context, server *server.Server, fileName string, write func(z *util.ZipWriter) error) {
+	z := util.NewZipResponse(c.Writer, fileName)
+	if err := write(z); err != nil {
+		if c.Writer.Written() {
+			server.Cfg.Log.Error().Err(err).Msg("Failed to stream zip file")
+			return
+		}
+
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := z.Close(); err != nil {
+		server.Cfg.Log.Error().Err(err).Msg("Failed to finish streamed zip file")
+	}
+}
+
+// tokenOptionsFromForm reads the download token options from the request form.
+// Supported fields are ttl in seconds, max_downloads and one_time.
+func tokenOptionsFromForm(c *gin.Context) (storage.TokenOptions, error) {
+	var options storage.TokenOptions
+
+	if ttl := c.PostForm("ttl"); ttl != "" {
+		seconds, err := strconv.Atoi(ttl)
+		if err != nil {
+			return options, fmt.Errorf("invalid ttl parameter: %v", err)
+		}
+		options.TTL = time.Duration(seconds) * time.Second
+	}
+
+	if maxDownloads := c.PostForm("max_downloads"); maxDownloads != "" {
+		var err error
+		options.MaxDownloads, err = strconv.Atoi(maxDownloads)
+		if err != nil {
+			return options, fmt.Errorf("invalid max_downloads parameter: %v", err)
+		}
+	}
+
+	if oneTime := c.PostForm("one_time"); oneTime != "" {
+		var err error
+		options.OneTime, err = strconv.ParseBool(oneTime)
+		if err != nil {
+			return options, fmt.Errorf("invalid one_time parameter: %v", err)
+		}
+	}
+
+	return options, options.Validate()
+}
+
+// respondWithDownload issues a download token for a converted file and responds with it as the download ID.
+func respondWithDownload(c *gin.Context, message string, name string, options storage.TokenOptions) {
+	respondWithDownloadFields(c, message, name, options, nil)
+}
+
+// respondWithDownloadFields is respondWithDownload with extra fields added to the response.
+func respondWithDownloadFields(c *gin.Context, message string, name string, options storage.TokenOptions, fields gin.H) {
+	path, err := image_convert.GetConvertedFilePath(name)
+	if err != nil {
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	st := storage.GetInstance()
+	if st == nil {
+		image_convert.DeleteConvertedFile(name)
+		c.JSON(500, gin.H{
+			"error": "storage not initialized",
+		})
+		return
+	}
+
+	token, err := st.Tokens.Issue(path, options)
+	if err != nil {
+		image_convert.DeleteConvertedFile(name)
+		c.JSON(500, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"message":       message,
+		"download_id":   token.ID,
+		"expires_at":    token.ExpiresAt,
+		"max_downloads": token.MaxDownloads,
+	}
+	for key, value := range fields {
+		response[key] = value
+	}
+
+	c.JSON(200, response)
+}
+
+// saveUploadedFiles saves every file uploaded in the "files" or "file" fields to the temp directory.
+func saveUploadedFiles(c *gin.Context) ([]image_convert.InputFile, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+
+	formFiles := append(form.File["files"], form.File["file"]...)
+	if len(formFiles) == 0 {
+		return nil, errors.New("no files uploaded")
+	}
+
+	files := make([]image_convert.InputFile, 0, len(formFiles))
+	for _, formFile := range formFiles {
+		// Use a unique name so files with the same name in a batch do not collide
+		tempFilePath := filepath.Join(environment.GetRootTempDirectory(), util.GenerateUUIDv4()+util.GetFileExtension(formFile.Filename))
+		if err := c.SaveUploadedFile(formFile, tempFilePath); err != nil {
+			removeInputFiles(files)
+			return nil, err
+		}
+
+		files = append(files, image_convert.InputFile{
+			Name: formFile.Filename,
+			Path: tempFilePath,
+		})
+	}
+
+	return files, nil
+}
+
+// saveUploadedFile saves the file uploaded in the "file" field to the temp directory under a unique name.
+func saveUploadedFile(c *gin.Context) (string, error) {
+	formFile, err := c.FormFile("file")
+	if err != nil {
+		return "", errors.New("no file uploaded")
+	}
+
+	tempFilePath := filepath.Join(environment.GetRootTempDirectory(), util.GenerateUUIDv4()+util.GetFileExtension(formFile.Filename))
+	if err := c.SaveUploadedFile(formFile, tempFilePath); err != nil {
+		return "", err
+	}
+
+	return tempFilePath, nil
+}
+
+// intFromForm reads an optional integer form field, returning zero when it is not set.
+func intFromForm(c *gin.Context, field string) (int, error) {
+	value := c.PostForm(field)
+	if value == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: %v", field, err)
+	}
+	return n, nil
+}
+
+// boolFromForm reads an optional boolean form field, returning fallback when it is not set.
+func boolFromForm(c *gin.Context, field string, fallback bool) (bool, error) {
+	value := c.PostForm(field)
+	if value == "" {
+		return fallback, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s parameter: %v", field, err)
+	}
+	return b, nil
+}
+
+// removeInputFiles removes uploaded files from the temp directory.
+func removeInputFiles(files []image_convert.InputFile) {
+	for _, file := range files {
+		os.Remove(file.Path)
+	}
 }
@@ -1,10 +1,10 @@
 package spotify
 
 import (
+	"errors"
 	"net/http"
 	"rory-pearson/internal/spotify"
 	"rory-pearson/pkg/server"
-	"rory-pearson/pkg/util"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,84 +17,57 @@ func AuthRoutes(server *server.Server) {
 	}
 
 	server.Engine.GET("/api/spotify/login", func(c *gin.Context) {
-		// Generate a new UUID for the state
-		state := util.GenerateUUIDv4()
-
-		// Generate the authorization URL with the state
-		url := sm.Auth.AuthURL(state)
-
-		// Store the state in the session
-		sm.StoreSession(c.Request.Context(), state, nil) // Initial store for state, token will be added later
-
-		c.JSON(http.StatusOK, gin.H{"url": url, "state": state})
-	})
-
-	server.Engine.GET("/api/spotify/validate", func(c *gin.Context) {
-		state := c.Query("state") // Use the state parameter to identify the session
-
-		if state == "" {
-			c.JSON(403, gin.H{"error": "State is required"})
-			return
-		}
-
-		session := sm.GetSession(state)
-		if session == nil {
-			c.JSON(403, gin.H{"error": "Session not found"})
+		// Create a new session, the OAuth state in the URL is separate from the session ID
+		sessionID, url, err := sm.StartLogin(c.Request.Context())
+		if errors.Is(err, spotify.ErrorTooManyLogins) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
 			return
 		}
-
-		// Retrieve the current user profile
-		_, err := session.Client.CurrentUser(c.Request.Context())
 		if err != nil {
-			c.JSON(403, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(200, gin.H{"message": "Session is valid"})
-	})
+		// The session ID is only ever sent in the cookie
+		setSessionCookie(c, sessionID, int(spotify.SessionIdleTimeout.Seconds()))
 
-	server.Engine.GET("/api/spotify/disconnect", func(c *gin.Context) {
-		state := c.Query("state") // Use the state parameter to identify the session
+		c.JSON(http.StatusOK, gin.H{"url": url})
+	})
 
-		if state == "" {
-			c.JSON(403, gin.H{"error": "State is required"})
-			return
-		}
+	server.Engine.GET("/api/spotify/callback", func(c *gin.Context) {
+		sessionID, _ := c.Cookie(SessionCookieName)
 
-		session := sm.GetSession(state)
-		if session == nil {
-			c.JSON(403, gin.H{"error": "Session not found"})
+		// Verify the OAuth state belongs to this browser's login, then exchange the code
+		if err := sm.CompleteLogin(c.Request.Context(), sessionID, c.Request); err != nil {
+			sm.Log.Error().Err(err).Msg("failed to complete Spotify login")
+			c.JSON(403, gin.H{"error": "Couldn't get token"})
 			return
 		}
 
-		sm.DestroySession(state)
-
-		c.JSON(200, gin.H{"message": "Session disconnected"})
+		c.Redirect(http.StatusFound, "/spotify")
 	})
 
-	server.Engine.GET("/api/spotify/callback", func(c *gin.Context) {
-		state := c.Query("state")
-
-		if state == "" {
-			c.JSON(403, gin.H{"error": "State is required"})
-			return
-		}
+	authenticated := server.Engine.Group("/api/spotify", SessionMiddleware(sm))
 
-		// Verify state matches
-		session := sm.GetSession(state)
-		if session == nil {
-			c.JSON(403, gin.H{"error": "Session not found"})
-			return
-		}
+	authenticated.GET("/validate", func(c *gin.Context) {
+		session := GetSession(c)
 
-		token, err := sm.Auth.Token(c.Request.Context(), state, c.Request)
+		// Retrieve the current user profile from Spotify, a cached profile does not prove the token works
+		_, err := session.Client.CurrentUser(spotify.WithoutCache(c.Request.Context()))
 		if err != nil {
-			c.JSON(403, gin.H{"error": "Couldn't get token"})
+			respondWithSpotifyError(c, err)
 			return
 		}
 
-		sm.StoreSession(c.Request.Context(), state, token)
+		c.JSON(200, gin.H{"message": "Session is valid"})
+	})
+
+	authenticated.GET("/disconnect", func(c *gin.Context) {
+		session := GetSession(c)
 
-		c.Redirect(http.StatusFound, "/spotify")
+		sm.DestroySession(session.State)
+		clearSessionCookie(c)
+
+		c.JSON(200, gin.H{"message": "Session disconnected"})
 	})
 }
@@ -0,0 +1,87 @@
+package spotify
+
+import (
+	"errors"
+	"net/http"
+	"rory-pearson/internal/spotify"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// DuplicateRoutes registers the duplicate finder and merge routes on the authenticated Spotify group.
+func DuplicateRoutes(api *gin.RouterGroup) {
+	// Preview the duplicates without changing the playlist
+	api.GET("/playlists/:id/duplicates", func(c *gin.Context) {
+		options, ok := duplicateOptionsFromQuery(c)
+		if !ok {
+			return
+		}
+
+		report, err := spotify.FindDuplicates(c.Request.Context(), GetSession(c).Client, zSpotify.ID(c.Param("id")), options)
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	})
+
+	// Remove the duplicates, pass the snapshot_id of the preview to make sure it is still accurate
+	api.POST("/playlists/:id/duplicates/remove", func(c *gin.Context) {
+		options, ok := duplicateOptionsFromQuery(c)
+		if !ok {
+			return
+		}
+
+		report, err := spotify.RemoveDuplicates(c.Request.Context(), GetSession(c).Client, zSpotify.ID(c.Param("id")), options, c.Query("snapshot_id"))
+		if err != nil {
+			if errors.Is(err, spotify.ErrorPlaylistChanged) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "code": "playlist_changed"})
+				return
+			}
+
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	})
+
+	api.POST("/playlists/merge", func(c *gin.Context) {
+		options := spotify.MergeOptions{Dedupe: true}
+		if err := c.ShouldBindJSON(&options); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := options.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		result, err := spotify.MergePlaylists(c.Request.Context(), GetSession(c).Client, options)
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+}
+
+// duplicateOptionsFromQuery reads the likely query parameter, responding with 400 when it is invalid.
+func duplicateOptionsFromQuery(c *gin.Context) (spotify.DuplicateOptions, bool) {
+	var options spotify.DuplicateOptions
+
+	if raw := c.Query("likely"); raw != "" {
+		likely, err := strconv.ParseBool(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "likely must be true or false"})
+			return options, false
+		}
+		options.Likely = likely
+	}
+
+	return options, true
+}
@@ -0,0 +1,61 @@
+package spotify
+
+import (
+	"fmt"
+	"net/http"
+	"rory-pearson/internal/spotify"
+	"rory-pearson/pkg/util"
+
+	"github.com/gin-gonic/gin"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// ExportRoutes registers the playlist export routes on the authenticated Spotify group.
+func ExportRoutes(api *gin.RouterGroup) {
+	// Export every playlist of the user as a zip file with one file per playlist
+	api.GET("/playlists/export", func(c *gin.Context) {
+		format, err := spotify.ParseExportFormat(c.Query("format"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		z := util.NewZipResponse(c.Writer, "playlists.zip")
+		if err := spotify.WriteAllPlaylistsZip(c.Request.Context(), GetSession(c).Client, z, format); err != nil {
+			if c.Writer.Written() {
+				spotify.GetInstance().Log.Error().Err(err).Msg("Failed to stream playlist export")
+				return
+			}
+
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		if err := z.Close(); err != nil {
+			spotify.GetInstance().Log.Error().Err(err).Msg("Failed to finish playlist export")
+		}
+	})
+
+	api.GET("/playlists/:id/export", func(c *gin.Context) {
+		format, err := spotify.ParseExportFormat(c.Query("format"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Every track is fetched before anything is written, so errors can still be reported
+		export, err := spotify.ExportPlaylist(c.Request.Context(), GetSession(c).Client, zSpotify.ID(c.Param("id")))
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		c.Header("Content-Type", format.ContentType())
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.FileName(format)))
+		c.Status(http.StatusOK)
+
+		if err := export.Write(c.Writer, format); err != nil {
+			spotify.GetInstance().Log.Error().Err(err).Msg("Failed to write playlist export")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package spotify
+
+import (
+	"net/http"
+	"rory-pearson/internal/spotify"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HistoryRoutes registers the listening history and stats routes on the authenticated Spotify group.
+func HistoryRoutes(api *gin.RouterGroup, sm *spotify.SpotifyManager) {
+	api.GET("/history", func(c *gin.Context) {
+		session := GetSession(c)
+
+		user, err := session.Client.CurrentUser(c.Request.Context())
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		enabled, err := sm.History.Enabled(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		latest, err := sm.History.Latest(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		response := gin.H{"enabled": enabled}
+		if !latest.IsZero() {
+			response["last_played_at"] = latest
+		}
+		c.JSON(http.StatusOK, response)
+	})
+
+	// Opt the user in or out of recording for all their sessions, recording right away when opting in
+	api.PUT("/history", func(c *gin.Context) {
+		session := GetSession(c)
+
+		var request struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := session.Client.CurrentUser(c.Request.Context())
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		if err := sm.History.SetEnabled(user.ID, request.Enabled); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		recorded := 0
+		if request.Enabled {
+			count, err := spotify.RecordRecentlyPlayed(c.Request.Context(), session.Client, sm.History)
+			if err != nil {
+				respondWithSpotifyError(c, err)
+				return
+			}
+			recorded = count
+		}
+
+		c.JSON(http.StatusOK, gin.H{"enabled": request.Enabled, "recorded": recorded})
+	})
+
+	// Stop recording for every session of the user and forget every recorded play
+	api.DELETE("/history", func(c *gin.Context) {
+		session := GetSession(c)
+
+		user, err := session.Client.CurrentUser(c.Request.Context())
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		if err := sm.History.SetEnabled(user.ID, false); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := sm.History.Delete(user.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Listening history deleted"})
+	})
+
+	// Stats of the recorded plays, days and hours are in the tz location, UTC by default
+	api.GET("/stats", func(c *gin.Context) {
+		period, err := spotify.ParseStatsPeriod(c.Query("period"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		location, err := time.LoadLocation(c.DefaultQuery("tz", "UTC"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tz must be an IANA time zone"})
+			return
+		}
+
+		limit, ok := intFromQuery(c, "limit")
+		if !ok {
+			return
+		}
+		if limit <= 0 {
+			limit = spotify.DefaultStatsLimit
+		}
+
+		user, err := GetSession(c).Client.CurrentUser(c.Request.Context())
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		// Streaks need every play, the period is applied while computing the stats
+		plays, err := sm.History.Plays(user.ID, time.Time{})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, spotify.ComputeStats(plays, period, time.Now(), location, limit))
+	})
+}
@@ -0,0 +1,66 @@
+package spotify
+
+import (
+	"net/http"
+	"rory-pearson/internal/spotify"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SessionCookieName is the cookie holding the Spotify session ID.
+	SessionCookieName = "spotify_session"
+	// sessionCookiePath limits the cookie to the Spotify API.
+	sessionCookiePath = "/api/spotify"
+	// sessionContextKey is the gin context key the loaded session is stored under.
+	sessionContextKey = "spotify_session"
+)
+
+// SessionMiddleware loads the session named by the session cookie and aborts with 403 when there is none.
+// The `state` query parameter is still accepted as a deprecated fallback for older clients.
+func SessionMiddleware(sm *spotify.SpotifyManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sessionID, err := c.Cookie(SessionCookieName)
+		if err != nil || sessionID == "" {
+			sessionID = c.Query("state")
+			if sessionID != "" {
+				c.Header("Deprecation", "true")
+				sm.Log.Warn().Str("path", c.FullPath()).Msg("deprecated state query parameter used for Spotify session")
+			}
+		}
+
+		if sessionID == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Session is required"})
+			return
+		}
+
+		// Pending sessions have no token until the login completes
+		session := sm.GetSession(sessionID)
+		if session == nil || session.Token == nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Session not found"})
+			return
+		}
+
+		c.Set(sessionContextKey, session)
+		c.Next()
+	}
+}
+
+// GetSession returns the session loaded by SessionMiddleware.
+func GetSession(c *gin.Context) *spotify.Session {
+	return c.MustGet(sessionContextKey).(*spotify.Session)
+}
+
+// setSessionCookie stores the session ID in an HttpOnly cookie. SameSite Lax still sends it
+// when Spotify redirects back to the callback.
+func setSessionCookie(c *gin.Context, sessionID string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(SessionCookieName, sessionID, maxAge, sessionCookiePath, "", secure, true)
+}
+
+// clearSessionCookie removes the session cookie.
+func clearSessionCookie(c *gin.Context) {
+	setSessionCookie(c, "", -1)
+}
@@ -0,0 +1,65 @@
+package spotify
+
+import (
+	"fmt"
+	"rory-pearson/internal/spotify"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pageOptionsFromQuery reads the limit, offset and all query parameters, checking the limit
+// against the largest page of the endpoint.
+func pageOptionsFromQuery(c *gin.Context, maxLimit int) (spotify.PageOptions, error) {
+	var options spotify.PageOptions
+
+	for field, value := range map[string]*int{"limit": &options.Limit, "offset": &options.Offset} {
+		raw := c.Query(field)
+		if raw == "" {
+			continue
+		}
+
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return options, fmt.Errorf("%s must be a number", field)
+		}
+		*value = parsed
+	}
+
+	if raw := c.Query("all"); raw != "" {
+		all, err := strconv.ParseBool(raw)
+		if err != nil {
+			return options, fmt.Errorf("all must be true or false")
+		}
+		options.All = all
+	}
+
+	return options, options.Validate(maxLimit)
+}
+
+// streamJSONArray streams the values passed to write as a JSON array. Errors before the first value
+// are returned as a JSON error, later ones can only cut the response short.
+func streamJSONArray(c *gin.Context, each func(write func(any) error) error) {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	array := spotify.NewJSONArrayWriter(c.Writer)
+
+	err := each(func(v any) error {
+		if err := array.Write(v); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		if !array.Started() {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		c.Error(err)
+		spotify.GetInstance().Log.Error().Err(err).Msg("failed to stream Spotify results")
+		return
+	}
+
+	array.Close()
+}
@@ -0,0 +1,181 @@
+package spotify
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"rory-pearson/internal/spotify"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlayerRoutes registers the playback control routes on the authenticated Spotify group.
+// Every command targets the active device unless a device_id query parameter is given.
+func PlayerRoutes(api *gin.RouterGroup) {
+	player := api.Group("/player")
+
+	player.POST("/play", func(c *gin.Context) {
+		position, ok := intFromQuery(c, "position_ms")
+		if !ok {
+			return
+		}
+
+		request := spotify.PlayRequest{
+			DeviceID:   c.Query("device_id"),
+			ContextURI: c.Query("context_uri"),
+			PositionMs: position,
+		}
+		if uris := c.Query("uris"); uris != "" {
+			request.URIs = strings.Split(uris, ",")
+		}
+
+		// A JSON body can be used instead of query parameters
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		err := spotify.NewPlayer(GetSession(c).Client).Play(c.Request.Context(), request)
+		respondWithPlayerResult(c, "Playback started", err)
+	})
+
+	player.POST("/pause", func(c *gin.Context) {
+		err := spotify.NewPlayer(GetSession(c).Client).Pause(c.Request.Context(), c.Query("device_id"))
+		respondWithPlayerResult(c, "Playback paused", err)
+	})
+
+	player.POST("/next", func(c *gin.Context) {
+		err := spotify.NewPlayer(GetSession(c).Client).Next(c.Request.Context(), c.Query("device_id"))
+		respondWithPlayerResult(c, "Skipped to next track", err)
+	})
+
+	player.POST("/previous", func(c *gin.Context) {
+		err := spotify.NewPlayer(GetSession(c).Client).Previous(c.Request.Context(), c.Query("device_id"))
+		respondWithPlayerResult(c, "Skipped to previous track", err)
+	})
+
+	player.POST("/seek", func(c *gin.Context) {
+		position, ok := intFromQuery(c, "position_ms")
+		if !ok {
+			return
+		}
+
+		err := spotify.NewPlayer(GetSession(c).Client).Seek(c.Request.Context(), position, c.Query("device_id"))
+		respondWithPlayerResult(c, "Seeked", err)
+	})
+
+	player.POST("/volume", func(c *gin.Context) {
+		percent, ok := intFromQuery(c, "volume_percent")
+		if !ok {
+			return
+		}
+
+		err := spotify.NewPlayer(GetSession(c).Client).Volume(c.Request.Context(), percent, c.Query("device_id"))
+		respondWithPlayerResult(c, "Volume set", err)
+	})
+
+	player.POST("/shuffle", func(c *gin.Context) {
+		state, err := strconv.ParseBool(c.Query("state"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "state must be true or false"})
+			return
+		}
+
+		err = spotify.NewPlayer(GetSession(c).Client).Shuffle(c.Request.Context(), state, c.Query("device_id"))
+		respondWithPlayerResult(c, "Shuffle set", err)
+	})
+
+	player.POST("/repeat", func(c *gin.Context) {
+		mode := spotify.RepeatMode(c.Query("state"))
+
+		err := spotify.NewPlayer(GetSession(c).Client).Repeat(c.Request.Context(), mode, c.Query("device_id"))
+		respondWithPlayerResult(c, "Repeat set", err)
+	})
+
+	player.POST("/queue", func(c *gin.Context) {
+		err := spotify.NewPlayer(GetSession(c).Client).Queue(c.Request.Context(), c.Query("uri"), c.Query("device_id"))
+		respondWithPlayerResult(c, "Added to queue", err)
+	})
+
+	player.GET("/devices", func(c *gin.Context) {
+		devices, err := spotify.NewPlayer(GetSession(c).Client).Devices(c.Request.Context())
+		if err != nil {
+			respondWithSpotifyError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"devices": devices})
+	})
+
+	player.POST("/transfer", func(c *gin.Context) {
+		play := false
+		if raw := c.Query("play"); raw != "" {
+			var err error
+			if play, err = strconv.ParseBool(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "play must be true or false"})
+				return
+			}
+		}
+
+		err := spotify.NewPlayer(GetSession(c).Client).Transfer(c.Request.Context(), c.Query("device_id"), play)
+		respondWithPlayerResult(c, "Playback transferred", err)
+	})
+}
+
+// intFromQuery reads an optional integer query parameter, responding with 400 when it is not a number.
+func intFromQuery(c *gin.Context, field string) (int, bool) {
+	raw := c.Query(field)
+	if raw == "" {
+		return 0, true
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": field + " must be a number"})
+		return 0, false
+	}
+	return value, true
+}
+
+// respondWithPlayerResult responds with the message, or the error when the command failed.
+func respondWithPlayerResult(c *gin.Context, message string, err error) {
+	if err != nil {
+		respondWithSpotifyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// respondWithSpotifyError responds with the status and code of a typed Spotify error, so clients
+// can tell the user to log in again, pick a device or retry later. Other upstream failures are 502.
+func respondWithSpotifyError(c *gin.Context, err error) {
+	err = spotify.TypedError(err)
+
+	var rateLimit *spotify.RateLimitError
+	switch {
+	case errors.Is(err, spotify.ErrorInvalidPlayerRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": "invalid_request"})
+	case errors.Is(err, spotify.ErrorMissingScope):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "missing_scope"})
+	case errors.Is(err, spotify.ErrorPremiumRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "code": "premium_required"})
+	case errors.Is(err, spotify.ErrorNoActiveDevice):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "code": "no_active_device"})
+	case errors.Is(err, spotify.ErrorUnauthorized):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "code": "unauthorized"})
+	case errors.Is(err, spotify.ErrorNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "code": "not_found"})
+	case errors.As(err, &rateLimit):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(rateLimit.RetryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "code": "rate_limited"})
+	case errors.Is(err, spotify.ErrorRateLimited):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "code": "rate_limited"})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "code": "upstream_error"})
+	}
+}
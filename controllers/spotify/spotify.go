@@ -1,6 +1,7 @@
 package spotify
 
 import (
+	"io"
 	"rory-pearson/internal/spotify"
 	"rory-pearson/pkg/server"
 
@@ -19,104 +20,121 @@ func Initialize(server *server.Server) {
 		return
 	}
 
-	server.Engine.GET("/api/spotify/profile", func(c *gin.Context) {
-		state := c.Query("state") // Use the state parameter to identify the session
+	// Every route below needs a logged in session
+	api := server.Engine.Group("/api/spotify", SessionMiddleware(sm))
 
-		if state == "" {
-			c.JSON(403, gin.H{"error": "State is required"})
-			return
-		}
+	PlayerRoutes(api)
+	ExportRoutes(api)
+	DuplicateRoutes(api)
+	HistoryRoutes(api, sm)
 
-		session := sm.GetSession(state)
-		if session == nil {
-			c.JSON(403, gin.H{"error": "Session not found"})
-			return
-		}
+	api.GET("/profile", func(c *gin.Context) {
+		session := GetSession(c)
 
 		// Retrieve the current user profile
 		user, err := session.Client.CurrentUser(c.Request.Context())
 		if err != nil {
-			c.JSON(403, gin.H{"error": err.Error()})
+			respondWithSpotifyError(c, err)
 			return
 		}
 
 		c.JSON(200, user)
 	})
 
-	server.Engine.GET("/api/spotify/playlists", func(c *gin.Context) {
-		state := c.Query("state") // Use the state parameter to identify the session
+	api.GET("/playlists", func(c *gin.Context) {
+		session := GetSession(c)
 
-		if state == "" {
-			c.JSON(403, gin.H{"error": "State is required"})
+		options, err := pageOptionsFromQuery(c, spotify.MaxPlaylistsLimit)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
 
-		session := sm.GetSession(state)
-		if session == nil {
-			c.JSON(403, gin.H{"error": "Session not found"})
+		// Stream every playlist as an array when all pages are requested
+		if options.All {
+			streamJSONArray(c, func(write func(any) error) error {
+				return spotify.EachPlaylist(c.Request.Context(), session.Client, options, func(playlist zSpotify.SimplePlaylist) error {
+					return write(playlist)
+				})
+			})
 			return
 		}
 
-		// Retrieve the current user playlists
-		playlists, err := session.Client.CurrentUsersPlaylists(c.Request.Context())
+		// Retrieve a page of the current user playlists
+		playlists, err := spotify.GetPlaylists(c.Request.Context(), session.Client, options)
 		if err != nil {
-			c.JSON(403, gin.H{"error": err.Error()})
+			respondWithSpotifyError(c, err)
 			return
 		}
 
 		c.JSON(200, playlists)
 	})
 
-	server.Engine.GET("/api/spotify/tracks", func(c *gin.Context) {
-		state := c.Query("state") // Use the state parameter to identify the session
+	api.GET("/tracks", func(c *gin.Context) {
+		session := GetSession(c)
 		playlistId := c.Query("playlistId")
 
-		if state == "" {
-			c.JSON(403, gin.H{"error": "State is required"})
+		if playlistId == "" {
+			c.JSON(400, gin.H{"error": "Playlist ID is required"})
 			return
 		}
 
-		if playlistId == "" {
-			c.JSON(403, gin.H{"error": "Playlist ID is required"})
+		options, err := pageOptionsFromQuery(c, spotify.MaxPlaylistItemsLimit)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
 			return
 		}
 
-		session := sm.GetSession(state)
-		if session == nil {
-			c.JSON(403, gin.H{"error": "Session not found"})
+		// Stream every track as an array when all pages are requested
+		if options.All {
+			streamJSONArray(c, func(write func(any) error) error {
+				return spotify.EachPlaylistItem(c.Request.Context(), session.Client, zSpotify.ID(playlistId), options, func(item zSpotify.PlaylistItem) error {
+					return write(item)
+				})
+			})
 			return
 		}
 
-		tracks, err := session.Client.GetPlaylistItems(c.Request.Context(), zSpotify.ID(playlistId))
+		// Retrieve a page of the playlist tracks
+		tracks, err := spotify.GetPlaylistItems(c.Request.Context(), session.Client, zSpotify.ID(playlistId), options)
 		if err != nil {
-			c.JSON(403, gin.H{"error": err.Error()})
+			respondWithSpotifyError(c, err)
 			return
 		}
 
 		c.JSON(200, tracks)
 	})
 
-	server.Engine.GET("/api/spotify/now-playing", func(c *gin.Context) {
-		state := c.Query("state") // Use the state parameter to identify the session
-
-		if state == "" {
-			c.JSON(403, gin.H{"error": "State is required"})
-			return
-		}
-
-		session := sm.GetSession(state)
-		if session == nil {
-			c.JSON(403, gin.H{"error": "Session not found"})
-			return
-		}
+	api.GET("/now-playing", func(c *gin.Context) {
+		session := GetSession(c)
 
 		// Retrieve the current user profile
 		np, err := session.Client.PlayerCurrentlyPlaying(c.Request.Context())
 		if err != nil {
-			c.JSON(403, gin.H{"error": err.Error()})
+			respondWithSpotifyError(c, err)
 			return
 		}
 
 		c.JSON(200, np)
 	})
+
+	// Stream now playing changes, every open stream of a session shares one poller
+	api.GET("/now-playing/stream", func(c *gin.Context) {
+		events, unsubscribe := sm.SubscribeNowPlaying(GetSession(c).State)
+		defer unsubscribe()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return false
+				}
+
+				c.SSEvent(string(event.Type), event)
+				return true
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	})
 }
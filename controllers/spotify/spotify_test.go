@@ -0,0 +1,137 @@
+package spotify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"rory-pearson/database"
+	"rory-pearson/internal/spotify"
+	"rory-pearson/pkg/log"
+	"rory-pearson/pkg/server"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// TestFakeSpotifyFlow logs in through the routes against the built-in fake Spotify.
+func TestFakeSpotifyFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("SERVER_HOST", "http://localhost")
+	t.Setenv("SERVER_PORT", "0")
+	t.Setenv("UI_BUILD_PATH", t.TempDir())
+	t.Setenv("DB_URL", "unused")
+	t.Setenv("SPOTIFY_CLIENT_ID", "fake-client")
+	t.Setenv("SPOTIFY_SESSION_KEY", "") // Sessions are kept in memory without a key
+	t.Setenv("SPOTIFY_FAKE", "true")
+
+	logger := log.New(log.Config{ID: "spotify_controller_test"})
+
+	history, err := database.NewHistory(t.TempDir())
+	assert.NoError(t, err)
+
+	sm := spotify.Initialize(spotify.Config{Log: logger, History: history})
+	if !assert.NotNil(t, sm) {
+		return
+	}
+	t.Cleanup(sm.Close)
+
+	svr, err := server.New(server.Config{Log: logger})
+	assert.NoError(t, err)
+	Initialize(svr)
+
+	ts := httptest.NewServer(svr.Engine)
+	t.Cleanup(ts.Close)
+
+	jar, err := cookiejar.New(nil)
+	assert.NoError(t, err)
+	client := &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	getJSON := func(path string, target any) int {
+		response, err := client.Get(ts.URL + path)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer response.Body.Close()
+		if target != nil {
+			assert.NoError(t, json.NewDecoder(response.Body).Decode(target))
+		}
+		return response.StatusCode
+	}
+
+	// Unauthenticated requests are rejected before logging in
+	assert.Equal(t, http.StatusForbidden, getJSON("/api/spotify/profile", nil))
+
+	var login struct {
+		URL string `json:"url"`
+	}
+	assert.Equal(t, http.StatusOK, getJSON("/api/spotify/login", &login))
+
+	// The fake approves the login straight away and redirects back to the callback
+	response, err := client.Get(login.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	response.Body.Close()
+	assert.Equal(t, http.StatusFound, response.StatusCode)
+
+	callback, err := url.Parse(response.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, getJSON(callback.RequestURI(), nil))
+
+	var user zSpotify.PrivateUser
+	assert.Equal(t, http.StatusOK, getJSON("/api/spotify/profile", &user))
+	assert.Equal(t, "fake-user", user.ID)
+
+	var playlists []zSpotify.SimplePlaylist
+	assert.Equal(t, http.StatusOK, getJSON("/api/spotify/playlists?all=true", &playlists))
+	if assert.Len(t, playlists, 2) {
+		assert.Equal(t, "Focus", playlists[0].Name)
+	}
+
+	var tracks []struct {
+		Track struct {
+			Track struct {
+				ID string `json:"id"`
+			} `json:"Track"`
+		} `json:"track"`
+	}
+	assert.Equal(t, http.StatusOK, getJSON("/api/spotify/tracks?playlistId=playlist-focus&all=true", &tracks))
+	if assert.Len(t, tracks, 3) {
+		assert.Equal(t, "track-04", tracks[0].Track.Track.ID)
+	}
+
+	send := func(method string, path string, body string) int {
+		request, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		request.Header.Set("Content-Type", "application/json")
+		response, err := client.Do(request)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		response.Body.Close()
+		return response.StatusCode
+	}
+
+	// The history setting belongs to the user, so every session of the user records or stops
+	assert.Equal(t, http.StatusOK, send(http.MethodPut, "/api/spotify/history", `{"enabled":true}`))
+	enabled, err := history.Enabled("fake-user")
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+
+	var setting struct {
+		Enabled bool `json:"enabled"`
+	}
+	assert.Equal(t, http.StatusOK, getJSON("/api/spotify/history", &setting))
+	assert.True(t, setting.Enabled)
+
+	assert.Equal(t, http.StatusOK, send(http.MethodDelete, "/api/spotify/history", ""))
+	enabled, err = history.Enabled("fake-user")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+}
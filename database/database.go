@@ -1 +1,2 @@
+// Package database keeps data recorded by the server on the local disk.
 package database
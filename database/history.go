@@ -0,0 +1,234 @@
+package database
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// historyFileExtension is the extension of the files holding the plays of a user.
+	historyFileExtension = ".jsonl"
+	// enabledFileExtension is the extension of the file marking a user as opted in to recording.
+	enabledFileExtension = ".enabled"
+)
+
+// Play is a single listen of a track.
+type Play struct {
+	TrackID    string    `json:"track_id"`
+	Title      string    `json:"title"`
+	Artists    []string  `json:"artists"`
+	ArtistIDs  []string  `json:"artist_ids,omitempty"`
+	Genres     []string  `json:"genres,omitempty"` // Genres of the artists when the play was recorded
+	Album      string    `json:"album,omitempty"`
+	DurationMs int       `json:"duration_ms"`
+	PlayedAt   time.Time `json:"played_at"`
+}
+
+// History stores the plays of every user in an append only file per user, and whether the user
+// opted in to recording.
+type History struct {
+	mu     sync.Mutex
+	dir    string
+	latest map[string]time.Time // Time of the last recorded play, keyed by user
+}
+
+// NewHistory returns a history storing its files in dir, creating the directory when needed.
+func NewHistory(dir string) (*History, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &History{dir: dir, latest: make(map[string]time.Time)}, nil
+}
+
+// Record appends the plays of the user that are newer than the last recorded play, oldest first,
+// so polling overlapping windows records every play once. It returns the number of plays added.
+func (h *History) Record(user string, plays []Play) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	latest, err := h.latestPlay(user)
+	if err != nil {
+		return 0, err
+	}
+
+	sorted := make([]Play, len(plays))
+	copy(sorted, plays)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].PlayedAt.Before(sorted[j].PlayedAt) })
+
+	file, err := os.OpenFile(h.path(user), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+
+	// Start on a new line after a partial line left by a crash, so only that line is lost
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			writer.WriteByte('\n')
+		}
+	}
+
+	encoder := json.NewEncoder(writer)
+
+	added := 0
+	for _, play := range sorted {
+		if !play.PlayedAt.After(latest) {
+			continue
+		}
+		if err := encoder.Encode(play); err != nil {
+			return added, err
+		}
+
+		latest = play.PlayedAt
+		added++
+	}
+
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	h.latest[user] = latest
+	return added, nil
+}
+
+// Plays returns the plays of the user since the time, oldest first. A zero time returns every play.
+func (h *History) Plays(user string, since time.Time) ([]Play, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.read(user, since)
+}
+
+// Latest returns the time of the last recorded play of the user, or a zero time without plays.
+func (h *History) Latest(user string) (time.Time, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.latestPlay(user)
+}
+
+// Delete removes every play of the user. Deleting a user without plays is not an error.
+func (h *History) Delete(user string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.latest, user)
+	if err := os.Remove(h.path(user)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// SetEnabled turns the recording of plays for the user on or off. The setting applies to every
+// session of the user.
+func (h *History) SetEnabled(user string, enabled bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	path := h.enabledPath(user)
+	if !enabled {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	return os.WriteFile(path, nil, 0o600)
+}
+
+// Enabled reports whether the user opted in to recording.
+func (h *History) Enabled(user string) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := os.Stat(h.enabledPath(user))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// latestPlay returns the cached time of the last play, reading the file the first time.
+// The caller must hold h.mu.
+func (h *History) latestPlay(user string) (time.Time, error) {
+	if latest, ok := h.latest[user]; ok {
+		return latest, nil
+	}
+
+	plays, err := h.read(user, time.Time{})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var latest time.Time
+	if len(plays) > 0 {
+		latest = plays[len(plays)-1].PlayedAt
+	}
+
+	h.latest[user] = latest
+	return latest, nil
+}
+
+// read decodes the plays of the user since the time. The caller must hold h.mu.
+func (h *History) read(user string, since time.Time) ([]Play, error) {
+	file, err := os.Open(h.path(user))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var plays []Play
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// A write interrupted by a crash leaves a partial last line, which is skipped
+		var play Play
+		if err := json.Unmarshal(scanner.Bytes(), &play); err != nil {
+			continue
+		}
+		if play.PlayedAt.Before(since) {
+			continue
+		}
+		plays = append(plays, play)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read history: %w", err)
+	}
+
+	return plays, nil
+}
+
+// path returns the file of the user. The user ID is hashed so it is always a valid file name.
+func (h *History) path(user string) string {
+	return filepath.Join(h.dir, userFileName(user)+historyFileExtension)
+}
+
+// enabledPath returns the file marking the user as opted in.
+func (h *History) enabledPath(user string) string {
+	return filepath.Join(h.dir, userFileName(user)+enabledFileExtension)
+}
+
+// userFileName hashes the user ID into a valid file name.
+func userFileName(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return hex.EncodeToString(sum[:])
+}
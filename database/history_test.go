@@ -0,0 +1,143 @@
+package database
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPlay(id string, playedAt time.Time) Play {
+	return Play{TrackID: id, Title: "Track " + id, Artists: []string{"Artist"}, DurationMs: 180000, PlayedAt: playedAt}
+}
+
+func TestHistoryRecordsNewPlaysOnce(t *testing.T) {
+	history, err := NewHistory(t.TempDir())
+	assert.NoError(t, err)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	added, err := history.Record("user", []Play{testPlay("b", start.Add(time.Minute)), testPlay("a", start)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	// Overlapping polls only add the plays after the last recorded one
+	added, err = history.Record("user", []Play{testPlay("c", start.Add(2*time.Minute)), testPlay("b", start.Add(time.Minute))})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	plays, err := history.Plays("user", time.Time{})
+	assert.NoError(t, err)
+	if assert.Len(t, plays, 3) {
+		assert.Equal(t, []string{"a", "b", "c"}, []string{plays[0].TrackID, plays[1].TrackID, plays[2].TrackID})
+	}
+
+	latest, err := history.Latest("user")
+	assert.NoError(t, err)
+	assert.True(t, latest.Equal(start.Add(2*time.Minute)))
+
+	// Other users have their own history
+	plays, err = history.Plays("other", time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, plays)
+}
+
+func TestHistorySurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := NewHistory(dir)
+	assert.NoError(t, err)
+	_, err = first.Record("user", []Play{testPlay("a", start), testPlay("b", start.Add(time.Hour))})
+	assert.NoError(t, err)
+
+	second, err := NewHistory(dir)
+	assert.NoError(t, err)
+
+	latest, err := second.Latest("user")
+	assert.NoError(t, err)
+	assert.True(t, latest.Equal(start.Add(time.Hour)))
+
+	plays, err := second.Plays("user", start.Add(time.Minute))
+	assert.NoError(t, err)
+	if assert.Len(t, plays, 1) {
+		assert.Equal(t, "b", plays[0].TrackID)
+	}
+}
+
+func TestHistorySkipsPartialLines(t *testing.T) {
+	history, err := NewHistory(t.TempDir())
+	assert.NoError(t, err)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	_, err = history.Record("user", []Play{testPlay("a", start)})
+	assert.NoError(t, err)
+
+	// Simulate a write interrupted by a crash
+	file, err := os.OpenFile(history.path("user"), os.O_APPEND|os.O_WRONLY, 0o600)
+	assert.NoError(t, err)
+	_, err = file.WriteString(`{"track_id":"b","played`)
+	assert.NoError(t, err)
+	file.Close()
+
+	plays, err := history.Plays("user", time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, plays, 1)
+
+	// The next play is written on its own line
+	_, err = history.Record("user", []Play{testPlay("c", start.Add(time.Hour))})
+	assert.NoError(t, err)
+
+	plays, err = history.Plays("user", time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, plays, 2)
+}
+
+func TestHistoryDelete(t *testing.T) {
+	history, err := NewHistory(t.TempDir())
+	assert.NoError(t, err)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	_, err = history.Record("user", []Play{testPlay("a", start)})
+	assert.NoError(t, err)
+
+	assert.NoError(t, history.Delete("user"))
+	assert.NoError(t, history.Delete("user"))
+
+	plays, err := history.Plays("user", time.Time{})
+	assert.NoError(t, err)
+	assert.Empty(t, plays)
+
+	// Plays before the deleted ones can be recorded again
+	added, err := history.Record("user", []Play{testPlay("a", start)})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+}
+
+func TestHistoryEnabled(t *testing.T) {
+	dir := t.TempDir()
+	history, err := NewHistory(dir)
+	assert.NoError(t, err)
+
+	enabled, err := history.Enabled("user")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	assert.NoError(t, history.SetEnabled("user", true))
+
+	// The setting survives a restart and belongs to the user only
+	history, err = NewHistory(dir)
+	assert.NoError(t, err)
+	enabled, err = history.Enabled("user")
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+	enabled, err = history.Enabled("other")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+
+	assert.NoError(t, history.SetEnabled("user", false))
+	assert.NoError(t, history.SetEnabled("user", false))
+	enabled, err = history.Enabled("user")
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+}
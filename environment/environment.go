@@ -11,14 +11,19 @@ import (
 )
 
 // Environment holds the environment variables required by the application.
-// These are loaded from the .env file during initialization.
+// These are loaded from the .env file during initialization. Keys tagged
+// omitempty are optional and may be left unset.
 type Environment struct {
-	ServerHost          string `json:"SERVER_HOST"`           // Host for the server to run on
-	ServerPort          string `json:"SERVER_PORT"`           // Port for the server to run on
-	UIBuildPath         string `json:"UI_BUILD_PATH"`         // Path to the UI build files
-	DbUrl               string `json:"DB_URL"`                // Database connection URL
-	SpotifyClientId     string `json:"SPOTIFY_CLIENT_ID"`     // Spotify client ID
-	SpotifyClientSecret string `json:"SPOTIFY_CLIENT_SECRET"` // Spotify client secret
+	ServerHost          string `json:"SERVER_HOST"`                     // Host for the server to run on
+	ServerPort          string `json:"SERVER_PORT"`                     // Port for the server to run on
+	UIBuildPath         string `json:"UI_BUILD_PATH"`                   // Path to the UI build files
+	DbUrl               string `json:"DB_URL"`                          // Database connection URL
+	SpotifyClientId     string `json:"SPOTIFY_CLIENT_ID"`               // Spotify client ID
+	SpotifyClientSecret string `json:"SPOTIFY_CLIENT_SECRET,omitempty"` // Spotify client secret, not needed with PKCE
+	SpotifyAuthMode     string `json:"SPOTIFY_AUTH_MODE,omitempty"`     // "secret" or "pkce", defaults to pkce without a client secret
+	SpotifySessionKey   string `json:"SPOTIFY_SESSION_KEY,omitempty"`   // 32 byte hex or base64 key encrypting stored Spotify sessions, kept in memory when unset
+	SpotifyFake         string `json:"SPOTIFY_FAKE,omitempty"`          // "true" serves Spotify from a built-in fake with seeded data, for development
+	AllowedOrigins      string `json:"ALLOWED_ORIGINS,omitempty"`       // Comma separated origins allowed to make credentialed cross-origin requests, defaults to the UI dev server
 }
 
 // Initialize loads the environment variables from the .env file and
@@ -33,10 +38,10 @@ func Initialize() (*Environment, error) {
 	}
 
 	// Retrieve the expected environment keys (JSON tags from Environment struct)
-	keys := getExpectedKeys()
+	keys, optional := getExpectedKeys()
 
 	// Fetch environment values using the keys
-	envValues, err := getEnvValues(keys)
+	envValues, err := getEnvValues(keys, optional)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get environment values: %v", err)
 	}
@@ -71,14 +76,14 @@ type EnvValues struct {
 
 // getEnvValues retrieves the environment variable values for the given keys.
 // It returns an error if any required environment variable is not set.
-func getEnvValues(keys []string) ([]EnvValues, error) {
+func getEnvValues(keys []string, optional map[string]bool) ([]EnvValues, error) {
 	var envValues []EnvValues
 
 	for _, k := range keys {
 		v := os.Getenv(k)
 
 		// If a required environment variable is missing, return an error
-		if v == "" {
+		if v == "" && !optional[k] {
 			return nil, fmt.Errorf("environment variable %s is not set", k)
 		}
 
@@ -88,15 +93,23 @@ func getEnvValues(keys []string) ([]EnvValues, error) {
 	return envValues, nil
 }
 
-// getExpectedKeys returns a list of all expected environment variable keys.
-// It extracts these keys from the JSON tags in the Environment struct.
-func getExpectedKeys() []string {
+// getExpectedKeys returns a list of all expected environment variable keys and
+// the set of keys that are optional. It extracts these keys from the JSON tags
+// in the Environment struct.
+func getExpectedKeys() ([]string, map[string]bool) {
 	var keys []string
+	optional := make(map[string]bool)
 	val := reflect.TypeOf(Environment{})
 	for i := 0; i < val.NumField(); i++ {
-		keys = append(keys, strings.ToUpper(val.Field(i).Tag.Get("json")))
+		name, options, _ := strings.Cut(val.Field(i).Tag.Get("json"), ",")
+		key := strings.ToUpper(name)
+
+		keys = append(keys, key)
+		if options == "omitempty" {
+			optional[key] = true
+		}
 	}
-	return keys
+	return keys, optional
 }
 
 // mapToStruct converts a map of environment variable key-value pairs
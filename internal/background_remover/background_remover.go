@@ -2,12 +2,15 @@ package background_remover
 
 import (
 	"errors"
+	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"rory-pearson/internal/image_convert"
 	"rory-pearson/pkg/log"
 	"rory-pearson/pkg/python"
 	"rory-pearson/pkg/util"
+	"sync"
 )
 
 type Config struct {
@@ -21,6 +24,8 @@ type BackgroundRemover struct {
 	StoragePath string
 	Python      *python.Python
 
+	mu            sync.Mutex
+	jobs          map[string]*Job
 	JobsRunning   int
 	JobsCompleted int
 }
@@ -30,6 +35,11 @@ const (
 	MaxConcurrentJobs = 5
 )
 
+var (
+	ErrorMaxConcurrentJobs = errors.New("max concurrent jobs reached")
+	ErrorJobNotFound       = errors.New("job not found")
+)
+
 var instance *BackgroundRemover
 
 // Initialize creates and returns a singleton instance of BackgroundRemover.
@@ -40,19 +50,20 @@ func Initialize(c Config) (*BackgroundRemover, error) {
 		return instance, nil
 	}
 
+	// Get the Python instance, the singleton is only set once it is available
+	p, err := python.GetInstance()
+	if err != nil {
+		return nil, err
+	}
+
 	// Initialize the BackgroundRemover instance
 	instance = &BackgroundRemover{
 		Log:         c.Log,
 		StoragePath: c.StoragePath,
+		Python:      p,
+		jobs:        make(map[string]*Job),
 	}
 
-	// Get the Python instance
-	p, err := python.GetInstance()
-	if err != nil {
-		return nil, err
-	}
-	instance.Python = p
-
 	// Log the initialization
 	instance.Log.Info().Msg("Background remover initialized")
 
@@ -68,28 +79,76 @@ func GetInstance() *BackgroundRemover {
 type StoredFile struct {
 	FileName string
 	FilePath string
+
+	// Optimization reports the bytes saved when the output was optimized
+	Optimization *image_convert.OptimizeResult
+}
+
+// Options holds the optional steps run on the output of a background removal.
+type Options struct {
+	// Watermark is drawn over the output when set
+	Watermark *image_convert.WatermarkOptions
+	// Optimize quantizes and recompresses the output PNG when set, after the watermark
+	Optimize *image_convert.OptimizeOptions
 }
 
 // Trigger handles a background removal request. It checks for max concurrent jobs,
 // temporarily saves the uploaded file, triggers the Python background remover command,
 // and deletes the original file after processing.
-func (b *BackgroundRemover) Trigger(file *multipart.FileHeader) (*StoredFile, error) {
-	b.Log.Info().Msg("Background remover request")
-
+func (b *BackgroundRemover) Trigger(file *multipart.FileHeader, options Options) (*StoredFile, error) {
 	// Ensure the maximum number of concurrent jobs is not exceeded
-	if b.JobsRunning >= MaxConcurrentJobs {
-		return nil, errors.New("max concurrent jobs reached")
+	if err := b.reserveSlot(); err != nil {
+		return nil, err
 	}
-
-	b.JobsRunning++
+	defer b.releaseSlot()
 
 	// Temporarily save the file
 	storedFile, err := b.TemporarilySaveFile(file)
 	if err != nil {
-		b.JobsRunning--
 		return nil, err
 	}
 
+	return b.process(storedFile, options, nil)
+}
+
+// reserveSlot counts a new running job, or returns ErrorMaxConcurrentJobs when
+// MaxConcurrentJobs are already running. A reserved slot must be released with releaseSlot.
+func (b *BackgroundRemover) reserveSlot() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.JobsRunning >= MaxConcurrentJobs {
+		return ErrorMaxConcurrentJobs
+	}
+	b.JobsRunning++
+
+	return nil
+}
+
+// releaseSlot frees a slot taken by reserveSlot.
+func (b *BackgroundRemover) releaseSlot() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.JobsRunning--
+}
+
+// process runs the Python background remover command on an already stored file.
+// Output of the command is parsed into progress updates and passed to onProgress, if set.
+// The input file is removed once processing has finished. The caller must hold a slot from reserveSlot.
+func (b *BackgroundRemover) process(storedFile *StoredFile, options Options, onProgress func(python.Progress)) (*StoredFile, error) {
+	b.Log.Info().Msg("Background remover request")
+
+	// Rotate the image upright and strip its metadata before it is processed
+	normalizedPath, err := image_convert.NormalizeFile(storedFile.FilePath)
+	if err != nil {
+		storedFile.RemoveFile()
+		return nil, err
+	}
+	storedFile = &StoredFile{
+		FileName: filepath.Base(normalizedPath),
+		FilePath: normalizedPath,
+	}
+
 	// Prepare the output file path
 	modifiedFileName := "output_" + storedFile.FileName
 	modifiedFilePath := filepath.Join(b.StoragePath, "temp", modifiedFileName)
@@ -97,14 +156,27 @@ func (b *BackgroundRemover) Trigger(file *multipart.FileHeader) (*StoredFile, er
 	// Run the background remover command using Python
 	cmd, err := b.Python.Command("backgroundremover", "-i", storedFile.FilePath, "-a", "-ae", "15", "-o", modifiedFilePath)
 	if err != nil {
-		b.JobsRunning--
+		storedFile.RemoveFile()
 		return nil, err
 	}
+
+	// Parse the command output into progress updates alongside the regular logging
+	var progressWriter *python.ProgressWriter
+	if onProgress != nil {
+		progressWriter = python.NewProgressWriter(onProgress)
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, progressWriter)
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, progressWriter)
+	}
+
 	cmd.Run()
 
+	if progressWriter != nil {
+		progressWriter.Flush()
+	}
+
 	// Check if the output file was successfully created
 	if _, err := os.Stat(modifiedFilePath); os.IsNotExist(err) {
-		b.JobsRunning--
+		storedFile.RemoveFile()
 		return nil, errors.New("file not found")
 	}
 
@@ -114,15 +186,37 @@ func (b *BackgroundRemover) Trigger(file *multipart.FileHeader) (*StoredFile, er
 		return nil, err
 	}
 
-	b.JobsRunning--
+	output := &StoredFile{
+		FileName: modifiedFileName,
+		FilePath: modifiedFilePath,
+	}
+
+	if options.Watermark != nil {
+		if err := image_convert.ApplyWatermarkFile(modifiedFilePath, *options.Watermark); err != nil {
+			os.Remove(modifiedFilePath)
+			return nil, err
+		}
+	}
+
+	// An optimization failure still leaves a usable output, so it is only logged
+	if options.Optimize != nil {
+		result, err := image_convert.OptimizeFile(modifiedFilePath, *options.Optimize)
+		if err != nil {
+			b.Log.Error().Err(err).Msg("Failed to optimize background remover output")
+		} else {
+			output.Optimization = &result
+			b.Log.Info().Int64("bytes_saved", result.BytesSaved).Msg("Background remover output optimized")
+		}
+	}
+
+	b.mu.Lock()
+	b.JobsCompleted++
+	b.mu.Unlock()
 
 	b.Log.Info().Msg("Background remover request completed")
 
 	// Return the stored output file
-	return &StoredFile{
-		FileName: modifiedFileName,
-		FilePath: modifiedFilePath,
-	}, nil
+	return output, nil
 }
 
 // TemporarilySaveFile saves the uploaded file to a temporary directory.
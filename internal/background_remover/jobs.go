@@ -0,0 +1,232 @@
+package background_remover
+
+import (
+	"mime/multipart"
+	"rory-pearson/internal/image_convert"
+	"rory-pearson/pkg/python"
+	"rory-pearson/pkg/util"
+	"sync"
+	"time"
+)
+
+// JobStatus describes the lifecycle state of a background removal job.
+type JobStatus string
+
+const (
+	JobStatusRunning   JobStatus = "running"
+	JobStatusCompleted JobStatus = "completed"
+	JobStatusFailed    JobStatus = "failed"
+)
+
+const (
+	// JobRetention is how long a finished job is kept around for its result to be collected.
+	JobRetention = 10 * time.Minute
+	// jobEventBuffer is the number of progress events buffered per subscriber.
+	jobEventBuffer = 16
+)
+
+// JobEvent is a snapshot of a job's state sent to subscribers.
+type JobEvent struct {
+	ID       string          `json:"id"`
+	Status   JobStatus       `json:"status"`
+	Progress python.Progress `json:"progress"`
+	Error    string          `json:"error,omitempty"`
+
+	// Optimization reports the bytes saved once an optimized job has completed
+	Optimization *image_convert.OptimizeResult `json:"optimization,omitempty"`
+}
+
+// Job is a background removal request running asynchronously.
+type Job struct {
+	ID string
+
+	mu          sync.Mutex
+	status      JobStatus
+	progress    python.Progress
+	result      *StoredFile
+	err         error
+	finishedAt  time.Time
+	subscribers map[chan JobEvent]struct{}
+}
+
+// StartJob saves the uploaded file and starts processing it in the background.
+// It returns ErrorMaxConcurrentJobs straight away when no job slot is free.
+// The returned job can be subscribed to for progress updates.
+func (b *BackgroundRemover) StartJob(file *multipart.FileHeader, options Options) (*Job, error) {
+	// The slot is reserved before accepting so the caller is told synchronously when over capacity
+	if err := b.reserveSlot(); err != nil {
+		return nil, err
+	}
+
+	storedFile, err := b.TemporarilySaveFile(file)
+	if err != nil {
+		b.releaseSlot()
+		return nil, err
+	}
+
+	job := &Job{
+		ID:          util.GenerateUUIDv4(),
+		status:      JobStatusRunning,
+		subscribers: make(map[chan JobEvent]struct{}),
+	}
+
+	b.mu.Lock()
+	b.pruneJobs()
+	b.jobs[job.ID] = job
+	b.mu.Unlock()
+
+	go func() {
+		defer b.releaseSlot()
+
+		result, err := b.process(storedFile, options, job.setProgress)
+		job.finish(result, err)
+		if err != nil {
+			b.Log.Error().Err(err).Str("job_id", job.ID).Msg("Background remover job failed")
+		}
+	}()
+
+	return job, nil
+}
+
+// GetJob returns the job with the given ID.
+func (b *BackgroundRemover) GetJob(id string) (*Job, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	job, ok := b.jobs[id]
+	if !ok {
+		return nil, ErrorJobNotFound
+	}
+
+	return job, nil
+}
+
+// RemoveJob forgets a job, deleting its result file if one is still stored.
+func (b *BackgroundRemover) RemoveJob(id string) {
+	b.mu.Lock()
+	job, ok := b.jobs[id]
+	delete(b.jobs, id)
+	b.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.result != nil {
+		job.result.RemoveFile()
+		job.result = nil
+	}
+}
+
+// pruneJobs removes finished jobs older than JobRetention. The caller must hold b.mu.
+func (b *BackgroundRemover) pruneJobs() {
+	for id, job := range b.jobs {
+		job.mu.Lock()
+		expired := job.status != JobStatusRunning && time.Since(job.finishedAt) > JobRetention
+		if expired && job.result != nil {
+			job.result.RemoveFile()
+			job.result = nil
+		}
+		job.mu.Unlock()
+
+		if expired {
+			delete(b.jobs, id)
+		}
+	}
+}
+
+// Snapshot returns the current state of the job.
+func (j *Job) Snapshot() JobEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshot()
+}
+
+// Result returns the output file of a completed job, or the error of a failed one.
+// It returns nil and no error while the job is still running.
+func (j *Job) Result() (*StoredFile, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.result, j.err
+}
+
+// Subscribe returns a channel receiving the job's events, starting with its current state.
+// The channel is closed once the job has finished; the final state is available through Snapshot.
+// The returned function must be called to unsubscribe.
+func (j *Job) Subscribe() (<-chan JobEvent, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	events := make(chan JobEvent, jobEventBuffer)
+	events <- j.snapshot()
+
+	if j.status != JobStatusRunning {
+		close(events)
+		return events, func() {}
+	}
+
+	j.subscribers[events] = struct{}{}
+
+	return events, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		if _, ok := j.subscribers[events]; ok {
+			delete(j.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// setProgress records a progress update and broadcasts it to subscribers.
+func (j *Job) setProgress(progress python.Progress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.progress = progress
+	event := j.snapshot()
+	for events := range j.subscribers {
+		// Slow subscribers miss intermediate updates rather than blocking the job
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// finish records the outcome of the job and closes all subscriptions.
+func (j *Job) finish(result *StoredFile, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.result = result
+	j.err = err
+	j.finishedAt = time.Now()
+	if err != nil {
+		j.status = JobStatusFailed
+	} else {
+		j.status = JobStatusCompleted
+		j.progress.Percent = 100
+	}
+
+	for events := range j.subscribers {
+		delete(j.subscribers, events)
+		close(events)
+	}
+}
+
+func (j *Job) snapshot() JobEvent {
+	event := JobEvent{
+		ID:       j.ID,
+		Status:   j.status,
+		Progress: j.progress,
+	}
+	if j.err != nil {
+		event.Error = j.err.Error()
+	}
+	if j.result != nil {
+		event.Optimization = j.result.Optimization
+	}
+	return event
+}
@@ -0,0 +1,196 @@
+package background_remover
+
+import (
+	"errors"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"rory-pearson/pkg/log"
+	"rory-pearson/pkg/python"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRemover(t *testing.T) *BackgroundRemover {
+	return &BackgroundRemover{
+		Log: log.New(log.Config{
+			ID:            "background_remover_test",
+			ConsoleOutput: false,
+			FileOutput:    false,
+		}),
+		StoragePath: t.TempDir(),
+		jobs:        make(map[string]*Job),
+	}
+}
+
+func newTestJob(id string) *Job {
+	return &Job{
+		ID:          id,
+		status:      JobStatusRunning,
+		subscribers: make(map[chan JobEvent]struct{}),
+	}
+}
+
+// newResultFile creates a file standing in for a job's output.
+func newResultFile(t *testing.T, dir string) *StoredFile {
+	path := filepath.Join(dir, "output.png")
+	assert.NoError(t, os.WriteFile(path, []byte("png"), 0644))
+	return &StoredFile{FileName: "output.png", FilePath: path}
+}
+
+func TestStartJobOverCapacity(t *testing.T) {
+	b := newTestRemover(t)
+	b.JobsRunning = MaxConcurrentJobs
+
+	job, err := b.StartJob(&multipart.FileHeader{Filename: "input.png"}, Options{})
+	assert.ErrorIs(t, err, ErrorMaxConcurrentJobs)
+	assert.Nil(t, job)
+
+	// Nothing is registered or saved for a rejected job
+	assert.Empty(t, b.jobs)
+	assert.NoDirExists(t, filepath.Join(b.StoragePath, "temp"))
+	assert.Equal(t, MaxConcurrentJobs, b.JobsRunning)
+
+	_, err = b.Trigger(&multipart.FileHeader{Filename: "input.png"}, Options{})
+	assert.ErrorIs(t, err, ErrorMaxConcurrentJobs)
+}
+
+func TestStartJobReleasesSlotWhenSaveFails(t *testing.T) {
+	b := newTestRemover(t)
+
+	// The header has no content to open
+	_, err := b.StartJob(&multipart.FileHeader{Filename: "input.png"}, Options{})
+	assert.Error(t, err)
+	assert.Equal(t, 0, b.JobsRunning)
+	assert.Empty(t, b.jobs)
+}
+
+func TestJobSubscribe(t *testing.T) {
+	job := newTestJob("job")
+
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	// The current state is sent first
+	event := <-events
+	assert.Equal(t, JobStatusRunning, event.Status)
+
+	job.setProgress(python.Progress{Stage: "Processing", Percent: 40})
+	event = <-events
+	assert.Equal(t, "Processing", event.Progress.Stage)
+	assert.Equal(t, 40.0, event.Progress.Percent)
+
+	result := newResultFile(t, t.TempDir())
+	job.finish(result, nil)
+
+	// Finishing closes the subscription, the final state comes from the snapshot
+	_, ok := <-events
+	assert.False(t, ok)
+
+	snapshot := job.Snapshot()
+	assert.Equal(t, JobStatusCompleted, snapshot.Status)
+	assert.Equal(t, 100.0, snapshot.Progress.Percent)
+
+	file, err := job.Result()
+	assert.NoError(t, err)
+	assert.Equal(t, result, file)
+}
+
+func TestJobSubscribeAfterFinish(t *testing.T) {
+	job := newTestJob("job")
+	job.finish(nil, errors.New("model failed"))
+
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	event, ok := <-events
+	assert.True(t, ok)
+	assert.Equal(t, JobStatusFailed, event.Status)
+	assert.Equal(t, "model failed", event.Error)
+
+	_, ok = <-events
+	assert.False(t, ok)
+
+	file, err := job.Result()
+	assert.Nil(t, file)
+	assert.EqualError(t, err, "model failed")
+}
+
+func TestJobUnsubscribe(t *testing.T) {
+	job := newTestJob("job")
+
+	events, unsubscribe := job.Subscribe()
+	<-events
+	unsubscribe()
+
+	_, ok := <-events
+	assert.False(t, ok)
+	assert.Empty(t, job.subscribers)
+
+	// Unsubscribing twice or finishing afterwards does not close the channel again
+	unsubscribe()
+	job.finish(nil, nil)
+}
+
+func TestJobSlowSubscriberDoesNotBlock(t *testing.T) {
+	job := newTestJob("job")
+
+	events, unsubscribe := job.Subscribe()
+	defer unsubscribe()
+
+	for i := 0; i < jobEventBuffer*2; i++ {
+		job.setProgress(python.Progress{Percent: float64(i)})
+	}
+	assert.Len(t, events, jobEventBuffer)
+}
+
+func TestPruneJobs(t *testing.T) {
+	b := newTestRemover(t)
+
+	running := newTestJob("running")
+
+	recent := newTestJob("recent")
+	recent.finish(nil, nil)
+
+	expired := newTestJob("expired")
+	result := newResultFile(t, b.StoragePath)
+	expired.finish(result, nil)
+	expired.finishedAt = time.Now().Add(-JobRetention - time.Minute)
+
+	for _, job := range []*Job{running, recent, expired} {
+		b.jobs[job.ID] = job
+	}
+
+	b.mu.Lock()
+	b.pruneJobs()
+	b.mu.Unlock()
+
+	for _, id := range []string{"running", "recent"} {
+		_, err := b.GetJob(id)
+		assert.NoError(t, err)
+	}
+
+	_, err := b.GetJob("expired")
+	assert.ErrorIs(t, err, ErrorJobNotFound)
+	assert.NoFileExists(t, result.FilePath)
+}
+
+func TestRemoveJob(t *testing.T) {
+	b := newTestRemover(t)
+
+	job := newTestJob("job")
+	result := newResultFile(t, b.StoragePath)
+	job.finish(result, nil)
+	b.jobs[job.ID] = job
+
+	b.RemoveJob(job.ID)
+
+	_, err := b.GetJob(job.ID)
+	assert.ErrorIs(t, err, ErrorJobNotFound)
+	assert.NoFileExists(t, result.FilePath)
+
+	// Removing an unknown job is a no-op
+	b.RemoveJob("missing")
+}
@@ -0,0 +1,465 @@
+package image_convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"math/bits"
+	"regexp"
+	"rory-pearson/pkg/util"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// AtlasPacking is the bin packing algorithm used to place sprites in an atlas.
+type AtlasPacking string
+
+const (
+	// PackingMaxRects tracks every free rectangle and places each sprite where it fits tightest.
+	// It produces the smallest atlases.
+	PackingMaxRects AtlasPacking = "maxrects"
+	// PackingShelf places sprites in rows sorted by height. It is faster but wastes more space.
+	PackingShelf AtlasPacking = "shelf"
+)
+
+const (
+	// DefaultAtlasSize is the largest atlas side used when no maximum is requested.
+	DefaultAtlasSize = 4096
+	// MaxAtlasSize is the largest atlas side that can be requested.
+	MaxAtlasSize = 8192
+	// MaxAtlasPadding is the largest padding that can be requested.
+	MaxAtlasPadding = 64
+	// atlasName is the name of the files in an atlas zip.
+	atlasName = "atlas"
+)
+
+var ErrorAtlasTooSmall = errors.New("sprites do not fit in the maximum atlas size")
+
+// cssClassPattern matches characters that can not be used in a CSS class name.
+var cssClassPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// AtlasOptions controls how sprites are packed.
+type AtlasOptions struct {
+	Packing    AtlasPacking
+	Padding    int  // Pixels between sprites and around the edge of the atlas
+	PowerOfTwo bool // Round the atlas dimensions up to powers of two
+	MaxSize    int  // Largest atlas width or height
+}
+
+// AtlasFrame is the location of a sprite in the atlas.
+type AtlasFrame struct {
+	Name  string // File name of the sprite, unique within the atlas
+	Frame image.Rectangle
+}
+
+// Atlas is a packed sprite sheet.
+type Atlas struct {
+	Image  *image.NRGBA
+	Frames []AtlasFrame
+}
+
+// ParseAtlasPacking validates a packing algorithm name. An empty name is maxrects.
+func ParseAtlasPacking(packing string) (AtlasPacking, error) {
+	switch AtlasPacking(strings.ToLower(packing)) {
+	case "", PackingMaxRects:
+		return PackingMaxRects, nil
+	case PackingShelf:
+		return PackingShelf, nil
+	default:
+		return "", fmt.Errorf("unsupported packing algorithm: %s", packing)
+	}
+}
+
+// Validate checks the options and fills in defaults.
+func (o *AtlasOptions) Validate() error {
+	if o.Packing == "" {
+		o.Packing = PackingMaxRects
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultAtlasSize
+	}
+	if o.MaxSize < 1 || o.MaxSize > MaxAtlasSize {
+		return fmt.Errorf("maximum atlas size must be between 1 and %d", MaxAtlasSize)
+	}
+	if o.Padding < 0 || o.Padding > MaxAtlasPadding {
+		return fmt.Errorf("atlas padding must be between 0 and %d", MaxAtlasPadding)
+	}
+	return nil
+}
+
+// GenerateAtlas packs the images into an atlas and stores it in a zip file,
+// returning the name of the zip.
+func GenerateAtlas(files []InputFile, options AtlasOptions) (string, error) {
+	return storeZip(func(z *util.ZipWriter) error {
+		return WriteAtlasZip(z, files, options)
+	})
+}
+
+// WriteAtlasZip packs the images into an atlas and writes the atlas PNG,
+// a TexturePacker JSON frame map and a CSS sprite stylesheet into the zip.
+func WriteAtlasZip(z *util.ZipWriter, files []InputFile, options AtlasOptions) error {
+	atlas, err := PackAtlas(files, options)
+	if err != nil {
+		return err
+	}
+
+	imageName := atlasName + FormatPNG.Extension()
+	if err := z.WriteFile(imageName, func(w io.Writer) error { return png.Encode(w, atlas.Image) }); err != nil {
+		return err
+	}
+
+	if err := z.WriteFile(atlasName+".json", func(w io.Writer) error { return atlas.WriteJSON(w, imageName) }); err != nil {
+		return err
+	}
+
+	return z.WriteFile(atlasName+".css", func(w io.Writer) error { return atlas.WriteCSS(w, imageName) })
+}
+
+// PackAtlas decodes the images and packs them into a single atlas.
+func PackAtlas(files []InputFile, options AtlasOptions) (*Atlas, error) {
+	if len(files) == 0 {
+		return nil, ErrorNoImages
+	}
+
+	images := make([]image.Image, len(files))
+	for i, file := range files {
+		img, err := loadImage(file.Path, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		images[i] = img
+	}
+
+	names := make([]string, len(files))
+	used := make(map[string]int)
+	for i, file := range files {
+		names[i] = outputName(file.Name, FormatPNG, used)
+	}
+
+	return Pack(images, names, options)
+}
+
+// Pack places the images in an atlas. Frames keep the order of the images.
+func Pack(images []image.Image, names []string, options AtlasOptions) (*Atlas, error) {
+	if len(images) == 0 {
+		return nil, ErrorNoImages
+	}
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Every sprite reserves its padding on the right and bottom, the left and top edges are
+	// padded by shifting the whole layout
+	sizes := make([]image.Point, len(images))
+	for i, img := range images {
+		sizes[i] = img.Bounds().Size().Add(image.Pt(options.Padding, options.Padding))
+	}
+
+	positions, size, err := packSizes(sizes, options)
+	if err != nil {
+		return nil, err
+	}
+
+	atlas := &Atlas{
+		Image:  image.NewNRGBA(image.Rectangle{Max: size}),
+		Frames: make([]AtlasFrame, len(images)),
+	}
+	for i, img := range images {
+		origin := positions[i].Add(image.Pt(options.Padding, options.Padding))
+		frame := image.Rectangle{Min: origin, Max: origin.Add(img.Bounds().Size())}
+		draw.Draw(atlas.Image, frame, img, img.Bounds().Min, draw.Src)
+		atlas.Frames[i] = AtlasFrame{Name: names[i], Frame: frame}
+	}
+
+	return atlas, nil
+}
+
+// packSizes tries several atlas widths and keeps the layout with the smallest area.
+// It returns the position of every size and the dimensions of the atlas.
+func packSizes(sizes []image.Point, options AtlasOptions) ([]image.Point, image.Point, error) {
+	// Larger sprites first, both algorithms fill the gaps with the smaller ones
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := sizes[order[i]], sizes[order[j]]
+		if options.Packing == PackingShelf {
+			return a.Y > b.Y
+		}
+		return max(a.X, a.Y) > max(b.X, b.Y)
+	})
+
+	// The layout area excludes the top and left padding
+	limit := options.MaxSize - options.Padding
+	widest, area := 0, 0
+	for _, size := range sizes {
+		widest = max(widest, size.X)
+		area += size.X * size.Y
+	}
+	if widest > limit {
+		return nil, image.Point{}, ErrorAtlasTooSmall
+	}
+
+	var best []image.Point
+	var bestSize image.Point
+	for _, width := range atlasWidths(widest, area, limit, options) {
+		positions, ok := make([]image.Point, len(sizes)), false
+		if options.Packing == PackingShelf {
+			ok = packShelf(sizes, order, positions, width, limit)
+		} else {
+			ok = packMaxRects(sizes, order, positions, width, limit)
+		}
+		if !ok {
+			continue
+		}
+
+		var used image.Point
+		for i, position := range positions {
+			used.X = max(used.X, position.X+sizes[i].X)
+			used.Y = max(used.Y, position.Y+sizes[i].Y)
+		}
+		used = used.Add(image.Pt(options.Padding, options.Padding))
+		if options.PowerOfTwo {
+			used = image.Pt(nextPowerOfTwo(used.X), nextPowerOfTwo(used.Y))
+		}
+		if used.X > options.MaxSize || used.Y > options.MaxSize {
+			continue
+		}
+
+		if best == nil || used.X*used.Y < bestSize.X*bestSize.Y ||
+			(used.X*used.Y == bestSize.X*bestSize.Y && max(used.X, used.Y) < max(bestSize.X, bestSize.Y)) {
+			best, bestSize = positions, used
+		}
+	}
+
+	if best == nil {
+		return nil, image.Point{}, ErrorAtlasTooSmall
+	}
+	return best, bestSize, nil
+}
+
+// atlasWidths returns the layout widths worth trying, starting around a square atlas.
+func atlasWidths(widest, area, limit int, options AtlasOptions) []int {
+	square := int(math.Ceil(math.Sqrt(float64(area))))
+
+	var widths []int
+	if options.PowerOfTwo {
+		for width := nextPowerOfTwo(widest + options.Padding); width <= options.MaxSize; width *= 2 {
+			widths = append(widths, width-options.Padding)
+		}
+	} else {
+		for _, scale := range []float64{1, 1.25, 1.5, 2, 3} {
+			widths = append(widths, int(float64(square)*scale))
+		}
+	}
+	widths = append(widths, limit)
+
+	valid := widths[:0]
+	for _, width := range widths {
+		if width >= widest && width <= limit {
+			valid = append(valid, width)
+		}
+	}
+	return valid
+}
+
+// packShelf places the sizes left to right in rows, starting a new row when one is full.
+func packShelf(sizes []image.Point, order []int, positions []image.Point, width, height int) bool {
+	x, y, shelfHeight := 0, 0, 0
+	for _, i := range order {
+		size := sizes[i]
+		if x+size.X > width {
+			x, y, shelfHeight = 0, y+shelfHeight, 0
+		}
+		if y+size.Y > height {
+			return false
+		}
+
+		positions[i] = image.Pt(x, y)
+		x += size.X
+		shelfHeight = max(shelfHeight, size.Y)
+	}
+	return true
+}
+
+// packMaxRects places the sizes with the maximal rectangles algorithm, using the
+// best short side fit heuristic.
+func packMaxRects(sizes []image.Point, order []int, positions []image.Point, width, height int) bool {
+	free := []image.Rectangle{image.Rect(0, 0, width, height)}
+
+	for _, i := range order {
+		size := sizes[i]
+
+		found := false
+		var placed image.Rectangle
+		bestShort, bestLong := math.MaxInt, math.MaxInt
+		for _, rect := range free {
+			if rect.Dx() < size.X || rect.Dy() < size.Y {
+				continue
+			}
+
+			leftX, leftY := rect.Dx()-size.X, rect.Dy()-size.Y
+			short, long := min(leftX, leftY), max(leftX, leftY)
+			if short < bestShort || (short == bestShort && long < bestLong) {
+				placed = image.Rectangle{Min: rect.Min, Max: rect.Min.Add(size)}
+				bestShort, bestLong, found = short, long, true
+			}
+		}
+		if !found {
+			return false
+		}
+
+		positions[i] = placed.Min
+		free = splitFreeRects(free, placed)
+	}
+	return true
+}
+
+// splitFreeRects removes the placed rectangle from the free rectangles, replacing every
+// overlapped free rectangle with the maximal rectangles around it.
+func splitFreeRects(free []image.Rectangle, placed image.Rectangle) []image.Rectangle {
+	next := make([]image.Rectangle, 0, len(free)+4)
+	for _, rect := range free {
+		if !rect.Overlaps(placed) {
+			next = append(next, rect)
+			continue
+		}
+
+		if placed.Min.X > rect.Min.X {
+			next = append(next, image.Rect(rect.Min.X, rect.Min.Y, placed.Min.X, rect.Max.Y))
+		}
+		if placed.Max.X < rect.Max.X {
+			next = append(next, image.Rect(placed.Max.X, rect.Min.Y, rect.Max.X, rect.Max.Y))
+		}
+		if placed.Min.Y > rect.Min.Y {
+			next = append(next, image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, placed.Min.Y))
+		}
+		if placed.Max.Y < rect.Max.Y {
+			next = append(next, image.Rect(rect.Min.X, placed.Max.Y, rect.Max.X, rect.Max.Y))
+		}
+	}
+
+	// Drop rectangles contained in another, they can never give a better fit
+	pruned := next[:0]
+	for i, rect := range next {
+		contained := false
+		for j, other := range next {
+			if i != j && rect.In(other) && (rect != other || i > j) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			pruned = append(pruned, rect)
+		}
+	}
+	return pruned
+}
+
+// nextPowerOfTwo rounds n up to a power of two.
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
+
+// texturePackerRect is a rectangle in the TexturePacker JSON format.
+type texturePackerRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// texturePackerSize is a size in the TexturePacker JSON format.
+type texturePackerSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type texturePackerFrame struct {
+	Frame            texturePackerRect `json:"frame"`
+	Rotated          bool              `json:"rotated"`
+	Trimmed          bool              `json:"trimmed"`
+	SpriteSourceSize texturePackerRect `json:"spriteSourceSize"`
+	SourceSize       texturePackerSize `json:"sourceSize"`
+}
+
+type texturePackerMeta struct {
+	App     string            `json:"app"`
+	Version string            `json:"version"`
+	Image   string            `json:"image"`
+	Format  string            `json:"format"`
+	Size    texturePackerSize `json:"size"`
+	Scale   string            `json:"scale"`
+}
+
+// WriteJSON writes the frame map in the TexturePacker JSON (hash) format, which most game
+// engines can load. imageName is the file name of the atlas image.
+func (a *Atlas) WriteJSON(w io.Writer, imageName string) error {
+	frames := make(map[string]texturePackerFrame, len(a.Frames))
+	for _, frame := range a.Frames {
+		size := frame.Frame.Size()
+		frames[frame.Name] = texturePackerFrame{
+			Frame:            texturePackerRect{X: frame.Frame.Min.X, Y: frame.Frame.Min.Y, W: size.X, H: size.Y},
+			SpriteSourceSize: texturePackerRect{W: size.X, H: size.Y},
+			SourceSize:       texturePackerSize{W: size.X, H: size.Y},
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Frames map[string]texturePackerFrame `json:"frames"`
+		Meta   texturePackerMeta             `json:"meta"`
+	}{
+		Frames: frames,
+		Meta: texturePackerMeta{
+			App:     "rory-pearson",
+			Version: "1.0",
+			Image:   imageName,
+			Format:  "RGBA8888",
+			Size:    texturePackerSize{W: a.Image.Bounds().Dx(), H: a.Image.Bounds().Dy()},
+			Scale:   "1",
+		},
+	})
+}
+
+// WriteCSS writes a stylesheet with a "sprite" class using the atlas as background and
+// a "sprite-<name>" class for every frame. imageName is the file name of the atlas image.
+func (a *Atlas) WriteCSS(w io.Writer, imageName string) error {
+	css := new(strings.Builder)
+	fmt.Fprintf(css, ".sprite {\n  display: inline-block;\n  background-image: url(%q);\n  background-repeat: no-repeat;\n}\n", imageName)
+
+	used := make(map[string]int)
+	for _, frame := range a.Frames {
+		size := frame.Frame.Size()
+		fmt.Fprintf(css, "\n.sprite-%s {\n  width: %dpx;\n  height: %dpx;\n  background-position: %dpx %dpx;\n}\n",
+			cssClassName(frame.Name, used), size.X, size.Y, -frame.Frame.Min.X, -frame.Frame.Min.Y)
+	}
+
+	_, err := io.WriteString(w, css.String())
+	return err
+}
+
+// cssClassName turns a sprite file name into a unique CSS class name suffix.
+func cssClassName(name string, used map[string]int) string {
+	class := strings.Trim(cssClassPattern.ReplaceAllString(strings.TrimSuffix(name, FormatPNG.Extension()), "-"), "-")
+	if class == "" {
+		class = "image"
+	}
+
+	used[class]++
+	if used[class] > 1 {
+		class = fmt.Sprintf("%s-%d", class, used[class]-1)
+	}
+	return class
+}
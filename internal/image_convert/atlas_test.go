@@ -0,0 +1,156 @@
+package image_convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"rory-pearson/pkg/util"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spriteImages returns sprites of mixed sizes and their names.
+func spriteImages() ([]image.Image, []string) {
+	sizes := []image.Point{{64, 64}, {32, 48}, {100, 20}, {16, 16}, {16, 16}, {40, 70}, {8, 90}}
+
+	images := make([]image.Image, len(sizes))
+	names := make([]string, len(sizes))
+	for i, size := range sizes {
+		images[i] = testImage(size.X, size.Y)
+		names[i] = fmt.Sprintf("sprite_%d.png", i)
+	}
+	return images, names
+}
+
+// assertValidAtlas checks that every frame fits in the atlas, keeps its size and is separated by the padding.
+func assertValidAtlas(t *testing.T, atlas *Atlas, images []image.Image, padding int) {
+	t.Helper()
+
+	bounds := atlas.Image.Bounds().Inset(padding)
+	for i, frame := range atlas.Frames {
+		assert.Equal(t, images[i].Bounds().Size(), frame.Frame.Size(), frame.Name)
+		assert.True(t, frame.Frame.In(bounds), "%s should be inside the padded atlas", frame.Name)
+
+		for j, other := range atlas.Frames[i+1:] {
+			padded := other.Frame.Inset(-padding)
+			assert.False(t, frame.Frame.Overlaps(padded), "%s and %s should not overlap", frame.Name, atlas.Frames[i+1+j].Name)
+		}
+	}
+}
+
+func TestPack(t *testing.T) {
+	images, names := spriteImages()
+
+	for _, packing := range []AtlasPacking{PackingMaxRects, PackingShelf} {
+		for _, padding := range []int{0, 2} {
+			atlas, err := Pack(images, names, AtlasOptions{Packing: packing, Padding: padding})
+			if !assert.NoError(t, err, packing) {
+				continue
+			}
+			assertValidAtlas(t, atlas, images, padding)
+
+			// The sprite pixels are copied into their frames
+			frame := atlas.Frames[0].Frame
+			assert.Equal(t, images[0].At(10, 5), atlas.Image.At(frame.Min.X+10, frame.Min.Y+5))
+		}
+	}
+}
+
+func TestPackPowerOfTwo(t *testing.T) {
+	images, names := spriteImages()
+
+	atlas, err := Pack(images, names, AtlasOptions{PowerOfTwo: true, Padding: 1})
+	assert.NoError(t, err)
+	assertValidAtlas(t, atlas, images, 1)
+
+	size := atlas.Image.Bounds().Size()
+	assert.Equal(t, nextPowerOfTwo(size.X), size.X)
+	assert.Equal(t, nextPowerOfTwo(size.Y), size.Y)
+}
+
+func TestPackTooSmall(t *testing.T) {
+	images, names := spriteImages()
+
+	_, err := Pack(images, names, AtlasOptions{MaxSize: 64})
+	assert.ErrorIs(t, err, ErrorAtlasTooSmall)
+
+	_, err = Pack(images, names, AtlasOptions{MaxSize: 128, Padding: 10})
+	assert.ErrorIs(t, err, ErrorAtlasTooSmall, "padding needs space too")
+
+	_, err = Pack(images, names, AtlasOptions{Padding: MaxAtlasPadding + 1})
+	assert.Error(t, err)
+}
+
+func TestNextPowerOfTwo(t *testing.T) {
+	for n, expected := range map[int]int{0: 1, 1: 1, 2: 2, 3: 4, 64: 64, 65: 128} {
+		assert.Equal(t, expected, nextPowerOfTwo(n), n)
+	}
+}
+
+func TestWriteAtlasZip(t *testing.T) {
+	dir := t.TempDir()
+
+	var files []InputFile
+	for i, name := range []string{"player.png", "enemy boss.png", "player.png"} {
+		path := filepath.Join(dir, fmt.Sprintf("%d.png", i))
+		file, err := os.Create(path)
+		assert.NoError(t, err)
+		assert.NoError(t, encodePNGFile(file, testImage(10+i, 20)))
+		files = append(files, InputFile{Name: name, Path: path})
+	}
+
+	buf := new(bytes.Buffer)
+	z := util.NewZipWriter(buf)
+	assert.NoError(t, WriteAtlasZip(z, files, AtlasOptions{}))
+	assert.NoError(t, z.Close())
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	contents := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		contents[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+
+	atlas, _, err := image.DecodeConfig(bytes.NewReader(contents["atlas.png"]))
+	assert.NoError(t, err)
+
+	var frameMap struct {
+		Frames map[string]struct {
+			Frame      struct{ X, Y, W, H int }
+			SourceSize struct{ W, H int }
+		}
+		Meta struct {
+			Image string
+			Size  struct{ W, H int }
+		}
+	}
+	assert.NoError(t, json.Unmarshal(contents["atlas.json"], &frameMap))
+	assert.Equal(t, "atlas.png", frameMap.Meta.Image)
+	assert.Equal(t, atlas.Width, frameMap.Meta.Size.W)
+	assert.Equal(t, atlas.Height, frameMap.Meta.Size.H)
+
+	// Duplicate names are made unique
+	assert.Len(t, frameMap.Frames, 3)
+	assert.Equal(t, 12, frameMap.Frames["player_1.png"].Frame.W)
+	assert.Equal(t, 20, frameMap.Frames["enemy boss.png"].SourceSize.H)
+
+	css := string(contents["atlas.css"])
+	assert.Contains(t, css, `background-image: url("atlas.png");`)
+	assert.Contains(t, css, ".sprite-enemy-boss {")
+	assert.Contains(t, css, ".sprite-player_1 {")
+
+	boss := frameMap.Frames["enemy boss.png"].Frame
+	assert.Contains(t, css, fmt.Sprintf("background-position: %dpx %dpx;", -boss.X, -boss.Y))
+	assert.Equal(t, 3, strings.Count(css, "width:"))
+}
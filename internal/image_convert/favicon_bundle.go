@@ -0,0 +1,347 @@
+package image_convert
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"rory-pearson/pkg/util"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/draw"
+)
+
+// MaskableSafeZone is the fraction of a maskable icon that is guaranteed to stay visible.
+// The source image is scaled to fit inside it and the rest is filled with the background colour.
+const MaskableSafeZone = 0.8
+
+// FaviconBundleConfig holds the options used to generate a favicon bundle.
+type FaviconBundleConfig struct {
+	Name            string // Application name used in the manifest and tile config
+	ShortName       string // Short application name, defaults to Name
+	ThemeColor      string // Theme colour as a hex string, e.g. "#ffffff"
+	BackgroundColor string // Background colour as a hex string, defaults to ThemeColor
+	BasePath        string // URL path or URL the icons will be served from, defaults to "/"
+	Resize          ResizeOptions
+	Pipeline        Pipeline // Applied to the image before any icon is rendered
+}
+
+// faviconPNG describes a PNG icon in the bundle.
+type faviconPNG struct {
+	Name     string
+	Width    int
+	Height   int
+	Maskable bool // Pad the icon into the maskable safe zone
+	Opaque   bool // Fill transparent areas with the background colour
+}
+
+var faviconICOSizes = []int{16, 32, 48}
+
+var faviconPNGs = []faviconPNG{
+	{Name: "favicon-16x16.png", Width: 16, Height: 16},
+	{Name: "favicon-32x32.png", Width: 32, Height: 32},
+	{Name: "apple-touch-icon.png", Width: 180, Height: 180, Opaque: true},
+	{Name: "android-chrome-192x192.png", Width: 192, Height: 192},
+	{Name: "android-chrome-512x512.png", Width: 512, Height: 512},
+	{Name: "maskable-icon-192x192.png", Width: 192, Height: 192, Maskable: true},
+	{Name: "maskable-icon-512x512.png", Width: 512, Height: 512, Maskable: true},
+	{Name: "mstile-70x70.png", Width: 70, Height: 70},
+	{Name: "mstile-150x150.png", Width: 150, Height: 150},
+	{Name: "mstile-310x150.png", Width: 310, Height: 150},
+	{Name: "mstile-310x310.png", Width: 310, Height: 310},
+}
+
+// WebManifest is the subset of the web app manifest written to site.webmanifest.
+type WebManifest struct {
+	Name            string            `json:"name"`
+	ShortName       string            `json:"short_name"`
+	Icons           []WebManifestIcon `json:"icons"`
+	ThemeColor      string            `json:"theme_color"`
+	BackgroundColor string            `json:"background_color"`
+	Display         string            `json:"display"`
+}
+
+// WebManifestIcon is a single icon entry in the web app manifest.
+type WebManifestIcon struct {
+	Src     string `json:"src"`
+	Sizes   string `json:"sizes"`
+	Type    string `json:"type"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// GenerateFaviconBundle generates a complete favicon and app icon bundle from the image
+// and stores it as a zip file. It returns the name of the zip file, which is used as the download ID.
+func GenerateFaviconBundle(imagePath string, config FaviconBundleConfig) (string, error) {
+	return storeZip(func(z *util.ZipWriter) error {
+		return WriteFaviconBundle(z, imagePath, config)
+	})
+}
+
+// WriteFaviconBundle generates a complete favicon and app icon bundle from the image
+// and streams every file into the zip writer as it is encoded.
+func WriteFaviconBundle(z *util.ZipWriter, imagePath string, config FaviconBundleConfig) error {
+	config, err := config.withDefaults()
+	if err != nil {
+		return err
+	}
+
+	background, err := ParseHexColor(config.BackgroundColor)
+	if err != nil {
+		return err
+	}
+
+	img, err := loadImage(imagePath, config.Pipeline)
+	if err != nil {
+		return err
+	}
+
+	// favicon.ico with the sizes browsers request
+	icons := make([]image.Image, 0, len(faviconICOSizes))
+	for _, size := range faviconICOSizes {
+		icons = append(icons, Resize(img, image.Point{size, size}, config.Resize))
+	}
+	if err := z.WriteFile("favicon.ico", func(w io.Writer) error { return EncodeICO(w, icons) }); err != nil {
+		return err
+	}
+
+	// PNG icons
+	for _, icon := range faviconPNGs {
+		rendered := renderFaviconPNG(img, icon, background, config.Resize)
+		if err := z.WriteFile(icon.Name, func(w io.Writer) error { return png.Encode(w, rendered) }); err != nil {
+			return err
+		}
+	}
+
+	// site.webmanifest
+	manifest, err := json.MarshalIndent(config.manifest(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode manifest: %v", err)
+	}
+	if err := writeBundleFile(z, "site.webmanifest", manifest); err != nil {
+		return err
+	}
+
+	// browserconfig.xml
+	browserConfig, err := config.browserConfig()
+	if err != nil {
+		return err
+	}
+	if err := writeBundleFile(z, "browserconfig.xml", browserConfig); err != nil {
+		return err
+	}
+
+	// Ready to paste HTML snippet
+	return writeBundleFile(z, "favicon.html", []byte(config.htmlSnippet()))
+}
+
+// ParseHexColor parses a colour in the form "#rgb", "#rrggbb" or "#rrggbbaa".
+func ParseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+
+	var c color.NRGBA
+	var err error
+	switch len(hex) {
+	case 3:
+		_, err = fmt.Sscanf(hex, "%1x%1x%1x", &c.R, &c.G, &c.B)
+		c.R, c.G, c.B = c.R*17, c.G*17, c.B*17
+		c.A = 255
+	case 6:
+		_, err = fmt.Sscanf(hex, "%02x%02x%02x", &c.R, &c.G, &c.B)
+		c.A = 255
+	case 8:
+		_, err = fmt.Sscanf(hex, "%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	default:
+		err = fmt.Errorf("invalid length")
+	}
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid hex colour %q: %v", s, err)
+	}
+
+	return c, nil
+}
+
+// withDefaults validates the config and fills in default values.
+func (c FaviconBundleConfig) withDefaults() (FaviconBundleConfig, error) {
+	if c.Name == "" {
+		c.Name = "App"
+	}
+	if c.ShortName == "" {
+		c.ShortName = c.Name
+	}
+	if c.ThemeColor == "" {
+		c.ThemeColor = "#ffffff"
+	}
+	if c.BackgroundColor == "" {
+		c.BackgroundColor = c.ThemeColor
+	}
+	if c.BasePath == "" {
+		c.BasePath = "/"
+	}
+	if !strings.HasSuffix(c.BasePath, "/") {
+		c.BasePath += "/"
+	}
+
+	if _, err := ParseHexColor(c.ThemeColor); err != nil {
+		return c, err
+	}
+	if err := validateBasePath(c.BasePath); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// validateBasePath checks the base path is a plain URL path or URL that can be written into
+// HTML attributes and XML without changing their structure.
+func validateBasePath(path string) error {
+	if strings.ContainsFunc(path, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(`"'<>\`+"`", r)
+	}) {
+		return fmt.Errorf("invalid base path %q: must be a plain URL path", path)
+	}
+
+	if _, err := url.Parse(path); err != nil {
+		return fmt.Errorf("invalid base path %q: %v", path, err)
+	}
+
+	return nil
+}
+
+func (c FaviconBundleConfig) manifest() WebManifest {
+	icons := []WebManifestIcon{}
+	for _, icon := range faviconPNGs {
+		if !strings.HasPrefix(icon.Name, "android-chrome") && !icon.Maskable {
+			continue
+		}
+
+		entry := WebManifestIcon{
+			Src:   c.BasePath + icon.Name,
+			Sizes: fmt.Sprintf("%dx%d", icon.Width, icon.Height),
+			Type:  "image/png",
+		}
+		if icon.Maskable {
+			entry.Purpose = "maskable"
+		}
+		icons = append(icons, entry)
+	}
+
+	return WebManifest{
+		Name:            c.Name,
+		ShortName:       c.ShortName,
+		Icons:           icons,
+		ThemeColor:      c.ThemeColor,
+		BackgroundColor: c.BackgroundColor,
+		Display:         "standalone",
+	}
+}
+
+func (c FaviconBundleConfig) browserConfig() ([]byte, error) {
+	type logo struct {
+		Src string `xml:"src,attr"`
+	}
+	type tile struct {
+		Square70  logo   `xml:"square70x70logo"`
+		Square150 logo   `xml:"square150x150logo"`
+		Wide310   logo   `xml:"wide310x150logo"`
+		Square310 logo   `xml:"square310x310logo"`
+		Color     string `xml:"TileColor"`
+	}
+	type browserConfig struct {
+		XMLName xml.Name `xml:"browserconfig"`
+		Tile    tile     `xml:"msapplication>tile"`
+	}
+
+	data, err := xml.MarshalIndent(browserConfig{
+		Tile: tile{
+			Square70:  logo{Src: c.BasePath + "mstile-70x70.png"},
+			Square150: logo{Src: c.BasePath + "mstile-150x150.png"},
+			Wide310:   logo{Src: c.BasePath + "mstile-310x150.png"},
+			Square310: logo{Src: c.BasePath + "mstile-310x310.png"},
+			Color:     c.ThemeColor,
+		},
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not encode browserconfig: %v", err)
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
+
+func (c FaviconBundleConfig) htmlSnippet() string {
+	// The values are validated, escaping keeps characters like & valid inside the attributes
+	c.BasePath = html.EscapeString(c.BasePath)
+	c.ThemeColor = html.EscapeString(c.ThemeColor)
+
+	lines := []string{
+		fmt.Sprintf(`<link rel="icon" href="%sfavicon.ico" sizes="any">`, c.BasePath),
+		fmt.Sprintf(`<link rel="icon" type="image/png" sizes="32x32" href="%sfavicon-32x32.png">`, c.BasePath),
+		fmt.Sprintf(`<link rel="icon" type="image/png" sizes="16x16" href="%sfavicon-16x16.png">`, c.BasePath),
+		fmt.Sprintf(`<link rel="apple-touch-icon" sizes="180x180" href="%sapple-touch-icon.png">`, c.BasePath),
+		fmt.Sprintf(`<link rel="manifest" href="%ssite.webmanifest">`, c.BasePath),
+		fmt.Sprintf(`<meta name="msapplication-config" content="%sbrowserconfig.xml">`, c.BasePath),
+		fmt.Sprintf(`<meta name="msapplication-TileColor" content="%s">`, c.ThemeColor),
+		fmt.Sprintf(`<meta name="theme-color" content="%s">`, c.ThemeColor),
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// renderFaviconPNG renders the source image at the size and style required by the icon.
+func renderFaviconPNG(img image.Image, icon faviconPNG, background color.NRGBA, options ResizeOptions) image.Image {
+	canvas := image.NewRGBA(image.Rect(0, 0, icon.Width, icon.Height))
+	if icon.Maskable || icon.Opaque {
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	}
+
+	// Fit the icon inside a centred square, shrunk to the safe zone for maskable icons
+	side := min(icon.Width, icon.Height)
+	if icon.Maskable {
+		side = int(float64(side) * MaskableSafeZone)
+	}
+	offset := image.Point{(icon.Width - side) / 2, (icon.Height - side) / 2}
+	target := image.Rectangle{Min: offset, Max: offset.Add(image.Point{side, side})}
+
+	draw.Draw(canvas, target, Resize(img, image.Point{side, side}, options), image.Point{}, draw.Over)
+
+	return canvas
+}
+
+// decodeImageFile decodes an image file, rotating it upright according to its EXIF orientation.
+func decodeImageFile(imagePath string) (image.Image, error) {
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not open image file: %v", err)
+	}
+
+	img, err := decodeImageData(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode image: %v", err)
+	}
+
+	return img, nil
+}
+
+// loadImage decodes an image file and runs the pipeline on it.
+func loadImage(imagePath string, pipeline Pipeline) (image.Image, error) {
+	img, err := decodeImageFile(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return pipeline.Apply(img)
+}
+
+// writeBundleFile writes a file with the given contents into the bundle.
+func writeBundleFile(z *util.ZipWriter, name string, data []byte) error {
+	return z.WriteFile(name, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,141 @@
+package image_convert
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHexColor(t *testing.T) {
+	c, err := ParseHexColor("#ff8000")
+	assert.NoError(t, err)
+	assert.Equal(t, color.NRGBA{R: 255, G: 128, B: 0, A: 255}, c)
+
+	c, err = ParseHexColor("0f0")
+	assert.NoError(t, err)
+	assert.Equal(t, color.NRGBA{R: 0, G: 255, B: 0, A: 255}, c)
+
+	c, err = ParseHexColor("#00000080")
+	assert.NoError(t, err)
+	assert.Equal(t, color.NRGBA{A: 128}, c)
+
+	_, err = ParseHexColor("#12")
+	assert.Error(t, err)
+
+	_, err = ParseHexColor("#zzzzzz")
+	assert.Error(t, err)
+}
+
+func TestGenerateFaviconBundle(t *testing.T) {
+	input, err := os.CreateTemp(t.TempDir(), "*.png")
+	assert.NoError(t, err)
+	assert.NoError(t, encodePNGFile(input, testImage(600, 600)))
+
+	name, err := GenerateFaviconBundle(input.Name(), FaviconBundleConfig{
+		Name:       "Rory Pearson",
+		ThemeColor: "#112233",
+	})
+	assert.NoError(t, err, "failed to generate bundle")
+	defer DeleteConvertedFile(name)
+
+	path, err := GetConvertedFilePath(name)
+	assert.NoError(t, err, "bundle should exist")
+
+	archive, err := zip.OpenReader(path)
+	assert.NoError(t, err)
+	defer archive.Close()
+
+	files := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	expected := []string{"favicon.ico", "site.webmanifest", "browserconfig.xml", "favicon.html"}
+	for _, icon := range faviconPNGs {
+		expected = append(expected, icon.Name)
+	}
+	for _, name := range expected {
+		assert.Contains(t, files, name, "bundle should contain %s", name)
+	}
+
+	// Manifest fields come from the config
+	var manifest WebManifest
+	assert.NoError(t, json.Unmarshal(readZipFile(t, files["site.webmanifest"]), &manifest))
+	assert.Equal(t, "Rory Pearson", manifest.Name)
+	assert.Equal(t, "Rory Pearson", manifest.ShortName)
+	assert.Equal(t, "#112233", manifest.ThemeColor)
+	assert.Equal(t, "#112233", manifest.BackgroundColor)
+	assert.Len(t, manifest.Icons, 4)
+
+	// Apple touch icon has the right size
+	apple := decodeZipPNG(t, files["apple-touch-icon.png"])
+	assert.Equal(t, image.Point{180, 180}, apple.Bounds().Size())
+
+	// Maskable icons are padded with the background colour outside the safe zone
+	maskable := decodeZipPNG(t, files["maskable-icon-512x512.png"])
+	assert.Equal(t, image.Point{512, 512}, maskable.Bounds().Size())
+	assert.Equal(t, color.NRGBA{R: 0x11, G: 0x22, B: 0x33, A: 255}, color.NRGBAModel.Convert(maskable.At(511, 511)))
+}
+
+func readZipFile(t *testing.T, f *zip.File) []byte {
+	t.Helper()
+
+	r, err := f.Open()
+	assert.NoError(t, err)
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	return data
+}
+
+func decodeZipPNG(t *testing.T, f *zip.File) image.Image {
+	t.Helper()
+
+	r, err := f.Open()
+	assert.NoError(t, err)
+	defer r.Close()
+
+	img, err := png.Decode(r)
+	assert.NoError(t, err)
+	return img
+}
+
+func TestFaviconBundleBasePath(t *testing.T) {
+	valid := map[string]string{
+		"":                             "/",
+		"/static/icons":                "/static/icons/",
+		"https://cdn.example.com/img/": "https://cdn.example.com/img/",
+	}
+	for input, expected := range valid {
+		config, err := FaviconBundleConfig{BasePath: input}.withDefaults()
+		assert.NoError(t, err, "%q should be a valid base path", input)
+		assert.Equal(t, expected, config.BasePath)
+	}
+
+	invalid := []string{
+		`/icons/" onload="alert(1)`,
+		"/icons/'><script>",
+		"/my icons/",
+		"/icons/\n",
+		"%zz/",
+	}
+	for _, input := range invalid {
+		_, err := FaviconBundleConfig{BasePath: input}.withDefaults()
+		assert.Error(t, err, "%q should be rejected", input)
+	}
+
+	// Characters allowed in URLs are still escaped in the HTML snippet
+	config, err := FaviconBundleConfig{BasePath: "/icons?v=1&theme=dark/"}.withDefaults()
+	assert.NoError(t, err)
+	snippet := config.htmlSnippet()
+	assert.Contains(t, snippet, `href="/icons?v=1&amp;theme=dark/favicon.ico"`)
+	assert.NotContains(t, snippet, "&theme")
+}
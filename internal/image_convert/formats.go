@@ -0,0 +1,289 @@
+package image_convert
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"rory-pearson/pkg/util"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp" // Register the WebP decoder
+)
+
+// ImageFormat is an image file format that images can be converted to.
+type ImageFormat string
+
+const (
+	FormatPNG  ImageFormat = "png"
+	FormatJPEG ImageFormat = "jpeg"
+	FormatGIF  ImageFormat = "gif"
+	FormatBMP  ImageFormat = "bmp"
+	FormatTIFF ImageFormat = "tiff"
+	FormatICO  ImageFormat = "ico"
+)
+
+const (
+	// DefaultJPEGQuality is used when no JPEG quality is requested.
+	DefaultJPEGQuality = 90
+	// DefaultGIFColors is used when no GIF palette size is requested.
+	DefaultGIFColors = 256
+	// MaxICOSize is the largest image dimension an ICO entry can hold.
+	MaxICOSize = 256
+)
+
+func init() {
+	// Allow ICO files as input, decoding the largest image they contain
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeLargestICO, decodeICOConfig)
+}
+
+// EncodeOptions holds format specific encoding options.
+// Metadata is never copied to the output, except for the colour profile when KeepColorProfile is set.
+type EncodeOptions struct {
+	Quality int // JPEG quality from 1 to 100
+	Colors  int // Maximum number of GIF palette colours from 2 to 256
+
+	KeepColorProfile bool   // Copy the ICC profile of the input to PNG and JPEG outputs
+	ColorProfile     []byte // ICC profile to embed, set from the input when KeepColorProfile is set
+}
+
+// InputFile is an uploaded image waiting to be converted.
+type InputFile struct {
+	Name string // Original file name, used to name the output
+	Path string // Location of the file on disk
+}
+
+// ParseImageFormat validates an image format name. "jpg" and "tif" are accepted as aliases.
+func ParseImageFormat(format string) (ImageFormat, error) {
+	switch strings.ToLower(format) {
+	case "png":
+		return FormatPNG, nil
+	case "jpeg", "jpg":
+		return FormatJPEG, nil
+	case "gif":
+		return FormatGIF, nil
+	case "bmp":
+		return FormatBMP, nil
+	case "tiff", "tif":
+		return FormatTIFF, nil
+	case "ico":
+		return FormatICO, nil
+	default:
+		return "", fmt.Errorf("unsupported image format: %s", format)
+	}
+}
+
+// Extension returns the file extension for the format, including the dot.
+func (f ImageFormat) Extension() string {
+	switch f {
+	case FormatJPEG:
+		return ".jpg"
+	default:
+		return "." + string(f)
+	}
+}
+
+// Encode writes the image in the given format.
+// The colour profile in options is embedded into PNG and JPEG outputs.
+func Encode(w io.Writer, img image.Image, format ImageFormat, options EncodeOptions) error {
+	if len(options.ColorProfile) == 0 || (format != FormatPNG && format != FormatJPEG) {
+		return encode(w, img, format, options)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := encode(buf, img, format, options); err != nil {
+		return err
+	}
+
+	data, err := embedColorProfile(buf.Bytes(), format, options.ColorProfile)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// encode writes the image in the given format without any metadata.
+func encode(w io.Writer, img image.Image, format ImageFormat, options EncodeOptions) error {
+	switch format {
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatJPEG:
+		quality := options.Quality
+		if quality == 0 {
+			quality = DefaultJPEGQuality
+		}
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("jpeg quality must be between 1 and 100")
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatGIF:
+		colors := options.Colors
+		if colors == 0 {
+			colors = DefaultGIFColors
+		}
+		if colors < 2 || colors > 256 {
+			return fmt.Errorf("gif colours must be between 2 and 256")
+		}
+		return gif.Encode(w, img, &gif.Options{
+			NumColors: colors,
+			Quantizer: MedianCutQuantizer{ReserveTransparent: true},
+			Drawer:    draw.FloydSteinberg,
+		})
+	case FormatBMP:
+		return bmp.Encode(w, img)
+	case FormatTIFF:
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	case FormatICO:
+		return EncodeICO(w, []image.Image{fitICO(img)})
+	default:
+		return fmt.Errorf("unsupported image format: %s", format)
+	}
+}
+
+// ConvertFormat runs the pipeline on every input file and converts it to the format.
+// A single file is stored as is, multiple files are stored as a zip file.
+// It returns the name of the stored file, which is used as the download ID.
+func ConvertFormat(files []InputFile, format ImageFormat, options EncodeOptions, pipeline Pipeline) (string, error) {
+	if len(files) == 0 {
+		return "", ErrorNoImages
+	}
+
+	if len(files) > 1 {
+		return storeZip(func(z *util.ZipWriter) error {
+			return WriteFormatZip(z, files, format, options, pipeline)
+		})
+	}
+
+	name := util.GenerateUUIDv4() + format.Extension()
+	outputPath := filepath.Join(storageDirectory, name)
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("could not create output file: %v", err)
+	}
+	defer output.Close()
+
+	if err := convertFile(output, files[0], format, options, pipeline); err != nil {
+		output.Close()
+		os.Remove(outputPath)
+		return "", err
+	}
+
+	return name, nil
+}
+
+// WriteFormatZip converts every input file to the format and streams them into the zip writer.
+func WriteFormatZip(z *util.ZipWriter, files []InputFile, format ImageFormat, options EncodeOptions, pipeline Pipeline) error {
+	if len(files) == 0 {
+		return ErrorNoImages
+	}
+
+	used := make(map[string]int)
+	for _, file := range files {
+		// Decode before the entry is created so a failing file does not leave an empty entry
+		img, err := loadImage(file.Path, pipeline)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Name, err)
+		}
+
+		name := outputName(file.Name, format, used)
+		if err := z.WriteFile(name, func(w io.Writer) error { return encodeFile(w, img, file, format, options) }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// convertFile decodes and processes a single input file and writes it to w in the format.
+func convertFile(w io.Writer, file InputFile, format ImageFormat, options EncodeOptions, pipeline Pipeline) error {
+	img, err := loadImage(file.Path, pipeline)
+	if err != nil {
+		return fmt.Errorf("%s: %v", file.Name, err)
+	}
+
+	return encodeFile(w, img, file, format, options)
+}
+
+// encodeFile encodes a processed input file, copying its colour profile when requested.
+func encodeFile(w io.Writer, img image.Image, file InputFile, format ImageFormat, options EncodeOptions) error {
+	if options.KeepColorProfile {
+		data, err := os.ReadFile(file.Path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file.Name, err)
+		}
+		options.ColorProfile = ReadColorProfile(data)
+	}
+
+	if err := Encode(w, img, format, options); err != nil {
+		return fmt.Errorf("%s: could not encode image: %v", file.Name, err)
+	}
+
+	return nil
+}
+
+// outputName derives a unique output file name from the input name.
+func outputName(name string, format ImageFormat, used map[string]int) string {
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	if base == "" || base == "." {
+		base = "image"
+	}
+
+	used[base]++
+	if used[base] > 1 {
+		base = fmt.Sprintf("%s_%d", base, used[base]-1)
+	}
+
+	return base + format.Extension()
+}
+
+// fitICO scales images larger than MaxICOSize down, keeping their aspect ratio.
+func fitICO(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= MaxICOSize && bounds.Dy() <= MaxICOSize {
+		return img
+	}
+
+	size := containRect(bounds, image.Point{MaxICOSize, MaxICOSize}).Size()
+	return Resize(img, size, ResizeOptions{Fit: FitStretch})
+}
+
+// decodeLargestICO decodes the largest image of an ICO file for the image package.
+func decodeLargestICO(r io.Reader) (image.Image, error) {
+	images, err := DecodeICO(r)
+	if err != nil {
+		return nil, err
+	}
+
+	largest := images[0]
+	for _, img := range images[1:] {
+		if img.Bounds().Dx()*img.Bounds().Dy() > largest.Bounds().Dx()*largest.Bounds().Dy() {
+			largest = img
+		}
+	}
+	return largest, nil
+}
+
+// decodeICOConfig returns the size of the largest image of an ICO file for the image package.
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	img, err := decodeLargestICO(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	return image.Config{
+		ColorModel: img.ColorModel(),
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+	}, nil
+}
@@ -0,0 +1,154 @@
+package image_convert
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeFormats(t *testing.T) {
+	source := testImage(64, 48)
+
+	for _, format := range []ImageFormat{FormatPNG, FormatJPEG, FormatGIF, FormatBMP, FormatTIFF, FormatICO} {
+		t.Run(string(format), func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			assert.NoError(t, Encode(buf, source, format, EncodeOptions{}))
+
+			decoded, name, err := image.Decode(buf)
+			assert.NoError(t, err, "encoded image should decode")
+			assert.Equal(t, string(format), name)
+			assert.Equal(t, source.Bounds().Size(), decoded.Bounds().Size())
+
+			// Lossy formats only need to be close to the source
+			expected := color.NRGBAModel.Convert(source.At(48, 40)).(color.NRGBA)
+			actual := color.NRGBAModel.Convert(decoded.At(48, 40)).(color.NRGBA)
+			assert.InDelta(t, expected.R, actual.R, 16)
+			assert.InDelta(t, expected.G, actual.G, 16)
+			assert.InDelta(t, expected.B, actual.B, 16)
+		})
+	}
+}
+
+func TestEncodeGIFPalette(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Encode(buf, testImage(64, 64), FormatGIF, EncodeOptions{Colors: 16}))
+
+	decoded, err := gif.Decode(buf)
+	assert.NoError(t, err)
+
+	paletted := decoded.(*image.Paletted)
+	assert.LessOrEqual(t, len(paletted.Palette), 16, "palette should be limited to the requested colours")
+
+	// The transparent corner of the test image stays transparent
+	_, _, _, a := paletted.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0), a)
+}
+
+func TestEncodeOptionErrors(t *testing.T) {
+	img := testImage(8, 8)
+	assert.Error(t, Encode(new(bytes.Buffer), img, FormatJPEG, EncodeOptions{Quality: 101}))
+	assert.Error(t, Encode(new(bytes.Buffer), img, FormatGIF, EncodeOptions{Colors: 1}))
+	assert.Error(t, Encode(new(bytes.Buffer), img, ImageFormat("webp"), EncodeOptions{}))
+
+	_, err := ParseImageFormat("webp")
+	assert.Error(t, err)
+
+	format, err := ParseImageFormat("JPG")
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJPEG, format)
+}
+
+func TestEncodeICOFitsLargeImages(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Encode(buf, testImage(1024, 512), FormatICO, EncodeOptions{}))
+
+	images, err := DecodeICO(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Point{256, 128}, images[0].Bounds().Size())
+}
+
+func TestConvertFormatBatch(t *testing.T) {
+	dir := t.TempDir()
+
+	var files []InputFile
+	for i, name := range []string{"logo.png", "logo.png", "photo.ico"} {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		file, err := os.Create(path)
+		assert.NoError(t, err)
+
+		if filepath.Ext(name) == ".ico" {
+			assert.NoError(t, EncodeICO(file, []image.Image{testImage(16, 16), testImage(32, 32)}))
+			file.Close()
+		} else {
+			assert.NoError(t, encodePNGFile(file, testImage(40, 40)))
+		}
+
+		files = append(files, InputFile{Name: name, Path: path})
+	}
+
+	name, err := ConvertFormat(files, FormatJPEG, EncodeOptions{Quality: 80}, nil)
+	assert.NoError(t, err, "failed to convert batch")
+	defer DeleteConvertedFile(name)
+	assert.Equal(t, ".zip", filepath.Ext(name))
+
+	path, err := GetConvertedFilePath(name)
+	assert.NoError(t, err)
+
+	archive, err := zip.OpenReader(path)
+	assert.NoError(t, err)
+	defer archive.Close()
+
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{"logo.jpg", "logo_1.jpg", "photo.jpg"}, names)
+
+	// ICO inputs are decoded from their largest image
+	r, err := archive.File[2].Open()
+	assert.NoError(t, err)
+	defer r.Close()
+	config, _, err := image.DecodeConfig(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 32, config.Width)
+}
+
+func TestConvertFormatSingle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	assert.NoError(t, encodePNGFile(file, testImage(20, 10)))
+
+	name, err := ConvertFormat([]InputFile{{Name: "input.png", Path: path}}, FormatBMP, EncodeOptions{}, nil)
+	assert.NoError(t, err)
+	defer DeleteConvertedFile(name)
+	assert.Equal(t, ".bmp", filepath.Ext(name))
+
+	_, err = ConvertFormat(nil, FormatBMP, EncodeOptions{}, nil)
+	assert.ErrorIs(t, err, ErrorNoImages)
+}
+
+func TestMedianCutQuantizer(t *testing.T) {
+	palette := MedianCutQuantizer{}.Quantize(make(color.Palette, 0, 8), testImage(64, 64))
+	assert.Len(t, palette, 8)
+
+	palette = MedianCutQuantizer{ReserveTransparent: true}.Quantize(make(color.Palette, 0, 8), testImage(64, 64))
+	assert.Len(t, palette, 8)
+	assert.Equal(t, color.NRGBA{}, palette[0], "first entry should be transparent")
+
+	// Images with fewer colours than the palette size keep their exact colours
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+	palette = MedianCutQuantizer{}.Quantize(make(color.Palette, 0, 16), img)
+	assert.ElementsMatch(t, color.Palette{img.At(0, 0), img.At(1, 0)}, palette)
+}
@@ -0,0 +1,80 @@
+package image_convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+const (
+	icnsMagic      = "icns"
+	icnsHeaderSize = 8
+)
+
+// icnsTypes maps the PNG-based ICNS entry types to the pixel size they hold.
+var icnsTypes = []struct {
+	Type string
+	Size int
+}{
+	{"ic07", 128},
+	{"ic08", 256},
+	{"ic09", 512},
+	{"ic10", 1024}, // 512x512@2x
+}
+
+// ICNSSizes are the sizes written into an ICNS file.
+var ICNSSizes = []image.Point{
+	{128, 128},
+	{256, 256},
+	{512, 512},
+	{1024, 1024},
+}
+
+// EncodeICNS writes the images as an Apple ICNS container with PNG-compressed entries.
+// Every image must be square with a size supported by one of the ic07 to ic10 entry types.
+func EncodeICNS(w io.Writer, images []image.Image) error {
+	if len(images) == 0 {
+		return ErrorNoImages
+	}
+
+	body := new(bytes.Buffer)
+	for _, img := range images {
+		iconType, err := icnsType(img.Bounds().Size())
+		if err != nil {
+			return err
+		}
+
+		data := new(bytes.Buffer)
+		if err := png.Encode(data, img); err != nil {
+			return fmt.Errorf("could not encode png: %v", err)
+		}
+
+		// Entry lengths include the 8 byte type and length header
+		body.WriteString(iconType)
+		binary.Write(body, binary.BigEndian, uint32(icnsHeaderSize+data.Len()))
+		body.Write(data.Bytes())
+	}
+
+	header := new(bytes.Buffer)
+	header.WriteString(icnsMagic)
+	binary.Write(header, binary.BigEndian, uint32(icnsHeaderSize+body.Len()))
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(body.Bytes())
+	return err
+}
+
+// icnsType returns the ICNS entry type for an image size.
+func icnsType(size image.Point) (string, error) {
+	for _, t := range icnsTypes {
+		if size.X == t.Size && size.Y == t.Size {
+			return t.Type, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported icns image size %dx%d", size.X, size.Y)
+}
@@ -0,0 +1,216 @@
+package image_convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// icnsEntry is a parsed ICNS entry.
+type icnsEntry struct {
+	Type string
+	Data []byte
+}
+
+// parseICNS parses the container structure of an ICNS file.
+func parseICNS(t *testing.T, data []byte) []icnsEntry {
+	t.Helper()
+
+	assert.Equal(t, icnsMagic, string(data[:4]), "file should start with the icns magic")
+	assert.Equal(t, uint32(len(data)), binary.BigEndian.Uint32(data[4:8]), "header length should match the file size")
+
+	var entries []icnsEntry
+	for offset := icnsHeaderSize; offset < len(data); {
+		if !assert.LessOrEqual(t, offset+icnsHeaderSize, len(data), "entry header is truncated") {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		if !assert.LessOrEqual(t, offset+length, len(data), "entry is truncated") {
+			break
+		}
+
+		entries = append(entries, icnsEntry{
+			Type: string(data[offset : offset+4]),
+			Data: data[offset+icnsHeaderSize : offset+length],
+		})
+		offset += length
+	}
+
+	return entries
+}
+
+func TestEncodeICNS(t *testing.T) {
+	var images []image.Image
+	for _, size := range ICNSSizes {
+		images = append(images, testImage(size.X, size.Y))
+	}
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, EncodeICNS(buf, images))
+
+	entries := parseICNS(t, buf.Bytes())
+	assert.Len(t, entries, 4)
+
+	for i, expected := range []struct {
+		Type string
+		Size int
+	}{{"ic07", 128}, {"ic08", 256}, {"ic09", 512}, {"ic10", 1024}} {
+		assert.Equal(t, expected.Type, entries[i].Type)
+
+		img, err := png.Decode(bytes.NewReader(entries[i].Data))
+		assert.NoError(t, err, "entry should contain a png")
+		assert.Equal(t, image.Point{expected.Size, expected.Size}, img.Bounds().Size())
+	}
+}
+
+func TestEncodeICNSErrors(t *testing.T) {
+	assert.ErrorIs(t, EncodeICNS(new(bytes.Buffer), nil), ErrorNoImages)
+	assert.Error(t, EncodeICNS(new(bytes.Buffer), []image.Image{testImage(100, 100)}), "unsupported sizes should fail")
+}
+
+func TestEncodeCUR(t *testing.T) {
+	var images []image.Image
+	for _, size := range CursorSizes {
+		images = append(images, testImage(size.X, size.Y))
+	}
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, EncodeCUR(buf, images, FocalPoint{X: 0.25, Y: 0.5}))
+
+	reader := bytes.NewReader(buf.Bytes())
+	var dir iconDir
+	assert.NoError(t, binary.Read(reader, binary.LittleEndian, &dir))
+	assert.Equal(t, uint16(iconTypeCursor), dir.Type, "should be a cursor")
+	assert.Equal(t, uint16(len(CursorSizes)), dir.Count)
+
+	entries := make([]iconDirEntry, dir.Count)
+	assert.NoError(t, binary.Read(reader, binary.LittleEndian, &entries))
+
+	// The hotspot is scaled to every size
+	for i, size := range CursorSizes {
+		assert.Equal(t, uint8(size.X), entries[i].Width)
+		assert.Equal(t, uint16(size.X/4), entries[i].Planes, "hotspot x")
+		assert.Equal(t, uint16(size.Y/2), entries[i].BitCount, "hotspot y")
+	}
+
+	// Cursors share the icon image encoding
+	decoded, err := DecodeICO(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	for i := range images {
+		assertSameImage(t, images[i], decoded[i])
+	}
+}
+
+func TestCursorHotspotClamped(t *testing.T) {
+	x, y := cursorHotspot(image.Point{32, 32}, image.Point{X: 64, Y: -1})
+	assert.Equal(t, uint16(31), x)
+	assert.Equal(t, uint16(0), y)
+}
+
+func TestConvertICNSAndCUR(t *testing.T) {
+	input, err := os.CreateTemp(t.TempDir(), "*.png")
+	assert.NoError(t, err)
+	assert.NoError(t, encodePNGFile(input, testImage(200, 200)))
+
+	name, err := Convert(input.Name(), ConvertOptions{Format: OutputICNS})
+	assert.NoError(t, err)
+	defer DeleteConvertedFile(name)
+
+	path, err := GetConvertedFilePath(name)
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, parseICNS(t, data), len(ICNSSizes))
+
+	name, err = Convert(input.Name(), ConvertOptions{Format: OutputCUR, Hotspot: image.Point{100, 50}})
+	assert.NoError(t, err)
+	defer DeleteConvertedFile(name)
+
+	path, err = GetConvertedFilePath(name)
+	assert.NoError(t, err)
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var entry iconDirEntry
+	assert.NoError(t, binary.Read(bytes.NewReader(data[iconDirSize:]), binary.LittleEndian, &entry))
+	assert.Equal(t, uint16(16), entry.Planes, "hotspot x should be scaled to 32px")
+	assert.Equal(t, uint16(8), entry.BitCount, "hotspot y should be scaled to 32px")
+}
+
+func TestConvertCURNonSquare(t *testing.T) {
+	input, err := os.CreateTemp(t.TempDir(), "*.png")
+	assert.NoError(t, err)
+	assert.NoError(t, encodePNGFile(input, testImage(200, 100)))
+
+	readHotspot := func(options ConvertOptions) (uint16, uint16) {
+		options.Format = OutputCUR
+		name, err := Convert(input.Name(), options)
+		assert.NoError(t, err)
+		defer DeleteConvertedFile(name)
+
+		path, err := GetConvertedFilePath(name)
+		assert.NoError(t, err)
+		data, err := os.ReadFile(path)
+		assert.NoError(t, err)
+
+		// The first entry is the 32px cursor
+		var entry iconDirEntry
+		assert.NoError(t, binary.Read(bytes.NewReader(data[iconDirSize:]), binary.LittleEndian, &entry))
+		return entry.Planes, entry.BitCount
+	}
+
+	// Contain pads 8px above and below the 32x16 image, the hotspot moves with it
+	x, y := readHotspot(ConvertOptions{Hotspot: image.Point{0, 0}})
+	assert.Equal(t, [2]uint16{0, 8}, [2]uint16{x, y})
+	x, y = readHotspot(ConvertOptions{Hotspot: image.Point{199, 99}})
+	assert.Equal(t, [2]uint16{31, 23}, [2]uint16{x, y})
+
+	// Cover crops the centre 100x100 of the source
+	x, y = readHotspot(ConvertOptions{Hotspot: image.Point{60, 50}, Resize: ResizeOptions{Fit: FitCover}})
+	assert.Equal(t, [2]uint16{3, 16}, [2]uint16{x, y})
+
+	// Points cropped away are clamped to the edge
+	x, y = readHotspot(ConvertOptions{Hotspot: image.Point{10, 50}, Resize: ResizeOptions{Fit: FitCover}})
+	assert.Equal(t, [2]uint16{0, 16}, [2]uint16{x, y})
+
+	// Stretch scales each axis on its own
+	x, y = readHotspot(ConvertOptions{Hotspot: image.Point{100, 50}, Resize: ResizeOptions{Fit: FitStretch}})
+	assert.Equal(t, [2]uint16{16, 16}, [2]uint16{x, y})
+}
+
+func TestResizedPoint(t *testing.T) {
+	src := image.Rect(0, 0, 200, 100)
+	size := image.Point{32, 32}
+
+	// The mapped point matches where the marked pixel ends up in the resized image
+	assert.Equal(t, image.Point{16, 16}, resizedPoint(image.Point{100, 50}, src, size, ResizeOptions{}))
+	assert.Equal(t, image.Point{16, 8}, resizedPoint(image.Point{100, 0}, src, size, ResizeOptions{}))
+	assert.Equal(t, image.Point{0, 16}, resizedPoint(image.Point{50, 50}, src, size, ResizeOptions{Fit: FitCover}))
+
+	// A focus point moves the cover crop and the hotspot with it
+	focus := &FocalPoint{X: 0, Y: 0.5}
+	assert.Equal(t, image.Point{16, 16}, resizedPoint(image.Point{50, 50}, src, size, ResizeOptions{Fit: FitCover, Focus: focus}))
+}
+
+func TestStoreIconRemovesPartialFile(t *testing.T) {
+	before, err := os.ReadDir(storageDirectory)
+	assert.NoError(t, err)
+
+	_, err = storeIcon(".ico", func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return errors.New("encoding failed")
+	})
+	assert.Error(t, err)
+
+	after, err := os.ReadDir(storageDirectory)
+	assert.NoError(t, err)
+	assert.Len(t, after, len(before), "the partial icon should be removed")
+}
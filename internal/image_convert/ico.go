@@ -0,0 +1,336 @@
+package image_convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+const (
+	// Resource types stored in the ICONDIR header
+	iconTypeIcon   = 1
+	iconTypeCursor = 2
+
+	// Images at or above this size are stored as PNG, smaller ones as BMP
+	icoPNGThreshold = 256
+
+	iconDirSize      = 6
+	iconDirEntrySize = 16
+	bmpInfoSize      = 40
+)
+
+var (
+	ErrorNoImages       = errors.New("no images to encode")
+	ErrorImageTooLarge  = errors.New("icon images can not be larger than 256x256")
+	ErrorInvalidIconDir = errors.New("invalid icon directory")
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// iconDir is the ICONDIR header at the start of an ICO or CUR file.
+type iconDir struct {
+	Reserved uint16
+	Type     uint16
+	Count    uint16
+}
+
+// iconDirEntry describes a single image in an ICO or CUR file.
+// For cursors, Planes and BitCount hold the hotspot X and Y coordinates instead.
+type iconDirEntry struct {
+	Width      uint8
+	Height     uint8
+	ColorCount uint8
+	Reserved   uint8
+	Planes     uint16
+	BitCount   uint16
+	Size       uint32
+	Offset     uint32
+}
+
+// bmpInfoHeader is the BITMAPINFOHEADER used by BMP entries inside an icon.
+type bmpInfoHeader struct {
+	Size          uint32
+	Width         int32
+	Height        int32
+	Planes        uint16
+	BitCount      uint16
+	Compression   uint32
+	SizeImage     uint32
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	ClrUsed       uint32
+	ClrImportant  uint32
+}
+
+// iconImage is an encoded image ready to be written into an icon directory.
+type iconImage struct {
+	Width    int
+	Height   int
+	Planes   uint16
+	BitCount uint16
+	Data     []byte
+}
+
+// EncodeICO writes the images as a single multi-resolution ICO file.
+// Images of 256px are stored PNG-compressed, smaller sizes as 32-bit BMPs
+// for compatibility with older Windows versions.
+func EncodeICO(w io.Writer, images []image.Image) error {
+	return encodeICO(w, images, png.Encode)
+}
+
+// encodeICO writes the images as an ICO file, using encodePNG for the PNG-compressed entries.
+func encodeICO(w io.Writer, images []image.Image, encodePNG func(io.Writer, image.Image) error) error {
+	entries := make([]iconImage, 0, len(images))
+	for _, img := range images {
+		entry, err := encodeIconImage(img, encodePNG)
+		if err != nil {
+			return err
+		}
+
+		entry.Planes = 1
+		entry.BitCount = 32
+		entries = append(entries, entry)
+	}
+
+	return writeIconDir(w, iconTypeIcon, entries)
+}
+
+// EncodeCUR writes the images as a single Windows CUR file. The hotspot is given as
+// a fraction of the image size so it lands on the same spot in every size.
+func EncodeCUR(w io.Writer, images []image.Image, hotspot FocalPoint) error {
+	hotspots := make([]image.Point, 0, len(images))
+	for _, img := range images {
+		size := img.Bounds().Size()
+		hotspots = append(hotspots, image.Point{int(hotspot.X * float64(size.X)), int(hotspot.Y * float64(size.Y))})
+	}
+
+	return encodeCUR(w, images, hotspots)
+}
+
+// encodeCUR writes the images as a single Windows CUR file with a hotspot in pixels for each image.
+func encodeCUR(w io.Writer, images []image.Image, hotspots []image.Point) error {
+	entries := make([]iconImage, 0, len(images))
+	for i, img := range images {
+		entry, err := encodeIconImage(img, png.Encode)
+		if err != nil {
+			return err
+		}
+
+		// Cursor entries store the hotspot where icons store planes and bit count
+		x, y := cursorHotspot(img.Bounds().Size(), hotspots[i])
+		entry.Planes = x
+		entry.BitCount = y
+		entries = append(entries, entry)
+	}
+
+	return writeIconDir(w, iconTypeCursor, entries)
+}
+
+// cursorHotspot clamps a hotspot in pixels to the size.
+func cursorHotspot(size image.Point, hotspot image.Point) (uint16, uint16) {
+	x := min(max(hotspot.X, 0), size.X-1)
+	y := min(max(hotspot.Y, 0), size.Y-1)
+	return uint16(x), uint16(y)
+}
+
+// DecodeICO reads every image stored in an ICO or CUR file.
+func DecodeICO(r io.Reader) ([]image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read icon: %v", err)
+	}
+
+	reader := bytes.NewReader(data)
+
+	var dir iconDir
+	if err := binary.Read(reader, binary.LittleEndian, &dir); err != nil {
+		return nil, ErrorInvalidIconDir
+	}
+	if dir.Reserved != 0 || (dir.Type != iconTypeIcon && dir.Type != iconTypeCursor) || dir.Count == 0 {
+		return nil, ErrorInvalidIconDir
+	}
+
+	entries := make([]iconDirEntry, dir.Count)
+	if err := binary.Read(reader, binary.LittleEndian, &entries); err != nil {
+		return nil, ErrorInvalidIconDir
+	}
+
+	images := make([]image.Image, 0, len(entries))
+	for i, entry := range entries {
+		end := uint64(entry.Offset) + uint64(entry.Size)
+		if end > uint64(len(data)) {
+			return nil, fmt.Errorf("icon entry %d is out of bounds", i)
+		}
+
+		img, err := decodeIconImage(data[entry.Offset:end])
+		if err != nil {
+			return nil, fmt.Errorf("could not decode icon entry %d: %v", i, err)
+		}
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// writeIconDir writes the ICONDIR header, its entries and the image data.
+func writeIconDir(w io.Writer, iconType uint16, images []iconImage) error {
+	if len(images) == 0 {
+		return ErrorNoImages
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, iconDir{
+		Type:  iconType,
+		Count: uint16(len(images)),
+	})
+
+	offset := uint32(iconDirSize + iconDirEntrySize*len(images))
+	for _, img := range images {
+		binary.Write(buf, binary.LittleEndian, iconDirEntry{
+			Width:    iconDimension(img.Width),
+			Height:   iconDimension(img.Height),
+			Planes:   img.Planes,
+			BitCount: img.BitCount,
+			Size:     uint32(len(img.Data)),
+			Offset:   offset,
+		})
+		offset += uint32(len(img.Data))
+	}
+
+	for _, img := range images {
+		buf.Write(img.Data)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// iconDimension converts a size to its directory representation, where 0 means 256.
+func iconDimension(size int) uint8 {
+	if size >= 256 {
+		return 0
+	}
+	return uint8(size)
+}
+
+// encodeIconImage encodes a single image as PNG or BMP depending on its size.
+func encodeIconImage(img image.Image, encodePNG func(io.Writer, image.Image) error) (iconImage, error) {
+	bounds := img.Bounds()
+	if bounds.Dx() > 256 || bounds.Dy() > 256 {
+		return iconImage{}, ErrorImageTooLarge
+	}
+
+	entry := iconImage{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+
+	if bounds.Dx() >= icoPNGThreshold || bounds.Dy() >= icoPNGThreshold {
+		buf := new(bytes.Buffer)
+		if err := encodePNG(buf, img); err != nil {
+			return iconImage{}, fmt.Errorf("could not encode png: %v", err)
+		}
+		entry.Data = buf.Bytes()
+	} else {
+		entry.Data = encodeIconBMP(img)
+	}
+
+	return entry, nil
+}
+
+// encodeIconBMP encodes an image as a 32-bit BMP without a file header,
+// followed by the 1-bit AND mask, as expected inside an icon.
+func encodeIconBMP(img image.Image) []byte {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	maskStride := ((width + 31) / 32) * 4
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, bmpInfoHeader{
+		Size:      bmpInfoSize,
+		Width:     int32(width),
+		Height:    int32(height * 2), // XOR bitmap and AND mask
+		Planes:    1,
+		BitCount:  32,
+		SizeImage: uint32(width*height*4 + maskStride*height),
+	})
+
+	// Pixels are stored bottom-up as non-premultiplied BGRA
+	mask := make([]byte, maskStride*height)
+	for y := height - 1; y >= 0; y-- {
+		row := height - 1 - y
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			buf.Write([]byte{c.B, c.G, c.R, c.A})
+
+			if c.A == 0 {
+				mask[row*maskStride+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	buf.Write(mask)
+
+	return buf.Bytes()
+}
+
+// decodeIconImage decodes a single PNG or BMP icon entry.
+func decodeIconImage(data []byte) (image.Image, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		return png.Decode(bytes.NewReader(data))
+	}
+
+	reader := bytes.NewReader(data)
+	var header bmpInfoHeader
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.Size < bmpInfoSize || header.Compression != 0 {
+		return nil, errors.New("unsupported bitmap header")
+	}
+
+	width, height := int(header.Width), int(header.Height/2)
+	if width <= 0 || height <= 0 || width > 256 || height > 256 {
+		return nil, errors.New("invalid bitmap size")
+	}
+
+	var bytesPerPixel int
+	switch header.BitCount {
+	case 32:
+		bytesPerPixel = 4
+	case 24:
+		bytesPerPixel = 3
+	default:
+		return nil, fmt.Errorf("unsupported bit count %d", header.BitCount)
+	}
+
+	stride := ((width*bytesPerPixel + 3) / 4) * 4
+	maskStride := ((width + 31) / 32) * 4
+	pixelsStart := int(header.Size)
+	maskStart := pixelsStart + stride*height
+	if maskStart > len(data) {
+		return nil, errors.New("bitmap data is truncated")
+	}
+	hasMask := maskStart+maskStride*height <= len(data)
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for row := 0; row < height; row++ {
+		y := height - 1 - row
+		for x := 0; x < width; x++ {
+			p := data[pixelsStart+row*stride+x*bytesPerPixel:]
+			c := color.NRGBA{R: p[2], G: p[1], B: p[0], A: 255}
+			if bytesPerPixel == 4 {
+				c.A = p[3]
+			} else if hasMask && data[maskStart+row*maskStride+x/8]&(0x80>>(x%8)) != 0 {
+				c.A = 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	return img, nil
+}
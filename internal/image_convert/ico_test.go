@@ -0,0 +1,137 @@
+package image_convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"rory-pearson/environment"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	// The storage directory is created relative to the package when it is imported
+	environment.DestroyStorage()
+	os.Exit(code)
+}
+
+// testImage creates an image with a gradient and a fully transparent corner.
+func testImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255}
+			if x < width/4 && y < height/4 {
+				c = color.NRGBA{}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func assertSameImage(t *testing.T, expected, actual image.Image) {
+	t.Helper()
+
+	assert.Equal(t, expected.Bounds().Size(), actual.Bounds().Size(), "sizes should match")
+
+	eb, ab := expected.Bounds(), actual.Bounds()
+	for y := 0; y < eb.Dy(); y++ {
+		for x := 0; x < eb.Dx(); x++ {
+			ec := color.NRGBAModel.Convert(expected.At(eb.Min.X+x, eb.Min.Y+y))
+			ac := color.NRGBAModel.Convert(actual.At(ab.Min.X+x, ab.Min.Y+y))
+			if ec != ac {
+				t.Fatalf("pixel (%d, %d) differs: expected %v, got %v", x, y, ec, ac)
+			}
+		}
+	}
+}
+
+func TestEncodeICORoundTrip(t *testing.T) {
+	var images []image.Image
+	for _, size := range Sizes {
+		images = append(images, testImage(size.X, size.Y))
+	}
+
+	buf := new(bytes.Buffer)
+	err := EncodeICO(buf, images)
+	assert.NoError(t, err, "failed to encode icon")
+
+	decoded, err := DecodeICO(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err, "failed to decode icon")
+	assert.Equal(t, len(images), len(decoded), "all sizes should be stored")
+
+	for i := range images {
+		assertSameImage(t, images[i], decoded[i])
+	}
+}
+
+func TestEncodeICOEntryFormats(t *testing.T) {
+	buf := new(bytes.Buffer)
+	err := EncodeICO(buf, []image.Image{testImage(16, 16), testImage(256, 256)})
+	assert.NoError(t, err, "failed to encode icon")
+
+	data := buf.Bytes()
+
+	var dir iconDir
+	reader := bytes.NewReader(data)
+	assert.NoError(t, binary.Read(reader, binary.LittleEndian, &dir))
+	assert.Equal(t, uint16(iconTypeIcon), dir.Type, "should be an icon")
+	assert.Equal(t, uint16(2), dir.Count, "should contain two images")
+
+	entries := make([]iconDirEntry, dir.Count)
+	assert.NoError(t, binary.Read(reader, binary.LittleEndian, &entries))
+
+	// 16px is stored as a BMP, 256px as a PNG with a size of 0 in the directory
+	assert.Equal(t, uint8(16), entries[0].Width)
+	assert.False(t, bytes.HasPrefix(data[entries[0].Offset:], pngSignature), "small sizes should be BMP")
+	assert.Equal(t, uint8(0), entries[1].Width)
+	assert.True(t, bytes.HasPrefix(data[entries[1].Offset:], pngSignature), "256px should be PNG")
+}
+
+func TestEncodeICOErrors(t *testing.T) {
+	err := EncodeICO(new(bytes.Buffer), nil)
+	assert.ErrorIs(t, err, ErrorNoImages)
+
+	err = EncodeICO(new(bytes.Buffer), []image.Image{testImage(512, 512)})
+	assert.ErrorIs(t, err, ErrorImageTooLarge)
+
+	_, err = DecodeICO(bytes.NewReader([]byte("not an icon")))
+	assert.ErrorIs(t, err, ErrorInvalidIconDir)
+}
+
+func TestConvertICO(t *testing.T) {
+	input, err := os.CreateTemp(t.TempDir(), "*.png")
+	assert.NoError(t, err)
+	assert.NoError(t, encodePNGFile(input, testImage(300, 300)))
+
+	name, err := Convert(input.Name(), ConvertOptions{Format: OutputICO})
+	assert.NoError(t, err, "failed to convert image")
+
+	path, err := GetConvertedFilePath(name)
+	assert.NoError(t, err, "converted file should exist")
+
+	file, err := os.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	decoded, err := DecodeICO(file)
+	assert.NoError(t, err, "failed to decode converted icon")
+	assert.Equal(t, len(Sizes), len(decoded), "all sizes should be stored")
+	for i, size := range Sizes {
+		assert.Equal(t, size, decoded[i].Bounds().Size())
+	}
+
+	assert.NoError(t, DeleteConvertedFile(name))
+}
+
+func encodePNGFile(file *os.File, img image.Image) error {
+	defer file.Close()
+	return png.Encode(file, img)
+}
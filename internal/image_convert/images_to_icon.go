@@ -3,17 +3,16 @@ package image_convert
 import (
 	"fmt"
 	"image"
+	"io"
 	"os"
 	"path/filepath"
 	"rory-pearson/environment"
 	"rory-pearson/pkg/util"
-
-	ico "github.com/Kodeworks/golang-image-ico"
-	"golang.org/x/image/draw"
 )
 
 var storageDirectory = environment.CreateStorageDirectory("image_convert_storage")
 
+// Sizes are the sizes written into ICO files.
 var Sizes = []image.Point{
 	{16, 16},
 	{24, 24},
@@ -24,87 +23,194 @@ var Sizes = []image.Point{
 	{256, 256},
 }
 
-// Convert converts the image into multiple icon sizes and compresses them into a zip file.
-func Convert(imagePath string) (string, error) {
-	outputDir := util.GenerateUUIDv4()
-	dirPath := filepath.Join(storageDirectory, outputDir)
+// CursorSizes are the sizes written into CUR files.
+var CursorSizes = []image.Point{
+	{32, 32},
+	{48, 48},
+	{64, 64},
+	{128, 128},
+}
+
+// OutputFormat selects how the converted icons are packaged.
+type OutputFormat string
+
+const (
+	// OutputICO produces a single .ico file containing every size.
+	OutputICO OutputFormat = "ico"
+	// OutputICOZip produces one .ico file per size, compressed into a zip file.
+	OutputICOZip OutputFormat = "ico-zip"
+	// OutputICNS produces a single macOS .icns file.
+	OutputICNS OutputFormat = "icns"
+	// OutputCUR produces a single Windows .cur file.
+	OutputCUR OutputFormat = "cur"
+)
 
-	// Create the directory for storing converted icons
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return "", fmt.Errorf("could not create output directory: %v", err)
+// DefaultOutputFormat is used when no output format is requested.
+const DefaultOutputFormat = OutputICO
+
+// ParseOutputFormat validates an output format, falling back to DefaultOutputFormat when empty.
+func ParseOutputFormat(format string) (OutputFormat, error) {
+	switch OutputFormat(format) {
+	case "":
+		return DefaultOutputFormat, nil
+	case OutputICO, OutputICOZip, OutputICNS, OutputCUR:
+		return OutputFormat(format), nil
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", format)
 	}
+}
 
-	// Open and decode the image file
-	imageFile, err := os.Open(imagePath)
+// ConvertOptions holds the options used to convert an image into icons.
+type ConvertOptions struct {
+	Format OutputFormat  // Packaging of the icons, defaults to DefaultOutputFormat
+	Resize ResizeOptions // How the image is resized to each icon size
+	// Hotspot of cursors in pixels of the source image, defaults to the top left corner
+	Hotspot image.Point
+	// Pipeline is applied to the image before it is resized
+	Pipeline Pipeline
+}
+
+// Convert converts the image into multiple icon sizes and stores them in the requested format.
+// It returns the name of the stored file, which is used as the download ID.
+func Convert(imagePath string, options ConvertOptions) (string, error) {
+	// Open, decode and process the image file
+	img, err := loadImage(imagePath, options.Pipeline)
 	if err != nil {
-		return "", fmt.Errorf("could not open image file: %v", err)
+		return "", err
 	}
-	defer imageFile.Close()
 
-	img, _, err := image.Decode(imageFile)
+	switch options.Format {
+	case "", OutputICO:
+		return storeIcon(".ico", func(w io.Writer) error {
+			return EncodeICO(w, resizeAll(img, Sizes, options.Resize))
+		})
+	case OutputICOZip:
+		return storeZip(func(z *util.ZipWriter) error {
+			return writeICOZip(z, resizeAll(img, Sizes, options.Resize))
+		})
+	case OutputICNS:
+		return storeIcon(".icns", func(w io.Writer) error {
+			return EncodeICNS(w, resizeAll(img, ICNSSizes, options.Resize))
+		})
+	case OutputCUR:
+		// The hotspot follows the same fit as the resize, so padding and cropping move it too
+		hotspots := make([]image.Point, 0, len(CursorSizes))
+		for _, size := range CursorSizes {
+			hotspots = append(hotspots, resizedPoint(img.Bounds().Min.Add(options.Hotspot), img.Bounds(), size, options.Resize))
+		}
+		return storeIcon(".cur", func(w io.Writer) error {
+			return encodeCUR(w, resizeAll(img, CursorSizes, options.Resize), hotspots)
+		})
+	default:
+		return "", fmt.Errorf("unsupported output format: %s", options.Format)
+	}
+}
+
+// resizeAll resizes the image to every size.
+func resizeAll(img image.Image, sizes []image.Point, options ResizeOptions) []image.Image {
+	images := make([]image.Image, 0, len(sizes))
+	for _, size := range sizes {
+		images = append(images, Resize(img, size, options))
+	}
+	return images
+}
+
+// storeIcon writes a single icon file with the given extension using the encode function.
+// The partial file is removed if encoding or writing fails.
+func storeIcon(extension string, encode func(w io.Writer) error) (string, error) {
+	name := util.GenerateUUIDv4() + extension
+	path := filepath.Join(storageDirectory, name)
+
+	file, err := os.Create(path)
 	if err != nil {
-		return "", fmt.Errorf("could not decode image: %v", err)
+		return "", fmt.Errorf("could not create output file: %v", err)
 	}
 
-	// Resize and save the icons
-	for _, size := range Sizes {
-		resizedImg := resizeImage(img, size)
-		rgbaImg := convertToRGBA(resizedImg)
+	if err := encode(file); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("could not encode image: %v", err)
+	}
 
-		icoFilePath := filepath.Join(dirPath, fmt.Sprintf("%dx%d.ico", size.X, size.Y))
-		icoFile, err := os.Create(icoFilePath)
-		if err != nil {
-			return "", fmt.Errorf("could not create output file: %v", err)
-		}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("could not write output file: %v", err)
+	}
 
-		if err := ico.Encode(icoFile, rgbaImg); err != nil {
-			icoFile.Close()
-			return "", fmt.Errorf("could not encode image: %v", err)
+	return name, nil
+}
+
+// WriteICOZip converts the image into one .ico file per size and streams them into the zip writer.
+func WriteICOZip(z *util.ZipWriter, imagePath string, options ConvertOptions) error {
+	img, err := loadImage(imagePath, options.Pipeline)
+	if err != nil {
+		return err
+	}
+
+	return writeICOZip(z, resizeAll(img, Sizes, options.Resize))
+}
+
+// writeICOZip writes every icon as its own .ico file into the zip writer.
+func writeICOZip(z *util.ZipWriter, icons []image.Image) error {
+	for _, icon := range icons {
+		bounds := icon.Bounds()
+		name := fmt.Sprintf("%dx%d.ico", bounds.Dx(), bounds.Dy())
+		if err := z.WriteFile(name, func(w io.Writer) error { return EncodeICO(w, []image.Image{icon}) }); err != nil {
+			return err
 		}
-		icoFile.Close()
 	}
 
-	// Compress the directory into a zip file and remove the icons folder
-	zipName := outputDir + ".zip"
-	if err := util.CompressDirectoryAndDelete(dirPath, storageDirectory, zipName); err != nil {
-		return "", fmt.Errorf("could not compress directory: %v", err)
+	return nil
+}
+
+// storeZip streams a zip file into storage using the write function.
+// The partial file is removed if writing fails.
+func storeZip(write func(z *util.ZipWriter) error) (string, error) {
+	zipName := util.GenerateUUIDv4() + ".zip"
+
+	z, err := util.CreateZipFile(filepath.Join(storageDirectory, zipName))
+	if err != nil {
+		return "", err
+	}
+
+	if err := write(z); err != nil {
+		z.Abort()
+		return "", err
+	}
+
+	if err := z.Close(); err != nil {
+		os.Remove(filepath.Join(storageDirectory, zipName))
+		return "", fmt.Errorf("could not write zip file: %v", err)
 	}
 
 	return zipName, nil
 }
 
-// GetConvertedZipPath returns the path to the converted zip file and optionally deletes it.
-func GetConvertedZipPath(zipName string) (string, error) {
-	// Ensure the zipName ends with .zip
-	if filepath.Ext(zipName) != ".zip" {
-		zipName += ".zip"
+// GetConvertedFilePath returns the path to a converted file.
+// Download IDs without an extension are assumed to refer to a zip file.
+func GetConvertedFilePath(name string) (string, error) {
+	if filepath.Ext(name) == "" {
+		name += ".zip"
 	}
 
-	zipPath := filepath.Join(storageDirectory, zipName)
-	if _, err := os.Stat(zipPath); os.IsNotExist(err) {
+	filePath := filepath.Join(storageDirectory, filepath.Base(name))
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		return "", fmt.Errorf("file does not exist: %v", err)
 	}
 
-	return zipPath, nil
+	return filePath, nil
 }
 
-func DeleteConvertedZip(zipName string) error {
-	if err := os.Remove(filepath.Join(storageDirectory, zipName)); err != nil {
-		return fmt.Errorf("could not delete zip file: %v", err)
+// DeleteConvertedFile removes a converted file from storage.
+func DeleteConvertedFile(name string) error {
+	filePath, err := GetConvertedFilePath(name)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-// Utility functions for image conversion and resizing
-func convertToRGBA(img image.Image) *image.RGBA {
-	rgba := image.NewRGBA(img.Bounds())
-	draw.Draw(rgba, rgba.Bounds(), img, image.Point{0, 0}, draw.Src)
-	return rgba
-}
+	if err := os.Remove(filePath); err != nil {
+		return fmt.Errorf("could not delete converted file: %v", err)
+	}
 
-func resizeImage(img image.Image, size image.Point) image.Image {
-	resizedImg := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
-	draw.NearestNeighbor.Scale(resizedImg, resizedImg.Bounds(), img, img.Bounds(), draw.Src, nil)
-	return resizedImg
+	return nil
 }
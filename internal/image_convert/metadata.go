@@ -0,0 +1,255 @@
+package image_convert
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"rory-pearson/pkg/exif"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxColorProfileSize limits the size of decompressed ICC profiles.
+	maxColorProfileSize = 4 << 20
+	// jpegICCChunkSize is the largest profile chunk that fits into a single APP2 segment.
+	jpegICCChunkSize = 65535 - 2 - len(jpegICCHeader) - 2
+)
+
+const jpegICCHeader = "ICC_PROFILE\x00"
+
+// ApplyOrientation rotates and flips the image so it is displayed upright,
+// according to an EXIF orientation value.
+func ApplyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= exif.OrientationNormal || orientation > exif.OrientationRotate270 {
+		return img
+	}
+
+	nrgba := toNRGBA(img)
+	switch orientation {
+	case exif.OrientationFlipHorizontal:
+		return flip(nrgba, true)
+	case exif.OrientationRotate180:
+		return rotate(nrgba, 180)
+	case exif.OrientationFlipVertical:
+		return flip(nrgba, false)
+	case exif.OrientationTranspose:
+		return flip(rotate(nrgba, 90), true)
+	case exif.OrientationRotate90:
+		return rotate(nrgba, 90)
+	case exif.OrientationTransverse:
+		return flip(rotate(nrgba, 270), true)
+	case exif.OrientationRotate270:
+		return rotate(nrgba, 270)
+	}
+
+	return img
+}
+
+// decodeImageData decodes an image and applies its EXIF orientation.
+func decodeImageData(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return ApplyOrientation(img, exif.ReadOrientation(bytes.NewReader(data))), nil
+}
+
+// NormalizeFile rewrites an image file as a PNG with its EXIF orientation applied
+// and all metadata removed. It returns the path of the new file and removes the original.
+// Files in formats that can not be decoded are left untouched.
+func NormalizeFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read image file: %v", err)
+	}
+
+	img, err := decodeImageData(data)
+	if errors.Is(err, image.ErrFormat) {
+		return path, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not decode image: %v", err)
+	}
+
+	normalizedPath := strings.TrimSuffix(path, filepath.Ext(path)) + FormatPNG.Extension()
+	if normalizedPath == path {
+		normalizedPath = strings.TrimSuffix(path, filepath.Ext(path)) + "_normalized" + FormatPNG.Extension()
+	}
+
+	file, err := os.Create(normalizedPath)
+	if err != nil {
+		return "", fmt.Errorf("could not create normalized file: %v", err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		os.Remove(normalizedPath)
+		return "", fmt.Errorf("could not encode normalized image: %v", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+
+	return normalizedPath, nil
+}
+
+// ReadColorProfile returns the ICC colour profile embedded in a JPEG or PNG file, or nil if there is none.
+func ReadColorProfile(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return readJPEGColorProfile(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return readPNGColorProfile(data)
+	default:
+		return nil
+	}
+}
+
+// readJPEGColorProfile joins the profile chunks of the APP2 ICC_PROFILE segments in order.
+func readJPEGColorProfile(data []byte) []byte {
+	chunks := make(map[int][]byte)
+	for offset := 2; offset+4 <= len(data) && data[offset] == 0xFF; {
+		marker := data[offset+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		// The length includes its own two bytes
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+
+		segment := data[offset+4 : end]
+		if marker == 0xE2 && len(segment) > len(jpegICCHeader)+2 && strings.HasPrefix(string(segment), jpegICCHeader) {
+			sequence := int(segment[len(jpegICCHeader)])
+			chunks[sequence] = segment[len(jpegICCHeader)+2:]
+		}
+		offset = end
+	}
+
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	sequences := make([]int, 0, len(chunks))
+	for sequence := range chunks {
+		sequences = append(sequences, sequence)
+	}
+	sort.Ints(sequences)
+
+	var profile []byte
+	for _, sequence := range sequences {
+		profile = append(profile, chunks[sequence]...)
+	}
+	return profile
+}
+
+// readPNGColorProfile decompresses the profile of the iCCP chunk.
+func readPNGColorProfile(data []byte) []byte {
+	for offset := len(pngSignature); offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		end := offset + 12 + length
+		if length < 0 || end > len(data) {
+			return nil
+		}
+
+		switch string(data[offset+4 : offset+8]) {
+		case "iCCP":
+			// Profile name, NUL separator, compression method and the zlib stream
+			chunk := data[offset+8 : offset+8+length]
+			separator := bytes.IndexByte(chunk, 0)
+			if separator < 0 || separator+2 > len(chunk) {
+				return nil
+			}
+
+			r, err := zlib.NewReader(bytes.NewReader(chunk[separator+2:]))
+			if err != nil {
+				return nil
+			}
+			defer r.Close()
+
+			profile, err := io.ReadAll(io.LimitReader(r, maxColorProfileSize))
+			if err != nil {
+				return nil
+			}
+			return profile
+		case "IDAT", "IEND":
+			// The profile must come before the image data
+			return nil
+		}
+		offset = end
+	}
+
+	return nil
+}
+
+// embedColorProfile adds an ICC colour profile to encoded PNG or JPEG data.
+// Other formats are returned unchanged.
+func embedColorProfile(data []byte, format ImageFormat, profile []byte) ([]byte, error) {
+	switch format {
+	case FormatPNG:
+		return embedPNGColorProfile(data, profile)
+	case FormatJPEG:
+		return embedJPEGColorProfile(data, profile), nil
+	default:
+		return data, nil
+	}
+}
+
+// embedPNGColorProfile inserts an iCCP chunk directly after the IHDR chunk.
+func embedPNGColorProfile(data []byte, profile []byte) ([]byte, error) {
+	ihdrEnd := len(pngSignature) + 12 + 13
+	if len(data) < ihdrEnd || string(data[len(pngSignature)+4:len(pngSignature)+8]) != "IHDR" {
+		return nil, errors.New("invalid png data")
+	}
+
+	chunk := new(bytes.Buffer)
+	chunk.WriteString("iCCP")
+	chunk.WriteString("ICC Profile\x00\x00") // Name, separator and zlib compression method
+	zw := zlib.NewWriter(chunk)
+	zw.Write(profile)
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("could not compress colour profile: %v", err)
+	}
+
+	output := bytes.NewBuffer(make([]byte, 0, len(data)+chunk.Len()+8))
+	output.Write(data[:ihdrEnd])
+	binary.Write(output, binary.BigEndian, uint32(chunk.Len()-4))
+	output.Write(chunk.Bytes())
+	binary.Write(output, binary.BigEndian, crc32.ChecksumIEEE(chunk.Bytes()))
+	output.Write(data[ihdrEnd:])
+
+	return output.Bytes(), nil
+}
+
+// embedJPEGColorProfile inserts the profile as APP2 segments directly after the SOI marker.
+func embedJPEGColorProfile(data []byte, profile []byte) []byte {
+	count := (len(profile) + jpegICCChunkSize - 1) / jpegICCChunkSize
+
+	output := bytes.NewBuffer(make([]byte, 0, len(data)+len(profile)+count*18))
+	output.Write(data[:2])
+	for i := 0; i < count; i++ {
+		chunk := profile[i*jpegICCChunkSize : min((i+1)*jpegICCChunkSize, len(profile))]
+		output.Write([]byte{0xFF, 0xE2})
+		binary.Write(output, binary.BigEndian, uint16(2+len(jpegICCHeader)+2+len(chunk)))
+		output.WriteString(jpegICCHeader)
+		output.Write([]byte{byte(i + 1), byte(count)})
+		output.Write(chunk)
+	}
+	output.Write(data[2:])
+
+	return output.Bytes()
+}
@@ -0,0 +1,159 @@
+package image_convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"rory-pearson/pkg/exif"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// jpegWithOrientation encodes the image as a JPEG carrying an EXIF orientation tag.
+func jpegWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+
+	encoded := new(bytes.Buffer)
+	assert.NoError(t, jpeg.Encode(encoded, img, &jpeg.Options{Quality: 100}))
+
+	// Little endian TIFF header with a single IFD holding the orientation
+	tiff := new(bytes.Buffer)
+	tiff.WriteString("II*\x00")
+	binary.Write(tiff, binary.LittleEndian, uint32(8))
+	binary.Write(tiff, binary.LittleEndian, uint16(1))
+	binary.Write(tiff, binary.LittleEndian, []uint16{0x0112, 3})
+	binary.Write(tiff, binary.LittleEndian, uint32(1))
+	binary.Write(tiff, binary.LittleEndian, []uint16{orientation, 0})
+	binary.Write(tiff, binary.LittleEndian, uint32(0))
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	data := new(bytes.Buffer)
+	data.Write(encoded.Bytes()[:2])
+	data.Write([]byte{0xFF, 0xE1})
+	binary.Write(data, binary.BigEndian, uint16(len(payload)+2))
+	data.Write(payload)
+	data.Write(encoded.Bytes()[2:])
+
+	return data.Bytes()
+}
+
+func TestApplyOrientation(t *testing.T) {
+	for _, expected := range []struct {
+		Orientation int
+		Size        image.Point
+		Marker      image.Point
+	}{
+		{exif.OrientationNormal, image.Point{4, 2}, image.Point{0, 0}},
+		{exif.OrientationFlipHorizontal, image.Point{4, 2}, image.Point{3, 0}},
+		{exif.OrientationRotate180, image.Point{4, 2}, image.Point{3, 1}},
+		{exif.OrientationFlipVertical, image.Point{4, 2}, image.Point{0, 1}},
+		{exif.OrientationTranspose, image.Point{2, 4}, image.Point{0, 0}},
+		{exif.OrientationRotate90, image.Point{2, 4}, image.Point{1, 0}},
+		{exif.OrientationTransverse, image.Point{2, 4}, image.Point{1, 3}},
+		{exif.OrientationRotate270, image.Point{2, 4}, image.Point{0, 3}},
+	} {
+		oriented := toNRGBA(ApplyOrientation(markedImage(), expected.Orientation))
+		assert.Equal(t, expected.Size, oriented.Rect.Size(), "orientation %d", expected.Orientation)
+		assert.Equal(t, color.NRGBA{R: 255, A: 255}, oriented.NRGBAAt(expected.Marker.X, expected.Marker.Y), "orientation %d", expected.Orientation)
+	}
+}
+
+func TestDecodeImageFileOrientation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	assert.NoError(t, os.WriteFile(path, jpegWithOrientation(t, testImage(40, 20), exif.OrientationRotate90), 0644))
+
+	img, err := decodeImageFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Point{20, 40}, img.Bounds().Size(), "image should be rotated upright")
+}
+
+func TestNormalizeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photo.jpg")
+	assert.NoError(t, os.WriteFile(path, jpegWithOrientation(t, testImage(40, 20), exif.OrientationRotate270), 0644))
+
+	normalizedPath, err := NormalizeFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "photo.png"), normalizedPath)
+	assert.NoFileExists(t, path, "original file should be removed")
+
+	data, err := os.ReadFile(normalizedPath)
+	assert.NoError(t, err)
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 20, config.Width)
+
+	_, err = exif.Decode(bytes.NewReader(data))
+	assert.ErrorIs(t, err, exif.ErrorNoExif, "metadata should be stripped")
+
+	// Files that can not be decoded are left as they are
+	unknown := filepath.Join(dir, "photo.heic")
+	assert.NoError(t, os.WriteFile(unknown, []byte("not an image"), 0644))
+	normalizedPath, err = NormalizeFile(unknown)
+	assert.NoError(t, err)
+	assert.Equal(t, unknown, normalizedPath)
+}
+
+func TestColorProfileRoundTrip(t *testing.T) {
+	// Large enough to be split over multiple JPEG segments
+	profile := make([]byte, 70000)
+	for i := range profile {
+		profile[i] = byte(i * 7)
+	}
+
+	for _, format := range []ImageFormat{FormatPNG, FormatJPEG} {
+		buf := new(bytes.Buffer)
+		assert.NoError(t, Encode(buf, testImage(16, 16), format, EncodeOptions{ColorProfile: profile}))
+
+		assert.Equal(t, profile, ReadColorProfile(buf.Bytes()), "%s should keep the profile", format)
+
+		_, _, err := image.Decode(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err, "%s should still decode", format)
+	}
+
+	// Outputs have no profile unless one is given
+	buf := new(bytes.Buffer)
+	assert.NoError(t, Encode(buf, testImage(16, 16), FormatPNG, EncodeOptions{}))
+	assert.Nil(t, ReadColorProfile(buf.Bytes()))
+}
+
+func TestReadColorProfileMalformed(t *testing.T) {
+	for _, data := range [][]byte{
+		{0xFF, 0xD8, 0xFF, 0xE2, 0x00, 0x00},             // Segment length shorter than the length field
+		{0xFF, 0xD8, 0xFF, 0xE2, 0x00, 0x01},             // Segment length shorter than the length field
+		{0xFF, 0xD8, 0xFF, 0xE2, 0x00, 0x10, 0x49, 0x43}, // Segment runs past the end of the data
+		{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n', 0xFF, 0xFF, 0xFF, 0xFF, 'i', 'C', 'C', 'P'},
+	} {
+		assert.NotPanics(t, func() {
+			assert.Nil(t, ReadColorProfile(data))
+		}, "% x", data)
+	}
+}
+
+func TestConvertFormatStripsMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	assert.NoError(t, os.WriteFile(path, jpegWithOrientation(t, testImage(40, 20), exif.OrientationRotate90), 0644))
+
+	name, err := ConvertFormat([]InputFile{{Name: "photo.jpg", Path: path}}, FormatJPEG, EncodeOptions{KeepColorProfile: true}, nil)
+	assert.NoError(t, err)
+	defer DeleteConvertedFile(name)
+
+	outputPath, err := GetConvertedFilePath(name)
+	assert.NoError(t, err)
+	data, err := os.ReadFile(outputPath)
+	assert.NoError(t, err)
+
+	_, err = exif.Decode(bytes.NewReader(data))
+	assert.ErrorIs(t, err, exif.ErrorNoExif, "exif should be stripped")
+	assert.Nil(t, ReadColorProfile(data), "input has no profile to keep")
+
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 20, config.Width, "output should be rotated upright")
+}
@@ -0,0 +1,301 @@
+package image_convert
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"rory-pearson/pkg/util"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// DefaultOptimizeColors is the palette size PNG outputs are reduced to when no size is requested.
+	DefaultOptimizeColors = 256
+)
+
+var (
+	ErrorUnsupportedOptimize = errors.New("only PNG, JPEG and ICO files can be optimized")
+	ErrorTargetSizeTooSmall  = errors.New("jpeg can not be encoded under the target size")
+)
+
+// OptimizeOptions controls how outputs are made smaller.
+type OptimizeOptions struct {
+	Colors   int  // PNG palette size from 2 to 256
+	Lossless bool // Only recompress PNGs, keeping every colour
+	NoDither bool // Map PNG colours to the nearest palette entry without diffusing the error
+
+	Quality    int   // JPEG quality from 1 to 100, the highest quality tried when a target size is set
+	TargetSize int64 // Largest JPEG size in bytes, the highest quality that fits is used
+}
+
+// OptimizeResult reports how much smaller an optimized file got.
+type OptimizeResult struct {
+	Name          string      `json:"name,omitempty"`
+	Format        ImageFormat `json:"format"`
+	OriginalSize  int64       `json:"original_size"`
+	OptimizedSize int64       `json:"optimized_size"`
+	BytesSaved    int64       `json:"bytes_saved"`
+	Quality       int         `json:"quality,omitempty"` // JPEG quality used
+}
+
+// Validate checks the options and fills in defaults.
+func (o *OptimizeOptions) Validate() error {
+	if o.Colors == 0 {
+		o.Colors = DefaultOptimizeColors
+	}
+	if o.Colors < 2 || o.Colors > 256 {
+		return fmt.Errorf("optimize colours must be between 2 and 256")
+	}
+	if o.Quality == 0 {
+		o.Quality = DefaultJPEGQuality
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		return fmt.Errorf("jpeg quality must be between 1 and 100")
+	}
+	if o.TargetSize < 0 {
+		return fmt.Errorf("target size can not be negative")
+	}
+	return nil
+}
+
+// EncodeOptimizedPNG quantizes the image to a palette, keeping its alpha, and writes it
+// with the best zlib compression.
+func EncodeOptimizedPNG(w io.Writer, img image.Image, options OptimizeOptions) error {
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if options.Lossless {
+		return encoder.Encode(w, img)
+	}
+
+	bounds := img.Bounds()
+	palette := MedianCutQuantizer{KeepAlpha: true}.Quantize(make(color.Palette, 0, options.Colors), img)
+	if len(palette) == 0 {
+		return encoder.Encode(w, img)
+	}
+
+	paletted := image.NewPaletted(bounds, palette)
+	var drawer draw.Drawer = draw.FloydSteinberg
+	if options.NoDither {
+		drawer = draw.Src
+	}
+	drawer.Draw(paletted, bounds, img, bounds.Min)
+
+	return encoder.Encode(w, paletted)
+}
+
+// EncodeOptimizedJPEG writes the image as a JPEG at the requested quality, or at the highest
+// quality that stays under the target size. It returns the quality used.
+func EncodeOptimizedJPEG(w io.Writer, img image.Image, options OptimizeOptions) (int, error) {
+	if err := options.Validate(); err != nil {
+		return 0, err
+	}
+
+	if options.TargetSize == 0 {
+		return options.Quality, jpeg.Encode(w, img, &jpeg.Options{Quality: options.Quality})
+	}
+
+	// The size grows with the quality, so search for the highest quality that fits
+	var best []byte
+	quality := 0
+	lo, hi := 1, options.Quality
+	for lo <= hi {
+		mid := (lo + hi) / 2
+
+		buf := new(bytes.Buffer)
+		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: mid}); err != nil {
+			return 0, err
+		}
+
+		if int64(buf.Len()) <= options.TargetSize {
+			best, quality = buf.Bytes(), mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	if best == nil {
+		return 0, ErrorTargetSizeTooSmall
+	}
+
+	_, err := w.Write(best)
+	return quality, err
+}
+
+// Optimize re-encodes PNG, JPEG and ICO data to make it smaller. The original data is
+// returned when optimizing does not save anything.
+func Optimize(data []byte, options OptimizeOptions) ([]byte, OptimizeResult, error) {
+	if err := options.Validate(); err != nil {
+		return nil, OptimizeResult{}, err
+	}
+
+	_, name, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, OptimizeResult{}, fmt.Errorf("could not decode image: %v", err)
+	}
+
+	result := OptimizeResult{OriginalSize: int64(len(data))}
+	buf := new(bytes.Buffer)
+
+	switch name {
+	case "png":
+		result.Format = FormatPNG
+
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, result, fmt.Errorf("could not decode image: %v", err)
+		}
+		if err := EncodeOptimizedPNG(buf, img, options); err != nil {
+			return nil, result, err
+		}
+	case "jpeg":
+		result.Format = FormatJPEG
+
+		// Re-encoding drops the EXIF data, so the orientation is applied to the pixels
+		img, err := decodeImageData(data)
+		if err != nil {
+			return nil, result, err
+		}
+		if result.Quality, err = EncodeOptimizedJPEG(buf, img, options); err != nil {
+			return nil, result, err
+		}
+	case "ico":
+		result.Format = FormatICO
+
+		// Cursors are not supported as their hotspots would be lost
+		if data[2] != iconTypeIcon {
+			return nil, result, ErrorUnsupportedOptimize
+		}
+
+		images, err := DecodeICO(bytes.NewReader(data))
+		if err != nil {
+			return nil, result, err
+		}
+		err = encodeICO(buf, images, func(w io.Writer, img image.Image) error {
+			return EncodeOptimizedPNG(w, img, options)
+		})
+		if err != nil {
+			return nil, result, err
+		}
+	default:
+		return nil, result, ErrorUnsupportedOptimize
+	}
+
+	// Recompressing an already small file can come out larger, and a smaller original
+	// also meets any target size
+	if buf.Len() >= len(data) {
+		result.OptimizedSize = result.OriginalSize
+		result.Quality = 0
+		return data, result, nil
+	}
+
+	result.OptimizedSize = int64(buf.Len())
+	result.BytesSaved = result.OriginalSize - result.OptimizedSize
+	return buf.Bytes(), result, nil
+}
+
+// OptimizeFile optimizes the file in place.
+func OptimizeFile(path string, options OptimizeOptions) (OptimizeResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return OptimizeResult{}, fmt.Errorf("could not read file: %v", err)
+	}
+
+	optimized, result, err := Optimize(data, options)
+	if err != nil {
+		return result, err
+	}
+
+	if result.BytesSaved > 0 {
+		if err := os.WriteFile(path, optimized, 0644); err != nil {
+			return result, fmt.Errorf("could not write optimized file: %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// OptimizeFiles optimizes every input file. A single file is stored as is, multiple files
+// are stored as a zip file. It returns the name of the stored file and the result for every input.
+func OptimizeFiles(files []InputFile, options OptimizeOptions) (string, []OptimizeResult, error) {
+	if len(files) == 0 {
+		return "", nil, ErrorNoImages
+	}
+
+	var results []OptimizeResult
+	if len(files) > 1 {
+		name, err := storeZip(func(z *util.ZipWriter) error {
+			var err error
+			results, err = WriteOptimizedZip(z, files, options)
+			return err
+		})
+		return name, results, err
+	}
+
+	optimized, result, err := optimizeInputFile(files[0], options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := util.GenerateUUIDv4() + result.Format.Extension()
+	if err := os.WriteFile(filepath.Join(storageDirectory, name), optimized, 0644); err != nil {
+		return "", nil, fmt.Errorf("could not create output file: %v", err)
+	}
+
+	return name, []OptimizeResult{result}, nil
+}
+
+// WriteOptimizedZip optimizes every input file and streams them into the zip writer.
+func WriteOptimizedZip(z *util.ZipWriter, files []InputFile, options OptimizeOptions) ([]OptimizeResult, error) {
+	if len(files) == 0 {
+		return nil, ErrorNoImages
+	}
+
+	results := make([]OptimizeResult, 0, len(files))
+	used := make(map[string]int)
+	for _, file := range files {
+		optimized, result, err := optimizeInputFile(file, options)
+		if err != nil {
+			return nil, err
+		}
+
+		name := outputName(file.Name, result.Format, used)
+		if err := z.WriteFile(name, func(w io.Writer) error {
+			_, err := w.Write(optimized)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// optimizeInputFile reads and optimizes a single input file.
+func optimizeInputFile(file InputFile, options OptimizeOptions) ([]byte, OptimizeResult, error) {
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		return nil, OptimizeResult{}, fmt.Errorf("%s: %v", file.Name, err)
+	}
+
+	optimized, result, err := Optimize(data, options)
+	if err != nil {
+		return nil, result, fmt.Errorf("%s: %v", file.Name, err)
+	}
+
+	result.Name = file.Name
+	return optimized, result, nil
+}
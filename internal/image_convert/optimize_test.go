@@ -0,0 +1,125 @@
+package image_convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noisyImage returns a gradient with noise, like a photo, whose alpha fades from opaque
+// to transparent when translucent is set.
+func noisyImage(width, height int, translucent bool) *image.NRGBA {
+	random := rand.New(rand.NewSource(1))
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			noise := uint8(random.Intn(24))
+			c := color.NRGBA{R: uint8(x*200/width) + noise, G: uint8(y*200/height) + noise, B: 150 + noise, A: 255}
+			if translucent {
+				c.A = uint8(255 - x*255/width)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestMedianCutQuantizerKeepAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 255, A: 100})
+
+	palette := MedianCutQuantizer{KeepAlpha: true}.Quantize(make(color.Palette, 0, 8), img)
+	assert.Equal(t, color.Palette{
+		color.NRGBA{},
+		color.NRGBA{R: 255, A: 100},
+		color.NRGBA{R: 255, A: 255},
+	}, palette, "translucent pixels keep their alpha")
+
+	palette = MedianCutQuantizer{}.Quantize(make(color.Palette, 0, 8), img)
+	assert.Equal(t, color.Palette{color.NRGBA{R: 255, A: 255}}, palette)
+}
+
+func TestOptimizePNG(t *testing.T) {
+	img := noisyImage(128, 128, true)
+	original := new(bytes.Buffer)
+	assert.NoError(t, png.Encode(original, img))
+
+	optimized, result, err := Optimize(original.Bytes(), OptimizeOptions{Colors: 64})
+	assert.NoError(t, err)
+	assert.Equal(t, FormatPNG, result.Format)
+	assert.Equal(t, int64(original.Len()), result.OriginalSize)
+	assert.Equal(t, int64(len(optimized)), result.OptimizedSize)
+	assert.Equal(t, result.OriginalSize-result.OptimizedSize, result.BytesSaved)
+	assert.Greater(t, result.BytesSaved, int64(0))
+
+	decoded, err := png.Decode(bytes.NewReader(optimized))
+	assert.NoError(t, err)
+	paletted, ok := decoded.(*image.Paletted)
+	if assert.True(t, ok, "optimized PNGs use a palette") {
+		assert.LessOrEqual(t, len(paletted.Palette), 64)
+	}
+
+	// Alpha survives quantization
+	opaque := color.NRGBAModel.Convert(decoded.At(0, 64)).(color.NRGBA)
+	faded := color.NRGBAModel.Convert(decoded.At(120, 64)).(color.NRGBA)
+	assert.Greater(t, opaque.A, uint8(220))
+	assert.Less(t, faded.A, uint8(40))
+
+	// Recompressing a file that is already as small as it gets keeps the data
+	again, result, err := Optimize(optimized, OptimizeOptions{Lossless: true})
+	assert.NoError(t, err)
+	assert.Equal(t, optimized, again)
+	assert.Zero(t, result.BytesSaved)
+	assert.Equal(t, result.OriginalSize, result.OptimizedSize)
+}
+
+func TestOptimizeJPEGTargetSize(t *testing.T) {
+	img := testImage(256, 256)
+	original := new(bytes.Buffer)
+	assert.NoError(t, jpeg.Encode(original, img, &jpeg.Options{Quality: 100}))
+
+	target := int64(original.Len() / 4)
+	optimized, result, err := Optimize(original.Bytes(), OptimizeOptions{TargetSize: target})
+	assert.NoError(t, err)
+	assert.Equal(t, FormatJPEG, result.Format)
+	assert.LessOrEqual(t, int64(len(optimized)), target)
+	assert.Greater(t, result.Quality, 0)
+
+	_, _, err = Optimize(original.Bytes(), OptimizeOptions{TargetSize: 100})
+	assert.ErrorIs(t, err, ErrorTargetSizeTooSmall)
+}
+
+func TestOptimizeICO(t *testing.T) {
+	original := new(bytes.Buffer)
+	assert.NoError(t, EncodeICO(original, []image.Image{noisyImage(256, 256, false), testImage(32, 32)}))
+
+	optimized, result, err := Optimize(original.Bytes(), OptimizeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, FormatICO, result.Format)
+	assert.Greater(t, result.BytesSaved, int64(0))
+
+	images, err := DecodeICO(bytes.NewReader(optimized))
+	assert.NoError(t, err)
+	assert.Len(t, images, 2)
+	assert.Equal(t, image.Pt(256, 256), images[0].Bounds().Size())
+}
+
+func TestOptimizeUnsupported(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, gif.Encode(buf, testImage(8, 8), nil))
+
+	_, _, err := Optimize(buf.Bytes(), OptimizeOptions{})
+	assert.ErrorIs(t, err, ErrorUnsupportedOptimize)
+
+	_, _, err = Optimize(buf.Bytes(), OptimizeOptions{Colors: 300})
+	assert.Error(t, err)
+}
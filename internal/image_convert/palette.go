@@ -0,0 +1,234 @@
+package image_convert
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// PaletteMethod is the algorithm used to find the dominant colours of an image.
+type PaletteMethod string
+
+const (
+	PaletteMedianCut PaletteMethod = "median-cut"
+	PaletteKMeans    PaletteMethod = "kmeans"
+)
+
+const (
+	// DefaultPaletteColors is the number of colours extracted when no count is requested.
+	DefaultPaletteColors = 6
+	// MaxPaletteColors is the largest number of colours that can be extracted.
+	MaxPaletteColors = 32
+	// paletteSampleSize is the size images are reduced to before their colours are counted.
+	paletteSampleSize = 128
+	// kMeansIterations limits the refinement passes of the k-means method.
+	kMeansIterations = 10
+	// DefaultSwatchWidth is the width of a rendered palette swatch when no width is requested.
+	DefaultSwatchWidth = 600
+	// MaxSwatchWidth is the widest palette swatch that can be rendered.
+	MaxSwatchWidth = 4096
+	// swatchHeight is the height of a rendered palette swatch.
+	swatchHeight = 100
+)
+
+var ErrorNoOpaquePixels = errors.New("image has no opaque pixels")
+
+// PaletteColor is a dominant colour of an image and the share of the opaque pixels it covers.
+type PaletteColor struct {
+	Hex        string  `json:"hex"`
+	RGB        [3]int  `json:"rgb"`
+	Percentage float64 `json:"percentage"`
+}
+
+// ParsePaletteMethod validates a palette method name. An empty name is median cut.
+func ParsePaletteMethod(method string) (PaletteMethod, error) {
+	switch PaletteMethod(strings.ToLower(method)) {
+	case "", PaletteMedianCut:
+		return PaletteMedianCut, nil
+	case PaletteKMeans, "k-means":
+		return PaletteKMeans, nil
+	default:
+		return "", fmt.Errorf("unsupported palette method: %s", method)
+	}
+}
+
+// ExtractPalette decodes an image file and returns its dominant colours, most common first.
+func ExtractPalette(imagePath string, count int, method PaletteMethod) ([]PaletteColor, error) {
+	img, err := loadImage(imagePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return Palette(img, count, method)
+}
+
+// Palette returns the dominant colours of the image, most common first.
+// Transparent pixels are ignored.
+func Palette(img image.Image, count int, method PaletteMethod) ([]PaletteColor, error) {
+	if count == 0 {
+		count = DefaultPaletteColors
+	}
+	if count < 1 || count > MaxPaletteColors {
+		return nil, fmt.Errorf("palette colours must be between 1 and %d", MaxPaletteColors)
+	}
+
+	histogram, _ := colorHistogram(samplePalette(img), false)
+	if len(histogram) == 0 {
+		return nil, ErrorNoOpaquePixels
+	}
+
+	boxes := medianCut(histogram, count)
+	centers := make([][3]float64, len(boxes))
+	counts := make([]int, len(boxes))
+	total := 0
+	for i, box := range boxes {
+		c := box.average()
+		centers[i] = [3]float64{float64(c.R), float64(c.G), float64(c.B)}
+		counts[i] = box.count
+		total += box.count
+	}
+
+	// k-means starts from the median cut colours and moves them to the centre of their clusters
+	if method == PaletteKMeans {
+		centers, counts = kMeans(histogram, centers)
+	}
+
+	palette := make([]PaletteColor, 0, len(centers))
+	for i, center := range centers {
+		if counts[i] == 0 {
+			continue
+		}
+
+		c := color.NRGBA{
+			R: uint8(math.Round(center[0])),
+			G: uint8(math.Round(center[1])),
+			B: uint8(math.Round(center[2])),
+			A: 255,
+		}
+		palette = append(palette, PaletteColor{
+			Hex:        fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B),
+			RGB:        [3]int{int(c.R), int(c.G), int(c.B)},
+			Percentage: math.Round(float64(counts[i])/float64(total)*10000) / 100,
+		})
+	}
+
+	sort.SliceStable(palette, func(i, j int) bool {
+		return palette[i].Percentage > palette[j].Percentage
+	})
+
+	return palette, nil
+}
+
+// samplePalette reduces large images so counting their colours stays fast.
+func samplePalette(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= paletteSampleSize && bounds.Dy() <= paletteSampleSize {
+		return img
+	}
+
+	size := containRect(bounds, image.Point{paletteSampleSize, paletteSampleSize}).Size()
+	sample := image.NewNRGBA(image.Rectangle{Max: size})
+	draw.ApproxBiLinear.Scale(sample, sample.Bounds(), img, bounds, draw.Src, nil)
+	return sample
+}
+
+// kMeans refines the cluster centres over the histogram, returning the final
+// centres and the number of pixels assigned to each.
+func kMeans(histogram []quantizeColor, centers [][3]float64) ([][3]float64, []int) {
+	assignments := make([]int, len(histogram))
+	counts := make([]int, len(centers))
+
+	for iteration := 0; iteration < kMeansIterations; iteration++ {
+		changed := iteration == 0
+		for i, c := range histogram {
+			nearest := nearestCenter(c.c, centers)
+			if nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][3]float64, len(centers))
+		counts = make([]int, len(centers))
+		for i, c := range histogram {
+			cluster := assignments[i]
+			for channel := 0; channel < 3; channel++ {
+				sums[cluster][channel] += float64(c.c[channel]) * float64(c.count)
+			}
+			counts[cluster] += c.count
+		}
+
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			for channel := 0; channel < 3; channel++ {
+				centers[i][channel] = sums[i][channel] / float64(counts[i])
+			}
+		}
+	}
+
+	return centers, counts
+}
+
+// nearestCenter returns the index of the centre closest to the colour.
+func nearestCenter(c [4]uint8, centers [][3]float64) int {
+	nearest, best := 0, math.MaxFloat64
+	for i, center := range centers {
+		distance := 0.0
+		for channel := 0; channel < 3; channel++ {
+			d := float64(c[channel]) - center[channel]
+			distance += d * d
+		}
+		if distance < best {
+			nearest, best = i, distance
+		}
+	}
+	return nearest
+}
+
+// EncodeSwatch renders the palette as a PNG strip where every colour's width matches its percentage.
+func EncodeSwatch(w io.Writer, palette []PaletteColor, width int) error {
+	if len(palette) == 0 {
+		return errors.New("palette is empty")
+	}
+	if width == 0 {
+		width = DefaultSwatchWidth
+	}
+	if width < len(palette) || width > MaxSwatchWidth {
+		return fmt.Errorf("swatch width must be between %d and %d", len(palette), MaxSwatchWidth)
+	}
+
+	swatch := image.NewNRGBA(image.Rect(0, 0, width, swatchHeight))
+
+	total := 0.0
+	for _, c := range palette {
+		total += c.Percentage
+	}
+
+	x, covered := 0, 0.0
+	for i, c := range palette {
+		covered += c.Percentage
+		end := int(math.Round(covered / total * float64(width)))
+		if i == len(palette)-1 {
+			end = width
+		}
+
+		fill := color.NRGBA{R: uint8(c.RGB[0]), G: uint8(c.RGB[1]), B: uint8(c.RGB[2]), A: 255}
+		draw.Draw(swatch, image.Rect(x, 0, end, swatchHeight), image.NewUniform(fill), image.Point{}, draw.Src)
+		x = end
+	}
+
+	return png.Encode(w, swatch)
+}
@@ -0,0 +1,89 @@
+package image_convert
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// splitImage returns a 10x10 image that is 70% red and 30% blue.
+func splitImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= 7 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestPalette(t *testing.T) {
+	for _, method := range []PaletteMethod{PaletteMedianCut, PaletteKMeans} {
+		palette, err := Palette(splitImage(), 2, method)
+		assert.NoError(t, err, method)
+		assert.Equal(t, []PaletteColor{
+			{Hex: "#ff0000", RGB: [3]int{255, 0, 0}, Percentage: 70},
+			{Hex: "#0000ff", RGB: [3]int{0, 0, 255}, Percentage: 30},
+		}, palette, method)
+	}
+}
+
+func TestPaletteIgnoresTransparentPixels(t *testing.T) {
+	img := splitImage()
+	for y := 0; y < 10; y++ {
+		img.SetNRGBA(0, y, color.NRGBA{})
+	}
+
+	palette, err := Palette(img, 2, PaletteMedianCut)
+	assert.NoError(t, err)
+	assert.Len(t, palette, 2)
+	assert.Equal(t, 66.67, palette[0].Percentage)
+
+	_, err = Palette(image.NewNRGBA(image.Rect(0, 0, 4, 4)), 2, PaletteMedianCut)
+	assert.ErrorIs(t, err, ErrorNoOpaquePixels)
+
+	_, err = Palette(img, MaxPaletteColors+1, PaletteMedianCut)
+	assert.Error(t, err)
+}
+
+func TestParsePaletteMethod(t *testing.T) {
+	method, err := ParsePaletteMethod("")
+	assert.NoError(t, err)
+	assert.Equal(t, PaletteMedianCut, method)
+
+	method, err = ParsePaletteMethod("K-Means")
+	assert.NoError(t, err)
+	assert.Equal(t, PaletteKMeans, method)
+
+	_, err = ParsePaletteMethod("octree")
+	assert.Error(t, err)
+}
+
+func TestEncodeSwatch(t *testing.T) {
+	palette, err := Palette(splitImage(), 2, PaletteMedianCut)
+	assert.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, EncodeSwatch(buf, palette, 100))
+
+	swatch, err := png.Decode(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Pt(100, swatchHeight), swatch.Bounds().Size())
+
+	// Bars are as wide as the share of their colour
+	red := color.NRGBAModel.Convert(swatch.At(69, 0))
+	blue := color.NRGBAModel.Convert(swatch.At(70, 0))
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, red)
+	assert.Equal(t, color.NRGBA{B: 255, A: 255}, blue)
+
+	assert.Error(t, EncodeSwatch(buf, palette, MaxSwatchWidth+1))
+	assert.Error(t, EncodeSwatch(buf, nil, 0))
+}
@@ -0,0 +1,371 @@
+package image_convert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Operation types supported by the processing pipeline.
+const (
+	OperationCrop         = "crop"
+	OperationRotate       = "rotate"
+	OperationFlip         = "flip"
+	OperationGrayscale    = "grayscale"
+	OperationBrightness   = "brightness"
+	OperationContrast     = "contrast"
+	OperationTrim         = "trim"
+	OperationRoundCorners = "round_corners"
+	OperationPad          = "pad"
+)
+
+const (
+	// MaxPipelineOperations limits the number of operations in a single pipeline.
+	MaxPipelineOperations = 32
+	// MaxPipelineSize is the largest width or height an operation may produce, the same as MaxAtlasSize.
+	MaxPipelineSize = MaxAtlasSize
+)
+
+var (
+	ErrorEmptyImage       = errors.New("operation produced an empty image")
+	ErrorPipelineTooLarge = fmt.Errorf("operation can not produce an image larger than %dx%d", MaxPipelineSize, MaxPipelineSize)
+)
+
+// Operation is a single step of a processing pipeline. Only the fields used by
+// the operation type need to be set, e.g. {"op": "rotate", "angle": 90}.
+type Operation struct {
+	Op string `json:"op"`
+
+	// crop
+	X      int `json:"x,omitempty"`
+	Y      int `json:"y,omitempty"`
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+
+	// rotate, clockwise in multiples of 90 degrees
+	Angle int `json:"angle,omitempty"`
+
+	// flip, "horizontal" or "vertical"
+	Direction string `json:"direction,omitempty"`
+
+	// brightness and contrast, from -100 to 100
+	Amount float64 `json:"amount,omitempty"`
+
+	// trim, pixels with alpha at or below the threshold are trimmed
+	Threshold uint8 `json:"threshold,omitempty"`
+
+	// round_corners
+	Radius int `json:"radius,omitempty"`
+
+	// pad
+	Top    int    `json:"top,omitempty"`
+	Right  int    `json:"right,omitempty"`
+	Bottom int    `json:"bottom,omitempty"`
+	Left   int    `json:"left,omitempty"`
+	Color  string `json:"color,omitempty"` // Hex colour, transparent when empty
+}
+
+// Pipeline is an ordered list of operations applied to an image.
+type Pipeline []Operation
+
+// ParsePipeline decodes and validates a JSON list of operations. An empty string is an empty pipeline.
+func ParsePipeline(data string) (Pipeline, error) {
+	if data == "" {
+		return nil, nil
+	}
+
+	var pipeline Pipeline
+	if err := json.Unmarshal([]byte(data), &pipeline); err != nil {
+		return nil, fmt.Errorf("invalid operations: %v", err)
+	}
+
+	if err := pipeline.Validate(); err != nil {
+		return nil, err
+	}
+
+	return pipeline, nil
+}
+
+// Validate checks every operation for unknown types and invalid parameters.
+func (p Pipeline) Validate() error {
+	if len(p) > MaxPipelineOperations {
+		return fmt.Errorf("too many operations: maximum is %d", MaxPipelineOperations)
+	}
+
+	for i, op := range p {
+		if err := op.validate(); err != nil {
+			return fmt.Errorf("operation %d (%s): %v", i, op.Op, err)
+		}
+	}
+
+	return nil
+}
+
+// Apply runs every operation in order and returns the processed image.
+func (p Pipeline) Apply(img image.Image) (image.Image, error) {
+	if len(p) == 0 {
+		return img, nil
+	}
+
+	current := toNRGBA(img)
+	for i, op := range p {
+		next, err := op.apply(current)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+		if next.Rect.Empty() {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, ErrorEmptyImage)
+		}
+		current = next
+	}
+
+	return current, nil
+}
+
+func (op Operation) validate() error {
+	switch op.Op {
+	case OperationCrop:
+		if op.Width <= 0 || op.Height <= 0 {
+			return errors.New("width and height must be positive")
+		}
+	case OperationRotate:
+		if op.Angle%90 != 0 {
+			return errors.New("angle must be a multiple of 90")
+		}
+	case OperationFlip:
+		if op.Direction != "horizontal" && op.Direction != "vertical" {
+			return errors.New("direction must be horizontal or vertical")
+		}
+	case OperationGrayscale, OperationTrim:
+	case OperationBrightness, OperationContrast:
+		if op.Amount < -100 || op.Amount > 100 {
+			return errors.New("amount must be between -100 and 100")
+		}
+	case OperationRoundCorners:
+		if op.Radius <= 0 {
+			return errors.New("radius must be positive")
+		}
+	case OperationPad:
+		if op.Top < 0 || op.Right < 0 || op.Bottom < 0 || op.Left < 0 {
+			return errors.New("padding can not be negative")
+		}
+		// Each side is checked first so the sums can not overflow
+		if max(op.Top, op.Right, op.Bottom, op.Left) > MaxPipelineSize ||
+			op.Top+op.Bottom > MaxPipelineSize || op.Left+op.Right > MaxPipelineSize {
+			return fmt.Errorf("padding can not add more than %d pixels to a side", MaxPipelineSize)
+		}
+		if op.Color != "" {
+			if _, err := ParseHexColor(op.Color); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.New("unknown operation")
+	}
+
+	return nil
+}
+
+func (op Operation) apply(img *image.NRGBA) (*image.NRGBA, error) {
+	switch op.Op {
+	case OperationCrop:
+		return crop(img, image.Rect(op.X, op.Y, op.X+op.Width, op.Y+op.Height)), nil
+	case OperationRotate:
+		return rotate(img, op.Angle), nil
+	case OperationFlip:
+		return flip(img, op.Direction == "horizontal"), nil
+	case OperationGrayscale:
+		return mapColors(img, grayscale), nil
+	case OperationBrightness:
+		offset := op.Amount * 2.55
+		return mapColors(img, func(c color.NRGBA) color.NRGBA {
+			return adjustChannels(c, func(v float64) float64 { return v + offset })
+		}), nil
+	case OperationContrast:
+		// Standard contrast correction factor for an amount mapped onto -255..255
+		amount := op.Amount * 2.55
+		factor := (259 * (amount + 255)) / (255 * (259 - amount))
+		return mapColors(img, func(c color.NRGBA) color.NRGBA {
+			return adjustChannels(c, func(v float64) float64 { return factor*(v-128) + 128 })
+		}), nil
+	case OperationTrim:
+		return crop(img, opaqueBounds(img, op.Threshold)), nil
+	case OperationRoundCorners:
+		return roundCorners(img, op.Radius), nil
+	case OperationPad:
+		// The padded size depends on the input, so it is only known here
+		if img.Rect.Dx()+op.Left+op.Right > MaxPipelineSize || img.Rect.Dy()+op.Top+op.Bottom > MaxPipelineSize {
+			return nil, ErrorPipelineTooLarge
+		}
+
+		var background color.NRGBA
+		if op.Color != "" {
+			background, _ = ParseHexColor(op.Color)
+		}
+		return pad(img, op.Top, op.Right, op.Bottom, op.Left, background), nil
+	default:
+		return nil, errors.New("unknown operation")
+	}
+}
+
+// toNRGBA returns the image as an NRGBA image with its origin at (0, 0).
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	if nrgba, ok := img.(*image.NRGBA); ok && bounds.Min == (image.Point{}) {
+		return nrgba
+	}
+
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// crop returns a copy of the part of the image inside rect.
+func crop(img *image.NRGBA, rect image.Rectangle) *image.NRGBA {
+	rect = rect.Intersect(img.Bounds())
+	cropped := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+	return cropped
+}
+
+// rotate rotates the image clockwise by a multiple of 90 degrees.
+func rotate(img *image.NRGBA, angle int) *image.NRGBA {
+	turns := ((angle/90)%4 + 4) % 4
+	if turns == 0 {
+		return img
+	}
+
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	size := image.Point{width, height}
+	if turns%2 == 1 {
+		size = image.Point{height, width}
+	}
+
+	rotated := image.NewNRGBA(image.Rectangle{Max: size})
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch turns {
+			case 1:
+				dx, dy = height-1-y, x
+			case 2:
+				dx, dy = width-1-x, height-1-y
+			case 3:
+				dx, dy = y, width-1-x
+			}
+			rotated.SetNRGBA(dx, dy, img.NRGBAAt(x, y))
+		}
+	}
+
+	return rotated
+}
+
+// flip mirrors the image horizontally or vertically.
+func flip(img *image.NRGBA, horizontal bool) *image.NRGBA {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	flipped := image.NewNRGBA(img.Rect)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if horizontal {
+				flipped.SetNRGBA(width-1-x, y, img.NRGBAAt(x, y))
+			} else {
+				flipped.SetNRGBA(x, height-1-y, img.NRGBAAt(x, y))
+			}
+		}
+	}
+	return flipped
+}
+
+// mapColors returns a copy of the image with fn applied to every pixel.
+func mapColors(img *image.NRGBA, fn func(color.NRGBA) color.NRGBA) *image.NRGBA {
+	mapped := image.NewNRGBA(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			mapped.SetNRGBA(x, y, fn(img.NRGBAAt(x, y)))
+		}
+	}
+	return mapped
+}
+
+// grayscale converts a colour to its luminance, keeping alpha.
+func grayscale(c color.NRGBA) color.NRGBA {
+	y := uint8(math.Round(0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)))
+	return color.NRGBA{R: y, G: y, B: y, A: c.A}
+}
+
+// adjustChannels applies fn to the colour channels, clamping the result and keeping alpha.
+func adjustChannels(c color.NRGBA, fn func(float64) float64) color.NRGBA {
+	clamp := func(v float64) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(255, v))))
+	}
+	return color.NRGBA{
+		R: clamp(fn(float64(c.R))),
+		G: clamp(fn(float64(c.G))),
+		B: clamp(fn(float64(c.B))),
+		A: c.A,
+	}
+}
+
+// opaqueBounds returns the smallest rectangle containing every pixel with alpha above threshold.
+func opaqueBounds(img *image.NRGBA, threshold uint8) image.Rectangle {
+	bounds := image.Rectangle{}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			if img.NRGBAAt(x, y).A > threshold {
+				bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	return bounds
+}
+
+// roundCorners makes the corners outside a circle of the radius transparent, anti-aliasing the edge.
+func roundCorners(img *image.NRGBA, radius int) *image.NRGBA {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	radius = min(radius, width/2, height/2)
+	r := float64(radius)
+
+	rounded := image.NewNRGBA(img.Rect)
+	copy(rounded.Pix, img.Pix)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Distance from the centre of the corner circle this pixel falls into
+			cx, cy := -1.0, -1.0
+			if x < radius {
+				cx = r
+			} else if x >= width-radius {
+				cx = float64(width) - r
+			}
+			if y < radius {
+				cy = r
+			} else if y >= height-radius {
+				cy = float64(height) - r
+			}
+			if cx < 0 || cy < 0 {
+				continue
+			}
+
+			distance := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			coverage := math.Max(0, math.Min(1, r-distance+0.5))
+			c := rounded.NRGBAAt(x, y)
+			c.A = uint8(math.Round(float64(c.A) * coverage))
+			rounded.SetNRGBA(x, y, c)
+		}
+	}
+
+	return rounded
+}
+
+// pad adds borders of the background colour around the image.
+func pad(img *image.NRGBA, top, right, bottom, left int, background color.NRGBA) *image.NRGBA {
+	padded := image.NewNRGBA(image.Rect(0, 0, img.Rect.Dx()+left+right, img.Rect.Dy()+top+bottom))
+	draw.Draw(padded, padded.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(padded, img.Rect.Add(image.Point{left, top}), img, img.Rect.Min, draw.Src)
+	return padded
+}
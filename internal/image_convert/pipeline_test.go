@@ -0,0 +1,168 @@
+package image_convert
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// markedImage creates an opaque 4x2 image with a red pixel in the top left corner.
+func markedImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 0, G: 0, B: 255, A: 255})
+		}
+	}
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	return img
+}
+
+func applyPipeline(t *testing.T, img image.Image, operations string) *image.NRGBA {
+	t.Helper()
+
+	pipeline, err := ParsePipeline(operations)
+	assert.NoError(t, err, "failed to parse pipeline")
+
+	result, err := pipeline.Apply(img)
+	assert.NoError(t, err, "failed to apply pipeline")
+	return toNRGBA(result)
+}
+
+func TestParsePipeline(t *testing.T) {
+	pipeline, err := ParsePipeline("")
+	assert.NoError(t, err)
+	assert.Empty(t, pipeline)
+
+	pipeline, err = ParsePipeline(`[{"op": "rotate", "angle": 90}, {"op": "grayscale"}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, Pipeline{{Op: OperationRotate, Angle: 90}, {Op: OperationGrayscale}}, pipeline)
+
+	for _, invalid := range []string{
+		`not json`,
+		`[{"op": "blur"}]`,
+		`[{"op": "rotate", "angle": 45}]`,
+		`[{"op": "flip", "direction": "diagonal"}]`,
+		`[{"op": "crop", "width": 0, "height": 10}]`,
+		`[{"op": "brightness", "amount": 150}]`,
+		`[{"op": "round_corners"}]`,
+		`[{"op": "pad", "top": -1}]`,
+		`[{"op": "pad", "top": 1, "color": "nope"}]`,
+		`[{"op": "pad", "left": 8192, "right": 1}]`,
+		`[{"op": "pad", "top": 9223372036854775807, "bottom": 9223372036854775807}]`,
+	} {
+		_, err := ParsePipeline(invalid)
+		assert.Error(t, err, "%s should be rejected", invalid)
+	}
+}
+
+func TestPipelineGeometry(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+
+	rotated := applyPipeline(t, markedImage(), `[{"op": "rotate", "angle": 90}]`)
+	assert.Equal(t, image.Point{2, 4}, rotated.Rect.Size())
+	assert.Equal(t, red, rotated.NRGBAAt(1, 0), "top left should move to top right")
+
+	rotated = applyPipeline(t, markedImage(), `[{"op": "rotate", "angle": -90}]`)
+	assert.Equal(t, red, rotated.NRGBAAt(0, 3), "top left should move to bottom left")
+
+	rotated = applyPipeline(t, markedImage(), `[{"op": "rotate", "angle": 180}]`)
+	assert.Equal(t, red, rotated.NRGBAAt(3, 1), "top left should move to bottom right")
+
+	flipped := applyPipeline(t, markedImage(), `[{"op": "flip", "direction": "horizontal"}]`)
+	assert.Equal(t, red, flipped.NRGBAAt(3, 0))
+
+	flipped = applyPipeline(t, markedImage(), `[{"op": "flip", "direction": "vertical"}]`)
+	assert.Equal(t, red, flipped.NRGBAAt(0, 1))
+
+	cropped := applyPipeline(t, markedImage(), `[{"op": "crop", "x": 0, "y": 0, "width": 2, "height": 10}]`)
+	assert.Equal(t, image.Point{2, 2}, cropped.Rect.Size(), "crop should be clamped to the image")
+	assert.Equal(t, red, cropped.NRGBAAt(0, 0))
+
+	padded := applyPipeline(t, markedImage(), `[{"op": "pad", "top": 1, "left": 2, "right": 3, "color": "#00ff00"}]`)
+	assert.Equal(t, image.Point{9, 3}, padded.Rect.Size())
+	assert.Equal(t, color.NRGBA{G: 255, A: 255}, padded.NRGBAAt(0, 0))
+	assert.Equal(t, red, padded.NRGBAAt(2, 1))
+}
+
+func TestPipelineTrim(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	img.SetNRGBA(3, 2, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(6, 7, color.NRGBA{G: 255, A: 10})
+
+	trimmed := applyPipeline(t, img, `[{"op": "trim"}]`)
+	assert.Equal(t, image.Point{4, 6}, trimmed.Rect.Size())
+
+	trimmed = applyPipeline(t, img, `[{"op": "trim", "threshold": 50}]`)
+	assert.Equal(t, image.Point{1, 1}, trimmed.Rect.Size(), "faint pixels should be trimmed with a threshold")
+
+	pipeline, _ := ParsePipeline(`[{"op": "trim"}]`)
+	_, err := pipeline.Apply(image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	assert.ErrorIs(t, err, ErrorEmptyImage, "trimming a transparent image leaves nothing")
+}
+
+func TestPipelineColors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 200, G: 100, B: 50, A: 128})
+
+	gray := applyPipeline(t, img, `[{"op": "grayscale"}]`).NRGBAAt(0, 0)
+	assert.Equal(t, gray.R, gray.G)
+	assert.Equal(t, gray.G, gray.B)
+	assert.Equal(t, uint8(128), gray.A, "alpha should be kept")
+
+	brighter := applyPipeline(t, img, `[{"op": "brightness", "amount": 20}]`).NRGBAAt(0, 0)
+	assert.Equal(t, color.NRGBA{R: 251, G: 151, B: 101, A: 128}, brighter)
+
+	higher := applyPipeline(t, img, `[{"op": "contrast", "amount": 50}]`).NRGBAAt(0, 0)
+	assert.Greater(t, higher.R, uint8(200))
+	assert.Less(t, higher.B, uint8(50))
+
+	unchanged := applyPipeline(t, img, `[{"op": "contrast", "amount": 0}]`).NRGBAAt(0, 0)
+	assert.Equal(t, img.NRGBAAt(0, 0), unchanged)
+}
+
+func TestPipelineRoundCorners(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for i := 0; i < len(img.Pix); i++ {
+		img.Pix[i] = 255
+	}
+
+	rounded := applyPipeline(t, img, `[{"op": "round_corners", "radius": 6}]`)
+	assert.Equal(t, uint8(0), rounded.NRGBAAt(0, 0).A, "corner should be transparent")
+	assert.Equal(t, uint8(0), rounded.NRGBAAt(19, 19).A, "corner should be transparent")
+	assert.Equal(t, uint8(255), rounded.NRGBAAt(10, 0).A, "edges between corners should stay opaque")
+	assert.Equal(t, uint8(255), rounded.NRGBAAt(10, 10).A, "centre should stay opaque")
+}
+
+func TestPipelineChain(t *testing.T) {
+	result := applyPipeline(t, markedImage(), `[
+		{"op": "crop", "x": 0, "y": 0, "width": 2, "height": 2},
+		{"op": "rotate", "angle": 270},
+		{"op": "pad", "bottom": 2}
+	]`)
+
+	assert.Equal(t, image.Point{2, 4}, result.Rect.Size())
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, result.NRGBAAt(0, 1))
+	assert.Equal(t, uint8(0), result.NRGBAAt(0, 3).A, "padding should default to transparent")
+}
+
+func TestPipelinePadTooLarge(t *testing.T) {
+	// Padding within the limit on its own can still grow the image past it
+	pipeline, err := ParsePipeline(`[{"op": "pad", "left": 4096, "right": 4096}]`)
+	assert.NoError(t, err)
+
+	_, err = pipeline.Apply(markedImage())
+	assert.ErrorIs(t, err, ErrorPipelineTooLarge)
+
+	// Repeated padding is capped too
+	pipeline, err = ParsePipeline(`[{"op": "pad", "top": 8000}, {"op": "pad", "bottom": 8000}]`)
+	assert.NoError(t, err)
+
+	_, err = pipeline.Apply(markedImage())
+	assert.ErrorIs(t, err, ErrorPipelineTooLarge)
+
+	result := applyPipeline(t, markedImage(), `[{"op": "pad", "left": 4094, "right": 4094}]`)
+	assert.Equal(t, image.Point{MaxPipelineSize, 2}, result.Rect.Size())
+}
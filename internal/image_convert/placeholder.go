@@ -0,0 +1,233 @@
+package image_convert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// DefaultBlurHashComponents is the number of BlurHash components on each axis when none are requested.
+	DefaultBlurHashComponents = 4
+	// DefaultLQIPSize is the longest side of the low quality image placeholder when no size is requested.
+	DefaultLQIPSize = 16
+	// MaxLQIPSize is the largest low quality image placeholder that can be requested.
+	MaxLQIPSize = 64
+	// blurHashSampleSize is the size images are reduced to before the BlurHash is calculated.
+	blurHashSampleSize = 32
+	// lqipJPEGQuality is used for placeholders of opaque images.
+	lqipJPEGQuality = 70
+)
+
+const base83Characters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// PlaceholderOptions controls the generated placeholders.
+type PlaceholderOptions struct {
+	ComponentsX int // BlurHash components horizontally, from 1 to 9
+	ComponentsY int // BlurHash components vertically, from 1 to 9
+	LQIPSize    int // Longest side of the low quality image placeholder in pixels
+}
+
+// Placeholder holds the placeholders generated for an image.
+type Placeholder struct {
+	BlurHash string `json:"blurhash"`
+	LQIP     string `json:"lqip"` // Data URI of a tiny version of the image
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// GeneratePlaceholder decodes an image file and generates its BlurHash and low quality image placeholder.
+func GeneratePlaceholder(imagePath string, options PlaceholderOptions) (*Placeholder, error) {
+	if options.ComponentsX == 0 {
+		options.ComponentsX = DefaultBlurHashComponents
+	}
+	if options.ComponentsY == 0 {
+		options.ComponentsY = DefaultBlurHashComponents
+	}
+	if options.LQIPSize == 0 {
+		options.LQIPSize = DefaultLQIPSize
+	}
+	if options.LQIPSize < 1 || options.LQIPSize > MaxLQIPSize {
+		return nil, fmt.Errorf("placeholder size must be between 1 and %d", MaxLQIPSize)
+	}
+
+	img, err := loadImage(imagePath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := BlurHash(img, options.ComponentsX, options.ComponentsY)
+	if err != nil {
+		return nil, err
+	}
+
+	lqip, err := LQIP(img, options.LQIPSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Placeholder{
+		BlurHash: hash,
+		LQIP:     lqip,
+		Width:    img.Bounds().Dx(),
+		Height:   img.Bounds().Dy(),
+	}, nil
+}
+
+// BlurHash encodes the image as a BlurHash string with the given number of components on each axis.
+// Transparent areas are blended onto white.
+func BlurHash(img image.Image, componentsX, componentsY int) (string, error) {
+	if componentsX < 1 || componentsX > 9 || componentsY < 1 || componentsY > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9")
+	}
+
+	// The hash only holds low frequencies, so a small sample gives the same result much faster
+	bounds := img.Bounds()
+	size := bounds.Size()
+	if size.X > blurHashSampleSize || size.Y > blurHashSampleSize {
+		size = containRect(bounds, image.Point{blurHashSampleSize, blurHashSampleSize}).Size()
+	}
+	sample := image.NewNRGBA(image.Rectangle{Max: size})
+	draw.Draw(sample, sample.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.BiLinear.Scale(sample, sample.Bounds(), img, bounds, draw.Over, nil)
+
+	// Pixels in linear light
+	width, height := size.X, size.Y
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := sample.NRGBAAt(x, y)
+			linear[y*width+x] = [3]float64{sRGBToLinear(c.R), sRGBToLinear(c.G), sRGBToLinear(c.B)}
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					for channel := 0; channel < 3; channel++ {
+						factor[channel] += basis * linear[y*width+x][channel]
+					}
+				}
+			}
+
+			scale := normalisation / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	hash := new(strings.Builder)
+	encodeBase83(hash, (componentsX-1)+(componentsY-1)*9, 1)
+
+	// The AC components are quantised relative to the largest one
+	maximum := 1.0
+	if len(factors) > 1 {
+		actual := 0.0
+		for _, factor := range factors[1:] {
+			for _, v := range factor {
+				actual = math.Max(actual, math.Abs(v))
+			}
+		}
+
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		encodeBase83(hash, quantised, 1)
+	} else {
+		encodeBase83(hash, 0, 1)
+	}
+
+	dc := factors[0]
+	encodeBase83(hash, linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4)
+
+	for _, factor := range factors[1:] {
+		var quantised [3]int
+		for channel, v := range factor {
+			quantised[channel] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		encodeBase83(hash, quantised[0]*19*19+quantised[1]*19+quantised[2], 2)
+	}
+
+	return hash.String(), nil
+}
+
+// LQIP returns a data URI of a tiny version of the image, with size as its longest side.
+// Images with transparency are encoded as PNG, opaque images as JPEG.
+func LQIP(img image.Image, size int) (string, error) {
+	target := containRect(img.Bounds(), image.Point{size, size}).Size()
+	tiny := Resize(img, image.Point{max(target.X, 1), max(target.Y, 1)}, ResizeOptions{Fit: FitStretch, Filter: FilterBilinear})
+
+	buf := new(bytes.Buffer)
+	mediaType := "image/jpeg"
+	if isOpaque(tiny) {
+		if err := jpeg.Encode(buf, tiny, &jpeg.Options{Quality: lqipJPEGQuality}); err != nil {
+			return "", fmt.Errorf("could not encode placeholder: %v", err)
+		}
+	} else {
+		mediaType = "image/png"
+		if err := png.Encode(buf, tiny); err != nil {
+			return "", fmt.Errorf("could not encode placeholder: %v", err)
+		}
+	}
+
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// isOpaque reports whether every pixel of the image is fully opaque.
+func isOpaque(img image.Image) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// encodeBase83 appends value as length base83 digits.
+func encodeBase83(b *strings.Builder, value, length int) {
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		b.WriteByte(base83Characters[digit])
+	}
+}
+
+// sRGBToLinear converts an sRGB channel value to linear light.
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear light channel value back to sRGB.
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// signPow raises the magnitude of value to exp, keeping its sign.
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,75 @@
+package image_convert
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlurHash(t *testing.T) {
+	red := image.NewUniform(color.NRGBA{R: 255, A: 255})
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, red)
+		}
+	}
+
+	hash, err := BlurHash(img, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "00TI:j", hash)
+
+	// The hash length depends only on the number of components
+	hash, err = BlurHash(splitImage(), 4, 3)
+	assert.NoError(t, err)
+	assert.Len(t, hash, 4+2*4*3)
+
+	_, err = BlurHash(img, 0, 4)
+	assert.Error(t, err)
+	_, err = BlurHash(img, 4, 10)
+	assert.Error(t, err)
+}
+
+// decodeDataURI returns the media type and decoded image of a base64 data URI.
+func decodeDataURI(t *testing.T, uri string) (string, image.Image) {
+	t.Helper()
+
+	header, payload, found := strings.Cut(uri, ",")
+	assert.True(t, found)
+	data, err := base64.StdEncoding.DecodeString(payload)
+	assert.NoError(t, err)
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	return strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64"), img
+}
+
+func TestLQIP(t *testing.T) {
+	wide := image.NewNRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			wide.SetNRGBA(x, y, color.NRGBA{G: 200, A: 255})
+		}
+	}
+
+	uri, err := LQIP(wide, 16)
+	assert.NoError(t, err)
+	mediaType, img := decodeDataURI(t, uri)
+	assert.Equal(t, "image/jpeg", mediaType, "opaque images are JPEG")
+	assert.Equal(t, image.Pt(16, 8), img.Bounds().Size())
+
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 50; x++ {
+			wide.SetNRGBA(x, y, color.NRGBA{})
+		}
+	}
+	uri, err = LQIP(wide, 16)
+	assert.NoError(t, err)
+	mediaType, _ = decodeDataURI(t, uri)
+	assert.Equal(t, "image/png", mediaType, "transparency is kept as PNG")
+}
@@ -0,0 +1,192 @@
+package image_convert
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// transparentThreshold is the alpha below which a pixel is treated as fully transparent
+// when building a palette.
+const transparentThreshold = 128
+
+// MedianCutQuantizer builds palettes by recursively splitting the colour space
+// along its widest channel at the median. It implements draw.Quantizer.
+type MedianCutQuantizer struct {
+	// ReserveTransparent keeps a fully transparent entry in the palette when the
+	// image contains transparent pixels.
+	ReserveTransparent bool
+	// KeepAlpha gives translucent pixels palette entries with their own alpha instead of
+	// treating them as opaque. Fully transparent pixels share a single entry.
+	KeepAlpha bool
+}
+
+// quantizeColor is a non-premultiplied RGBA colour in the image together with how often it occurs.
+type quantizeColor struct {
+	c     [4]uint8
+	count int
+}
+
+// colorBox is a group of colours that will end up as one palette entry.
+type colorBox struct {
+	colors []quantizeColor
+	count  int
+}
+
+// Quantize appends up to cap(p)-len(p) colours representative of m to p.
+func (q MedianCutQuantizer) Quantize(p color.Palette, m image.Image) color.Palette {
+	size := cap(p) - len(p)
+	if size <= 0 {
+		return p
+	}
+
+	histogram, transparent := colorHistogram(m, q.KeepAlpha)
+	if transparent && (q.ReserveTransparent || q.KeepAlpha) {
+		p = append(p, color.NRGBA{})
+		size--
+	}
+
+	for _, box := range medianCut(histogram, size) {
+		p = append(p, box.average())
+	}
+
+	return p
+}
+
+// colorHistogram counts the opaque colours of an image and reports whether it has transparent pixels.
+// With keepAlpha, translucent pixels are counted with their alpha and only fully transparent
+// pixels are left out.
+func colorHistogram(m image.Image, keepAlpha bool) ([]quantizeColor, bool) {
+	bounds := m.Bounds()
+	counts := make(map[[4]uint8]int)
+	transparent := false
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			if c.A == 0 || (!keepAlpha && c.A < transparentThreshold) {
+				transparent = true
+				continue
+			}
+			if !keepAlpha {
+				c.A = 255
+			}
+			counts[[4]uint8{c.R, c.G, c.B, c.A}]++
+		}
+	}
+
+	histogram := make([]quantizeColor, 0, len(counts))
+	for c, count := range counts {
+		histogram = append(histogram, quantizeColor{c: c, count: count})
+	}
+
+	// Map iteration order is random, sort so palettes are deterministic
+	sort.Slice(histogram, func(i, j int) bool {
+		a, b := histogram[i].c, histogram[j].c
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		if a[1] != b[1] {
+			return a[1] < b[1]
+		}
+		if a[2] != b[2] {
+			return a[2] < b[2]
+		}
+		return a[3] < b[3]
+	})
+
+	return histogram, transparent
+}
+
+// medianCut splits the histogram into at most n boxes.
+func medianCut(histogram []quantizeColor, n int) []colorBox {
+	if len(histogram) == 0 || n <= 0 {
+		return nil
+	}
+
+	boxes := []colorBox{newColorBox(histogram)}
+	for len(boxes) < n {
+		// Split the box with the most pixels that still has more than one colour
+		index := -1
+		for i, box := range boxes {
+			if len(box.colors) > 1 && (index < 0 || box.count > boxes[index].count) {
+				index = i
+			}
+		}
+		if index < 0 {
+			break
+		}
+
+		a, b := boxes[index].split()
+		boxes[index] = a
+		boxes = append(boxes, b)
+	}
+
+	return boxes
+}
+
+func newColorBox(colors []quantizeColor) colorBox {
+	box := colorBox{colors: colors}
+	for _, c := range colors {
+		box.count += c.count
+	}
+	return box
+}
+
+// split divides the box at the pixel median of its widest channel.
+func (b colorBox) split() (colorBox, colorBox) {
+	channel := b.widestChannel()
+	sort.SliceStable(b.colors, func(i, j int) bool {
+		return b.colors[i].c[channel] < b.colors[j].c[channel]
+	})
+
+	half, seen := b.count/2, 0
+	median := 1
+	for i, c := range b.colors[:len(b.colors)-1] {
+		seen += c.count
+		median = i + 1
+		if seen >= half {
+			break
+		}
+	}
+
+	return newColorBox(b.colors[:median]), newColorBox(b.colors[median:])
+}
+
+// widestChannel returns the channel with the largest range of values in the box.
+// Alpha only has a range when translucent colours are kept.
+func (b colorBox) widestChannel() int {
+	lo := [4]uint8{255, 255, 255, 255}
+	hi := [4]uint8{}
+	for _, c := range b.colors {
+		for i := 0; i < 4; i++ {
+			lo[i] = min(lo[i], c.c[i])
+			hi[i] = max(hi[i], c.c[i])
+		}
+	}
+
+	channel := 0
+	for i := 1; i < 4; i++ {
+		if hi[i]-lo[i] > hi[channel]-lo[channel] {
+			channel = i
+		}
+	}
+	return channel
+}
+
+// average returns the pixel weighted mean colour of the box.
+func (b colorBox) average() color.NRGBA {
+	var sum [4]int
+	for _, c := range b.colors {
+		for i := 0; i < 4; i++ {
+			sum[i] += int(c.c[i]) * c.count
+		}
+	}
+
+	return color.NRGBA{
+		R: uint8((sum[0] + b.count/2) / b.count),
+		G: uint8((sum[1] + b.count/2) / b.count),
+		B: uint8((sum[2] + b.count/2) / b.count),
+		A: uint8((sum[3] + b.count/2) / b.count),
+	}
+}
@@ -0,0 +1,227 @@
+package image_convert
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// ResampleFilter selects the interpolation used when resizing.
+type ResampleFilter string
+
+const (
+	FilterNearest    ResampleFilter = "nearest"
+	FilterBilinear   ResampleFilter = "bilinear"
+	FilterCatmullRom ResampleFilter = "catmull-rom"
+	FilterLanczos    ResampleFilter = "lanczos"
+)
+
+// FitMode selects how an image is fitted into a size with a different aspect ratio.
+type FitMode string
+
+const (
+	// FitContain scales the whole image into the size and pads the remaining space.
+	FitContain FitMode = "contain"
+	// FitCover scales the image to fill the size and crops the overflow.
+	FitCover FitMode = "cover"
+	// FitStretch scales the image to the size, ignoring its aspect ratio.
+	FitStretch FitMode = "stretch"
+)
+
+const (
+	DefaultResampleFilter = FilterLanczos
+	DefaultFitMode        = FitContain
+
+	// SharpenMaxSize is the largest output dimension that gets the sharpening pass.
+	SharpenMaxSize = 64
+	// sharpenAmount is the strength of the unsharp mask applied to small sizes.
+	sharpenAmount = 0.5
+)
+
+// lanczos is a Lanczos-3 windowed sinc kernel.
+var lanczos = &draw.Kernel{
+	Support: 3,
+	At: func(t float64) float64 {
+		if t == 0 {
+			return 1
+		}
+		x := math.Pi * t
+		return 3 * math.Sin(x) * math.Sin(x/3) / (x * x)
+	},
+}
+
+// FocalPoint is a point of interest in an image, as fractions of its width and height.
+type FocalPoint struct {
+	X float64
+	Y float64
+}
+
+// ResizeOptions controls how images are resized. The zero value resizes with
+// DefaultResampleFilter and DefaultFitMode onto a transparent background.
+type ResizeOptions struct {
+	Filter     ResampleFilter
+	Fit        FitMode
+	Background color.NRGBA // Padding colour for FitContain
+	Focus      *FocalPoint // Point kept in view by FitCover, defaults to the centre
+	Sharpen    bool        // Sharpen outputs no larger than SharpenMaxSize
+}
+
+// ParseResampleFilter validates a resample filter, falling back to DefaultResampleFilter when empty.
+func ParseResampleFilter(filter string) (ResampleFilter, error) {
+	switch ResampleFilter(filter) {
+	case "":
+		return DefaultResampleFilter, nil
+	case FilterNearest, FilterBilinear, FilterCatmullRom, FilterLanczos:
+		return ResampleFilter(filter), nil
+	default:
+		return "", fmt.Errorf("unsupported resample filter: %s", filter)
+	}
+}
+
+// ParseFitMode validates a fit mode, falling back to DefaultFitMode when empty.
+func ParseFitMode(fit string) (FitMode, error) {
+	switch FitMode(fit) {
+	case "":
+		return DefaultFitMode, nil
+	case FitContain, FitCover, FitStretch:
+		return FitMode(fit), nil
+	default:
+		return "", fmt.Errorf("unsupported fit mode: %s", fit)
+	}
+}
+
+// Resize resizes the image to the given size using the options.
+func Resize(img image.Image, size image.Point, options ResizeOptions) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	src := img.Bounds()
+	if src.Empty() || dst.Bounds().Empty() {
+		return dst
+	}
+
+	interpolator := options.Filter.interpolator()
+
+	switch options.Fit {
+	case FitStretch:
+		interpolator.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	case FitCover:
+		interpolator.Scale(dst, dst.Bounds(), img, coverRect(src, size, options.Focus), draw.Src, nil)
+	default:
+		if options.Background.A > 0 {
+			draw.Draw(dst, dst.Bounds(), image.NewUniform(options.Background), image.Point{}, draw.Src)
+		}
+		interpolator.Scale(dst, containRect(src, size), img, src, draw.Over, nil)
+	}
+
+	if options.Sharpen && size.X <= SharpenMaxSize && size.Y <= SharpenMaxSize {
+		sharpen(dst, sharpenAmount)
+	}
+
+	return dst
+}
+
+// interpolator returns the draw interpolator for the filter.
+func (f ResampleFilter) interpolator() draw.Interpolator {
+	switch f {
+	case FilterNearest:
+		return draw.NearestNeighbor
+	case FilterBilinear:
+		return draw.BiLinear
+	case FilterCatmullRom:
+		return draw.CatmullRom
+	default:
+		return lanczos
+	}
+}
+
+// resizedPoint maps a pixel of the source onto the image Resize produces with the same size and options.
+// Points cropped away by FitCover end up outside the returned image's bounds.
+func resizedPoint(point image.Point, src image.Rectangle, size image.Point, options ResizeOptions) image.Point {
+	from, to := src, image.Rectangle{Max: size}
+	switch options.Fit {
+	case FitStretch:
+		// The whole source maps onto the whole output
+	case FitCover:
+		from = coverRect(src, size, options.Focus)
+	default:
+		to = containRect(src, size)
+	}
+	if from.Empty() {
+		return to.Min
+	}
+
+	// Scale the centre of the pixel so it stays inside the pixel it lands on
+	x := (float64(point.X-from.Min.X) + 0.5) * float64(to.Dx()) / float64(from.Dx())
+	y := (float64(point.Y-from.Min.Y) + 0.5) * float64(to.Dy()) / float64(from.Dy())
+	return to.Min.Add(image.Point{int(math.Floor(x)), int(math.Floor(y))})
+}
+
+// containRect returns the centred rectangle of the given size that fits the source aspect ratio.
+func containRect(src image.Rectangle, size image.Point) image.Rectangle {
+	scale := math.Min(float64(size.X)/float64(src.Dx()), float64(size.Y)/float64(src.Dy()))
+	width := max(1, int(math.Round(float64(src.Dx())*scale)))
+	height := max(1, int(math.Round(float64(src.Dy())*scale)))
+
+	origin := image.Point{(size.X - width) / 2, (size.Y - height) / 2}
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Point{width, height})}
+}
+
+// coverRect returns the largest part of the source with the aspect ratio of size,
+// positioned so the focus point is as close to its centre as possible.
+func coverRect(src image.Rectangle, size image.Point, focus *FocalPoint) image.Rectangle {
+	scale := math.Max(float64(size.X)/float64(src.Dx()), float64(size.Y)/float64(src.Dy()))
+	width := min(src.Dx(), max(1, int(math.Round(float64(size.X)/scale))))
+	height := min(src.Dy(), max(1, int(math.Round(float64(size.Y)/scale))))
+
+	fx, fy := 0.5, 0.5
+	if focus != nil {
+		fx, fy = focus.X, focus.Y
+	}
+
+	x := int(math.Round(fx*float64(src.Dx()) - float64(width)/2))
+	y := int(math.Round(fy*float64(src.Dy()) - float64(height)/2))
+	x = max(0, min(x, src.Dx()-width))
+	y = max(0, min(y, src.Dy()-height))
+
+	origin := src.Min.Add(image.Point{x, y})
+	return image.Rectangle{Min: origin, Max: origin.Add(image.Point{width, height})}
+}
+
+// sharpen applies an unsharp mask with a 3x3 box blur to the image in place.
+// Alpha is left untouched so edges of transparent icons do not grow halos.
+func sharpen(img *image.RGBA, amount float64) {
+	bounds := img.Bounds()
+	src := image.NewRGBA(bounds)
+	copy(src.Pix, img.Pix)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var sum [3]float64
+			count := 0.0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					p := image.Point{x + dx, y + dy}
+					if !p.In(bounds) {
+						continue
+					}
+					i := src.PixOffset(p.X, p.Y)
+					sum[0] += float64(src.Pix[i])
+					sum[1] += float64(src.Pix[i+1])
+					sum[2] += float64(src.Pix[i+2])
+					count++
+				}
+			}
+
+			i := src.PixOffset(x, y)
+			alpha := float64(src.Pix[i+3])
+			for c := 0; c < 3; c++ {
+				v := float64(src.Pix[i+c])
+				sharpened := v + amount*(v-sum[c]/count)
+				// Premultiplied colour channels can not exceed alpha
+				img.Pix[i+c] = uint8(math.Round(math.Max(0, math.Min(alpha, sharpened))))
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package image_convert
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden images in testdata")
+
+// goldenTolerance is the largest per-channel difference accepted against a golden image,
+// allowing for floating point differences between platforms.
+const goldenTolerance = 2
+
+// ringsImage renders anti-aliased concentric rings, which show both aliasing and blurring.
+func ringsImage(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	cx, cy := float64(width)/2, float64(height)/2
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			v := uint8(127.5 + 127.5*math.Cos(d/3))
+			img.SetNRGBA(x, y, color.NRGBA{R: v, G: uint8(x * 255 / width), B: 255 - v, A: 255})
+		}
+	}
+	return img
+}
+
+// solidHalves creates an image with a red left half and a blue right half.
+func solidHalves(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// boxDownscale averages factor x factor blocks, giving a reference downscale.
+func boxDownscale(img image.Image, factor int) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx()/factor, b.Dy()/factor))
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			var sum [4]int
+			for dy := 0; dy < factor; dy++ {
+				for dx := 0; dx < factor; dx++ {
+					c := color.NRGBAModel.Convert(img.At(b.Min.X+x*factor+dx, b.Min.Y+y*factor+dy)).(color.NRGBA)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					sum[3] += int(c.A)
+				}
+			}
+			n := factor * factor
+			out.SetNRGBA(x, y, color.NRGBA{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n), uint8(sum[3] / n)})
+		}
+	}
+	return out
+}
+
+// meanSquaredError compares two images of the same size.
+func meanSquaredError(a, b image.Image) float64 {
+	bounds := a.Bounds()
+	var sum float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			ca := color.NRGBAModel.Convert(a.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			cb := color.NRGBAModel.Convert(b.At(b.Bounds().Min.X+x, b.Bounds().Min.Y+y)).(color.NRGBA)
+			for _, d := range []float64{
+				float64(ca.R) - float64(cb.R),
+				float64(ca.G) - float64(cb.G),
+				float64(ca.B) - float64(cb.B),
+			} {
+				sum += d * d
+			}
+		}
+	}
+	return sum / float64(bounds.Dx()*bounds.Dy()*3)
+}
+
+func TestResizeGolden(t *testing.T) {
+	source := ringsImage(200, 120)
+
+	tests := []struct {
+		name    string
+		size    image.Point
+		options ResizeOptions
+	}{
+		{"nearest", image.Point{48, 48}, ResizeOptions{Filter: FilterNearest}},
+		{"bilinear", image.Point{48, 48}, ResizeOptions{Filter: FilterBilinear}},
+		{"catmull-rom", image.Point{48, 48}, ResizeOptions{Filter: FilterCatmullRom}},
+		{"lanczos", image.Point{48, 48}, ResizeOptions{Filter: FilterLanczos}},
+		{"cover", image.Point{48, 48}, ResizeOptions{Fit: FitCover}},
+		{"stretch", image.Point{48, 48}, ResizeOptions{Fit: FitStretch}},
+		{"contain-background", image.Point{48, 48}, ResizeOptions{Background: color.NRGBA{R: 255, G: 255, B: 255, A: 255}}},
+		{"sharpen", image.Point{32, 32}, ResizeOptions{Fit: FitCover, Sharpen: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := Resize(source, test.size, test.options)
+			path := filepath.Join("testdata", "resize", test.name+".png")
+
+			if *updateGolden {
+				assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+				file, err := os.Create(path)
+				assert.NoError(t, err)
+				assert.NoError(t, encodePNGFile(file, actual))
+				return
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("missing golden image, run with -update: %v", err)
+			}
+			defer file.Close()
+
+			expected, err := png.Decode(file)
+			assert.NoError(t, err)
+			assert.Equal(t, expected.Bounds().Size(), actual.Bounds().Size())
+
+			eb := expected.Bounds()
+			for y := 0; y < eb.Dy(); y++ {
+				for x := 0; x < eb.Dx(); x++ {
+					ec := color.NRGBAModel.Convert(expected.At(eb.Min.X+x, eb.Min.Y+y)).(color.NRGBA)
+					ac := color.NRGBAModel.Convert(actual.At(x, y)).(color.NRGBA)
+					for _, d := range []int{
+						int(ec.R) - int(ac.R), int(ec.G) - int(ac.G), int(ec.B) - int(ac.B), int(ec.A) - int(ac.A),
+					} {
+						if d > goldenTolerance || d < -goldenTolerance {
+							t.Fatalf("pixel (%d, %d) differs from golden image: expected %v, got %v", x, y, ec, ac)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResizeFilterQuality(t *testing.T) {
+	source := ringsImage(256, 256)
+	reference := boxDownscale(source, 8)
+
+	errors := make(map[ResampleFilter]float64)
+	for _, filter := range []ResampleFilter{FilterNearest, FilterBilinear, FilterCatmullRom, FilterLanczos} {
+		errors[filter] = meanSquaredError(reference, Resize(source, image.Point{32, 32}, ResizeOptions{Filter: filter}))
+	}
+
+	// Nearest neighbour aliases badly when downscaling, every other filter should do better
+	assert.Less(t, errors[FilterBilinear], errors[FilterNearest])
+	assert.Less(t, errors[FilterCatmullRom], errors[FilterNearest])
+	assert.Less(t, errors[FilterLanczos], errors[FilterNearest])
+}
+
+func TestResizeContain(t *testing.T) {
+	resized := Resize(solidHalves(200, 100), image.Point{64, 64}, ResizeOptions{})
+
+	// The image keeps its 2:1 aspect ratio with transparent padding above and below
+	assert.Equal(t, uint8(0), resized.RGBAAt(32, 4).A, "top padding should be transparent")
+	assert.Equal(t, uint8(0), resized.RGBAAt(32, 60).A, "bottom padding should be transparent")
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, resized.RGBAAt(4, 32), "left half should be red")
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, resized.RGBAAt(60, 32), "right half should be blue")
+
+	padded := Resize(solidHalves(200, 100), image.Point{64, 64}, ResizeOptions{
+		Background: color.NRGBA{G: 255, A: 255},
+	})
+	assert.Equal(t, color.RGBA{G: 255, A: 255}, padded.RGBAAt(32, 4), "padding should use the background colour")
+}
+
+func TestResizeCoverFocus(t *testing.T) {
+	source := solidHalves(200, 100)
+
+	left := Resize(source, image.Point{50, 50}, ResizeOptions{Fit: FitCover, Focus: &FocalPoint{X: 0, Y: 0.5}})
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, left.RGBAAt(49, 25), "left focus should crop to the red half")
+
+	right := Resize(source, image.Point{50, 50}, ResizeOptions{Fit: FitCover, Focus: &FocalPoint{X: 1, Y: 0.5}})
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, right.RGBAAt(0, 25), "right focus should crop to the blue half")
+
+	centre := Resize(source, image.Point{50, 50}, ResizeOptions{Fit: FitCover})
+	assert.Equal(t, color.RGBA{R: 255, A: 255}, centre.RGBAAt(0, 25))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, centre.RGBAAt(49, 25))
+}
+
+func TestResizeSharpen(t *testing.T) {
+	source := ringsImage(256, 256)
+	plain := Resize(source, image.Point{32, 32}, ResizeOptions{})
+	sharpened := Resize(source, image.Point{32, 32}, ResizeOptions{Sharpen: true})
+
+	// Sharpening increases local contrast, moving pixels away from their neighbours' average
+	assert.Greater(t, meanSquaredError(boxBlur(sharpened), sharpened), meanSquaredError(boxBlur(plain), plain))
+
+	// Large sizes are never sharpened
+	large := Resize(source, image.Point{128, 128}, ResizeOptions{})
+	largeSharpened := Resize(source, image.Point{128, 128}, ResizeOptions{Sharpen: true})
+	assert.Equal(t, large.Pix, largeSharpened.Pix)
+}
+
+func TestParseResizeOptions(t *testing.T) {
+	filter, err := ParseResampleFilter("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultResampleFilter, filter)
+
+	_, err = ParseResampleFilter("cubic")
+	assert.Error(t, err)
+
+	fit, err := ParseFitMode("cover")
+	assert.NoError(t, err)
+	assert.Equal(t, FitCover, fit)
+
+	_, err = ParseFitMode("fill")
+	assert.Error(t, err)
+}
+
+// boxBlur returns a 3x3 box blurred copy of the image.
+func boxBlur(img *image.RGBA) *image.RGBA {
+	blurred := image.NewRGBA(img.Bounds())
+	copy(blurred.Pix, img.Pix)
+	sharpen(blurred, -1)
+	return blurred
+}
@@ -0,0 +1,319 @@
+package image_convert
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"rory-pearson/pkg/util"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Anchor is the position of a watermark in the image.
+type Anchor string
+
+const (
+	AnchorTopLeft     Anchor = "top-left"
+	AnchorTop         Anchor = "top"
+	AnchorTopRight    Anchor = "top-right"
+	AnchorLeft        Anchor = "left"
+	AnchorCenter      Anchor = "center"
+	AnchorRight       Anchor = "right"
+	AnchorBottomLeft  Anchor = "bottom-left"
+	AnchorBottom      Anchor = "bottom"
+	AnchorBottomRight Anchor = "bottom-right"
+)
+
+const (
+	DefaultAnchor           = AnchorBottomRight
+	DefaultWatermarkFont    = "regular"
+	DefaultWatermarkOpacity = 0.5
+	// MaxWatermarkFontSize is the largest text size in pixels that can be requested.
+	MaxWatermarkFontSize = 1000
+	// MaxWatermarkTextLength is the most characters watermark text can have.
+	MaxWatermarkTextLength = 500
+	// MaxWatermarkMargin is the largest margin in pixels that can be requested.
+	MaxWatermarkMargin = MaxPipelineSize
+	// minWatermarkFontSize is the smallest text size picked when no size is requested.
+	minWatermarkFontSize = 12
+)
+
+var (
+	ErrorNoWatermark       = errors.New("a watermark needs a logo or text")
+	ErrorWatermarkTooLarge = fmt.Errorf("watermark text can not be larger than %dx%d", MaxPipelineSize, MaxPipelineSize)
+)
+
+// DefaultWatermarkColor is used for text when no colour is set.
+var DefaultWatermarkColor = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+// anchorPositions places each anchor as fractions of the free space around the watermark.
+var anchorPositions = map[Anchor]FocalPoint{
+	AnchorTopLeft:     {0, 0},
+	AnchorTop:         {0.5, 0},
+	AnchorTopRight:    {1, 0},
+	AnchorLeft:        {0, 0.5},
+	AnchorCenter:      {0.5, 0.5},
+	AnchorRight:       {1, 0.5},
+	AnchorBottomLeft:  {0, 1},
+	AnchorBottom:      {0.5, 1},
+	AnchorBottomRight: {1, 1},
+}
+
+// watermarkFonts are the bundled Go fonts text can be rendered with.
+var watermarkFonts = map[string][]byte{
+	"regular":     goregular.TTF,
+	"bold":        gobold.TTF,
+	"italic":      goitalic.TTF,
+	"bold-italic": gobolditalic.TTF,
+	"mono":        gomono.TTF,
+	"mono-bold":   gomonobold.TTF,
+}
+
+// WatermarkOptions describes a logo or text overlay. When both are set the logo is used.
+type WatermarkOptions struct {
+	Logo  image.Image
+	Scale float64 // Logo width as a fraction of the image width, 0 keeps the logo size
+
+	Text     string
+	Font     string      // One of the bundled Go fonts, see watermarkFonts
+	FontSize float64     // Text height in pixels, 0 scales it with the image
+	Color    color.NRGBA // Text colour, DefaultWatermarkColor when zero
+
+	Anchor  Anchor
+	Opacity float64 // From 0 to 1, 0 uses DefaultWatermarkOpacity
+	Margin  int     // Pixels from the edges of the image, and between tiles
+	Tile    bool    // Repeat the watermark over the whole image instead of anchoring it
+}
+
+// ParseAnchor validates an anchor name, falling back to DefaultAnchor when empty.
+func ParseAnchor(anchor string) (Anchor, error) {
+	if anchor == "" {
+		return DefaultAnchor, nil
+	}
+	if _, ok := anchorPositions[Anchor(anchor)]; !ok {
+		return "", fmt.Errorf("unsupported anchor: %s", anchor)
+	}
+	return Anchor(anchor), nil
+}
+
+// LoadLogo decodes the image file used as the watermark logo.
+func (o *WatermarkOptions) LoadLogo(path string) error {
+	logo, err := decodeImageFile(path)
+	if err != nil {
+		return fmt.Errorf("could not decode logo: %v", err)
+	}
+
+	o.Logo = logo
+	return nil
+}
+
+// Validate checks the options and fills in defaults.
+func (o *WatermarkOptions) Validate() error {
+	if o.Logo == nil && strings.TrimSpace(o.Text) == "" {
+		return ErrorNoWatermark
+	}
+	if utf8.RuneCountInString(o.Text) > MaxWatermarkTextLength {
+		return fmt.Errorf("text can not be longer than %d characters", MaxWatermarkTextLength)
+	}
+	if o.Font == "" {
+		o.Font = DefaultWatermarkFont
+	}
+	if _, ok := watermarkFonts[o.Font]; !ok {
+		return fmt.Errorf("unsupported font: %s", o.Font)
+	}
+	if o.Color == (color.NRGBA{}) {
+		o.Color = DefaultWatermarkColor
+	}
+	if o.FontSize < 0 || o.FontSize > MaxWatermarkFontSize {
+		return fmt.Errorf("font size must be between 0 and %d", MaxWatermarkFontSize)
+	}
+	if o.Scale < 0 || o.Scale > 1 {
+		return fmt.Errorf("logo scale must be between 0 and 1")
+	}
+	if o.Anchor == "" {
+		o.Anchor = DefaultAnchor
+	}
+	if _, ok := anchorPositions[o.Anchor]; !ok {
+		return fmt.Errorf("unsupported anchor: %s", o.Anchor)
+	}
+	if o.Opacity == 0 {
+		o.Opacity = DefaultWatermarkOpacity
+	}
+	if o.Opacity < 0 || o.Opacity > 1 {
+		return fmt.Errorf("opacity must be between 0 and 1")
+	}
+	if o.Margin < 0 || o.Margin > MaxWatermarkMargin {
+		return fmt.Errorf("margin must be between 0 and %d", MaxWatermarkMargin)
+	}
+	return nil
+}
+
+// Watermark returns a copy of the image with the watermark drawn over it.
+func Watermark(img image.Image, options WatermarkOptions) (*image.NRGBA, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	mark, err := options.render(dst.Bounds().Size())
+	if err != nil {
+		return nil, err
+	}
+	size := mark.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return dst, nil
+	}
+
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(options.Opacity * 255))})
+	place := func(at image.Point) {
+		draw.DrawMask(dst, image.Rectangle{Min: at, Max: at.Add(size)}, mark, mark.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+
+	if options.Tile {
+		for y := options.Margin; y < dst.Rect.Dy(); y += size.Y + options.Margin {
+			for x := options.Margin; x < dst.Rect.Dx(); x += size.X + options.Margin {
+				place(image.Pt(x, y))
+			}
+		}
+		return dst, nil
+	}
+
+	// The anchor splits the space left around the watermark inside the margins
+	position := anchorPositions[options.Anchor]
+	free := dst.Rect.Size().Sub(size).Sub(image.Pt(2*options.Margin, 2*options.Margin))
+	place(image.Pt(
+		options.Margin+int(math.Round(position.X*float64(free.X))),
+		options.Margin+int(math.Round(position.Y*float64(free.Y))),
+	))
+
+	return dst, nil
+}
+
+// render returns the logo scaled for an image of the given size, or the rendered text.
+func (o *WatermarkOptions) render(size image.Point) (image.Image, error) {
+	if o.Logo != nil {
+		if o.Scale == 0 {
+			return o.Logo, nil
+		}
+
+		logo := o.Logo.Bounds().Size()
+		width := max(int(math.Round(o.Scale*float64(size.X))), 1)
+		height := max(int(math.Round(float64(width)*float64(logo.Y)/float64(logo.X))), 1)
+		return Resize(o.Logo, image.Pt(width, height), ResizeOptions{Fit: FitStretch}), nil
+	}
+
+	fontSize := o.FontSize
+	if fontSize == 0 {
+		fontSize = max(float64(size.Y)/20, minWatermarkFontSize)
+	}
+	return renderText(o.Text, watermarkFonts[o.Font], fontSize, o.Color)
+}
+
+// renderText draws the lines of text onto a transparent image just large enough to hold them.
+func renderText(text string, fontData []byte, size float64, c color.NRGBA) (*image.NRGBA, error) {
+	parsed, err := opentype.Parse(fontData)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse font: %v", err)
+	}
+
+	face, err := opentype.NewFace(parsed, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("could not create font face: %v", err)
+	}
+	defer face.Close()
+
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+
+	width := 0
+	for _, line := range lines {
+		width = max(width, font.MeasureString(face, line).Ceil())
+	}
+	height := lineHeight*(len(lines)-1) + (metrics.Ascent + metrics.Descent).Ceil()
+
+	// The size is measured before anything is allocated, so long text at a large size is rejected cheaply
+	if width > MaxPipelineSize || height > MaxPipelineSize {
+		return nil, ErrorWatermarkTooLarge
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	drawer := font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face}
+	for i, line := range lines {
+		drawer.Dot = fixed.Point26_6{X: 0, Y: metrics.Ascent + fixed.I(i*lineHeight)}
+		drawer.DrawString(line)
+	}
+
+	return img, nil
+}
+
+// WatermarkFile watermarks an image file and stores it in the format,
+// returning the name of the stored file.
+func WatermarkFile(imagePath string, format ImageFormat, encodeOptions EncodeOptions, options WatermarkOptions) (string, error) {
+	img, err := loadImage(imagePath, nil)
+	if err != nil {
+		return "", err
+	}
+
+	watermarked, err := Watermark(img, options)
+	if err != nil {
+		return "", err
+	}
+
+	name := util.GenerateUUIDv4() + format.Extension()
+	outputPath := filepath.Join(storageDirectory, name)
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("could not create output file: %v", err)
+	}
+	defer output.Close()
+
+	if err := encodeFile(output, watermarked, InputFile{Name: filepath.Base(imagePath), Path: imagePath}, format, encodeOptions); err != nil {
+		output.Close()
+		os.Remove(outputPath)
+		return "", err
+	}
+
+	return name, nil
+}
+
+// ApplyWatermarkFile draws the watermark over a PNG file in place.
+func ApplyWatermarkFile(path string, options WatermarkOptions) error {
+	img, err := loadImage(path, nil)
+	if err != nil {
+		return err
+	}
+
+	watermarked, err := Watermark(img, options)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("could not write watermarked file: %v", err)
+	}
+	defer file.Close()
+
+	return png.Encode(file, watermarked)
+}
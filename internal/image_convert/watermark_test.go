@@ -0,0 +1,141 @@
+package image_convert
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// solidImage returns an image filled with a single colour.
+func solidImage(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+var (
+	white = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red   = color.NRGBA{R: 255, A: 255}
+)
+
+func TestWatermarkLogoAnchor(t *testing.T) {
+	logo := solidImage(4, 4, red)
+
+	img, err := Watermark(solidImage(20, 20, white), WatermarkOptions{Logo: logo, Anchor: AnchorTopLeft, Margin: 2, Opacity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, white, img.NRGBAAt(1, 1))
+	assert.Equal(t, red, img.NRGBAAt(2, 2))
+	assert.Equal(t, red, img.NRGBAAt(5, 5))
+	assert.Equal(t, white, img.NRGBAAt(6, 6))
+
+	img, err = Watermark(solidImage(20, 20, white), WatermarkOptions{Logo: logo, Margin: 2, Opacity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, red, img.NRGBAAt(14, 14), "the default anchor is bottom right")
+	assert.Equal(t, red, img.NRGBAAt(17, 17))
+	assert.Equal(t, white, img.NRGBAAt(18, 18))
+
+	img, err = Watermark(solidImage(20, 20, white), WatermarkOptions{Logo: logo, Anchor: AnchorCenter, Opacity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, red, img.NRGBAAt(8, 8))
+	assert.Equal(t, white, img.NRGBAAt(7, 7))
+}
+
+func TestWatermarkOpacity(t *testing.T) {
+	source := solidImage(10, 10, white)
+
+	img, err := Watermark(source, WatermarkOptions{Logo: solidImage(10, 10, red)})
+	assert.NoError(t, err)
+
+	// Half of the red logo over white
+	c := img.NRGBAAt(5, 5)
+	assert.Equal(t, uint8(255), c.R)
+	assert.InDelta(t, 128, int(c.G), 1)
+	assert.InDelta(t, 128, int(c.B), 1)
+
+	assert.Equal(t, white, source.NRGBAAt(5, 5), "the source image is not modified")
+}
+
+func TestWatermarkTileAndScale(t *testing.T) {
+	img, err := Watermark(solidImage(20, 10, white), WatermarkOptions{Logo: solidImage(4, 4, red), Tile: true, Margin: 2, Opacity: 1})
+	assert.NoError(t, err)
+	for _, x := range []int{2, 8, 14} {
+		assert.Equal(t, red, img.NRGBAAt(x, 2), x)
+		assert.Equal(t, white, img.NRGBAAt(x-1, 2), x)
+	}
+	assert.Equal(t, red, img.NRGBAAt(2, 8), "tiles repeat down the image")
+
+	img, err = Watermark(solidImage(40, 40, white), WatermarkOptions{Logo: solidImage(4, 2, red), Scale: 0.5, Anchor: AnchorTopLeft, Opacity: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, red, img.NRGBAAt(19, 9), "the logo is scaled to half the image width")
+	assert.Equal(t, white, img.NRGBAAt(20, 0))
+	assert.Equal(t, white, img.NRGBAAt(0, 10))
+}
+
+func TestWatermarkText(t *testing.T) {
+	black := color.NRGBA{A: 255}
+	img, err := Watermark(solidImage(200, 100, white), WatermarkOptions{
+		Text:     "Sample",
+		FontSize: 24,
+		Color:    black,
+		Anchor:   AnchorTopLeft,
+		Opacity:  1,
+	})
+	assert.NoError(t, err)
+
+	// The text is drawn near the anchor and nowhere else
+	changed := image.Rectangle{}
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if img.NRGBAAt(x, y) != white {
+				changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	assert.False(t, changed.Empty(), "text should be drawn")
+	assert.Less(t, changed.Max.X, 120)
+	assert.Less(t, changed.Max.Y, 40)
+}
+
+func TestWatermarkValidate(t *testing.T) {
+	img := solidImage(10, 10, white)
+
+	_, err := Watermark(img, WatermarkOptions{Text: " "})
+	assert.ErrorIs(t, err, ErrorNoWatermark)
+
+	_, err = Watermark(img, WatermarkOptions{Text: "a", Font: "comic-sans"})
+	assert.Error(t, err)
+
+	_, err = Watermark(img, WatermarkOptions{Text: "a", Opacity: 1.5})
+	assert.Error(t, err)
+
+	_, err = Watermark(img, WatermarkOptions{Text: strings.Repeat("a", MaxWatermarkTextLength+1)})
+	assert.Error(t, err)
+
+	_, err = Watermark(img, WatermarkOptions{Text: "a", Margin: MaxWatermarkMargin + 1})
+	assert.Error(t, err)
+
+	_, err = ParseAnchor("middle")
+	assert.Error(t, err)
+
+	anchor, err := ParseAnchor("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAnchor, anchor)
+}
+
+func TestWatermarkTextTooLarge(t *testing.T) {
+	img := solidImage(10, 10, white)
+
+	// Within the length limit, but far too wide or tall to render at the largest size
+	_, err := Watermark(img, WatermarkOptions{Text: strings.Repeat("W", MaxWatermarkTextLength), FontSize: MaxWatermarkFontSize})
+	assert.ErrorIs(t, err, ErrorWatermarkTooLarge)
+
+	_, err = Watermark(img, WatermarkOptions{Text: "a" + strings.Repeat("\na", 20), FontSize: MaxWatermarkFontSize})
+	assert.ErrorIs(t, err, ErrorWatermarkTooLarge)
+}
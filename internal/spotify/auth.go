@@ -0,0 +1,99 @@
+package spotify
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+)
+
+// AuthMode is the OAuth flow used to log in to Spotify.
+type AuthMode string
+
+const (
+	// AuthModeSecret is the authorization code flow, authenticating with the client secret.
+	AuthModeSecret AuthMode = "secret"
+	// AuthModePKCE is the authorization code flow with PKCE, which only needs the client ID.
+	AuthModePKCE AuthMode = "pkce"
+)
+
+// codeVerifierSize is the number of random bytes in a PKCE code verifier, encoded to 43 characters.
+const codeVerifierSize = 32
+
+var ErrorMissingClientSecret = errors.New("the secret auth mode needs a Spotify client secret")
+
+// scopes are the permissions requested when logging in.
+var scopes = []string{
+	spotifyauth.ScopeUserReadPrivate,
+	spotifyauth.ScopeUserReadEmail,
+	spotifyauth.ScopePlaylistReadPrivate,
+	spotifyauth.ScopePlaylistReadCollaborative,
+	spotifyauth.ScopePlaylistModifyPublic,
+	spotifyauth.ScopePlaylistModifyPrivate,
+	spotifyauth.ScopeUserReadCurrentlyPlaying,
+	spotifyauth.ScopeUserReadPlaybackState,
+	spotifyauth.ScopeUserModifyPlaybackState,
+	spotifyauth.ScopeUserReadRecentlyPlayed,
+}
+
+// ParseAuthMode validates the auth mode. Without a mode PKCE is used, unless a client secret is set.
+func ParseAuthMode(mode string, clientSecret string) (AuthMode, error) {
+	switch AuthMode(mode) {
+	case "":
+		if clientSecret != "" {
+			return AuthModeSecret, nil
+		}
+		return AuthModePKCE, nil
+	case AuthModeSecret:
+		if clientSecret == "" {
+			return "", ErrorMissingClientSecret
+		}
+		return AuthModeSecret, nil
+	case AuthModePKCE:
+		return AuthModePKCE, nil
+	default:
+		return "", fmt.Errorf("unsupported Spotify auth mode: %s", mode)
+	}
+}
+
+// NewOAuthConfig returns the OAuth configuration for the Spotify accounts service. With PKCE the
+// client ID is sent in the request body and no secret is used, for the code exchange and for refreshes.
+func NewOAuthConfig(mode AuthMode, clientID string, clientSecret string, redirectURL string) *oauth2.Config {
+	config := &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   spotifyauth.AuthURL,
+			TokenURL:  spotifyauth.TokenURL,
+			AuthStyle: oauth2.AuthStyleInHeader,
+		},
+	}
+
+	if mode == AuthModePKCE {
+		config.ClientSecret = ""
+		config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+	}
+
+	return config
+}
+
+// newCodeVerifier returns a random PKCE code verifier.
+func newCodeVerifier() (string, error) {
+	verifier := make([]byte, codeVerifierSize)
+	if _, err := rand.Read(verifier); err != nil {
+		return "", fmt.Errorf("could not generate code verifier: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(verifier), nil
+}
+
+// codeChallenge returns the S256 PKCE challenge for the verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
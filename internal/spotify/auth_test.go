@@ -0,0 +1,106 @@
+package spotify
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func TestParseAuthMode(t *testing.T) {
+	mode, err := ParseAuthMode("", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, AuthModeSecret, mode)
+
+	mode, err = ParseAuthMode("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, AuthModePKCE, mode)
+
+	mode, err = ParseAuthMode("pkce", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, AuthModePKCE, mode)
+
+	_, err = ParseAuthMode("secret", "")
+	assert.ErrorIs(t, err, ErrorMissingClientSecret)
+
+	_, err = ParseAuthMode("implicit", "")
+	assert.Error(t, err)
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// base64url(sha256(verifier)) without padding
+	assert.Equal(t, "2I0opxH4i0v3dfIgzQ72tUZ8_l-sMusYChnyLqgLxaU", codeChallenge("dBjftJeZ4CVP-mJ92K9qXO6IhnRdwn8MvDpd1dQVvRs"))
+
+	verifier, err := newCodeVerifier()
+	assert.NoError(t, err)
+	assert.Len(t, verifier, 43)
+}
+
+// tokenRequests returns the form of every token request to the fake.
+func tokenRequests(fake *FakeServer) []FakeRequest {
+	var requests []FakeRequest
+	for _, request := range fake.Requests() {
+		if request.Method == http.MethodPost && request.Path == "/api/token" {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func TestPKCELogin(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModePKCE)
+
+	sessionID, authURL := startLogin(t, sm)
+
+	parsed, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, parsed.Query().Get("code_challenge"))
+	assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+
+	assert.NoError(t, sm.CompleteLogin(sm.ctx, sessionID, fakeLogin(t, authURL)))
+
+	session := sm.GetSession(sessionID)
+	if !assert.NotNil(t, session) || !assert.NotNil(t, session.Token) {
+		return
+	}
+	accessToken := session.Token.AccessToken
+
+	// Refreshing only needs the client ID as well
+	session.Token.Expiry = time.Now().Add(-time.Minute)
+	session = sm.GetSession(sessionID)
+	if assert.NotNil(t, session) {
+		assert.NotEqual(t, accessToken, session.Token.AccessToken)
+	}
+
+	requests := tokenRequests(fake)
+	if !assert.Len(t, requests, 2) {
+		return
+	}
+	for _, request := range requests {
+		form, err := url.ParseQuery(request.Body)
+		assert.NoError(t, err)
+		assert.Empty(t, request.Header.Get("Authorization"))
+		assert.Equal(t, "client_id", form.Get("client_id"))
+		assert.Empty(t, form.Get("client_secret"))
+
+		if form.Get("grant_type") == "authorization_code" {
+			assert.NotEmpty(t, form.Get("code_verifier"))
+		}
+	}
+}
+
+func TestSecretModeOmitsChallenge(t *testing.T) {
+	sm := newTestManager(t, NewMemorySessionStore(), nil)
+
+	_, authURL := startLogin(t, sm)
+	parsed, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.Empty(t, parsed.Query().Get("code_challenge"))
+
+	config := NewOAuthConfig(AuthModePKCE, "client_id", "client_secret", "")
+	assert.Empty(t, config.ClientSecret)
+	assert.Equal(t, oauth2.AuthStyleInParams, config.Endpoint.AuthStyle)
+}
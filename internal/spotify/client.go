@@ -0,0 +1,432 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	zSpotify "github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// ProfileCacheTTL is how long the profile of a session is cached.
+	ProfileCacheTTL = 5 * time.Minute
+	// PlaylistsCacheTTL is how long playlists are cached, and items of playlists without a known snapshot.
+	PlaylistsCacheTTL = time.Minute
+	// PlaylistItemsCacheTTL is how long the items of a playlist are cached while its snapshot is unchanged.
+	PlaylistItemsCacheTTL = 10 * time.Minute
+	// MaxRetryAfter is the longest Retry-After waited for, longer limits are returned as a RateLimitError.
+	MaxRetryAfter = 5 * time.Second
+
+	// maxRetries is how often a rate limited or failed request is retried.
+	maxRetries = 3
+	// retryBackoff is the delay before the first retry of a failed request, doubled for every retry.
+	retryBackoff = 500 * time.Millisecond
+	// defaultRetryAfter is waited when a rate limited response has no Retry-After.
+	defaultRetryAfter = time.Second
+)
+
+var (
+	ErrorUnauthorized = errors.New("the Spotify session is no longer valid, log in again")
+	ErrorNotFound     = errors.New("not found on Spotify")
+	ErrorRateLimited  = errors.New("Spotify rate limit reached, try again later")
+	ErrorUpstream     = errors.New("Spotify could not handle the request")
+)
+
+// RateLimitError is returned when Spotify still rate limits after the retries, or asks to wait
+// longer than MaxRetryAfter.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrorRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrorRateLimited
+}
+
+// TypedError maps Web API errors to typed errors, so callers can respond without knowing how
+// Spotify reports them. Spotify reports a missing scope as 401 or 403, and a missing device as
+// 404 with a reason message.
+func TypedError(err error) error {
+	var retrieveError *oauth2.RetrieveError
+	if errors.As(err, &retrieveError) {
+		return fmt.Errorf("%w: %s", ErrorUnauthorized, retrieveError.Error())
+	}
+
+	var apiError zSpotify.Error
+	if !errors.As(err, &apiError) {
+		return err
+	}
+
+	message := strings.ToLower(apiError.Message)
+	switch {
+	case apiError.Status == http.StatusNotFound && strings.Contains(message, "no active device"):
+		return fmt.Errorf("%w: %s", ErrorNoActiveDevice, apiError.Message)
+	case strings.Contains(message, "premium required"):
+		return fmt.Errorf("%w: %s", ErrorPremiumRequired, apiError.Message)
+	case (apiError.Status == http.StatusUnauthorized || apiError.Status == http.StatusForbidden) &&
+		(strings.Contains(message, "scope") || strings.Contains(message, "permissions missing")):
+		return fmt.Errorf("%w: %s", ErrorMissingScope, apiError.Message)
+	case apiError.Status == http.StatusUnauthorized:
+		return fmt.Errorf("%w: %s", ErrorUnauthorized, apiError.Message)
+	case apiError.Status == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrorNotFound, apiError.Message)
+	case apiError.Status == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrorRateLimited, apiError.Message)
+	case apiError.Status >= http.StatusInternalServerError:
+		return fmt.Errorf("%w: %s", ErrorUpstream, apiError.Message)
+	default:
+		return err
+	}
+}
+
+// Transport sends Web API requests through Base, waiting out rate limits, retrying server errors
+// and caching read-only responses in Cache when it is set.
+type Transport struct {
+	Base  http.RoundTripper
+	Cache *ResponseCache
+
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewTransport returns a transport sending requests through base, which adds the authentication.
+func NewTransport(base http.RoundTripper, cache *ResponseCache) *Transport {
+	return &Transport{Base: base, Cache: cache, sleep: sleep}
+}
+
+// noCacheKey marks a context whose requests skip the cached responses.
+type noCacheKey struct{}
+
+// WithoutCache returns a context whose requests are sent to Spotify even when a cached response is
+// available, for reads that have to be current, e.g. before a change. The fresh responses are still
+// cached for later requests.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// skipsCache reports whether the context was returned by WithoutCache.
+func skipsCache(ctx context.Context) bool {
+	skip, _ := ctx.Value(noCacheKey{}).(bool)
+	return skip
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if t.Cache != nil && r.Method == http.MethodGet && !skipsCache(r.Context()) {
+		if response := t.Cache.Get(r); response != nil {
+			return response, nil
+		}
+	}
+
+	response, err := t.send(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Cache != nil {
+		if r.Method != http.MethodGet {
+			t.Cache.Invalidate(r)
+		} else if response.StatusCode == http.StatusOK {
+			return t.Cache.Put(r, response)
+		}
+	}
+	return response, nil
+}
+
+// send sends the request, retrying it when it is rate limited, and when an idempotent request
+// fails with a server error.
+func (t *Transport) send(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	rewindable := r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+	for attempt := 0; ; attempt++ {
+		request, err := rewind(r, attempt)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := base.RoundTrip(request)
+		if err != nil {
+			return nil, err
+		}
+
+		var wait time.Duration
+		switch {
+		case response.StatusCode == http.StatusTooManyRequests:
+			// A rate limited request was not handled, so any method can be sent again
+			wait = retryAfter(response)
+			if wait > MaxRetryAfter || attempt >= maxRetries || !rewindable {
+				discard(response)
+				return nil, &RateLimitError{RetryAfter: wait}
+			}
+		case response.StatusCode >= http.StatusInternalServerError && isIdempotent(r.Method) && attempt < maxRetries && rewindable:
+			wait = retryBackoff << attempt
+		default:
+			return response, nil
+		}
+
+		discard(response)
+		if err := t.sleep(r.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rewind returns the request for the attempt, with a fresh body for retries.
+func rewind(r *http.Request, attempt int) (*http.Request, error) {
+	if attempt == 0 || r.GetBody == nil {
+		return r, nil
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	request := r.Clone(r.Context())
+	request.Body = body
+	return request, nil
+}
+
+// retryAfter reads the Retry-After header, given in seconds or as a date.
+func retryAfter(response *http.Response) time.Duration {
+	value := response.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date))
+	}
+	return defaultRetryAfter
+}
+
+// isIdempotent reports whether a request with the method can be sent again after a server error.
+// Adding tracks is a POST, sending it twice would add the tracks twice.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// discard reads and closes the body of a response that is not returned, so the connection is reused.
+func discard(response *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	response.Body.Close()
+}
+
+// sleep waits for the duration or until the context is canceled.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// cacheKind is the kind of read-only response that is cached.
+type cacheKind int
+
+const (
+	cacheNone cacheKind = iota
+	cacheProfile
+	cachePlaylists
+	cachePlaylist
+	cachePlaylistItems
+)
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	kind     cacheKind
+	playlist string // Playlist ID of playlist and playlist items responses
+	snapshot string // Snapshot of the playlist the items were read at
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+// ResponseCache caches the profile, playlists and playlist items of one session. Playlist items are
+// only reused while the snapshot of their playlist, learned from playlist responses, is unchanged.
+type ResponseCache struct {
+	mu        sync.Mutex
+	entries   map[string]cacheEntry // Keyed by the request URL
+	snapshots map[string]string     // Last seen snapshot ID, keyed by playlist ID
+	now       func() time.Time
+}
+
+// NewResponseCache returns an empty cache.
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{
+		entries:   make(map[string]cacheEntry),
+		snapshots: make(map[string]string),
+		now:       time.Now,
+	}
+}
+
+// Get returns the cached response to the request, or nil when there is none.
+func (c *ResponseCache) Get(r *http.Request) *http.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[r.URL.String()]
+	if !ok || !c.now().Before(entry.expires) {
+		return nil
+	}
+	if entry.kind == cachePlaylistItems && entry.snapshot != c.snapshots[entry.playlist] {
+		return nil
+	}
+
+	return cachedResponse(r, entry.header.Clone(), entry.body)
+}
+
+// Put caches a successful response to the request when it is read-only data, and returns a response
+// with the same content for the caller.
+func (c *ResponseCache) Put(r *http.Request, response *http.Response) (*http.Response, error) {
+	kind, playlist := classifyPath(r.URL.Path)
+	if kind == cacheNone {
+		return response, nil
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+
+	entry := cacheEntry{kind: kind, playlist: playlist, header: response.Header.Clone(), body: body}
+	switch kind {
+	case cacheProfile:
+		entry.expires = now.Add(ProfileCacheTTL)
+	case cachePlaylists:
+		entry.expires = now.Add(PlaylistsCacheTTL)
+		c.learnSnapshots(body)
+	case cachePlaylist:
+		entry.expires = now.Add(PlaylistsCacheTTL)
+		c.learnSnapshot(playlist, body)
+	case cachePlaylistItems:
+		entry.snapshot = c.snapshots[playlist]
+		entry.expires = now.Add(PlaylistsCacheTTL)
+		if entry.snapshot != "" {
+			entry.expires = now.Add(PlaylistItemsCacheTTL)
+		}
+	}
+	c.entries[r.URL.String()] = entry
+
+	return cachedResponse(r, response.Header, body), nil
+}
+
+// Invalidate forgets the cached data a changing request affects. Changing a playlist forgets its
+// items and snapshot, and the playlists of the user.
+func (c *ResponseCache) Invalidate(r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1")
+	if !strings.HasPrefix(path, "/playlists/") && !strings.HasPrefix(path, "/users/") {
+		return
+	}
+
+	var playlist string
+	if id, ok := strings.CutPrefix(path, "/playlists/"); ok {
+		playlist, _, _ = strings.Cut(id, "/")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.snapshots, playlist)
+	for key, entry := range c.entries {
+		if entry.kind == cachePlaylists || (playlist != "" && entry.playlist == playlist) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// learnSnapshots records the snapshots of a page of playlists. The caller must hold c.mu.
+func (c *ResponseCache) learnSnapshots(body []byte) {
+	var page struct {
+		Items []struct {
+			ID         string `json:"id"`
+			SnapshotID string `json:"snapshot_id"`
+		} `json:"items"`
+	}
+	if json.Unmarshal(body, &page) != nil {
+		return
+	}
+
+	for _, playlist := range page.Items {
+		if playlist.ID != "" && playlist.SnapshotID != "" {
+			c.snapshots[playlist.ID] = playlist.SnapshotID
+		}
+	}
+}
+
+// learnSnapshot records the snapshot of a playlist. The caller must hold c.mu.
+func (c *ResponseCache) learnSnapshot(playlist string, body []byte) {
+	var response struct {
+		SnapshotID string `json:"snapshot_id"`
+	}
+	if json.Unmarshal(body, &response) == nil && response.SnapshotID != "" {
+		c.snapshots[playlist] = response.SnapshotID
+	}
+}
+
+// classifyPath returns the kind of cached response for the path, and its playlist ID.
+func classifyPath(path string) (cacheKind, string) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/v1"), "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "me":
+		return cacheProfile, ""
+	case len(parts) == 2 && parts[0] == "me" && parts[1] == "playlists":
+		return cachePlaylists, ""
+	case len(parts) == 2 && parts[0] == "playlists":
+		return cachePlaylist, parts[1]
+	case len(parts) == 3 && parts[0] == "playlists" && parts[2] == "tracks":
+		return cachePlaylistItems, parts[1]
+	default:
+		return cacheNone, ""
+	}
+}
+
+// cachedResponse builds a 200 response with the body.
+func cachedResponse(r *http.Request, header http.Header, body []byte) *http.Response {
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
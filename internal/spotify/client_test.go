@@ -0,0 +1,223 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+// newTestTransport returns a client of the fake user using a transport that records its waits
+// instead of sleeping.
+func newTestTransport(fake *FakeServer, cache *ResponseCache) (*zSpotify.Client, *[]time.Duration) {
+	var waits []time.Duration
+	transport := NewTransport(fakeAuthTransport(fake), cache)
+	transport.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+
+	return zSpotify.New(&http.Client{Transport: transport}, zSpotify.WithBaseURL(fake.APIURL())), &waits
+}
+
+// fakeAuthTransport returns a transport authenticating requests as the fake user.
+func fakeAuthTransport(fake *FakeServer) http.RoundTripper {
+	return &oauth2.Transport{Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: addFakeToken(fake, "fake-user")})}
+}
+
+// requestBodies returns the bodies of the requests to the fake with the method and path.
+func requestBodies(fake *FakeServer, method string, path string) []string {
+	var bodies []string
+	for _, request := range fake.Requests() {
+		if request.Method == method && request.Path == path {
+			bodies = append(bodies, request.Body)
+		}
+	}
+	return bodies
+}
+
+func TestTransportWaitsForRetryAfter(t *testing.T) {
+	fake := startFake(t, nil)
+	fake.Fail(FakeFailure{Status: http.StatusTooManyRequests, RetryAfter: "2"}, FakeFailure{Status: http.StatusTooManyRequests})
+	client, waits := newTestTransport(fake, nil)
+
+	user, err := client.CurrentUser(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "fake-user", user.ID)
+	assert.Equal(t, []time.Duration{2 * time.Second, defaultRetryAfter}, *waits)
+	assert.Equal(t, 3, fake.Count(http.MethodGet, "/v1/me"))
+}
+
+func TestTransportReturnsLongRateLimits(t *testing.T) {
+	fake := startFake(t, nil)
+	fake.Fail(FakeFailure{Status: http.StatusTooManyRequests, RetryAfter: "60"})
+	client, waits := newTestTransport(fake, nil)
+
+	_, err := client.CurrentUser(context.Background())
+	var rateLimit *RateLimitError
+	if assert.ErrorAs(t, err, &rateLimit) {
+		assert.Equal(t, time.Minute, rateLimit.RetryAfter)
+	}
+	assert.ErrorIs(t, TypedError(err), ErrorRateLimited)
+	assert.Empty(t, *waits)
+	assert.Equal(t, 1, fake.Count(http.MethodGet, "/v1/me"))
+}
+
+func TestTransportRetriesRateLimitedWrites(t *testing.T) {
+	fake := startFake(t, nil)
+	fake.Fail(FakeFailure{Status: http.StatusTooManyRequests, RetryAfter: "1"})
+	client, _ := newTestTransport(fake, nil)
+
+	_, err := client.AddTracksToPlaylist(context.Background(), "playlist-focus", "track-01")
+	assert.NoError(t, err)
+
+	// The body is sent again with the retry
+	bodies := requestBodies(fake, http.MethodPost, "/v1/playlists/playlist-focus/tracks")
+	if assert.Len(t, bodies, 2) {
+		assert.Equal(t, bodies[0], bodies[1])
+		assert.Contains(t, bodies[1], "spotify:track:track-01")
+	}
+}
+
+func TestTransportRetriesServerErrors(t *testing.T) {
+	fake := startFake(t, nil)
+	fake.Fail(FakeFailure{Status: http.StatusBadGateway}, FakeFailure{Status: http.StatusServiceUnavailable})
+	client, waits := newTestTransport(fake, nil)
+
+	_, err := client.CurrentUser(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{retryBackoff, 2 * retryBackoff}, *waits)
+	assert.Equal(t, 3, fake.Count(http.MethodGet, "/v1/me"))
+
+	// After the retries the error is returned
+	failure := FakeFailure{Status: http.StatusInternalServerError}
+	fake.Fail(failure, failure, failure, failure)
+	_, err = client.CurrentUser(context.Background())
+	assert.ErrorIs(t, TypedError(err), ErrorUpstream)
+	assert.Equal(t, 7, fake.Count(http.MethodGet, "/v1/me"))
+}
+
+func TestTransportDoesNotRetryFailedPosts(t *testing.T) {
+	fake := startFake(t, nil)
+	fake.Fail(FakeFailure{Status: http.StatusInternalServerError})
+	client, waits := newTestTransport(fake, nil)
+
+	_, err := client.AddTracksToPlaylist(context.Background(), "playlist-focus", "track-01")
+	assert.ErrorIs(t, TypedError(err), ErrorUpstream)
+	assert.Empty(t, *waits)
+	assert.Equal(t, 1, fake.Count(http.MethodPost, "/v1/playlists/playlist-focus/tracks"))
+}
+
+func TestTransportStopsWaitingWhenCanceled(t *testing.T) {
+	fake := startFake(t, nil)
+	fake.Fail(FakeFailure{Status: http.StatusTooManyRequests, RetryAfter: "1"})
+	client := zSpotify.New(&http.Client{Transport: NewTransport(fakeAuthTransport(fake), nil)}, zSpotify.WithBaseURL(fake.APIURL()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.CurrentUser(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestResponseCache(t *testing.T) {
+	fake := startFake(t, nil)
+	cache := NewResponseCache()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	client, _ := newTestTransport(fake, cache)
+	ctx := context.Background()
+
+	// The profile is reused until it expires
+	for i := 0; i < 2; i++ {
+		_, err := client.CurrentUser(ctx)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, fake.Count(http.MethodGet, "/v1/me"))
+
+	now = now.Add(ProfileCacheTTL)
+	_, err := client.CurrentUser(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.Count(http.MethodGet, "/v1/me"))
+
+	// Items are reused while the snapshot of the playlist is unchanged
+	_, err = client.CurrentUsersPlaylists(ctx)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		_, err = client.GetPlaylistItems(ctx, "playlist-focus")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, fake.Count(http.MethodGet, "/v1/playlists/playlist-focus/tracks"))
+
+	// Past the playlists TTL, items are still valid for the same snapshot
+	now = now.Add(PlaylistsCacheTTL)
+	_, err = client.CurrentUsersPlaylists(ctx)
+	assert.NoError(t, err)
+	_, err = client.GetPlaylistItems(ctx, "playlist-focus")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.Count(http.MethodGet, "/v1/me/playlists"))
+	assert.Equal(t, 1, fake.Count(http.MethodGet, "/v1/playlists/playlist-focus/tracks"))
+
+	// A snapshot changed elsewhere is noticed when the playlists are read again
+	fake.mu.Lock()
+	fake.playlists["playlist-focus"].snapshot++
+	fake.mu.Unlock()
+	now = now.Add(PlaylistsCacheTTL)
+	_, err = client.CurrentUsersPlaylists(ctx)
+	assert.NoError(t, err)
+	_, err = client.GetPlaylistItems(ctx, "playlist-focus")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fake.Count(http.MethodGet, "/v1/playlists/playlist-focus/tracks"))
+
+	// Changing the playlist forgets its items and the playlists
+	_, err = client.AddTracksToPlaylist(ctx, "playlist-focus", "track-01")
+	assert.NoError(t, err)
+	_, err = client.GetPlaylistItems(ctx, "playlist-focus")
+	assert.NoError(t, err)
+	_, err = client.CurrentUsersPlaylists(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, fake.Count(http.MethodGet, "/v1/playlists/playlist-focus/tracks"))
+	assert.Equal(t, 4, fake.Count(http.MethodGet, "/v1/me/playlists"))
+}
+
+func TestSessionClientUsesCache(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+	session := fakeSession(t, sm)
+
+	for i := 0; i < 2; i++ {
+		_, err := session.Client.CurrentUser(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, fake.Count(http.MethodGet, "/v1/me"))
+}
+
+func TestWithoutCacheSkipsCachedResponses(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+	session := fakeSession(t, sm)
+	ctx := context.Background()
+
+	user, err := session.Client.CurrentUser(ctx)
+	assert.NoError(t, err)
+
+	fake.mu.Lock()
+	fake.users[user.ID].DisplayName = "Renamed"
+	fake.mu.Unlock()
+
+	// The cached profile is returned until the cache is skipped, which also updates it
+	user, err = session.Client.CurrentUser(ctx)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "Renamed", user.DisplayName)
+
+	user, err = session.Client.CurrentUser(WithoutCache(ctx))
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", user.DisplayName)
+
+	user, err = session.Client.CurrentUser(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "Renamed", user.DisplayName)
+}
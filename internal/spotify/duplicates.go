@@ -0,0 +1,358 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// DuplicateReason is why a track is considered a duplicate of an earlier one.
+type DuplicateReason string
+
+const (
+	// DuplicateExact is the same track ID.
+	DuplicateExact DuplicateReason = "exact"
+	// DuplicateISRC is a different track with the same recording code, e.g. a single and its album version.
+	DuplicateISRC DuplicateReason = "isrc"
+	// DuplicateTitle is the same normalized title and primary artist, e.g. a remaster and the original.
+	DuplicateTitle DuplicateReason = "title"
+)
+
+const (
+	// maxPlaylistChange is the largest number of items added or removed in one request.
+	maxPlaylistChange = 100
+	// MaxMergePlaylists is the largest number of playlists that can be merged at once.
+	MaxMergePlaylists = 50
+)
+
+var (
+	ErrorPlaylistChanged = errors.New("the playlist changed since the preview, preview the duplicates again")
+	ErrorNoPlaylists     = errors.New("at least one playlist is required")
+)
+
+// versionPattern matches title suffixes that name a version of the same recording, such as
+// "(2011 Remaster)" or " - Remastered Version", and featured artists.
+var versionPattern = regexp.MustCompile(`(?i)\s*(\([^)]*\)|\[[^\]]*\]|\s-\s.*)$`)
+
+// versionKeywords mark a suffix as naming a version rather than a different song. Remixes and
+// live recordings are kept apart as they are different recordings.
+var versionKeywords = []string{"remaster", "version", "mono", "stereo", "deluxe", "edition", "anniversary", "bonus", "feat", "ft.", "with "}
+
+// DuplicateOptions selects which duplicates are found.
+type DuplicateOptions struct {
+	Likely bool // Also match tracks with the same ISRC or normalized title and artist
+}
+
+// DuplicateTrack is a track at a position in a playlist.
+type DuplicateTrack struct {
+	Position int             `json:"position"`
+	Reason   DuplicateReason `json:"reason,omitempty"` // Empty for the track that is kept
+	ExportTrack
+}
+
+// DuplicateGroup is a kept track and the later tracks that repeat it.
+type DuplicateGroup struct {
+	Original   DuplicateTrack   `json:"original"`
+	Duplicates []DuplicateTrack `json:"duplicates"`
+}
+
+// DuplicateReport lists the duplicates in a playlist. The snapshot ID identifies the version of the
+// playlist the positions refer to.
+type DuplicateReport struct {
+	PlaylistID string           `json:"playlist_id"`
+	Name       string           `json:"name"`
+	SnapshotID string           `json:"snapshot_id"`
+	Total      int              `json:"total"`
+	Duplicates int              `json:"duplicates"`
+	Groups     []DuplicateGroup `json:"groups"`
+	Removed    int              `json:"removed,omitempty"`
+}
+
+// duplicateMatcher finds tracks repeating earlier ones, by ID and optionally by ISRC and normalized title.
+type duplicateMatcher struct {
+	likely bool
+	groups map[string]int // Match keys to the index of their group
+}
+
+// matchKey is a key tracks are matched on and the reason reported when it matches.
+type matchKey struct {
+	reason DuplicateReason
+	key    string
+}
+
+func newDuplicateMatcher(options DuplicateOptions) *duplicateMatcher {
+	return &duplicateMatcher{likely: options.Likely, groups: make(map[string]int)}
+}
+
+// match returns the group the track belongs to and why, or -1 for a track seen the first time,
+// which is then added as the group with the index next.
+func (m *duplicateMatcher) match(track ExportTrack, next int) (int, DuplicateReason) {
+	keys := []matchKey{{DuplicateExact, "uri:" + track.URI}}
+	if m.likely {
+		if track.ISRC != "" {
+			keys = append(keys, matchKey{DuplicateISRC, "isrc:" + strings.ToUpper(track.ISRC)})
+		}
+		if title := normalizedTitleKey(track); title != "" {
+			keys = append(keys, matchKey{DuplicateTitle, "title:" + title})
+		}
+	}
+
+	for _, k := range keys {
+		if group, ok := m.groups[k.key]; ok {
+			// Remember the other keys of this version too, so later versions match either
+			for _, other := range keys {
+				if _, ok := m.groups[other.key]; !ok {
+					m.groups[other.key] = group
+				}
+			}
+			return group, k.reason
+		}
+	}
+
+	for _, k := range keys {
+		m.groups[k.key] = next
+	}
+	return -1, ""
+}
+
+// normalizedTitleKey returns the title without version suffixes and the primary artist, folded to
+// lower case letters and digits.
+func normalizedTitleKey(track ExportTrack) string {
+	if len(track.Artists) == 0 {
+		return ""
+	}
+
+	title := track.Title
+	for {
+		match := versionPattern.FindStringIndex(title)
+		if match == nil || !isVersionSuffix(title[match[0]:]) || match[0] == 0 {
+			break
+		}
+		title = title[:match[0]]
+	}
+
+	title = normalizeText(title)
+	artist := normalizeText(track.Artists[0])
+	if title == "" || artist == "" {
+		return ""
+	}
+	return title + "|" + artist
+}
+
+// isVersionSuffix reports whether a title suffix names a version of the same recording.
+func isVersionSuffix(suffix string) bool {
+	suffix = strings.ToLower(suffix)
+	for _, keyword := range versionKeywords {
+		if strings.Contains(suffix, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeText keeps lower case letters and digits separated by single spaces.
+func normalizeText(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// FindDuplicates walks the playlist and groups its duplicates. Nothing is changed. The playlist is
+// read past the response cache, so the snapshot and positions match the playlist being changed.
+func FindDuplicates(ctx context.Context, client *zSpotify.Client, playlistID zSpotify.ID, options DuplicateOptions) (*DuplicateReport, error) {
+	ctx = WithoutCache(ctx)
+
+	playlist, err := client.GetPlaylist(ctx, playlistID, zSpotify.Fields("id,name,snapshot_id"))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DuplicateReport{
+		PlaylistID: playlist.ID.String(),
+		Name:       playlist.Name,
+		SnapshotID: playlist.SnapshotID,
+		Groups:     []DuplicateGroup{},
+	}
+
+	matcher := newDuplicateMatcher(options)
+	var groups []DuplicateGroup
+	position := 0
+	err = EachPlaylistItem(ctx, client, playlistID, PageOptions{All: true}, func(item zSpotify.PlaylistItem) error {
+		defer func() { position++ }()
+
+		// Local files and unavailable tracks can not be matched reliably
+		track, ok := exportTrack(item)
+		if !ok || item.IsLocal || track.URI == "" {
+			return nil
+		}
+
+		group, reason := matcher.match(track, len(groups))
+		if group < 0 {
+			groups = append(groups, DuplicateGroup{Original: DuplicateTrack{Position: position, ExportTrack: track}})
+			return nil
+		}
+
+		groups[group].Duplicates = append(groups[group].Duplicates, DuplicateTrack{Position: position, Reason: reason, ExportTrack: track})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.Total = position
+	for _, group := range groups {
+		if len(group.Duplicates) > 0 {
+			report.Groups = append(report.Groups, group)
+			report.Duplicates += len(group.Duplicates)
+		}
+	}
+
+	return report, nil
+}
+
+// RemoveDuplicates finds the duplicates in the playlist again and removes them, keeping the first
+// occurrence of every track. When a snapshot ID from a preview is given and the playlist has changed
+// since, nothing is removed and ErrorPlaylistChanged is returned.
+func RemoveDuplicates(ctx context.Context, client *zSpotify.Client, playlistID zSpotify.ID, options DuplicateOptions, snapshotID string) (*DuplicateReport, error) {
+	report, err := FindDuplicates(ctx, client, playlistID, options)
+	if err != nil {
+		return nil, err
+	}
+	if snapshotID != "" && snapshotID != report.SnapshotID {
+		return nil, ErrorPlaylistChanged
+	}
+
+	var duplicates []DuplicateTrack
+	for _, group := range report.Groups {
+		duplicates = append(duplicates, group.Duplicates...)
+	}
+
+	// Remove from the end so positions of earlier batches do not move
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Position > duplicates[j].Position
+	})
+
+	snapshot := report.SnapshotID
+	for start := 0; start < len(duplicates); start += maxPlaylistChange {
+		batch := duplicates[start:min(start+maxPlaylistChange, len(duplicates))]
+
+		var tracks []zSpotify.TrackToRemove
+		index := make(map[string]int)
+		for _, duplicate := range batch {
+			i, ok := index[duplicate.URI]
+			if !ok {
+				i = len(tracks)
+				index[duplicate.URI] = i
+				tracks = append(tracks, zSpotify.TrackToRemove{URI: duplicate.URI})
+			}
+			tracks[i].Positions = append(tracks[i].Positions, duplicate.Position)
+		}
+
+		snapshot, err = client.RemoveTracksFromPlaylistOpt(ctx, playlistID, tracks, snapshot)
+		if err != nil {
+			return report, fmt.Errorf("removed %d of %d duplicates: %w", report.Removed, len(duplicates), TypedError(err))
+		}
+		report.Removed += len(batch)
+	}
+
+	report.SnapshotID = snapshot
+	return report, nil
+}
+
+// MergeOptions describes a new playlist combining other playlists.
+type MergeOptions struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Public      bool     `json:"public"`
+	PlaylistIDs []string `json:"playlist_ids"`
+	Dedupe      bool     `json:"dedupe"`
+	Likely      bool     `json:"likely"` // Dedupe likely duplicates too, see DuplicateOptions
+}
+
+// MergeResult reports the playlist created by a merge.
+type MergeResult struct {
+	PlaylistID string `json:"playlist_id"`
+	URI        string `json:"uri"`
+	Added      int    `json:"added"`
+	Duplicates int    `json:"duplicates"`
+	Skipped    int    `json:"skipped"` // Episodes, local files and unavailable tracks
+}
+
+// Validate checks the options.
+func (o *MergeOptions) Validate() error {
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		return fmt.Errorf("a playlist name is required")
+	}
+	if len(o.PlaylistIDs) == 0 {
+		return ErrorNoPlaylists
+	}
+	if len(o.PlaylistIDs) > MaxMergePlaylists {
+		return fmt.Errorf("at most %d playlists can be merged", MaxMergePlaylists)
+	}
+	return nil
+}
+
+// MergePlaylists creates a playlist for the current user with the tracks of the playlists, in order,
+// leaving out duplicates when options.Dedupe is set.
+func MergePlaylists(ctx context.Context, client *zSpotify.Client, options MergeOptions) (*MergeResult, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+
+	result := &MergeResult{}
+	matcher := newDuplicateMatcher(DuplicateOptions{Likely: options.Likely})
+	var ids []zSpotify.ID
+
+	// Read every playlist before creating the new one, so a bad ID leaves nothing behind
+	for _, playlistID := range options.PlaylistIDs {
+		err := EachPlaylistItem(ctx, client, zSpotify.ID(playlistID), PageOptions{All: true}, func(item zSpotify.PlaylistItem) error {
+			if item.Track.Track == nil || item.IsLocal || item.Track.Track.ID == "" {
+				result.Skipped++
+				return nil
+			}
+
+			track, _ := exportTrack(item)
+			if options.Dedupe {
+				if group, _ := matcher.match(track, len(ids)); group >= 0 {
+					result.Duplicates++
+					return nil
+				}
+			}
+
+			ids = append(ids, item.Track.Track.ID)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", playlistID, err)
+		}
+	}
+
+	user, err := client.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := client.CreatePlaylistForUser(ctx, user.ID, options.Name, options.Description, options.Public, false)
+	if err != nil {
+		return nil, TypedError(err)
+	}
+	result.PlaylistID = playlist.ID.String()
+	result.URI = string(playlist.URI)
+
+	for start := 0; start < len(ids); start += maxPlaylistChange {
+		batch := ids[start:min(start+maxPlaylistChange, len(ids))]
+		if _, err := client.AddTracksToPlaylist(ctx, playlist.ID, batch...); err != nil {
+			return result, fmt.Errorf("added %d of %d tracks: %w", result.Added, len(ids), TypedError(err))
+		}
+		result.Added += len(batch)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,189 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// duplicateFixtures returns fixtures where mix has exact and likely duplicates, two shares a track
+// with mix, and big repeats ten tracks 25 times.
+func duplicateFixtures() *FakeFixtures {
+	fixtures := &FakeFixtures{
+		Users:   []FakeUser{{ID: "fake-user", Product: "premium"}},
+		Artists: []FakeArtist{{ID: "band", Name: "Band"}, {ID: "other", Name: "Other"}},
+		Tracks: []FakeTrack{
+			{ID: "a", Name: "Song A", Artists: []string{"band"}, ISRC: "ISRC-A"},
+			{ID: "b", Name: "Song B", Artists: []string{"band"}, ISRC: "ISRC-B"},
+			{ID: "a2", Name: "Song A - 2011 Remaster", Artists: []string{"band"}, ISRC: "ISRC-A2"},
+			{ID: "b2", Name: "Song B", Artists: []string{"band"}, ISRC: "isrc-b"},
+			{ID: "c", Name: "Song A (Live)", Artists: []string{"band"}, ISRC: "ISRC-C"},
+			{ID: "d", Name: "Song D", Artists: []string{"other"}},
+		},
+		Playlists: []FakePlaylist{
+			{ID: "mix", Owner: "fake-user", Tracks: []string{"a", "b", "a", "a2", "b2", "c", "a"}},
+			{ID: "two", Owner: "fake-user", Tracks: []string{"d", "b"}},
+		},
+	}
+
+	big := FakePlaylist{ID: "big", Owner: "fake-user"}
+	for i := 0; i < 250; i++ {
+		id := fmt.Sprintf("t%d", i%10)
+		if i < 10 {
+			fixtures.Tracks = append(fixtures.Tracks, FakeTrack{ID: id, Name: "Song", Artists: []string{"band"}})
+		}
+		big.Tracks = append(big.Tracks, id)
+	}
+	fixtures.Playlists = append(fixtures.Playlists, big)
+
+	return fixtures
+}
+
+// newDuplicatesClient returns a fake serving the duplicate fixtures and a client of its user.
+func newDuplicatesClient(t *testing.T) (*FakeServer, *zSpotify.Client) {
+	fake := startFake(t, duplicateFixtures())
+	return fake, newFakeClient(fake, "fake-user")
+}
+
+func TestFindExactDuplicates(t *testing.T) {
+	_, client := newDuplicatesClient(t)
+
+	report, err := FindDuplicates(context.Background(), client, "mix", DuplicateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, report.Total)
+	assert.Equal(t, 2, report.Duplicates)
+	if assert.Len(t, report.Groups, 1) {
+		group := report.Groups[0]
+		assert.Equal(t, 0, group.Original.Position)
+		assert.Equal(t, []int{2, 6}, positions(group.Duplicates))
+		assert.Equal(t, DuplicateExact, group.Duplicates[0].Reason)
+	}
+}
+
+func TestFindLikelyDuplicates(t *testing.T) {
+	_, client := newDuplicatesClient(t)
+
+	report, err := FindDuplicates(context.Background(), client, "mix", DuplicateOptions{Likely: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Duplicates)
+	if assert.Len(t, report.Groups, 2) {
+		assert.Equal(t, []int{2, 3, 6}, positions(report.Groups[0].Duplicates))
+		assert.Equal(t, DuplicateTitle, report.Groups[0].Duplicates[1].Reason)
+
+		// The ISRC matches regardless of case
+		assert.Equal(t, []int{4}, positions(report.Groups[1].Duplicates))
+		assert.Equal(t, DuplicateISRC, report.Groups[1].Duplicates[0].Reason)
+	}
+}
+
+func TestRemoveDuplicates(t *testing.T) {
+	fake, client := newDuplicatesClient(t)
+
+	preview, err := FindDuplicates(context.Background(), client, "mix", DuplicateOptions{Likely: true})
+	assert.NoError(t, err)
+
+	report, err := RemoveDuplicates(context.Background(), client, "mix", DuplicateOptions{Likely: true}, preview.SnapshotID)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Removed)
+	assert.Equal(t, []string{"a", "b", "c"}, fakeTracks(fake, "mix"))
+
+	// A stale preview is rejected without changing anything
+	_, err = RemoveDuplicates(context.Background(), client, "mix", DuplicateOptions{}, preview.SnapshotID)
+	assert.ErrorIs(t, err, ErrorPlaylistChanged)
+}
+
+func TestRemoveDuplicatesSkipsCachedSnapshot(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+	session := fakeSession(t, sm)
+	ctx := context.Background()
+
+	preview, err := FindDuplicates(ctx, session.Client, "playlist-road-trip", DuplicateOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, preview.Duplicates)
+
+	// Cache the playlist, then change it elsewhere
+	_, err = session.Client.GetPlaylist(ctx, "playlist-road-trip", zSpotify.Fields("id,name,snapshot_id"))
+	assert.NoError(t, err)
+	fake.mu.Lock()
+	playlist := fake.playlists["playlist-road-trip"]
+	playlist.Tracks = append(playlist.Tracks, "track-01")
+	playlist.snapshot++
+	fake.mu.Unlock()
+
+	_, err = RemoveDuplicates(ctx, session.Client, "playlist-road-trip", DuplicateOptions{}, preview.SnapshotID)
+	assert.ErrorIs(t, err, ErrorPlaylistChanged)
+
+	fake.mu.Lock()
+	assert.Len(t, fake.playlists["playlist-road-trip"].Tracks, 7)
+	fake.mu.Unlock()
+}
+
+func TestRemoveDuplicatesInBatches(t *testing.T) {
+	fake, client := newDuplicatesClient(t)
+
+	report, err := RemoveDuplicates(context.Background(), client, "big", DuplicateOptions{}, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 240, report.Removed)
+	assert.Equal(t, 3, fake.Count(http.MethodDelete, "/v1/playlists/big/tracks"))
+	assert.Equal(t, []string{"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7", "t8", "t9"}, fakeTracks(fake, "big"))
+}
+
+func TestMergePlaylists(t *testing.T) {
+	fake, client := newDuplicatesClient(t)
+
+	result, err := MergePlaylists(context.Background(), client, MergeOptions{
+		Name:        "Merged",
+		PlaylistIDs: []string{"mix", "two"},
+		Dedupe:      true,
+		Likely:      true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, result.Added)
+	assert.Equal(t, 5, result.Duplicates)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, fakeTracks(fake, result.PlaylistID))
+
+	// Without dedupe every track is kept
+	result, err = MergePlaylists(context.Background(), client, MergeOptions{Name: "All", PlaylistIDs: []string{"mix", "two"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 9, result.Added)
+}
+
+func TestMergePlaylistsValidation(t *testing.T) {
+	fake, client := newDuplicatesClient(t)
+
+	_, err := MergePlaylists(context.Background(), client, MergeOptions{Name: "Merged"})
+	assert.ErrorIs(t, err, ErrorNoPlaylists)
+
+	_, err = MergePlaylists(context.Background(), client, MergeOptions{PlaylistIDs: []string{"one"}})
+	assert.Error(t, err)
+
+	// A missing playlist fails before anything is created
+	_, err = MergePlaylists(context.Background(), client, MergeOptions{Name: "Merged", PlaylistIDs: []string{"missing"}})
+	assert.Error(t, err)
+	assert.Zero(t, fake.Count(http.MethodPost, "/v1/users/fake-user/playlists"))
+}
+
+func TestNormalizedTitleKey(t *testing.T) {
+	key := normalizedTitleKey(ExportTrack{Title: "Song A", Artists: []string{"Band"}})
+	assert.Equal(t, "song a|band", key)
+
+	for _, title := range []string{"Song A - Remastered 2009", "Song A (2011 Remaster)", "Song A [Mono Version]", "SONG A (feat. Guest)", "Song-A"} {
+		assert.Equal(t, key, normalizedTitleKey(ExportTrack{Title: title, Artists: []string{"band"}}), title)
+	}
+	for _, title := range []string{"Song A (Live)", "Song A - Radio Edit", "Song A Remix"} {
+		assert.NotEqual(t, key, normalizedTitleKey(ExportTrack{Title: title, Artists: []string{"Band"}}), title)
+	}
+	assert.Empty(t, normalizedTitleKey(ExportTrack{Title: "Song A"}))
+}
+
+func positions(tracks []DuplicateTrack) []int {
+	var result []int
+	for _, track := range tracks {
+		result = append(result, track.Position)
+	}
+	return result
+}
@@ -0,0 +1,365 @@
+package spotify
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"rory-pearson/pkg/util"
+	"strconv"
+	"strings"
+	"unicode"
+
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// ExportFormat is a file format playlists can be exported to.
+type ExportFormat string
+
+const (
+	ExportCSV  ExportFormat = "csv"
+	ExportJSON ExportFormat = "json"
+	ExportM3U  ExportFormat = "m3u"
+	ExportXSPF ExportFormat = "xspf"
+)
+
+// ParseExportFormat validates an export format name. An empty name is CSV.
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch ExportFormat(strings.ToLower(format)) {
+	case "", ExportCSV:
+		return ExportCSV, nil
+	case ExportJSON:
+		return ExportJSON, nil
+	case ExportM3U, "m3u8":
+		return ExportM3U, nil
+	case ExportXSPF:
+		return ExportXSPF, nil
+	default:
+		return "", fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// Extension returns the file extension of the format. M3U files are UTF-8, so they use .m3u8.
+func (f ExportFormat) Extension() string {
+	if f == ExportM3U {
+		return ".m3u8"
+	}
+	return "." + string(f)
+}
+
+// ContentType returns the MIME type of the format.
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case ExportJSON:
+		return "application/json"
+	case ExportM3U:
+		return "audio/x-mpegurl"
+	case ExportXSPF:
+		return "application/xspf+xml"
+	default:
+		return "text/csv"
+	}
+}
+
+// ExportTrack is a track as written to an export.
+type ExportTrack struct {
+	Title      string   `json:"title"`
+	Artists    []string `json:"artists"`
+	Album      string   `json:"album"`
+	DurationMs int      `json:"duration_ms"`
+	ISRC       string   `json:"isrc,omitempty"`
+	URI        string   `json:"uri"`
+}
+
+// PlaylistExport is a playlist with every one of its tracks.
+type PlaylistExport struct {
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	Description string        `json:"description,omitempty"`
+	Owner       string        `json:"owner,omitempty"`
+	URI         string        `json:"uri"`
+	Tracks      []ExportTrack `json:"tracks"`
+}
+
+// ExportPlaylist fetches the playlist and every page of its tracks.
+func ExportPlaylist(ctx context.Context, client *zSpotify.Client, playlistID zSpotify.ID) (*PlaylistExport, error) {
+	playlist, err := client.GetPlaylist(ctx, playlistID, zSpotify.Fields("id,name,description,owner(id,display_name),uri"))
+	if err != nil {
+		return nil, err
+	}
+
+	return exportPlaylist(ctx, client, playlist.SimplePlaylist)
+}
+
+// exportPlaylist fetches the tracks of the playlist.
+func exportPlaylist(ctx context.Context, client *zSpotify.Client, playlist zSpotify.SimplePlaylist) (*PlaylistExport, error) {
+	owner := playlist.Owner.DisplayName
+	if owner == "" {
+		owner = playlist.Owner.ID
+	}
+
+	export := &PlaylistExport{
+		ID:          playlist.ID.String(),
+		Name:        playlist.Name,
+		Description: playlist.Description,
+		Owner:       owner,
+		URI:         string(playlist.URI),
+		Tracks:      []ExportTrack{},
+	}
+
+	err := EachPlaylistItem(ctx, client, playlist.ID, PageOptions{All: true}, func(item zSpotify.PlaylistItem) error {
+		if track, ok := exportTrack(item); ok {
+			export.Tracks = append(export.Tracks, track)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// exportTrack converts a playlist item. Items that are no longer available have no track and are skipped,
+// episodes are exported with their show as the album.
+func exportTrack(item zSpotify.PlaylistItem) (ExportTrack, bool) {
+	switch {
+	case item.Track.Track != nil:
+		track := item.Track.Track
+
+		artists := make([]string, 0, len(track.Artists))
+		for _, artist := range track.Artists {
+			artists = append(artists, artist.Name)
+		}
+
+		// FullTrack shadows the external IDs of SimpleTrack with a map
+		isrc := track.ExternalIDs["isrc"]
+		if isrc == "" {
+			isrc = track.SimpleTrack.ExternalIDs.ISRC
+		}
+
+		return ExportTrack{
+			Title:      track.Name,
+			Artists:    artists,
+			Album:      track.Album.Name,
+			DurationMs: int(track.Duration),
+			ISRC:       isrc,
+			URI:        string(track.URI),
+		}, true
+	case item.Track.Episode != nil:
+		episode := item.Track.Episode
+
+		var artists []string
+		if episode.Show.Publisher != "" {
+			artists = append(artists, episode.Show.Publisher)
+		}
+
+		return ExportTrack{
+			Title:      episode.Name,
+			Artists:    artists,
+			Album:      episode.Show.Name,
+			DurationMs: int(episode.Duration_ms),
+			URI:        string(episode.URI),
+		}, true
+	default:
+		return ExportTrack{}, false
+	}
+}
+
+// Write writes the playlist in the format.
+func (p *PlaylistExport) Write(w io.Writer, format ExportFormat) error {
+	switch format {
+	case ExportCSV:
+		return p.writeCSV(w)
+	case ExportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(p)
+	case ExportM3U:
+		return p.writeM3U(w)
+	case ExportXSPF:
+		return p.writeXSPF(w)
+	default:
+		return fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+// FileName returns the name of the export file for the playlist.
+func (p *PlaylistExport) FileName(format ExportFormat) string {
+	return exportFileName(p.Name, format, make(map[string]int))
+}
+
+func (p *PlaylistExport) writeCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"title", "artists", "album", "duration_ms", "isrc", "uri"}); err != nil {
+		return err
+	}
+
+	for _, track := range p.Tracks {
+		err := writer.Write([]string{
+			track.Title,
+			strings.Join(track.Artists, "; "),
+			track.Album,
+			strconv.Itoa(track.DurationMs),
+			track.ISRC,
+			track.URI,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeM3U writes an extended M3U playlist with the Spotify URIs as locations.
+func (p *PlaylistExport) writeM3U(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "#EXTM3U\n#PLAYLIST:%s\n", m3uLine(p.Name)); err != nil {
+		return err
+	}
+
+	for _, track := range p.Tracks {
+		seconds := int(math.Round(float64(track.DurationMs) / 1000))
+		title := track.Title
+		if len(track.Artists) > 0 {
+			title = strings.Join(track.Artists, ", ") + " - " + title
+		}
+
+		if _, err := fmt.Fprintf(w, "#EXTINF:%d,%s\n", seconds, m3uLine(title)); err != nil {
+			return err
+		}
+		if track.Album != "" {
+			if _, err := fmt.Fprintf(w, "#EXTALB:%s\n", m3uLine(track.Album)); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", track.URI); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// m3uLine keeps values on a single line.
+func m3uLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// xspfPlaylist is the XML shareable playlist format, see https://xspf.org/spec.
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version    string      `xml:"version,attr"`
+	Title      string      `xml:"title,omitempty"`
+	Creator    string      `xml:"creator,omitempty"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Location   string      `xml:"location,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   string `xml:"location"`
+	Identifier string `xml:"identifier,omitempty"`
+	Title      string `xml:"title"`
+	Creator    string `xml:"creator,omitempty"`
+	Album      string `xml:"album,omitempty"`
+	Duration   int    `xml:"duration"`
+}
+
+func (p *PlaylistExport) writeXSPF(w io.Writer) error {
+	playlist := xspfPlaylist{
+		Version:    "1",
+		Title:      p.Name,
+		Creator:    p.Owner,
+		Annotation: p.Description,
+		Location:   p.URI,
+		Tracks:     make([]xspfTrack, 0, len(p.Tracks)),
+	}
+
+	for _, track := range p.Tracks {
+		identifier := ""
+		if track.ISRC != "" {
+			identifier = "urn:isrc:" + track.ISRC
+		}
+
+		playlist.Tracks = append(playlist.Tracks, xspfTrack{
+			Location:   track.URI,
+			Identifier: identifier,
+			Title:      track.Title,
+			Creator:    strings.Join(track.Artists, ", "),
+			Album:      track.Album,
+			Duration:   track.DurationMs,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteAllPlaylistsZip exports every playlist of the current user into the zip writer,
+// one file per playlist.
+func WriteAllPlaylistsZip(ctx context.Context, client *zSpotify.Client, z *util.ZipWriter, format ExportFormat) error {
+	// Collect the playlists first so their tracks are not fetched while paging through them
+	var playlists []zSpotify.SimplePlaylist
+	err := EachPlaylist(ctx, client, PageOptions{All: true}, func(playlist zSpotify.SimplePlaylist) error {
+		playlists = append(playlists, playlist)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]int)
+	for _, playlist := range playlists {
+		export, err := exportPlaylist(ctx, client, playlist)
+		if err != nil {
+			return fmt.Errorf("%s: %w", playlist.Name, err)
+		}
+
+		if err := z.WriteFile(exportFileName(export.Name, format, used), func(w io.Writer) error {
+			return export.Write(w, format)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportFileName turns the playlist name into a safe file name, numbering repeated names.
+func exportFileName(name string, format ExportFormat, used map[string]int) string {
+	base := strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == ':' || r == '*' || r == '?' || r == '"' || r == '<' || r == '>' || r == '|':
+			return '_'
+		case unicode.IsControl(r):
+			return -1
+		default:
+			return r
+		}
+	}, strings.TrimSpace(name))
+	base = strings.Trim(base, ". ")
+	if base == "" {
+		base = "playlist"
+	}
+
+	used[base]++
+	if used[base] > 1 {
+		base = fmt.Sprintf("%s_%d", base, used[base]-1)
+	}
+
+	return base + format.Extension()
+}
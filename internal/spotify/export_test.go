@@ -0,0 +1,185 @@
+package spotify
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"rory-pearson/pkg/util"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	format, err := ParseExportFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, ExportCSV, format)
+
+	format, err = ParseExportFormat("XSPF")
+	assert.NoError(t, err)
+	assert.Equal(t, ExportXSPF, format)
+	assert.Equal(t, ".m3u8", ExportM3U.Extension())
+
+	_, err = ParseExportFormat("pls")
+	assert.Error(t, err)
+}
+
+func TestExportPlaylist(t *testing.T) {
+	client := newFakeClient(startFake(t, pagingFixtures(0, 150)), "fake-user")
+
+	export, err := ExportPlaylist(context.Background(), client, "road-trip")
+	assert.NoError(t, err)
+	assert.Equal(t, "Road/Trip", export.Name)
+	assert.Equal(t, "Owner", export.Owner)
+	if assert.Len(t, export.Tracks, 150) {
+		assert.Equal(t, ExportTrack{
+			Title:      "Track 0",
+			Artists:    []string{"Artist", "Guest"},
+			Album:      "Album",
+			DurationMs: 180000,
+			ISRC:       "ISRC00000",
+			URI:        "spotify:track:track-000",
+		}, export.Tracks[0])
+	}
+	assert.Equal(t, "Road_Trip.csv", export.FileName(ExportCSV))
+}
+
+// testExport returns a small export with characters that need escaping in every format.
+func testExport() *PlaylistExport {
+	return &PlaylistExport{
+		Name:  "Mix <1>",
+		Owner: "Owner",
+		URI:   "spotify:playlist:abc",
+		Tracks: []ExportTrack{
+			{Title: "Hello, World", Artists: []string{"A", "B"}, Album: "Album & Co", DurationMs: 61500, ISRC: "ISRC1", URI: "spotify:track:1"},
+			{Title: "Line\nbreak", Artists: []string{"C"}, DurationMs: 1000, URI: "spotify:track:2"},
+		},
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, testExport().Write(buf, ExportCSV))
+
+	records, err := csv.NewReader(buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"title", "artists", "album", "duration_ms", "isrc", "uri"},
+		{"Hello, World", "A; B", "Album & Co", "61500", "ISRC1", "spotify:track:1"},
+		{"Line\nbreak", "C", "", "1000", "", "spotify:track:2"},
+	}, records)
+}
+
+func TestExportJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, testExport().Write(buf, ExportJSON))
+
+	var decoded PlaylistExport
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, *testExport(), decoded)
+}
+
+func TestExportM3U(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, testExport().Write(buf, ExportM3U))
+
+	assert.Equal(t, strings.Join([]string{
+		"#EXTM3U",
+		"#PLAYLIST:Mix <1>",
+		"#EXTINF:62,A, B - Hello, World",
+		"#EXTALB:Album & Co",
+		"spotify:track:1",
+		"#EXTINF:1,C - Line break",
+		"spotify:track:2",
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestExportXSPF(t *testing.T) {
+	buf := new(bytes.Buffer)
+	assert.NoError(t, testExport().Write(buf, ExportXSPF))
+	assert.True(t, strings.HasPrefix(buf.String(), "<?xml"))
+
+	var decoded xspfPlaylist
+	assert.NoError(t, xml.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, "http://xspf.org/ns/0/", decoded.XMLName.Space)
+	assert.Equal(t, "Mix <1>", decoded.Title)
+	if assert.Len(t, decoded.Tracks, 2) {
+		assert.Equal(t, xspfTrack{
+			Location:   "spotify:track:1",
+			Identifier: "urn:isrc:ISRC1",
+			Title:      "Hello, World",
+			Creator:    "A, B",
+			Album:      "Album & Co",
+			Duration:   61500,
+		}, decoded.Tracks[0])
+	}
+}
+
+func TestWriteAllPlaylistsZip(t *testing.T) {
+	client := newFakeClient(startFake(t, pagingFixtures(3, 5)), "fake-user")
+
+	buf := new(bytes.Buffer)
+	z := util.NewZipWriter(buf)
+	assert.NoError(t, WriteAllPlaylistsZip(context.Background(), client, z, ExportJSON))
+	assert.NoError(t, z.Close())
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+
+	names := make([]string, 0, len(reader.File))
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	assert.Equal(t, []string{"Playlist 0.json", "Playlist 1.json", "Playlist 2.json"}, names)
+
+	file, err := reader.File[0].Open()
+	assert.NoError(t, err)
+	data, err := io.ReadAll(file)
+	assert.NoError(t, err)
+
+	var export PlaylistExport
+	assert.NoError(t, json.Unmarshal(data, &export))
+	assert.Equal(t, "playlist-000", export.ID)
+	assert.Len(t, export.Tracks, 5)
+}
+
+func TestWriteAllPlaylistsZipKeepsErrorType(t *testing.T) {
+	fake := startFake(t, pagingFixtures(1, 0))
+	client := newFakeClient(fake, "fake-user")
+
+	// The playlist disappeared after it was listed
+	fake.Fail(FakeFailure{Status: http.StatusNotFound, Message: "Not found.", Path: "/v1/playlists/playlist-000/tracks"})
+
+	err := WriteAllPlaylistsZip(context.Background(), client, util.NewZipWriter(io.Discard), ExportCSV)
+	assert.ErrorContains(t, err, "Playlist 0")
+	assert.ErrorIs(t, TypedError(err), ErrorNotFound)
+}
+
+func TestExportFileName(t *testing.T) {
+	used := make(map[string]int)
+	assert.Equal(t, "a_b.csv", exportFileName("a/b", ExportCSV, used))
+	assert.Equal(t, "a_b_1.csv", exportFileName("a/b", ExportCSV, used))
+	assert.Equal(t, "playlist.xspf", exportFileName(" .. ", ExportXSPF, used))
+}
+
+func TestExportSkipsUnavailableTracks(t *testing.T) {
+	_, ok := exportTrack(zSpotify.PlaylistItem{})
+	assert.False(t, ok)
+
+	track, ok := exportTrack(zSpotify.PlaylistItem{Track: zSpotify.PlaylistItemTrack{Episode: &zSpotify.EpisodePage{
+		Name:        "Episode",
+		Duration_ms: 1000,
+		URI:         "spotify:episode:1",
+		Show:        zSpotify.SimpleShow{Name: "Show", Publisher: "Publisher"},
+	}}})
+	assert.True(t, ok)
+	assert.Equal(t, ExportTrack{Title: "Episode", Artists: []string{"Publisher"}, Album: "Show", DurationMs: 1000, URI: "spotify:episode:1"}, track)
+}
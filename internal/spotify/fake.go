@@ -0,0 +1,840 @@
+package spotify
+
+import (
+	"bytes"
+	"crypto/rand"
+	_ "embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeFixtures are the users, artists, tracks and playlists served by default by the fake.
+//
+//go:embed fixtures/fake_spotify.json
+var fakeFixtures []byte
+
+const (
+	// FakeServerAddress is where the fake listens when it is switched on through the environment.
+	FakeServerAddress = "127.0.0.1:0"
+	// fakeTokenLifetime is how long access tokens of the fake are valid.
+	fakeTokenLifetime = time.Hour
+	// fakeAlbumImage is the cover of every album, so the UI renders without network access.
+	fakeAlbumImage = `<svg xmlns="http://www.w3.org/2000/svg" width="64" height="64"><rect width="64" height="64" fill="#1db954"/></svg>`
+)
+
+// FakeFixtures is the data served by the fake. Tracks, artists and owners are referenced by ID.
+type FakeFixtures struct {
+	Users     []FakeUser     `json:"users"`
+	Artists   []FakeArtist   `json:"artists"`
+	Tracks    []FakeTrack    `json:"tracks"`
+	Playlists []FakePlaylist `json:"playlists"`
+}
+
+// FakeUser is a user of the fake. The first user is logged in unless the authorize URL names another.
+type FakeUser struct {
+	ID             string          `json:"id"`
+	DisplayName    string          `json:"display_name"`
+	Email          string          `json:"email"`
+	Country        string          `json:"country"`
+	Product        string          `json:"product"`
+	NowPlaying     *FakeNowPlaying `json:"now_playing,omitempty"`
+	RecentlyPlayed []string        `json:"recently_played,omitempty"` // Track IDs, most recent first
+
+	plays []fakePlay // Most recent first
+}
+
+// fakePlay is a play of a track by a fake user.
+type fakePlay struct {
+	track    string
+	playedAt time.Time
+}
+
+// FakeNowPlaying is the playback of a fake user.
+type FakeNowPlaying struct {
+	Track      string `json:"track"`
+	ProgressMs int    `json:"progress_ms"`
+	Playing    bool   `json:"is_playing"`
+}
+
+// FakeArtist is an artist of the fake.
+type FakeArtist struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Genres []string `json:"genres"`
+}
+
+// FakeTrack is a track of the fake.
+type FakeTrack struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Artists    []string `json:"artists"`
+	Album      string   `json:"album"`
+	DurationMs int      `json:"duration_ms"`
+	ISRC       string   `json:"isrc"`
+}
+
+// FakePlaylist is a playlist of the fake.
+type FakePlaylist struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Owner       string   `json:"owner"`
+	Public      bool     `json:"public"`
+	Tracks      []string `json:"tracks"`
+
+	snapshot int
+}
+
+// FakeRequest is a request received by the fake, for checking what a client sent.
+type FakeRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+// FakeFailure is an error the fake answers a Web API request with instead of serving it, e.g. to
+// try out rate limits and outages.
+type FakeFailure struct {
+	Status     int
+	Message    string // Defaults to the status text
+	RetryAfter string // Retry-After header of rate limits, in seconds or as a date
+	Path       string // Only requests with the path fail when set, e.g. "/v1/me"
+}
+
+// fakeCode is an authorization code waiting to be exchanged.
+type fakeCode struct {
+	user        string
+	redirectURI string
+	challenge   string
+}
+
+// FakeServer is an offline stand in for the Spotify accounts service and Web API. It logs every
+// authorize request in without asking, and serves the fixtures to the same clients used for Spotify.
+type FakeServer struct {
+	URL string
+
+	mu        sync.Mutex
+	server    *http.Server
+	started   time.Time
+	users     map[string]*FakeUser
+	userOrder []string
+	artists   map[string]FakeArtist
+	tracks    map[string]FakeTrack
+	playlists map[string]*FakePlaylist
+	codes     map[string]fakeCode
+	tokens    map[string]string // User ID, keyed by access token
+	refresh   map[string]string // User ID, keyed by refresh token
+	created   int
+	requests  []FakeRequest
+	failures  []FakeFailure
+}
+
+// DefaultFakeFixtures returns the fixtures built into the fake.
+func DefaultFakeFixtures() (*FakeFixtures, error) {
+	var fixtures FakeFixtures
+	if err := json.Unmarshal(fakeFixtures, &fixtures); err != nil {
+		return nil, fmt.Errorf("invalid fake Spotify fixtures: %w", err)
+	}
+	return &fixtures, nil
+}
+
+// StartFakeServer serves the fixtures, or the default fixtures when nil, on the address.
+func StartFakeServer(address string, fixtures *FakeFixtures) (*FakeServer, error) {
+	if fixtures == nil {
+		defaults, err := DefaultFakeFixtures()
+		if err != nil {
+			return nil, err
+		}
+		fixtures = defaults
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &FakeServer{
+		URL:       "http://" + listener.Addr().String(),
+		started:   time.Now(),
+		users:     make(map[string]*FakeUser),
+		artists:   make(map[string]FakeArtist),
+		tracks:    make(map[string]FakeTrack),
+		playlists: make(map[string]*FakePlaylist),
+		codes:     make(map[string]fakeCode),
+		tokens:    make(map[string]string),
+		refresh:   make(map[string]string),
+	}
+	for i := range fixtures.Users {
+		user := fixtures.Users[i]
+		for i, id := range user.RecentlyPlayed {
+			// Plays are four minutes apart before the fake started
+			user.plays = append(user.plays, fakePlay{track: id, playedAt: f.started.Add(-time.Duration(i+1) * 4 * time.Minute)})
+		}
+		f.users[user.ID] = &user
+		f.userOrder = append(f.userOrder, user.ID)
+	}
+	for _, artist := range fixtures.Artists {
+		f.artists[artist.ID] = artist
+	}
+	for _, track := range fixtures.Tracks {
+		f.tracks[track.ID] = track
+	}
+	for i := range fixtures.Playlists {
+		playlist := fixtures.Playlists[i]
+		playlist.Tracks = append([]string(nil), playlist.Tracks...)
+		f.playlists[playlist.ID] = &playlist
+	}
+
+	f.server = &http.Server{Handler: f, ReadHeaderTimeout: 10 * time.Second}
+	go f.server.Serve(listener)
+
+	return f, nil
+}
+
+// Close stops the fake.
+func (f *FakeServer) Close() error {
+	return f.server.Close()
+}
+
+// Requests returns every request received by the fake, oldest first.
+func (f *FakeServer) Requests() []FakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeRequest(nil), f.requests...)
+}
+
+// Count returns the number of requests received with the method and path, e.g. "/v1/me".
+func (f *FakeServer) Count(method string, path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := 0
+	for _, request := range f.requests {
+		if request.Method == method && request.Path == path {
+			count++
+		}
+	}
+	return count
+}
+
+// Fail answers the next matching Web API requests with the failures, in order, before serving them again.
+func (f *FakeServer) Fail(failures ...FakeFailure) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failures = append(f.failures, failures...)
+}
+
+// Endpoint returns the OAuth endpoint of the fake accounts service. Client credentials are
+// accepted in the header and in the parameters, like Spotify.
+func (f *FakeServer) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  f.URL + "/authorize",
+		TokenURL: f.URL + "/api/token",
+	}
+}
+
+// APIURL returns the base URL of the fake Web API, for zSpotify.WithBaseURL.
+func (f *FakeServer) APIURL() string {
+	return f.URL + "/v1/"
+}
+
+func (f *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "could not read the request body", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	f.requests = append(f.requests, FakeRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   string(body),
+	})
+
+	switch {
+	case r.URL.Path == "/authorize" && r.Method == http.MethodGet:
+		f.authorize(w, r)
+	case r.URL.Path == "/api/token" && r.Method == http.MethodPost:
+		f.token(w, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/"):
+		user, ok := f.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			fakeAPIError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		if f.fail(w, r) {
+			return
+		}
+		f.api(w, r, f.users[user])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// authorize logs in the user named by the user parameter, or the first user, and redirects back
+// with a code like Spotify does once the user agrees.
+func (f *FakeServer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("client_id") == "" || query.Get("response_type") != "code" || redirectURI == "" {
+		http.Error(w, "INVALID_CLIENT: missing client_id, response_type or redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if method := query.Get("code_challenge_method"); method != "" && method != "S256" {
+		http.Error(w, "code_challenge_method must be S256", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	user := query.Get("user")
+	if user == "" && len(f.userOrder) > 0 {
+		user = f.userOrder[0]
+	}
+
+	values := redirect.Query()
+	if _, ok := f.users[user]; !ok {
+		values.Set("error", "access_denied")
+	} else {
+		code := fakeToken()
+		f.codes[code] = fakeCode{user: user, redirectURI: redirectURI, challenge: query.Get("code_challenge")}
+		values.Set("code", code)
+	}
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges an authorization code, checking the PKCE verifier or the client secret, or a refresh token.
+func (f *FakeServer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		fakeTokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	clientID, secret, basic := r.BasicAuth()
+	if !basic {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID == "" {
+		fakeTokenError(w, "invalid_client", "missing client ID")
+		return
+	}
+
+	var user, refreshToken string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code, ok := f.codes[r.PostForm.Get("code")]
+		delete(f.codes, r.PostForm.Get("code"))
+
+		switch {
+		case !ok:
+			fakeTokenError(w, "invalid_grant", "Invalid authorization code")
+			return
+		case code.redirectURI != r.PostForm.Get("redirect_uri"):
+			fakeTokenError(w, "invalid_grant", "Invalid redirect URI")
+			return
+		case code.challenge != "" && codeChallenge(r.PostForm.Get("code_verifier")) != code.challenge:
+			fakeTokenError(w, "invalid_grant", "code_verifier was incorrect")
+			return
+		case code.challenge == "" && secret == "" && r.PostForm.Get("client_secret") == "":
+			fakeTokenError(w, "invalid_client", "Invalid client secret")
+			return
+		}
+
+		user = code.user
+		refreshToken = fakeToken()
+		f.refresh[refreshToken] = user
+	case "refresh_token":
+		var ok bool
+		if user, ok = f.refresh[r.PostForm.Get("refresh_token")]; !ok {
+			fakeTokenError(w, "invalid_grant", "Invalid refresh token")
+			return
+		}
+
+		// The refresh token is rotated, like Spotify does for PKCE clients
+		delete(f.refresh, r.PostForm.Get("refresh_token"))
+		refreshToken = fakeToken()
+		f.refresh[refreshToken] = user
+	default:
+		fakeTokenError(w, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	accessToken := fakeToken()
+	f.tokens[accessToken] = user
+
+	writeFakeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(fakeTokenLifetime.Seconds()),
+		"refresh_token": refreshToken,
+		"scope":         strings.Join(scopes, " "),
+	})
+}
+
+// api serves the Web API routes used by the server for the logged in user. The caller must hold f.mu.
+func (f *FakeServer) api(w http.ResponseWriter, r *http.Request, user *FakeUser) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1"), "/"), "/")
+	route := r.Method + " " + strings.Join(parts, "/")
+
+	switch {
+	case route == "GET me":
+		writeFakeJSON(w, http.StatusOK, f.userJSON(user, true))
+	case route == "GET me/playlists":
+		f.servePlaylists(w, r, user)
+	case route == "GET me/player/currently-playing":
+		f.serveNowPlaying(w, user)
+	case route == "GET me/player/recently-played":
+		f.serveRecentlyPlayed(w, r, user)
+	case route == "GET me/player/devices":
+		writeFakeJSON(w, http.StatusOK, map[string]any{"devices": []map[string]any{
+			{"id": "fake-device", "name": "Fake Speaker", "type": "Speaker", "is_active": user.NowPlaying != nil, "volume_percent": 50},
+		}})
+	case strings.HasPrefix(route, "PUT me/player") || strings.HasPrefix(route, "POST me/player"):
+		f.control(w, r, user, parts)
+	case route == "GET artists":
+		var artists []any
+		for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+			if artist, ok := f.artists[id]; ok {
+				artists = append(artists, f.artistJSON(artist, true))
+			} else {
+				artists = append(artists, nil)
+			}
+		}
+		writeFakeJSON(w, http.StatusOK, map[string]any{"artists": artists})
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "users" && parts[2] == "playlists":
+		f.createPlaylist(w, r, user, parts[1])
+	case len(parts) >= 2 && parts[0] == "playlists":
+		playlist, ok := f.playlists[parts[1]]
+		if !ok {
+			fakeAPIError(w, http.StatusNotFound, "Resource not found")
+			return
+		}
+		f.playlist(w, r, user, playlist, parts[2:])
+	default:
+		fakeAPIError(w, http.StatusNotFound, "Service not found")
+	}
+}
+
+// playlist serves a playlist and its items. The caller must hold f.mu.
+func (f *FakeServer) playlist(w http.ResponseWriter, r *http.Request, user *FakeUser, playlist *FakePlaylist, rest []string) {
+	route := r.Method + " " + strings.Join(rest, "/")
+	writable := playlist.Owner == user.ID
+
+	switch route {
+	case "GET ":
+		full := f.playlistJSON(playlist)
+		full["tracks"] = f.itemsPage(r, playlist, 0, MaxPlaylistItemsLimit)
+		full["followers"] = map[string]any{"total": 0}
+		writeFakeJSON(w, http.StatusOK, full)
+	case "GET tracks":
+		limit, offset := fakePage(r, MaxPlaylistItemsLimit)
+		writeFakeJSON(w, http.StatusOK, f.itemsPage(r, playlist, offset, limit))
+	case "POST tracks":
+		if !writable {
+			fakeAPIError(w, http.StatusForbidden, "You cannot add tracks to a playlist you don't own.")
+			return
+		}
+
+		var body struct {
+			URIs []string `json:"uris"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			fakeAPIError(w, http.StatusBadRequest, "Error parsing JSON.")
+			return
+		}
+		for _, uri := range body.URIs {
+			id, ok := strings.CutPrefix(uri, "spotify:track:")
+			if _, exists := f.tracks[id]; !ok || !exists {
+				fakeAPIError(w, http.StatusBadRequest, "Invalid track uri: "+uri)
+				return
+			}
+			playlist.Tracks = append(playlist.Tracks, id)
+		}
+
+		playlist.snapshot++
+		writeFakeJSON(w, http.StatusCreated, map[string]any{"snapshot_id": fakeSnapshot(playlist)})
+	case "DELETE tracks":
+		if !writable {
+			fakeAPIError(w, http.StatusForbidden, "You cannot remove tracks from a playlist you don't own.")
+			return
+		}
+		f.removeTracks(w, r, playlist)
+	default:
+		fakeAPIError(w, http.StatusNotFound, "Service not found")
+	}
+}
+
+// removeTracks removes tracks at positions, checked against the snapshot, or every occurrence
+// of tracks given without positions. The caller must hold f.mu.
+func (f *FakeServer) removeTracks(w http.ResponseWriter, r *http.Request, playlist *FakePlaylist) {
+	var body struct {
+		Tracks []struct {
+			URI       string `json:"uri"`
+			Positions []int  `json:"positions"`
+		} `json:"tracks"`
+		SnapshotID string `json:"snapshot_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		fakeAPIError(w, http.StatusBadRequest, "Error parsing JSON.")
+		return
+	}
+	if body.SnapshotID != "" && body.SnapshotID != fakeSnapshot(playlist) {
+		fakeAPIError(w, http.StatusBadRequest, "Invalid snapshot id")
+		return
+	}
+
+	remove := make(map[int]bool)
+	for _, track := range body.Tracks {
+		id := strings.TrimPrefix(track.URI, "spotify:track:")
+		for _, position := range track.Positions {
+			if position < 0 || position >= len(playlist.Tracks) || playlist.Tracks[position] != id {
+				fakeAPIError(w, http.StatusBadRequest, "Invalid track uri or position")
+				return
+			}
+			remove[position] = true
+		}
+		if len(track.Positions) == 0 {
+			for position, trackID := range playlist.Tracks {
+				if trackID == id {
+					remove[position] = true
+				}
+			}
+		}
+	}
+
+	var kept []string
+	for position, id := range playlist.Tracks {
+		if !remove[position] {
+			kept = append(kept, id)
+		}
+	}
+	playlist.Tracks = kept
+	playlist.snapshot++
+
+	writeFakeJSON(w, http.StatusOK, map[string]any{"snapshot_id": fakeSnapshot(playlist)})
+}
+
+// createPlaylist creates an empty playlist owned by the user. The caller must hold f.mu.
+func (f *FakeServer) createPlaylist(w http.ResponseWriter, r *http.Request, user *FakeUser, owner string) {
+	if owner != user.ID {
+		fakeAPIError(w, http.StatusForbidden, "You cannot create a playlist for another user")
+		return
+	}
+
+	var body struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Public      bool   `json:"public"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		fakeAPIError(w, http.StatusBadRequest, "Missing required field: name")
+		return
+	}
+
+	f.created++
+	playlist := &FakePlaylist{
+		ID:          fmt.Sprintf("playlist-created-%d", f.created),
+		Name:        body.Name,
+		Description: body.Description,
+		Owner:       user.ID,
+		Public:      body.Public,
+	}
+	f.playlists[playlist.ID] = playlist
+
+	created := f.playlistJSON(playlist)
+	created["tracks"] = f.itemsPage(r, playlist, 0, MaxPlaylistItemsLimit)
+	writeFakeJSON(w, http.StatusCreated, created)
+}
+
+// servePlaylists serves a page of the playlists owned by the user. The caller must hold f.mu.
+func (f *FakeServer) servePlaylists(w http.ResponseWriter, r *http.Request, user *FakeUser) {
+	var owned []*FakePlaylist
+	for _, playlist := range f.playlists {
+		if playlist.Owner == user.ID {
+			owned = append(owned, playlist)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool { return owned[i].ID < owned[j].ID })
+
+	limit, offset := fakePage(r, MaxPlaylistsLimit)
+	items := []any{}
+	for i := offset; i < min(offset+limit, len(owned)); i++ {
+		items = append(items, f.playlistJSON(owned[i]))
+	}
+
+	writeFakeJSON(w, http.StatusOK, f.page(r, items, limit, offset, len(owned)))
+}
+
+// serveNowPlaying serves the playback of the user, or 204 when nothing is playing. The caller must hold f.mu.
+func (f *FakeServer) serveNowPlaying(w http.ResponseWriter, user *FakeUser) {
+	if user.NowPlaying == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	track := f.tracks[user.NowPlaying.Track]
+	writeFakeJSON(w, http.StatusOK, map[string]any{
+		"timestamp":   time.Now().UnixMilli(),
+		"progress_ms": min(user.NowPlaying.ProgressMs, track.DurationMs),
+		"is_playing":  user.NowPlaying.Playing,
+		"item":        f.trackJSON(track),
+		"context":     nil,
+	})
+}
+
+// serveRecentlyPlayed serves the plays of the user after the after parameter, most recent first.
+// The caller must hold f.mu.
+func (f *FakeServer) serveRecentlyPlayed(w http.ResponseWriter, r *http.Request, user *FakeUser) {
+	limit, _ := fakePage(r, maxRecentlyPlayed)
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+
+	items := []any{}
+	for _, play := range user.plays {
+		if len(items) == limit || play.playedAt.UnixMilli() <= after {
+			break
+		}
+		items = append(items, map[string]any{
+			"track":     f.trackJSON(f.tracks[play.track]),
+			"played_at": play.playedAt.UTC().Format(time.RFC3339Nano),
+			"context":   nil,
+		})
+	}
+	writeFakeJSON(w, http.StatusOK, map[string]any{"items": items})
+}
+
+// control applies player commands to the playback of the user. The caller must hold f.mu.
+func (f *FakeServer) control(w http.ResponseWriter, r *http.Request, user *FakeUser, parts []string) {
+	if user.Product != "premium" {
+		fakeAPIError(w, http.StatusForbidden, "Player command failed: Premium required")
+		return
+	}
+	if user.NowPlaying == nil {
+		fakeAPIError(w, http.StatusNotFound, "Player command failed: No active device found")
+		return
+	}
+
+	switch strings.Join(parts, "/") {
+	case "me/player/play":
+		user.NowPlaying.Playing = true
+	case "me/player/pause":
+		user.NowPlaying.Playing = false
+	case "me/player/seek":
+		position, err := strconv.Atoi(r.URL.Query().Get("position_ms"))
+		if err != nil {
+			fakeAPIError(w, http.StatusBadRequest, "Missing position_ms")
+			return
+		}
+		user.NowPlaying.ProgressMs = position
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fail answers with the first queued failure matching the request, and reports whether there was
+// one. The caller must hold f.mu.
+func (f *FakeServer) fail(w http.ResponseWriter, r *http.Request) bool {
+	for i, failure := range f.failures {
+		if failure.Path != "" && failure.Path != r.URL.Path {
+			continue
+		}
+		f.failures = append(f.failures[:i], f.failures[i+1:]...)
+
+		if failure.RetryAfter != "" {
+			w.Header().Set("Retry-After", failure.RetryAfter)
+		}
+		if failure.Message == "" {
+			failure.Message = http.StatusText(failure.Status)
+		}
+		fakeAPIError(w, failure.Status, failure.Message)
+		return true
+	}
+	return false
+}
+
+// itemsPage builds a page of playlist items. The caller must hold f.mu.
+func (f *FakeServer) itemsPage(r *http.Request, playlist *FakePlaylist, offset int, limit int) map[string]any {
+	items := []any{}
+	for i := offset; i < min(offset+limit, len(playlist.Tracks)); i++ {
+		items = append(items, map[string]any{
+			"added_at": f.started.UTC().Format(time.RFC3339),
+			"added_by": f.userJSON(f.users[playlist.Owner], false),
+			"is_local": false,
+			"track":    f.trackJSON(f.tracks[playlist.Tracks[i]]),
+		})
+	}
+	return f.page(r, items, limit, offset, len(playlist.Tracks))
+}
+
+// page wraps items in a paging object with a next URL while there are more items.
+func (f *FakeServer) page(r *http.Request, items []any, limit int, offset int, total int) map[string]any {
+	page := map[string]any{"items": items, "limit": limit, "offset": offset, "total": total, "href": f.URL + r.URL.Path, "next": nil}
+	if offset+limit < total {
+		page["next"] = fmt.Sprintf("%s%s?limit=%d&offset=%d", f.URL, r.URL.Path, limit, offset+limit)
+	}
+	return page
+}
+
+func (f *FakeServer) userJSON(user *FakeUser, private bool) map[string]any {
+	if user == nil {
+		return nil
+	}
+
+	object := map[string]any{
+		"id":            user.ID,
+		"display_name":  user.DisplayName,
+		"uri":           "spotify:user:" + user.ID,
+		"href":          f.APIURL() + "users/" + user.ID,
+		"external_urls": map[string]string{"spotify": "https://open.spotify.com/user/" + user.ID},
+		"followers":     map[string]any{"total": 0},
+		"images":        []any{},
+		"type":          "user",
+	}
+	if private {
+		object["email"] = user.Email
+		object["country"] = user.Country
+		object["product"] = user.Product
+	}
+	return object
+}
+
+func (f *FakeServer) playlistJSON(playlist *FakePlaylist) map[string]any {
+	return map[string]any{
+		"id":            playlist.ID,
+		"name":          playlist.Name,
+		"description":   playlist.Description,
+		"public":        playlist.Public,
+		"collaborative": false,
+		"owner":         f.userJSON(f.users[playlist.Owner], false),
+		"snapshot_id":   fakeSnapshot(playlist),
+		"uri":           "spotify:playlist:" + playlist.ID,
+		"href":          f.APIURL() + "playlists/" + playlist.ID,
+		"external_urls": map[string]string{"spotify": "https://open.spotify.com/playlist/" + playlist.ID},
+		"images":        []any{},
+		"tracks":        map[string]any{"href": f.APIURL() + "playlists/" + playlist.ID + "/tracks", "total": len(playlist.Tracks)},
+		"type":          "playlist",
+	}
+}
+
+func (f *FakeServer) trackJSON(track FakeTrack) map[string]any {
+	var artists []any
+	for _, id := range track.Artists {
+		artists = append(artists, f.artistJSON(f.artists[id], false))
+	}
+
+	image := "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(fakeAlbumImage))
+	albumID := fakeID(track.Album)
+	return map[string]any{
+		"id":          track.ID,
+		"name":        track.Name,
+		"type":        "track",
+		"uri":         "spotify:track:" + track.ID,
+		"href":        f.APIURL() + "tracks/" + track.ID,
+		"duration_ms": track.DurationMs,
+		"artists":     artists,
+		"album": map[string]any{
+			"id":      albumID,
+			"name":    track.Album,
+			"uri":     "spotify:album:" + albumID,
+			"artists": artists,
+			"images":  []map[string]any{{"url": image, "width": 64, "height": 64}},
+		},
+		"external_ids":  map[string]string{"isrc": track.ISRC},
+		"external_urls": map[string]string{"spotify": "https://open.spotify.com/track/" + track.ID},
+		"is_local":      false,
+	}
+}
+
+func (f *FakeServer) artistJSON(artist FakeArtist, full bool) map[string]any {
+	object := map[string]any{
+		"id":   artist.ID,
+		"name": artist.Name,
+		"type": "artist",
+		"uri":  "spotify:artist:" + artist.ID,
+		"href": f.APIURL() + "artists/" + artist.ID,
+	}
+	if full {
+		object["genres"] = artist.Genres
+		object["images"] = []any{}
+		object["followers"] = map[string]any{"total": 0}
+	}
+	return object
+}
+
+// fakePage reads the limit and offset of a request.
+func fakePage(r *http.Request, maxLimit int) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxLimit {
+		limit = min(20, maxLimit)
+	}
+
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// fakeSnapshot returns the snapshot ID of the playlist, which changes with every edit.
+func fakeSnapshot(playlist *FakePlaylist) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d", playlist.ID, playlist.snapshot)))
+}
+
+// fakeID turns a name into an ID.
+func fakeID(name string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}), "-"))
+}
+
+// fakeToken returns a random token.
+func fakeToken() string {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		panic(errors.New("could not generate a fake token"))
+	}
+	return hex.EncodeToString(token)
+}
+
+func fakeAPIError(w http.ResponseWriter, status int, message string) {
+	writeFakeJSON(w, status, map[string]any{"error": map[string]any{"status": status, "message": message}})
+}
+
+func fakeTokenError(w http.ResponseWriter, code string, description string) {
+	writeFakeJSON(w, http.StatusBadRequest, map[string]any{"error": code, "error_description": description})
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
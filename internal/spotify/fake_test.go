@@ -0,0 +1,267 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rory-pearson/database"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+// newFakeManager returns a test manager logging in to a fake serving the default fixtures.
+func newFakeManager(t *testing.T, mode AuthMode) (*SpotifyManager, *FakeServer) {
+	fake := startFake(t, nil)
+
+	sm := newTestManagerWithMode(t, mode, NewMemorySessionStore(), nil)
+	sm.UseFakeServer(fake)
+	return sm, fake
+}
+
+// startFake starts a fake serving the fixtures, or the default fixtures when nil.
+func startFake(t *testing.T, fixtures *FakeFixtures) *FakeServer {
+	fake, err := StartFakeServer("127.0.0.1:0", fixtures)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { fake.Close() })
+	return fake
+}
+
+// addFakeToken gives the user of the fake an access token without logging in.
+func addFakeToken(fake *FakeServer, user string) string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	token := fakeToken()
+	fake.tokens[token] = user
+	return token
+}
+
+// newFakeClient returns a client of the user sending requests straight to the fake, without the
+// retries and cache of session clients.
+func newFakeClient(fake *FakeServer, user string) *zSpotify.Client {
+	source := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: addFakeToken(fake, user)})
+	return zSpotify.New(oauth2.NewClient(context.Background(), source), zSpotify.WithBaseURL(fake.APIURL()))
+}
+
+// fakeTracks returns the track IDs of a playlist of the fake.
+func fakeTracks(fake *FakeServer, playlist string) []string {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return append([]string(nil), fake.playlists[playlist].Tracks...)
+}
+
+// pagingFixtures returns fixtures with the playlists of fake-user, named "Playlist 0" onwards, and
+// the tracks, which are in every playlist. Road Trip of another user has the tracks as well.
+func pagingFixtures(playlists int, tracks int) *FakeFixtures {
+	fixtures := &FakeFixtures{
+		Users: []FakeUser{
+			{ID: "fake-user", DisplayName: "Fake Listener", Product: "premium"},
+			{ID: "owner", DisplayName: "Owner", Product: "premium"},
+		},
+		Artists: []FakeArtist{{ID: "artist", Name: "Artist"}, {ID: "guest", Name: "Guest"}},
+	}
+
+	var ids []string
+	for i := 0; i < tracks; i++ {
+		id := fmt.Sprintf("track-%03d", i)
+		ids = append(ids, id)
+		fixtures.Tracks = append(fixtures.Tracks, FakeTrack{
+			ID:         id,
+			Name:       fmt.Sprintf("Track %d", i),
+			Artists:    []string{"artist", "guest"},
+			Album:      "Album",
+			DurationMs: 180000 + i,
+			ISRC:       fmt.Sprintf("ISRC%05d", i),
+		})
+	}
+
+	for i := 0; i < playlists; i++ {
+		fixtures.Playlists = append(fixtures.Playlists, FakePlaylist{
+			ID:     fmt.Sprintf("playlist-%03d", i),
+			Name:   fmt.Sprintf("Playlist %d", i),
+			Owner:  "fake-user",
+			Tracks: ids,
+		})
+	}
+	fixtures.Playlists = append(fixtures.Playlists, FakePlaylist{ID: "road-trip", Name: "Road/Trip", Owner: "owner", Tracks: ids})
+
+	return fixtures
+}
+
+// fakeLogin follows the authorize URL to the fake and returns the callback request it redirects to.
+func fakeLogin(t *testing.T, authURL string) *http.Request {
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	response, err := client.Get(authURL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusFound, response.StatusCode)
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/spotify/callback", location.Path)
+
+	return httptest.NewRequest(http.MethodGet, location.RequestURI(), nil)
+}
+
+// fakeSession logs in to the fake and returns the session.
+func fakeSession(t *testing.T, sm *SpotifyManager) *Session {
+	sessionID, authURL := startLogin(t, sm)
+	assert.NoError(t, sm.CompleteLogin(context.Background(), sessionID, fakeLogin(t, authURL)))
+
+	session := sm.GetSession(sessionID)
+	if !assert.NotNil(t, session) || !assert.NotNil(t, session.Client) {
+		t.FailNow()
+	}
+	return session
+}
+
+func TestFakeLoginFlow(t *testing.T) {
+	for _, mode := range []AuthMode{AuthModeSecret, AuthModePKCE} {
+		t.Run(string(mode), func(t *testing.T) {
+			sm, _ := newFakeManager(t, mode)
+			ctx := context.Background()
+
+			sessionID, authURL := startLogin(t, sm)
+			assert.NoError(t, sm.CompleteLogin(ctx, sessionID, fakeLogin(t, authURL)))
+
+			session := sm.GetSession(sessionID)
+			if !assert.NotNil(t, session) || !assert.NotNil(t, session.Token) {
+				return
+			}
+
+			user, err := session.Client.CurrentUser(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, "fake-user", user.ID)
+			assert.Equal(t, "premium", user.Product)
+
+			playlists, err := GetPlaylists(ctx, session.Client, PageOptions{Limit: 1})
+			assert.NoError(t, err)
+			assert.Equal(t, 2, int(playlists.Total))
+
+			var names []string
+			err = EachPlaylist(ctx, session.Client, PageOptions{All: true}, func(playlist zSpotify.SimplePlaylist) error {
+				names = append(names, playlist.Name)
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"Focus", "Road Trip"}, names)
+
+			items, err := GetPlaylistItems(ctx, session.Client, "playlist-focus", PageOptions{})
+			assert.NoError(t, err)
+			if assert.Len(t, items.Items, 3) {
+				assert.Equal(t, "Nocturne for Test Doubles", items.Items[0].Track.Track.Name)
+			}
+
+			// The refresh token is exchanged with the fake too
+			session.Token.Expiry = time.Now().Add(-time.Minute)
+			accessToken := session.Token.AccessToken
+			session = sm.GetSession(sessionID)
+			if assert.NotNil(t, session) {
+				assert.NotEqual(t, accessToken, session.Token.AccessToken)
+				_, err = session.Client.CurrentUser(ctx)
+				assert.NoError(t, err)
+			}
+
+			// A token the client refreshes itself is written back to the session and the store
+			session.Token.Expiry = time.Now().Add(-time.Minute)
+			accessToken = session.Token.AccessToken
+			_, err = session.Client.PlayerDevices(ctx)
+			assert.NoError(t, err)
+			assert.NotEqual(t, accessToken, session.Token.AccessToken)
+
+			record, err := sm.Store.Load(sessionID)
+			assert.NoError(t, err)
+			assert.Equal(t, session.Token.AccessToken, record.Token.AccessToken)
+			assert.Equal(t, session.Token.RefreshToken, record.Token.RefreshToken)
+		})
+	}
+}
+
+func TestFakeRejectsWrongVerifier(t *testing.T) {
+	sm, _ := newFakeManager(t, AuthModePKCE)
+
+	sessionID, authURL := startLogin(t, sm)
+	callback := fakeLogin(t, authURL)
+
+	// Another login's verifier does not match the challenge of this one
+	sm.mu.Lock()
+	for state, login := range sm.logins {
+		login.Verifier = "wrong-verifier-wrong-verifier-wrong-verifier"
+		sm.logins[state] = login
+	}
+	sm.mu.Unlock()
+
+	assert.Error(t, sm.CompleteLogin(context.Background(), sessionID, callback))
+}
+
+func TestFakeRejectsUnknownTokens(t *testing.T) {
+	_, fake := newFakeManager(t, AuthModeSecret)
+
+	client := zSpotify.New(http.DefaultClient, zSpotify.WithBaseURL(fake.APIURL()))
+	_, err := client.CurrentUser(context.Background())
+	assert.ErrorIs(t, TypedError(err), ErrorUnauthorized)
+}
+
+func TestFakeServesFeatures(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+	ctx := context.Background()
+
+	sessionID, authURL := startLogin(t, sm)
+	assert.NoError(t, sm.CompleteLogin(ctx, sessionID, fakeLogin(t, authURL)))
+	client := sm.GetSession(sessionID).Client
+
+	// Road Trip has the same track twice and a remaster of it
+	report, err := FindDuplicates(ctx, client, "playlist-road-trip", DuplicateOptions{Likely: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Duplicates)
+
+	report, err = RemoveDuplicates(ctx, client, "playlist-road-trip", DuplicateOptions{}, report.SnapshotID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Removed)
+	assert.Len(t, fake.playlists["playlist-road-trip"].Tracks, 5)
+
+	merged, err := MergePlaylists(ctx, client, MergeOptions{Name: "Everything", PlaylistIDs: []string{"playlist-road-trip", "playlist-focus"}, Dedupe: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, merged.Added)
+
+	// Playback, history and genres come from the fixtures
+	playing, err := client.PlayerCurrentlyPlaying(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, zSpotify.ID("track-04"), playing.Item.ID)
+	assert.NoError(t, NewPlayer(client).Pause(ctx, ""))
+
+	history, err := database.NewHistory(t.TempDir())
+	assert.NoError(t, err)
+	added, err := RecordRecentlyPlayed(ctx, client, history)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, added)
+
+	plays, err := history.Plays("fake-user", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, "track-05", plays[0].TrackID)
+	assert.Equal(t, []string{"synthpop", "indie rock", "britpop"}, plays[0].Genres)
+}
+
+func TestFakeFreeUserCannotControlPlayback(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+
+	sessionID, authURL := startLogin(t, sm)
+	authURL += "&user=fake-free-user"
+	assert.NoError(t, sm.CompleteLogin(context.Background(), sessionID, fakeLogin(t, authURL)))
+
+	client := sm.GetSession(sessionID).Client
+	assert.ErrorIs(t, NewPlayer(client).Pause(context.Background(), ""), ErrorPremiumRequired)
+	assert.NotNil(t, fake.users["fake-free-user"])
+}
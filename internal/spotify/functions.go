@@ -4,17 +4,21 @@ import (
 	zSpotify "github.com/zmb3/spotify/v2"
 )
 
-func (s *SpotifyManager) GetNamesFromPlaylistTracks(session Session, playlistId string) ([]string, error) {
-	// Get the playlist tracks
-	tracks, err := session.Client.GetPlaylistItems(s.ctx, zSpotify.ID(playlistId))
+func (s *SpotifyManager) GetNamesFromPlaylistTracks(session *Session, playlistId string) ([]string, error) {
+	// Get every page of the playlist tracks
+	var names []string
+	err := EachPlaylistItem(s.ctx, session.Client, zSpotify.ID(playlistId), PageOptions{All: true}, func(item zSpotify.PlaylistItem) error {
+		switch {
+		case item.Track.Track != nil:
+			names = append(names, item.Track.Track.Name)
+		case item.Track.Episode != nil:
+			names = append(names, item.Track.Episode.Name)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	names := make([]string, len(tracks.Items))
-	for i, track := range tracks.Items {
-		names[i] = track.Track.Track.Name
-	}
-
 	return names, nil
 }
@@ -0,0 +1,185 @@
+package spotify
+
+import (
+	"context"
+	"rory-pearson/database"
+	"time"
+
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+const (
+	// DefaultHistoryInterval is how often opted in sessions are polled. Spotify only returns the
+	// last 50 plays, so fewer than 50 tracks must fit in one interval for nothing to be missed.
+	DefaultHistoryInterval = 15 * time.Minute
+	// maxRecentlyPlayed is the most plays Spotify returns for one request.
+	maxRecentlyPlayed = 50
+	// maxArtists is the most artists Spotify returns for one request.
+	maxArtists = 50
+)
+
+// StartHistoryRecorder records the listening history of opted in sessions at the given interval.
+// It uses the SpotifyManager's context for canceling the recorder when necessary.
+func (s *SpotifyManager) StartHistoryRecorder(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.RecordHistory(s.ctx)
+			case <-s.ctx.Done():
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// RecordHistory records the recently played tracks of every logged in user that opted in, once per
+// user however many sessions they have. Failures are logged per session so one broken session does
+// not stop the others. It returns the number of plays added.
+func (s *SpotifyManager) RecordHistory(ctx context.Context) int {
+	records, err := s.Store.List()
+	if err != nil {
+		s.Log.Error().Err(err).Msg("failed to list persisted sessions")
+		return 0
+	}
+
+	added := 0
+	recorded := make(map[string]bool)
+	for _, record := range records {
+		if record.Token == nil {
+			continue
+		}
+
+		// GetSession refreshes the token when it has expired
+		session := s.GetSession(record.State)
+		if session == nil || session.Client == nil {
+			continue
+		}
+
+		user, err := session.Client.CurrentUser(ctx)
+		if err != nil {
+			s.Log.Error().Err(TypedError(err)).Msg("failed to get the user of a session")
+			continue
+		}
+		if recorded[user.ID] {
+			continue
+		}
+
+		enabled, err := s.History.Enabled(user.ID)
+		if err != nil {
+			s.Log.Error().Err(err).Msg("failed to read the listening history setting")
+			continue
+		}
+		if !enabled {
+			continue
+		}
+
+		count, err := RecordRecentlyPlayed(ctx, session.Client, s.History)
+		if err != nil {
+			s.Log.Error().Err(err).Msg("failed to record listening history")
+			continue
+		}
+		recorded[user.ID] = true
+		added += count
+	}
+
+	return added
+}
+
+// RecordRecentlyPlayed records the tracks the current user played since their last recorded play,
+// with the genres of their artists. It returns the number of plays added.
+func RecordRecentlyPlayed(ctx context.Context, client *zSpotify.Client, history *database.History) (int, error) {
+	user, err := client.CurrentUser(ctx)
+	if err != nil {
+		return 0, TypedError(err)
+	}
+
+	latest, err := history.Latest(user.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	options := &zSpotify.RecentlyPlayedOptions{Limit: maxRecentlyPlayed}
+	if !latest.IsZero() {
+		options.AfterEpochMs = latest.UnixMilli()
+	}
+
+	items, err := client.PlayerRecentlyPlayedOpt(ctx, options)
+	if err != nil {
+		return 0, TypedError(err)
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	genres, err := artistGenres(ctx, client, items)
+	if err != nil {
+		return 0, TypedError(err)
+	}
+
+	plays := make([]database.Play, 0, len(items))
+	for _, item := range items {
+		plays = append(plays, newPlay(item, genres))
+	}
+
+	return history.Record(user.ID, plays)
+}
+
+// newPlay converts a recently played item, adding the genres of its artists once each.
+func newPlay(item zSpotify.RecentlyPlayedItem, genres map[zSpotify.ID][]string) database.Play {
+	play := database.Play{
+		TrackID:    string(item.Track.ID),
+		Title:      item.Track.Name,
+		Album:      item.Track.Album.Name,
+		DurationMs: int(item.Track.Duration),
+		PlayedAt:   item.PlayedAt,
+	}
+
+	seen := make(map[string]bool)
+	for _, artist := range item.Track.Artists {
+		play.Artists = append(play.Artists, artist.Name)
+		play.ArtistIDs = append(play.ArtistIDs, string(artist.ID))
+
+		for _, genre := range genres[artist.ID] {
+			if !seen[genre] {
+				seen[genre] = true
+				play.Genres = append(play.Genres, genre)
+			}
+		}
+	}
+
+	return play
+}
+
+// artistGenres looks up the genres of every artist of the items, in batches of up to 50 artists.
+func artistGenres(ctx context.Context, client *zSpotify.Client, items []zSpotify.RecentlyPlayedItem) (map[zSpotify.ID][]string, error) {
+	var ids []zSpotify.ID
+	seen := make(map[zSpotify.ID]bool)
+	for _, item := range items {
+		for _, artist := range item.Track.Artists {
+			if artist.ID != "" && !seen[artist.ID] {
+				seen[artist.ID] = true
+				ids = append(ids, artist.ID)
+			}
+		}
+	}
+
+	genres := make(map[zSpotify.ID][]string, len(ids))
+	for start := 0; start < len(ids); start += maxArtists {
+		artists, err := client.GetArtists(ctx, ids[start:min(start+maxArtists, len(ids))]...)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, artist := range artists {
+			if artist != nil {
+				genres[artist.ID] = artist.Genres
+			}
+		}
+	}
+
+	return genres, nil
+}
@@ -0,0 +1,112 @@
+package spotify
+
+import (
+	"context"
+	"rory-pearson/database"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// historyFixtures returns fixtures with three tracks by an artist and a guest, whose genres are
+// "indie" and their ID followed by "pop".
+func historyFixtures() *FakeFixtures {
+	fixtures := &FakeFixtures{
+		Users: []FakeUser{{ID: "listener", Product: "premium"}},
+		Artists: []FakeArtist{
+			{ID: "artist1", Name: "Artist", Genres: []string{"indie", "artist1 pop"}},
+			{ID: "artist2", Name: "Guest", Genres: []string{"indie", "artist2 pop"}},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		fixtures.Tracks = append(fixtures.Tracks, FakeTrack{
+			ID:         "track" + strconv.Itoa(i),
+			Name:       "Track " + strconv.Itoa(i),
+			Artists:    []string{"artist1", "artist2"},
+			Album:      "Album",
+			DurationMs: 120000,
+		})
+	}
+	return fixtures
+}
+
+// play adds a play of the track to the user of the fake.
+func play(fake *FakeServer, user string, track string, playedAt time.Time) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	// Plays are kept most recent first
+	plays := fake.users[user].plays
+	fake.users[user].plays = append([]fakePlay{{track: track, playedAt: playedAt}}, plays...)
+}
+
+// recentlyPlayedAfter returns the after parameter of every recently played request to the fake.
+func recentlyPlayedAfter(fake *FakeServer) []string {
+	var after []string
+	for _, request := range fake.Requests() {
+		if request.Path == "/v1/me/player/recently-played" {
+			after = append(after, request.Query.Get("after"))
+		}
+	}
+	return after
+}
+
+func TestRecordRecentlyPlayed(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake := startFake(t, historyFixtures())
+	play(fake, "listener", "track0", start)
+	play(fake, "listener", "track1", start.Add(3*time.Minute))
+	client := newFakeClient(fake, "listener")
+
+	history, err := database.NewHistory(t.TempDir())
+	assert.NoError(t, err)
+
+	added, err := RecordRecentlyPlayed(context.Background(), client, history)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	// The next poll only asks for plays after the last recorded one
+	play(fake, "listener", "track2", start.Add(6*time.Minute))
+	added, err = RecordRecentlyPlayed(context.Background(), client, history)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, []string{"", strconv.FormatInt(start.Add(3*time.Minute).UnixMilli(), 10)}, recentlyPlayedAfter(fake))
+
+	plays, err := history.Plays("listener", time.Time{})
+	assert.NoError(t, err)
+	if assert.Len(t, plays, 3) {
+		assert.Equal(t, "track0", plays[0].TrackID)
+		assert.Equal(t, []string{"Artist", "Guest"}, plays[0].Artists)
+		assert.Equal(t, []string{"indie", "artist1 pop", "artist2 pop"}, plays[0].Genres)
+		assert.Equal(t, 120000, plays[0].DurationMs)
+		assert.True(t, plays[2].PlayedAt.Equal(start.Add(6*time.Minute)))
+	}
+}
+
+func TestRecordHistoryOnlyOptedInUsers(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+	history, err := database.NewHistory(t.TempDir())
+	assert.NoError(t, err)
+	sm.History = history
+
+	// Two sessions of the same user
+	fakeSession(t, sm)
+	fakeSession(t, sm)
+
+	// Sessions read back from the store send their requests to the fake as well
+	sm.Sessions = make(map[string]*Session)
+	assert.Equal(t, 0, sm.RecordHistory(context.Background()))
+	assert.Empty(t, recentlyPlayedAfter(fake))
+
+	// Opting in records the user once, whichever of their sessions opted in
+	assert.NoError(t, history.SetEnabled("fake-user", true))
+	assert.Equal(t, 5, sm.RecordHistory(context.Background()))
+	assert.Len(t, recentlyPlayedAfter(fake), 1)
+
+	// Opting out stops recording for every session of the user
+	assert.NoError(t, history.SetEnabled("fake-user", false))
+	sm.RecordHistory(context.Background())
+	assert.Len(t, recentlyPlayedAfter(fake), 1)
+}
@@ -2,27 +2,52 @@ package spotify
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"rory-pearson/database"
 	"rory-pearson/environment"
 	"rory-pearson/pkg/log"
+	"rory-pearson/pkg/util"
+	"strconv"
 	"sync"
 	"time"
 
 	zSpotify "github.com/zmb3/spotify/v2"
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
 	"golang.org/x/oauth2"
 )
 
 type Config struct {
-	Log log.Log
+	Log     log.Log
+	Store   SessionStore      // Persists sessions, defaults to an encrypted store under the storage directory
+	History *database.History // Records listening history, defaults to a store under the storage directory
 }
 
+const (
+	// PendingSessionTTL is how long a login may take before it is pruned.
+	PendingSessionTTL = 10 * time.Minute
+	// MaxPendingLogins limits the logins waiting for their callback, which are only kept in memory.
+	MaxPendingLogins = 10000
+	// SessionIdleTimeout is how long a session that can be refreshed is kept without being used.
+	SessionIdleTimeout = 30 * 24 * time.Hour
+)
+
+var (
+	ErrorInvalidLoginState = errors.New("login state is invalid or expired")
+	ErrorTooManyLogins     = errors.New("too many logins in progress")
+)
+
 // Session represents a user's Spotify session, including the client for making API calls,
 // the OAuth2 token for authentication, and the state for session identification.
 type Session struct {
-	Client *zSpotify.Client
-	Token  *oauth2.Token
-	State  string
+	Client    *zSpotify.Client
+	Token     *oauth2.Token
+	State     string
+	CreatedAt time.Time
+	UpdatedAt time.Time // Last time the token was stored or refreshed
+
+	cache   *ResponseCache // Read-only responses of the client, kept when the token is refreshed
+	refresh sync.Mutex     // Serializes token refreshes so only one request exchanges the refresh token
 }
 
 // SpotifyManager manages Spotify sessions and handles token lifecycle management.
@@ -33,8 +58,23 @@ type SpotifyManager struct {
 
 	Log         log.Log
 	RedirectUrl string
-	Auth        *spotifyauth.Authenticator
-	Sessions    map[string]*Session // Map of Spotify sessions keyed by the state
+	Mode        AuthMode
+	OAuth       *oauth2.Config
+	APIURL      string              // Base URL of the Web API, empty for Spotify
+	Sessions    map[string]*Session // Map of Spotify sessions keyed by the session ID, loaded from Store on demand
+	Store       SessionStore
+	History     *database.History
+
+	logins     map[string]pendingLogin      // Logins waiting for their callback, keyed by the OAuth state
+	nowPlaying map[string]*NowPlayingPoller // Shared now playing pollers, keyed by the session ID
+	fake       *FakeServer                  // Serves Spotify offline when switched on through the environment
+}
+
+// pendingLogin links the OAuth state sent to Spotify to the session that started the login.
+type pendingLogin struct {
+	SessionID string
+	Verifier  string // PKCE code verifier, empty with the secret auth mode
+	CreatedAt time.Time
 }
 
 var instance *SpotifyManager
@@ -52,6 +92,42 @@ func Initialize(c Config) *SpotifyManager {
 		return nil
 	}
 
+	// PKCE only needs the client ID, the secret flow also needs the client secret
+	mode, err := ParseAuthMode(env.SpotifyAuthMode, env.SpotifyClientSecret)
+	if err != nil {
+		c.Log.Error().Err(err).Msg("invalid Spotify auth mode")
+		return nil
+	}
+
+	// Sessions are persisted encrypted so they survive restarts, which needs a session key
+	store := c.Store
+	if store == nil && env.SpotifySessionKey == "" {
+		c.Log.Warn().Msg("SPOTIFY_SESSION_KEY is not set, Spotify sessions are kept in memory and lost on restart")
+		store = NewMemorySessionStore()
+	}
+	if store == nil {
+		key, err := ParseSessionKey(env.SpotifySessionKey)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("invalid Spotify session key")
+			return nil
+		}
+
+		store, err = NewFileSessionStore(environment.CreateStorageDirectory("spotify_sessions"), key)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("failed to create Spotify session store")
+			return nil
+		}
+	}
+
+	history := c.History
+	if history == nil {
+		history, err = database.NewHistory(environment.CreateStorageDirectory("spotify_history"))
+		if err != nil {
+			c.Log.Error().Err(err).Msg("failed to create Spotify listening history")
+			return nil
+		}
+	}
+
 	// Create a context with cancellation for managing session cleanup and other tasks.
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -60,34 +136,40 @@ func Initialize(c Config) *SpotifyManager {
 
 	// Create and configure the SpotifyManager with authentication and session management.
 	authentication := &SpotifyManager{
-		ctx:    ctx,
-		cancel: cancel,
-		Log:    c.Log,
-		Auth: spotifyauth.New(
-			spotifyauth.WithRedirectURL(redirectUrl),
-			spotifyauth.WithScopes(
-				spotifyauth.ScopeUserReadPrivate,
-				spotifyauth.ScopeUserReadEmail,
-				spotifyauth.ScopePlaylistReadPrivate,
-				spotifyauth.ScopeUserReadCurrentlyPlaying,
-			),
-			spotifyauth.WithClientID(env.SpotifyClientId),         // Replace with actual Client ID
-			spotifyauth.WithClientSecret(env.SpotifyClientSecret), // Replace with actual Client Secret
-		),
-		Sessions: make(map[string]*Session),
-	}
-
-	authentication.Log.Info().Msg("SpotifyManager initialized")
+		ctx:         ctx,
+		cancel:      cancel,
+		Log:         c.Log,
+		RedirectUrl: redirectUrl,
+		Mode:        mode,
+		OAuth:       NewOAuthConfig(mode, env.SpotifyClientId, env.SpotifyClientSecret, redirectUrl),
+		Sessions:    make(map[string]*Session),
+		Store:       store,
+		History:     history,
+		logins:      make(map[string]pendingLogin),
+		nowPlaying:  make(map[string]*NowPlayingPoller),
+	}
+
+	// The fake serves seeded data without credentials or network access, for development
+	if fake, _ := strconv.ParseBool(env.SpotifyFake); fake {
+		server, err := StartFakeServer(FakeServerAddress, nil)
+		if err != nil {
+			c.Log.Error().Err(err).Msg("failed to start fake Spotify")
+			cancel()
+			return nil
+		}
+
+		authentication.UseFakeServer(server)
+		authentication.Log.Warn().Str("url", server.URL).Msg("Serving Spotify from the built-in fake")
+	}
+
+	authentication.Log.Info().Str("mode", string(mode)).Msg("SpotifyManager initialized")
 
 	instance = authentication
 	return instance
 }
 
-// GetInstance returns the singleton instance of SpotifyManager. It panics if the manager has not been initialized.
+// GetInstance returns the singleton instance of SpotifyManager, or nil if it has not been initialized.
 func GetInstance() *SpotifyManager {
-	if instance == nil {
-		panic("SpotifyManager not initialized")
-	}
 	return instance
 }
 
@@ -100,63 +182,224 @@ func (s *SpotifyManager) Close() {
 		s.cancel() // Cancel any ongoing cleanup or long-running operations
 	}
 
-	// Clear all stored sessions and log the closure.
+	if s.fake != nil {
+		s.fake.Close()
+	}
+
+	// Clear all loaded sessions and log the closure. Persisted sessions are kept for the next start.
 	s.Sessions = make(map[string]*Session)
 	s.Log.Info().Msg("SpotifyManager closed")
 	s.Log.Close()
 }
 
+// UseFakeServer sends logins and Web API requests of sessions created from now on to the fake.
+// The fake is closed with the manager.
+func (s *SpotifyManager) UseFakeServer(fake *FakeServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoint := fake.Endpoint()
+	s.OAuth.Endpoint.AuthURL = endpoint.AuthURL
+	s.OAuth.Endpoint.TokenURL = endpoint.TokenURL
+	s.APIURL = fake.APIURL()
+	s.fake = fake
+}
+
 // StoreSession stores a Spotify session identified by the state, including the OAuth token and Spotify client.
+// The session is also written to the store.
 func (s *SpotifyManager) StoreSession(ctx context.Context, state string, token *oauth2.Token) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// Keep the creation time of a session that is being completed
+	createdAt := time.Now()
+	if existing := s.loadSession(state); existing != nil {
+		createdAt = existing.CreatedAt
+	}
+
 	// Store the session with a new Spotify client using the provided token.
-	s.Sessions[state] = &Session{
-		Client: zSpotify.New(s.Auth.Client(ctx, token)),
-		Token:  token,
-		State:  state,
+	session := &Session{
+		Token:     token,
+		State:     state,
+		CreatedAt: createdAt,
+		UpdatedAt: time.Now(),
+		cache:     NewResponseCache(),
 	}
+	session.Client = s.newClient(ctx, session)
+	s.Sessions[state] = session
+	s.persistSession(session)
 }
 
-// GetSession retrieves a session by state and refreshes its token if it has expired.
-func (s *SpotifyManager) GetSession(state string) *Session {
+// StartLogin reserves a session ID for a login and returns it with the authorization URL. The URL
+// carries a separate random OAuth state, so the session ID never leaves the server in a URL.
+// With PKCE a code verifier is generated for the login and its challenge added to the URL.
+// The login is only kept in memory, the session is created once CompleteLogin has a token.
+func (s *SpotifyManager) StartLogin(ctx context.Context) (string, string, error) {
+	login := pendingLogin{
+		SessionID: util.GenerateUUIDv4(),
+		CreatedAt: time.Now(),
+	}
+	oauthState := util.GenerateUUIDv4()
+	if login.SessionID == "" || oauthState == "" {
+		return "", "", errors.New("could not generate login state")
+	}
+
+	var options []oauth2.AuthCodeOption
+	if s.Mode == AuthModePKCE {
+		verifier, err := newCodeVerifier()
+		if err != nil {
+			return "", "", err
+		}
+
+		login.Verifier = verifier
+		options = append(options,
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+			oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+		)
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Retrieve the session from the stored sessions.
-	session := s.Sessions[state]
-	if session == nil {
-		return nil
+	// Unauthenticated requests start logins, so abandoned ones are dropped before the limit is enforced
+	if len(s.logins) >= MaxPendingLogins {
+		s.pruneLogins(time.Now())
 	}
+	if len(s.logins) >= MaxPendingLogins {
+		return "", "", ErrorTooManyLogins
+	}
+	s.logins[oauthState] = login
 
-	if session.Token == nil {
-		return session
+	return login.SessionID, s.OAuth.AuthCodeURL(oauthState, options...), nil
+}
+
+// CompleteLogin exchanges the authorization code of the callback request for a token and stores it
+// in the session. The OAuth state can only be used once, and only by the session that started the login.
+func (s *SpotifyManager) CompleteLogin(ctx context.Context, sessionID string, r *http.Request) error {
+	oauthState := r.URL.Query().Get("state")
+
+	s.mu.Lock()
+	login, ok := s.logins[oauthState]
+	delete(s.logins, oauthState)
+	s.mu.Unlock()
+
+	if !ok || sessionID == "" || login.SessionID != sessionID || time.Since(login.CreatedAt) > PendingSessionTTL {
+		return ErrorInvalidLoginState
 	}
 
-	// Check if the token is expired. If expired, refresh it.
-	if isTokenExpired(session.Token) {
-		token, err := s.Auth.RefreshToken(s.ctx, session.Token)
-		if err != nil {
-			s.Log.Error().Err(err).Msg("failed to refresh token")
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		return fmt.Errorf("spotify login failed: %s", reason)
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return errors.New("spotify did not return an authorization code")
+	}
+
+	// The verifier proves this server started the login, in place of the client secret
+	var options []oauth2.AuthCodeOption
+	if login.Verifier != "" {
+		options = append(options, oauth2.SetAuthURLParam("code_verifier", login.Verifier))
+	}
+
+	token, err := s.OAuth.Exchange(ctx, code, options...)
+	if err != nil {
+		return fmt.Errorf("could not get token: %v", err)
+	}
+
+	s.StoreSession(ctx, sessionID, token)
+	return nil
+}
+
+// GetSession retrieves a session by state and refreshes its token if it has expired.
+// Sessions that are not loaded yet, e.g. after a restart, are read from the store.
+// The manager lock is not held while the store is read or the token is refreshed, so a slow
+// refresh only holds up requests of the same session.
+func (s *SpotifyManager) GetSession(state string) *Session {
+	s.mu.Lock()
+	session := s.Sessions[state]
+	s.mu.Unlock()
+
+	// Retrieve the session from the persisted sessions when it is not loaded.
+	if session == nil {
+		session = s.readSession(state)
+		if session == nil {
 			return nil
 		}
+	}
 
-		// Update the session with the new token.
-		session.Token = token
-		s.Sessions[state] = session
+	if session.Client == nil {
+		return session
+	}
+
+	// Refresh the token if it has expired, the same way the client does before a request
+	if _, err := s.sessionToken(session); err != nil {
+		s.Log.Error().Err(err).Msg("failed to refresh token")
+		return nil
 	}
 
 	return session
 }
 
+// sessionToken returns a valid token for the session, refreshing it when it has expired.
+// A refreshed token is stored in the session and written back so it survives a restart,
+// including a new refresh token when Spotify rotates it.
+func (s *SpotifyManager) sessionToken(session *Session) (*oauth2.Token, error) {
+	session.refresh.Lock()
+	defer session.refresh.Unlock()
+
+	s.mu.Lock()
+	token := session.Token
+	s.mu.Unlock()
+
+	if token == nil {
+		return nil, ErrorSessionNotFound
+	}
+	if token.Valid() {
+		return token, nil
+	}
+
+	// The refresh request authenticates the same way as the login, by secret or client ID
+	refreshed, err := s.OAuth.TokenSource(s.ctx, token).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session.Token = refreshed
+	session.UpdatedAt = time.Now()
+
+	// A session logged out during the refresh is not written back
+	if s.Sessions[session.State] == session {
+		s.persistSession(session)
+	}
+
+	return refreshed, nil
+}
+
+// sessionTokenSource supplies the token of a session to its client.
+type sessionTokenSource struct {
+	manager *SpotifyManager
+	session *Session
+}
+
+func (t sessionTokenSource) Token() (*oauth2.Token, error) {
+	return t.manager.sessionToken(t.session)
+}
+
 // DestroySession removes a session by state, effectively logging the user out.
 func (s *SpotifyManager) DestroySession(state string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Delete the session from the map.
+	// Delete the session from the map and the store.
 	delete(s.Sessions, state)
+	if err := s.Store.Delete(state); err != nil {
+		s.Log.Error().Err(err).Msg("failed to delete persisted session")
+	}
 }
 
 // StartSessionCleanup periodically removes expired sessions at the given interval.
@@ -168,14 +411,7 @@ func (s *SpotifyManager) StartSessionCleanup(interval time.Duration) {
 		for {
 			select {
 			case <-ticker.C:
-				s.mu.Lock()
-				// Iterate through sessions and remove expired ones.
-				for state, session := range s.Sessions {
-					if isTokenExpired(session.Token) {
-						delete(s.Sessions, state)
-					}
-				}
-				s.mu.Unlock()
+				s.PruneSessions(time.Now())
 			case <-s.ctx.Done(): // When context is canceled, stop the ticker and exit.
 				ticker.Stop()
 				return
@@ -184,11 +420,150 @@ func (s *SpotifyManager) StartSessionCleanup(interval time.Duration) {
 	}()
 }
 
-// isTokenExpired checks whether the OAuth token is expired or nearing expiration.
-// If the token is nil or has already expired, the function returns true.
-func isTokenExpired(token *oauth2.Token) bool {
-	if token == nil {
-		return true
+// PruneSessions removes expired sessions from memory and from the store.
+// It returns the number of sessions removed from the store.
+func (s *SpotifyManager) PruneSessions(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.Store.List()
+	if err != nil {
+		s.Log.Error().Err(err).Msg("failed to list persisted sessions")
+	}
+
+	pruned := 0
+	for _, record := range records {
+		if !isSessionExpired(record, now) {
+			continue
+		}
+
+		if err := s.Store.Delete(record.State); err != nil {
+			s.Log.Error().Err(err).Msg("failed to delete persisted session")
+			continue
+		}
+		delete(s.Sessions, record.State)
+		pruned++
+	}
+
+	s.pruneLogins(now)
+
+	// Sessions that could not be persisted only live in memory and expire the same way
+	for state, session := range s.Sessions {
+		if isSessionExpired(session.record(), now) {
+			delete(s.Sessions, state)
+		}
+	}
+
+	return pruned
+}
+
+// pruneLogins removes logins that never reached the callback. The caller must hold s.mu.
+func (s *SpotifyManager) pruneLogins(now time.Time) {
+	for oauthState, login := range s.logins {
+		if now.Sub(login.CreatedAt) > PendingSessionTTL {
+			delete(s.logins, oauthState)
+		}
+	}
+}
+
+// loadSession returns the loaded session for the state, reading it from the store when needed.
+// The caller must hold s.mu.
+func (s *SpotifyManager) loadSession(state string) *Session {
+	if session := s.Sessions[state]; session != nil {
+		return session
+	}
+
+	session := s.sessionFromStore(state)
+	if session != nil {
+		s.Sessions[state] = session
+	}
+	return session
+}
+
+// readSession reads a session from the store without holding s.mu while the store is read,
+// keeping a session loaded by another request in the meantime.
+func (s *SpotifyManager) readSession(state string) *Session {
+	session := s.sessionFromStore(state)
+	if session == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if loaded := s.Sessions[state]; loaded != nil {
+		return loaded
+	}
+	s.Sessions[state] = session
+	return session
+}
+
+// sessionFromStore reads a session from the store, returning nil when it is not found.
+func (s *SpotifyManager) sessionFromStore(state string) *Session {
+	record, err := s.Store.Load(state)
+	if err != nil {
+		if err != ErrorSessionNotFound {
+			s.Log.Error().Err(err).Msg("failed to load persisted session")
+		}
+		return nil
+	}
+
+	session := &Session{
+		Token:     record.Token,
+		State:     record.State,
+		CreatedAt: record.CreatedAt,
+		UpdatedAt: record.UpdatedAt,
+	}
+	if record.Token != nil {
+		session.cache = NewResponseCache()
+		session.Client = s.newClient(s.ctx, session)
+	}
+
+	return session
+}
+
+// newClient returns a Web API client for the session. The token is taken from the session before
+// every request, so refreshes by the client and by GetSession are shared and persisted. Its requests
+// wait out rate limits, retry server errors and reuse the read-only responses in the session cache.
+func (s *SpotifyManager) newClient(ctx context.Context, session *Session) *zSpotify.Client {
+	httpClient := oauth2.NewClient(ctx, sessionTokenSource{manager: s, session: session})
+	httpClient.Transport = NewTransport(httpClient.Transport, session.cache)
+
+	if s.APIURL != "" {
+		return zSpotify.New(httpClient, zSpotify.WithBaseURL(s.APIURL))
+	}
+	return zSpotify.New(httpClient)
+}
+
+// persistSession writes the session to the store, logging failures so requests keep working
+// from memory. The caller must hold s.mu.
+func (s *SpotifyManager) persistSession(session *Session) {
+	if err := s.Store.Save(session.record()); err != nil {
+		s.Log.Error().Err(err).Msg("failed to persist session")
+	}
+}
+
+// record returns the persisted part of the session.
+func (session *Session) record() SessionRecord {
+	return SessionRecord{
+		State:     session.State,
+		Token:     session.Token,
+		CreatedAt: session.CreatedAt,
+		UpdatedAt: session.UpdatedAt,
+	}
+}
+
+// isSessionExpired reports whether a persisted session can be pruned. Sessions without a token, which
+// older versions stored for pending logins, expire after PendingSessionTTL, sessions without a refresh
+// token when their token expires, and sessions that can be refreshed after SessionIdleTimeout without
+// a refresh.
+func isSessionExpired(record SessionRecord, now time.Time) bool {
+	switch {
+	case record.Token == nil:
+		return now.Sub(record.CreatedAt) > PendingSessionTTL
+	case record.Token.RefreshToken == "":
+		return record.Token.Expiry.Before(now)
+	default:
+		return now.Sub(record.UpdatedAt) > SessionIdleTimeout
 	}
-	return token.Expiry.Before(time.Now())
 }
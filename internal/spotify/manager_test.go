@@ -0,0 +1,300 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"rory-pearson/database"
+	"rory-pearson/pkg/log"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+func getLogger() log.Log {
+	return log.New(log.Config{
+		ID:            "spotify_test",
+		ConsoleOutput: false,
+		FileOutput:    false,
+		StoragePath:   "",
+	})
+}
+
+// rewriteTransport sends every request to the test server instead of Spotify.
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// newTestManager returns a manager using the store whose HTTP requests go to the server.
+func newTestManager(t *testing.T, store SessionStore, server *httptest.Server) *SpotifyManager {
+	return newTestManagerWithMode(t, AuthModeSecret, store, server)
+}
+
+// newTestManagerWithMode returns a test manager logging in with the auth mode.
+func newTestManagerWithMode(t *testing.T, mode AuthMode, store SessionStore, server *httptest.Server) *SpotifyManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if server != nil {
+		target, _ := url.Parse(server.URL)
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: rewriteTransport{target}})
+	}
+
+	return &SpotifyManager{
+		ctx:      ctx,
+		cancel:   cancel,
+		Log:      getLogger(),
+		Mode:     mode,
+		OAuth:    NewOAuthConfig(mode, "client_id", "client_secret", "http://localhost/api/spotify/callback"),
+		Sessions: make(map[string]*Session),
+		Store:    store,
+		logins:   make(map[string]pendingLogin),
+	}
+}
+
+func TestSessionSurvivesRestart(t *testing.T) {
+	store, err := NewFileSessionStore(t.TempDir(), testKey(3))
+	assert.NoError(t, err)
+
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}
+	first := newTestManager(t, store, nil)
+	first.StoreSession(context.Background(), "state", nil)
+	first.StoreSession(context.Background(), "state", token)
+
+	// A new manager reads the session back from the store
+	second := newTestManager(t, store, nil)
+	session := second.GetSession("state")
+	if assert.NotNil(t, session) {
+		assert.Equal(t, "access", session.Token.AccessToken)
+		assert.NotNil(t, session.Client)
+	}
+
+	second.DestroySession("state")
+	assert.Nil(t, newTestManager(t, store, nil).GetSession("state"))
+}
+
+func TestGetSessionWritesRefreshedToken(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+
+	fake.mu.Lock()
+	fake.refresh["refresh"] = "fake-user"
+	fake.mu.Unlock()
+
+	assert.NoError(t, sm.Store.Save(SessionRecord{
+		State:     "state",
+		Token:     &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)},
+		CreatedAt: time.Now().Add(-time.Hour),
+		UpdatedAt: time.Now().Add(-time.Hour),
+	}))
+
+	session := sm.GetSession("state")
+	if !assert.NotNil(t, session) {
+		return
+	}
+	assert.NotEqual(t, "expired", session.Token.AccessToken)
+	assert.NotEqual(t, "refresh", session.Token.RefreshToken)
+	assert.Equal(t, 1, fake.Count(http.MethodPost, "/api/token"))
+
+	record, err := sm.Store.Load("state")
+	assert.NoError(t, err)
+	assert.Equal(t, session.Token.AccessToken, record.Token.AccessToken)
+	assert.Equal(t, session.Token.RefreshToken, record.Token.RefreshToken)
+	assert.True(t, record.UpdatedAt.After(record.CreatedAt.Add(time.Minute)))
+}
+
+func TestGetSessionRefreshDoesNotBlockOtherSessions(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "refreshed",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	store := NewMemorySessionStore()
+	assert.NoError(t, store.Save(SessionRecord{
+		State: "expired",
+		Token: &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)},
+	}))
+	assert.NoError(t, store.Save(SessionRecord{
+		State: "valid",
+		Token: &oauth2.Token{AccessToken: "valid", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)},
+	}))
+
+	sm := newTestManager(t, store, server)
+	go sm.GetSession("expired")
+	<-started
+
+	// The other session is returned while the refresh is still waiting on the server
+	done := make(chan *Session)
+	go func() { done <- sm.GetSession("valid") }()
+	select {
+	case session := <-done:
+		if assert.NotNil(t, session) {
+			assert.Equal(t, "valid", session.Token.AccessToken)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetSession waited for the refresh of another session")
+	}
+}
+
+func TestPruneSessions(t *testing.T) {
+	now := time.Now()
+	store := NewMemorySessionStore()
+	records := []SessionRecord{
+		{State: "pending", CreatedAt: now.Add(-time.Minute)},
+		{State: "abandoned", CreatedAt: now.Add(-PendingSessionTTL - time.Minute)},
+		{State: "expired", Token: &oauth2.Token{AccessToken: "a", Expiry: now.Add(-time.Minute)}},
+		{State: "refreshable", Token: &oauth2.Token{AccessToken: "a", RefreshToken: "r", Expiry: now.Add(-time.Minute)}, UpdatedAt: now.Add(-time.Hour)},
+		{State: "idle", Token: &oauth2.Token{AccessToken: "a", RefreshToken: "r"}, UpdatedAt: now.Add(-SessionIdleTimeout - time.Hour)},
+	}
+	for _, record := range records {
+		assert.NoError(t, store.Save(record))
+	}
+
+	sm := newTestManager(t, store, nil)
+	sm.Sessions["abandoned"] = &Session{State: "abandoned", CreatedAt: now.Add(-PendingSessionTTL - time.Minute)}
+
+	assert.Equal(t, 3, sm.PruneSessions(now))
+
+	remaining, err := store.List()
+	assert.NoError(t, err)
+	states := make([]string, 0, len(remaining))
+	for _, record := range remaining {
+		states = append(states, record.State)
+	}
+	assert.ElementsMatch(t, []string{"pending", "refreshable"}, states)
+	assert.NotContains(t, sm.Sessions, "abandoned")
+}
+
+// startLogin starts a login, failing the test on errors.
+func startLogin(t *testing.T, sm *SpotifyManager) (string, string) {
+	sessionID, authURL, err := sm.StartLogin(context.Background())
+	assert.NoError(t, err)
+	return sessionID, authURL
+}
+
+func TestLogin(t *testing.T) {
+	sm, _ := newFakeManager(t, AuthModeSecret)
+
+	sessionID, authURL := startLogin(t, sm)
+	assert.NotContains(t, authURL, sessionID)
+
+	// Nothing is stored until the callback
+	assert.Nil(t, sm.GetSession(sessionID))
+	records, err := sm.Store.List()
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+
+	r := fakeLogin(t, authURL)
+	assert.NoError(t, sm.CompleteLogin(sm.ctx, sessionID, r))
+
+	session := sm.GetSession(sessionID)
+	if assert.NotNil(t, session) && assert.NotNil(t, session.Token) {
+		assert.NotEmpty(t, session.Token.AccessToken)
+	}
+	records, err = sm.Store.List()
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	// The OAuth state can only be used once
+	assert.ErrorIs(t, sm.CompleteLogin(sm.ctx, sessionID, r), ErrorInvalidLoginState)
+}
+
+func TestLoginRejectsOtherSession(t *testing.T) {
+	sm, _ := newFakeManager(t, AuthModeSecret)
+
+	sessionID, authURL := startLogin(t, sm)
+	otherID, _ := startLogin(t, sm)
+
+	assert.ErrorIs(t, sm.CompleteLogin(sm.ctx, otherID, fakeLogin(t, authURL)), ErrorInvalidLoginState)
+	assert.ErrorIs(t, sm.CompleteLogin(sm.ctx, "", fakeLogin(t, authURL)), ErrorInvalidLoginState)
+
+	assert.Nil(t, sm.GetSession(sessionID))
+}
+
+func TestPruneLogins(t *testing.T) {
+	sm, _ := newFakeManager(t, AuthModeSecret)
+
+	sessionID, authURL := startLogin(t, sm)
+	sm.PruneSessions(time.Now().Add(PendingSessionTTL + time.Minute))
+
+	assert.Empty(t, sm.logins)
+	assert.ErrorIs(t, sm.CompleteLogin(sm.ctx, sessionID, fakeLogin(t, authURL)), ErrorInvalidLoginState)
+}
+
+func TestMaxPendingLogins(t *testing.T) {
+	sm := newTestManager(t, NewMemorySessionStore(), nil)
+
+	for i := 0; i < MaxPendingLogins; i++ {
+		sm.logins[strconv.Itoa(i)] = pendingLogin{SessionID: strconv.Itoa(i), CreatedAt: time.Now()}
+	}
+
+	_, _, err := sm.StartLogin(context.Background())
+	assert.ErrorIs(t, err, ErrorTooManyLogins)
+
+	// Abandoned logins make room for new ones
+	sm.logins["0"] = pendingLogin{SessionID: "0", CreatedAt: time.Now().Add(-PendingSessionTTL - time.Minute)}
+	startLogin(t, sm)
+	assert.Len(t, sm.logins, MaxPendingLogins)
+}
+
+// setTestEnvironment sets the environment Initialize requires, without a session key.
+func setTestEnvironment(t *testing.T) {
+	t.Setenv("SERVER_HOST", "http://localhost")
+	t.Setenv("SERVER_PORT", "0")
+	t.Setenv("UI_BUILD_PATH", t.TempDir())
+	t.Setenv("DB_URL", "unused")
+	t.Setenv("SPOTIFY_CLIENT_ID", "client-id")
+	t.Setenv("SPOTIFY_SESSION_KEY", "")
+	t.Setenv("SPOTIFY_FAKE", "")
+
+	t.Cleanup(func() { instance = nil })
+}
+
+func TestInitializeWithoutSessionKey(t *testing.T) {
+	setTestEnvironment(t)
+
+	history, err := database.NewHistory(t.TempDir())
+	assert.NoError(t, err)
+
+	sm := Initialize(Config{Log: getLogger(), History: history})
+	if !assert.NotNil(t, sm) {
+		return
+	}
+	defer sm.Close()
+
+	// Sessions fall back to memory rather than failing startup
+	assert.IsType(t, &MemorySessionStore{}, sm.Store)
+	assert.Equal(t, sm, GetInstance())
+}
+
+func TestInitializeWithInvalidSessionKey(t *testing.T) {
+	setTestEnvironment(t)
+	t.Setenv("SPOTIFY_SESSION_KEY", "not-a-key")
+
+	history, err := database.NewHistory(t.TempDir())
+	assert.NoError(t, err)
+
+	// A malformed key is a configuration error, the manager is left uninitialized without panicking
+	assert.Nil(t, Initialize(Config{Log: getLogger(), History: history}))
+	assert.Nil(t, GetInstance())
+}
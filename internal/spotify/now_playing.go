@@ -0,0 +1,296 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// NowPlayingChange is the kind of change a now playing event describes.
+type NowPlayingChange string
+
+const (
+	NowPlayingSnapshot NowPlayingChange = "snapshot" // The full state, sent first to every subscriber
+	NowPlayingTrack    NowPlayingChange = "track"    // Another track, or nothing, is playing
+	NowPlayingState    NowPlayingChange = "state"    // Playback was paused or resumed
+	NowPlayingProgress NowPlayingChange = "progress" // Playback jumped, e.g. after a seek
+	NowPlayingError    NowPlayingChange = "error"    // Polling failed, it is retried unless the session is gone
+)
+
+const (
+	// NowPlayingInterval is how often a playing track is polled when it is not close to its end.
+	NowPlayingInterval = 5 * time.Second
+	// NowPlayingPausedInterval is how often paused playback is polled.
+	NowPlayingPausedInterval = 15 * time.Second
+	// NowPlayingIdleInterval is how often polled when nothing is playing, or after an error.
+	NowPlayingIdleInterval = 30 * time.Second
+	// nowPlayingMinInterval keeps polling near the end of a track from hammering the API.
+	nowPlayingMinInterval = time.Second
+	// nowPlayingTrackEndDelay is added to the remaining time of a track, so the next track has started when polled.
+	nowPlayingTrackEndDelay = 500 * time.Millisecond
+	// nowPlayingProgressTolerance is how far the progress may drift from the expected progress
+	// before it is reported as a jump.
+	nowPlayingProgressTolerance = 3 * time.Second
+	// nowPlayingEventBuffer is the number of events buffered per subscriber.
+	nowPlayingEventBuffer = 16
+)
+
+// NowPlayingEvent is a change of the playback of a session. Only the fields of the change are set.
+type NowPlayingEvent struct {
+	Type       NowPlayingChange           `json:"type"`
+	NowPlaying *zSpotify.CurrentlyPlaying `json:"now_playing,omitempty"` // Snapshot and track changes
+	Playing    *bool                      `json:"is_playing,omitempty"`  // State changes
+	ProgressMs *int                       `json:"progress_ms,omitempty"` // State and progress changes
+	Error      string                     `json:"error,omitempty"`
+}
+
+// nowPlayingState is a polled playback state with the time it was read.
+type nowPlayingState struct {
+	playing *zSpotify.CurrentlyPlaying
+	at      time.Time
+}
+
+// NowPlayingPoller polls the playback of one session for all its subscribers.
+type NowPlayingPoller struct {
+	fetch func(ctx context.Context) (*zSpotify.CurrentlyPlaying, error)
+	now   func() time.Time
+
+	mu          sync.Mutex
+	current     *nowPlayingState // Nil until the first successful poll
+	subscribers map[chan NowPlayingEvent]struct{}
+	cancel      context.CancelFunc
+	stopped     bool
+}
+
+// newNowPlayingPoller returns a poller reading the playback with fetch. It polls once started.
+func newNowPlayingPoller(fetch func(ctx context.Context) (*zSpotify.CurrentlyPlaying, error)) *NowPlayingPoller {
+	return &NowPlayingPoller{
+		fetch:       fetch,
+		now:         time.Now,
+		subscribers: make(map[chan NowPlayingEvent]struct{}),
+	}
+}
+
+// SubscribeNowPlaying returns the now playing events of the session. Every session has one shared
+// poller, started by its first subscriber and stopped when the last one unsubscribes. The channel
+// is closed when the session is gone. The returned function must be called to unsubscribe.
+func (s *SpotifyManager) SubscribeNowPlaying(state string) (<-chan NowPlayingEvent, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nowPlaying == nil {
+		s.nowPlaying = make(map[string]*NowPlayingPoller)
+	}
+
+	poller := s.nowPlaying[state]
+	if poller == nil || poller.isStopped() {
+		poller = newNowPlayingPoller(func(ctx context.Context) (*zSpotify.CurrentlyPlaying, error) {
+			// GetSession refreshes the token when it has expired
+			session := s.GetSession(state)
+			if session == nil || session.Client == nil {
+				return nil, ErrorSessionNotFound
+			}
+			return session.Client.PlayerCurrentlyPlaying(ctx)
+		})
+		poller.start(s.ctx)
+		s.nowPlaying[state] = poller
+	}
+
+	events := poller.subscribe()
+	return events, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if poller.unsubscribe(events) == 0 {
+			poller.stop()
+			if s.nowPlaying[state] == poller {
+				delete(s.nowPlaying, state)
+			}
+		}
+	}
+}
+
+// start polls in the background until stopped or the context is canceled.
+func (p *NowPlayingPoller) start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	p.mu.Lock()
+	p.cancel = cancel
+	p.mu.Unlock()
+
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				p.stop()
+				return
+			case <-timer.C:
+				delay, ok := p.poll(ctx)
+				if !ok {
+					p.stop()
+					return
+				}
+				timer.Reset(delay)
+			}
+		}
+	}()
+}
+
+// stop stops polling and closes every subscription. It does not wait for a running poll.
+func (p *NowPlayingPoller) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	if p.cancel != nil {
+		p.cancel()
+	}
+
+	for events := range p.subscribers {
+		delete(p.subscribers, events)
+		close(events)
+	}
+}
+
+func (p *NowPlayingPoller) isStopped() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stopped
+}
+
+// subscribe returns a channel receiving the events, starting with a snapshot once one is known.
+func (p *NowPlayingPoller) subscribe() chan NowPlayingEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	events := make(chan NowPlayingEvent, nowPlayingEventBuffer)
+	if p.current != nil {
+		events <- NowPlayingEvent{Type: NowPlayingSnapshot, NowPlaying: p.current.playing}
+	}
+
+	if p.stopped {
+		close(events)
+		return events
+	}
+
+	p.subscribers[events] = struct{}{}
+	return events
+}
+
+// unsubscribe removes the subscription and returns the number of subscribers left.
+func (p *NowPlayingPoller) unsubscribe(events chan NowPlayingEvent) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.subscribers[events]; ok {
+		delete(p.subscribers, events)
+		close(events)
+	}
+	return len(p.subscribers)
+}
+
+// poll reads the playback, broadcasts its changes and returns the delay until the next poll.
+// It returns false when the session is gone and polling should stop.
+func (p *NowPlayingPoller) poll(ctx context.Context) (time.Duration, bool) {
+	playing, err := p.fetch(ctx)
+	if err != nil {
+		p.broadcast(NowPlayingEvent{Type: NowPlayingError, Error: TypedError(err).Error()})
+
+		// Wait as long as Spotify asks when rate limited
+		var rateLimit *RateLimitError
+		if errors.As(err, &rateLimit) {
+			return max(NowPlayingIdleInterval, rateLimit.RetryAfter), true
+		}
+		return NowPlayingIdleInterval, err != ErrorSessionNotFound
+	}
+
+	next := &nowPlayingState{playing: playing, at: p.now()}
+
+	p.mu.Lock()
+	previous := p.current
+	p.current = next
+	p.mu.Unlock()
+
+	for _, event := range diffNowPlaying(previous, next) {
+		p.broadcast(event)
+	}
+
+	return nextNowPlayingInterval(playing), true
+}
+
+// broadcast sends the event to every subscriber.
+func (p *NowPlayingPoller) broadcast(event NowPlayingEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for events := range p.subscribers {
+		// Slow subscribers miss events rather than blocking the poller
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// diffNowPlaying returns the events describing the changes from the previous state to the next.
+func diffNowPlaying(previous, next *nowPlayingState) []NowPlayingEvent {
+	if previous == nil {
+		return []NowPlayingEvent{{Type: NowPlayingSnapshot, NowPlaying: next.playing}}
+	}
+
+	before, after := previous.playing, next.playing
+	if nowPlayingTrackID(before) != nowPlayingTrackID(after) {
+		return []NowPlayingEvent{{Type: NowPlayingTrack, NowPlaying: after}}
+	}
+	if after.Item == nil {
+		return nil
+	}
+
+	progress := int(after.Progress)
+	if before.Playing != after.Playing {
+		return []NowPlayingEvent{{Type: NowPlayingState, Playing: &after.Playing, ProgressMs: &progress}}
+	}
+
+	// A playing track is expected to have moved on by the time between the polls
+	expected := time.Duration(before.Progress) * time.Millisecond
+	if before.Playing {
+		expected += next.at.Sub(previous.at)
+	}
+
+	drift := time.Duration(after.Progress)*time.Millisecond - expected
+	if drift > nowPlayingProgressTolerance || drift < -nowPlayingProgressTolerance {
+		return []NowPlayingEvent{{Type: NowPlayingProgress, ProgressMs: &progress}}
+	}
+
+	return nil
+}
+
+// nextNowPlayingInterval polls faster near the end of a playing track and slower while paused or idle.
+func nextNowPlayingInterval(playing *zSpotify.CurrentlyPlaying) time.Duration {
+	switch {
+	case playing == nil || playing.Item == nil:
+		return NowPlayingIdleInterval
+	case !playing.Playing:
+		return NowPlayingPausedInterval
+	}
+
+	remaining := time.Duration(playing.Item.Duration-playing.Progress) * time.Millisecond
+	return max(nowPlayingMinInterval, min(NowPlayingInterval, remaining+nowPlayingTrackEndDelay))
+}
+
+// nowPlayingTrackID returns the ID of the playing track, or an empty ID when nothing is playing.
+func nowPlayingTrackID(playing *zSpotify.CurrentlyPlaying) zSpotify.ID {
+	if playing == nil || playing.Item == nil {
+		return ""
+	}
+	return playing.Item.ID
+}
@@ -0,0 +1,157 @@
+package spotify
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+func currentlyPlaying(id string, playing bool, progressMs int) *zSpotify.CurrentlyPlaying {
+	if id == "" {
+		return &zSpotify.CurrentlyPlaying{}
+	}
+
+	track := &zSpotify.FullTrack{}
+	track.ID = zSpotify.ID(id)
+	track.Duration = 180000
+	return &zSpotify.CurrentlyPlaying{Item: track, Playing: playing, Progress: zSpotify.Numeric(progressMs)}
+}
+
+func TestDiffNowPlaying(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := func(playing *zSpotify.CurrentlyPlaying, after time.Duration) *nowPlayingState {
+		return &nowPlayingState{playing: playing, at: start.Add(after)}
+	}
+	playing := state(currentlyPlaying("a", true, 10000), 0)
+
+	events := diffNowPlaying(nil, playing)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, NowPlayingSnapshot, events[0].Type)
+		assert.Equal(t, playing.playing, events[0].NowPlaying)
+	}
+
+	// Progress moving with the clock is not a change
+	assert.Empty(t, diffNowPlaying(playing, state(currentlyPlaying("a", true, 15000), 5*time.Second)))
+
+	events = diffNowPlaying(playing, state(currentlyPlaying("a", true, 90000), 5*time.Second))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, NowPlayingProgress, events[0].Type)
+		assert.Equal(t, 90000, *events[0].ProgressMs)
+	}
+
+	events = diffNowPlaying(playing, state(currentlyPlaying("a", false, 12000), 5*time.Second))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, NowPlayingState, events[0].Type)
+		assert.False(t, *events[0].Playing)
+		assert.Equal(t, 12000, *events[0].ProgressMs)
+	}
+
+	// Paused progress is expected to stay put
+	paused := state(currentlyPlaying("a", false, 12000), 0)
+	assert.Empty(t, diffNowPlaying(paused, state(currentlyPlaying("a", false, 12000), time.Minute)))
+
+	events = diffNowPlaying(playing, state(currentlyPlaying("b", true, 0), 5*time.Second))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, NowPlayingTrack, events[0].Type)
+		assert.Equal(t, zSpotify.ID("b"), events[0].NowPlaying.Item.ID)
+	}
+
+	events = diffNowPlaying(playing, state(currentlyPlaying("", false, 0), 5*time.Second))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, NowPlayingTrack, events[0].Type)
+		assert.Nil(t, events[0].NowPlaying.Item)
+	}
+	assert.Empty(t, diffNowPlaying(state(currentlyPlaying("", false, 0), 0), state(currentlyPlaying("", false, 0), time.Minute)))
+}
+
+func TestNextNowPlayingInterval(t *testing.T) {
+	assert.Equal(t, NowPlayingIdleInterval, nextNowPlayingInterval(nil))
+	assert.Equal(t, NowPlayingIdleInterval, nextNowPlayingInterval(currentlyPlaying("", false, 0)))
+	assert.Equal(t, NowPlayingPausedInterval, nextNowPlayingInterval(currentlyPlaying("a", false, 179000)))
+	assert.Equal(t, NowPlayingInterval, nextNowPlayingInterval(currentlyPlaying("a", true, 10000)))
+
+	// Near the end the next poll lands just after the track ends
+	assert.Equal(t, 2500*time.Millisecond, nextNowPlayingInterval(currentlyPlaying("a", true, 178000)))
+	assert.Equal(t, nowPlayingMinInterval, nextNowPlayingInterval(currentlyPlaying("a", true, 180000)))
+}
+
+func TestNowPlayingPollerBroadcasts(t *testing.T) {
+	responses := []*zSpotify.CurrentlyPlaying{currentlyPlaying("a", true, 0), currentlyPlaying("b", true, 0)}
+	poller := newNowPlayingPoller(func(ctx context.Context) (*zSpotify.CurrentlyPlaying, error) {
+		response := responses[0]
+		responses = responses[1:]
+		return response, nil
+	})
+
+	first := poller.subscribe()
+	delay, ok := poller.poll(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, NowPlayingInterval, delay)
+	assert.Equal(t, NowPlayingSnapshot, (<-first).Type)
+
+	// Later subscribers start from the last known state
+	second := poller.subscribe()
+	assert.Equal(t, NowPlayingSnapshot, (<-second).Type)
+
+	_, ok = poller.poll(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, NowPlayingTrack, (<-first).Type)
+	assert.Equal(t, NowPlayingTrack, (<-second).Type)
+
+	assert.Equal(t, 1, poller.unsubscribe(first))
+	poller.stop()
+	_, open := <-second
+	assert.False(t, open)
+}
+
+func TestSubscribeNowPlayingSharesPoller(t *testing.T) {
+	sm, fake := newFakeManager(t, AuthModeSecret)
+	session := fakeSession(t, sm)
+
+	first, unsubscribeFirst := sm.SubscribeNowPlaying(session.State)
+	second, unsubscribeSecond := sm.SubscribeNowPlaying(session.State)
+
+	for _, events := range []<-chan NowPlayingEvent{first, second} {
+		select {
+		case event := <-events:
+			assert.Equal(t, NowPlayingSnapshot, event.Type)
+			assert.Equal(t, zSpotify.ID("track-04"), event.NowPlaying.Item.ID)
+		case <-time.After(time.Second):
+			t.Fatal("no snapshot received")
+		}
+	}
+	assert.Equal(t, 1, fake.Count(http.MethodGet, "/v1/me/player/currently-playing"))
+	assert.Len(t, sm.nowPlaying, 1)
+
+	// The poller stops with its last subscriber
+	unsubscribeFirst()
+	assert.Len(t, sm.nowPlaying, 1)
+	unsubscribeSecond()
+	assert.Empty(t, sm.nowPlaying)
+}
+
+func TestSubscribeNowPlayingMissingSession(t *testing.T) {
+	sm := newTestManager(t, NewMemorySessionStore(), nil)
+
+	events, unsubscribe := sm.SubscribeNowPlaying("missing")
+	defer unsubscribe()
+
+	select {
+	case event := <-events:
+		assert.Equal(t, NowPlayingError, event.Type)
+		assert.Equal(t, ErrorSessionNotFound.Error(), event.Error)
+	case <-time.After(time.Second):
+		t.Fatal("no error received")
+	}
+
+	select {
+	case _, open := <-events:
+		assert.False(t, open)
+	case <-time.After(time.Second):
+		t.Fatal("events were not closed")
+	}
+}
@@ -0,0 +1,170 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+const (
+	// MaxPlaylistsLimit is the largest page of playlists Spotify returns.
+	MaxPlaylistsLimit = 50
+	// MaxPlaylistItemsLimit is the largest page of playlist items Spotify returns.
+	MaxPlaylistItemsLimit = 100
+)
+
+// PageOptions selects the items to fetch. Without All a single page is fetched with the limit
+// and offset passed through, with All every page from the offset onwards is walked.
+type PageOptions struct {
+	Limit  int  // Page size, 0 uses Spotify's default, or the largest page with All
+	Offset int  // Index of the first item
+	All    bool // Walk every page instead of returning a single one
+}
+
+// Validate checks the limit against the largest page the endpoint supports. A limit of 0 is
+// allowed and uses the default page size.
+func (o PageOptions) Validate(maxLimit int) error {
+	if o.Limit < 0 || o.Limit > maxLimit {
+		return fmt.Errorf("limit must be between 1 and %d, or 0 for the default", maxLimit)
+	}
+	if o.Offset < 0 {
+		return fmt.Errorf("offset can not be negative")
+	}
+	return nil
+}
+
+// requestOptions converts the options to Spotify request options. Walking every page uses
+// the largest page size unless a limit is set, so as few requests as possible are made.
+func (o PageOptions) requestOptions(maxLimit int) []zSpotify.RequestOption {
+	var options []zSpotify.RequestOption
+
+	limit := o.Limit
+	if o.All && limit == 0 {
+		limit = maxLimit
+	}
+	if limit > 0 {
+		options = append(options, zSpotify.Limit(limit))
+	}
+	if o.Offset > 0 {
+		options = append(options, zSpotify.Offset(o.Offset))
+	}
+
+	return options
+}
+
+// GetPlaylists returns a single page of the current user's playlists.
+func GetPlaylists(ctx context.Context, client *zSpotify.Client, options PageOptions) (*zSpotify.SimplePlaylistPage, error) {
+	if err := options.Validate(MaxPlaylistsLimit); err != nil {
+		return nil, err
+	}
+	return client.CurrentUsersPlaylists(ctx, options.requestOptions(MaxPlaylistsLimit)...)
+}
+
+// EachPlaylist calls fn for the current user's playlists, following every page when options.All is set.
+func EachPlaylist(ctx context.Context, client *zSpotify.Client, options PageOptions, fn func(zSpotify.SimplePlaylist) error) error {
+	page, err := GetPlaylists(ctx, client, options)
+	if err != nil {
+		return err
+	}
+
+	for {
+		for _, playlist := range page.Playlists {
+			if err := fn(playlist); err != nil {
+				return err
+			}
+		}
+
+		if done, err := nextPage(options, func() error { return client.NextPage(ctx, page) }); done || err != nil {
+			return err
+		}
+	}
+}
+
+// GetPlaylistItems returns a single page of the items in the playlist.
+func GetPlaylistItems(ctx context.Context, client *zSpotify.Client, playlistID zSpotify.ID, options PageOptions) (*zSpotify.PlaylistItemPage, error) {
+	if err := options.Validate(MaxPlaylistItemsLimit); err != nil {
+		return nil, err
+	}
+	return client.GetPlaylistItems(ctx, playlistID, options.requestOptions(MaxPlaylistItemsLimit)...)
+}
+
+// EachPlaylistItem calls fn for the items in the playlist, following every page when options.All is set.
+func EachPlaylistItem(ctx context.Context, client *zSpotify.Client, playlistID zSpotify.ID, options PageOptions, fn func(zSpotify.PlaylistItem) error) error {
+	page, err := GetPlaylistItems(ctx, client, playlistID, options)
+	if err != nil {
+		return err
+	}
+
+	for {
+		for _, item := range page.Items {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		if done, err := nextPage(options, func() error { return client.NextPage(ctx, page) }); done || err != nil {
+			return err
+		}
+	}
+}
+
+// nextPage loads the next page with next. It reports done when only a single page was
+// requested or the last page has been reached.
+func nextPage(options PageOptions, next func() error) (bool, error) {
+	if !options.All {
+		return true, nil
+	}
+
+	err := next()
+	if errors.Is(err, zSpotify.ErrNoMorePages) {
+		return true, nil
+	}
+	return false, err
+}
+
+// JSONArrayWriter streams values as a JSON array, so large results never have to be held in memory.
+// Nothing is written until the first value or Close, which lets callers still report errors that
+// happen before any value is written.
+type JSONArrayWriter struct {
+	w       io.Writer
+	encoder *json.Encoder
+	count   int
+}
+
+// NewJSONArrayWriter returns a writer streaming a JSON array to w.
+func NewJSONArrayWriter(w io.Writer) *JSONArrayWriter {
+	return &JSONArrayWriter{w: w, encoder: json.NewEncoder(w)}
+}
+
+// Write appends a value to the array.
+func (a *JSONArrayWriter) Write(v any) error {
+	separator := ","
+	if a.count == 0 {
+		separator = "["
+	}
+	if _, err := io.WriteString(a.w, separator); err != nil {
+		return err
+	}
+
+	a.count++
+	return a.encoder.Encode(v)
+}
+
+// Started reports whether any part of the array has been written.
+func (a *JSONArrayWriter) Started() bool {
+	return a.count > 0
+}
+
+// Close ends the array, writing an empty array when no values were written.
+func (a *JSONArrayWriter) Close() error {
+	end := "]"
+	if a.count == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(a.w, end)
+	return err
+}
@@ -0,0 +1,134 @@
+package spotify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+func TestPageOptionsValidate(t *testing.T) {
+	assert.NoError(t, PageOptions{}.Validate(MaxPlaylistsLimit)) // The default page size
+	assert.NoError(t, PageOptions{Limit: MaxPlaylistsLimit}.Validate(MaxPlaylistsLimit))
+
+	err := PageOptions{Limit: MaxPlaylistsLimit + 1}.Validate(MaxPlaylistsLimit)
+	assert.EqualError(t, err, "limit must be between 1 and 50, or 0 for the default")
+	assert.Error(t, PageOptions{Limit: -1}.Validate(MaxPlaylistsLimit))
+	assert.Error(t, PageOptions{Offset: -1}.Validate(MaxPlaylistsLimit))
+}
+
+func TestEachPlaylistAll(t *testing.T) {
+	fake := startFake(t, pagingFixtures(123, 0))
+	client := newFakeClient(fake, "fake-user")
+
+	var ids []string
+	err := EachPlaylist(context.Background(), client, PageOptions{All: true}, func(playlist zSpotify.SimplePlaylist) error {
+		ids = append(ids, playlist.ID.String())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 123)
+	assert.Equal(t, "playlist-000", ids[0])
+	assert.Equal(t, "playlist-122", ids[122])
+	assert.Equal(t, 3, fake.Count(http.MethodGet, "/v1/me/playlists")) // Pages of the largest size
+}
+
+func TestEachPlaylistAllFromOffset(t *testing.T) {
+	fake := startFake(t, pagingFixtures(30, 0))
+	client := newFakeClient(fake, "fake-user")
+
+	var ids []string
+	err := EachPlaylist(context.Background(), client, PageOptions{All: true, Limit: 10, Offset: 5}, func(playlist zSpotify.SimplePlaylist) error {
+		ids = append(ids, playlist.ID.String())
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 25)
+	assert.Equal(t, "playlist-005", ids[0])
+	assert.Equal(t, 3, fake.Count(http.MethodGet, "/v1/me/playlists"))
+}
+
+func TestGetPlaylistsSinglePage(t *testing.T) {
+	client := newFakeClient(startFake(t, pagingFixtures(123, 0)), "fake-user")
+
+	page, err := GetPlaylists(context.Background(), client, PageOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, page.Playlists, 20)
+
+	page, err = GetPlaylists(context.Background(), client, PageOptions{Limit: 5, Offset: 120})
+	assert.NoError(t, err)
+	assert.Len(t, page.Playlists, 3)
+	assert.Equal(t, "playlist-120", page.Playlists[0].ID.String())
+
+	_, err = GetPlaylists(context.Background(), client, PageOptions{Limit: MaxPlaylistsLimit + 1})
+	assert.Error(t, err)
+}
+
+func TestEachPlaylistItemAll(t *testing.T) {
+	fake := startFake(t, pagingFixtures(0, 250))
+	client := newFakeClient(fake, "fake-user")
+
+	var names []string
+	err := EachPlaylistItem(context.Background(), client, "road-trip", PageOptions{All: true}, func(item zSpotify.PlaylistItem) error {
+		names = append(names, item.Track.Track.Name)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, names, 250)
+	assert.Equal(t, "Track 249", names[249])
+	assert.Equal(t, 3, fake.Count(http.MethodGet, "/v1/playlists/road-trip/tracks"))
+}
+
+func TestEachPlaylistItemStopsOnError(t *testing.T) {
+	fake := startFake(t, pagingFixtures(0, 250))
+	client := newFakeClient(fake, "fake-user")
+
+	stop := errors.New("stop")
+	count := 0
+	err := EachPlaylistItem(context.Background(), client, "road-trip", PageOptions{All: true}, func(item zSpotify.PlaylistItem) error {
+		count++
+		if count == 150 {
+			return stop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 2, fake.Count(http.MethodGet, "/v1/playlists/road-trip/tracks"))
+}
+
+func TestGetNamesFromPlaylistTracks(t *testing.T) {
+	client := newFakeClient(startFake(t, pagingFixtures(0, 205)), "fake-user")
+	sm := newTestManager(t, NewMemorySessionStore(), nil)
+
+	names, err := sm.GetNamesFromPlaylistTracks(&Session{Client: client}, "road-trip")
+	assert.NoError(t, err)
+	assert.Len(t, names, 205)
+}
+
+func TestJSONArrayWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	array := NewJSONArrayWriter(buf)
+	assert.False(t, array.Started())
+	assert.NoError(t, array.Close())
+	assert.JSONEq(t, "[]", buf.String())
+
+	buf.Reset()
+	array = NewJSONArrayWriter(buf)
+	client := newFakeClient(startFake(t, pagingFixtures(75, 0)), "fake-user")
+	err := EachPlaylist(context.Background(), client, PageOptions{All: true}, func(playlist zSpotify.SimplePlaylist) error {
+		return array.Write(playlist)
+	})
+	assert.NoError(t, err)
+	assert.True(t, array.Started())
+	assert.NoError(t, array.Close())
+
+	var decoded []zSpotify.SimplePlaylist
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Len(t, decoded, 75)
+	assert.Equal(t, "Playlist 74", decoded[74].Name)
+}
@@ -0,0 +1,166 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// RepeatMode is the repeat state of the player.
+type RepeatMode string
+
+const (
+	RepeatOff     RepeatMode = "off"
+	RepeatTrack   RepeatMode = "track"
+	RepeatContext RepeatMode = "context"
+)
+
+var (
+	ErrorMissingScope         = errors.New("the session is missing a permission needed for this request, log in again")
+	ErrorNoActiveDevice       = errors.New("no active Spotify device, start playback on a device or transfer to one")
+	ErrorPremiumRequired      = errors.New("controlling playback needs Spotify Premium")
+	ErrorInvalidPlayerRequest = errors.New("invalid player request")
+)
+
+// PlayRequest describes what to play. Without a context or URIs the current playback resumes.
+type PlayRequest struct {
+	DeviceID   string   `json:"device_id,omitempty"`
+	ContextURI string   `json:"context_uri,omitempty"` // Album, artist or playlist URI
+	URIs       []string `json:"uris,omitempty"`        // Track URIs
+	PositionMs int      `json:"position_ms,omitempty"`
+}
+
+// Player controls playback on the devices of a session.
+type Player struct {
+	client *zSpotify.Client
+}
+
+// NewPlayer returns a player using the session's client.
+func NewPlayer(client *zSpotify.Client) *Player {
+	return &Player{client: client}
+}
+
+// Play starts the request's context or tracks, or resumes playback.
+func (p *Player) Play(ctx context.Context, request PlayRequest) error {
+	if request.PositionMs < 0 {
+		return invalidPlayerRequest("position can not be negative")
+	}
+
+	options := playOptions(request.DeviceID)
+	if options == nil {
+		options = &zSpotify.PlayOptions{}
+	}
+	if request.ContextURI != "" {
+		uri := zSpotify.URI(request.ContextURI)
+		options.PlaybackContext = &uri
+	}
+	for _, uri := range request.URIs {
+		options.URIs = append(options.URIs, zSpotify.URI(uri))
+	}
+	options.PositionMs = zSpotify.Numeric(request.PositionMs)
+
+	return TypedError(p.client.PlayOpt(ctx, options))
+}
+
+// Pause pauses playback.
+func (p *Player) Pause(ctx context.Context, deviceID string) error {
+	return TypedError(p.client.PauseOpt(ctx, playOptions(deviceID)))
+}
+
+// Next skips to the next track.
+func (p *Player) Next(ctx context.Context, deviceID string) error {
+	return TypedError(p.client.NextOpt(ctx, playOptions(deviceID)))
+}
+
+// Previous skips to the previous track.
+func (p *Player) Previous(ctx context.Context, deviceID string) error {
+	return TypedError(p.client.PreviousOpt(ctx, playOptions(deviceID)))
+}
+
+// Seek moves to the position in the current track.
+func (p *Player) Seek(ctx context.Context, positionMs int, deviceID string) error {
+	if positionMs < 0 {
+		return invalidPlayerRequest("position can not be negative")
+	}
+	return TypedError(p.client.SeekOpt(ctx, positionMs, playOptions(deviceID)))
+}
+
+// Volume sets the volume in percent.
+func (p *Player) Volume(ctx context.Context, percent int, deviceID string) error {
+	if percent < 0 || percent > 100 {
+		return invalidPlayerRequest("volume must be between 0 and 100")
+	}
+	return TypedError(p.client.VolumeOpt(ctx, percent, playOptions(deviceID)))
+}
+
+// Shuffle turns shuffle on or off.
+func (p *Player) Shuffle(ctx context.Context, shuffle bool, deviceID string) error {
+	return TypedError(p.client.ShuffleOpt(ctx, shuffle, playOptions(deviceID)))
+}
+
+// Repeat sets the repeat mode.
+func (p *Player) Repeat(ctx context.Context, mode RepeatMode, deviceID string) error {
+	switch mode {
+	case RepeatOff, RepeatTrack, RepeatContext:
+	default:
+		return invalidPlayerRequest("repeat must be one of off, track or context")
+	}
+	return TypedError(p.client.RepeatOpt(ctx, string(mode), playOptions(deviceID)))
+}
+
+// Queue adds a track to the end of the queue. The track is given as a track ID or URI.
+func (p *Player) Queue(ctx context.Context, track string, deviceID string) error {
+	id, err := trackID(track)
+	if err != nil {
+		return err
+	}
+	return TypedError(p.client.QueueSongOpt(ctx, id, playOptions(deviceID)))
+}
+
+// Devices lists the devices playback can be controlled on.
+func (p *Player) Devices(ctx context.Context) ([]zSpotify.PlayerDevice, error) {
+	devices, err := p.client.PlayerDevices(ctx)
+	if err != nil {
+		return nil, TypedError(err)
+	}
+	return devices, nil
+}
+
+// Transfer moves playback to the device, starting it when play is set.
+func (p *Player) Transfer(ctx context.Context, deviceID string, play bool) error {
+	if deviceID == "" {
+		return invalidPlayerRequest("a device ID is required")
+	}
+	return TypedError(p.client.TransferPlayback(ctx, zSpotify.ID(deviceID), play))
+}
+
+// invalidPlayerRequest returns an ErrorInvalidPlayerRequest with the reason.
+func invalidPlayerRequest(reason string) error {
+	return fmt.Errorf("%w: %s", ErrorInvalidPlayerRequest, reason)
+}
+
+// playOptions targets the device, or the active device when no ID is given.
+func playOptions(deviceID string) *zSpotify.PlayOptions {
+	if deviceID == "" {
+		return nil
+	}
+
+	id := zSpotify.ID(deviceID)
+	return &zSpotify.PlayOptions{DeviceID: &id}
+}
+
+// trackID returns the ID of a track given as an ID or a spotify:track: URI.
+func trackID(track string) (zSpotify.ID, error) {
+	track = strings.TrimSpace(track)
+
+	if id, ok := strings.CutPrefix(track, "spotify:track:"); ok && id != "" {
+		return zSpotify.ID(id), nil
+	}
+	if track == "" || strings.Contains(track, ":") {
+		return "", invalidPlayerRequest("queue accepts Spotify track URIs or track IDs")
+	}
+	return zSpotify.ID(track), nil
+}
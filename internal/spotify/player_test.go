@@ -0,0 +1,103 @@
+package spotify
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	zSpotify "github.com/zmb3/spotify/v2"
+)
+
+// newFakePlayer returns a player of the premium user of the default fake, which is playing.
+func newFakePlayer(t *testing.T) (*FakeServer, *Player) {
+	fake := startFake(t, nil)
+	return fake, NewPlayer(newFakeClient(fake, "fake-user"))
+}
+
+func TestPlayerCommands(t *testing.T) {
+	fake, player := newFakePlayer(t)
+	ctx := context.Background()
+
+	assert.NoError(t, player.Play(ctx, PlayRequest{ContextURI: "spotify:playlist:abc", PositionMs: 1000}))
+	assert.NoError(t, player.Pause(ctx, "device1"))
+	assert.NoError(t, player.Next(ctx, ""))
+	assert.NoError(t, player.Previous(ctx, ""))
+	assert.NoError(t, player.Seek(ctx, 5000, ""))
+	assert.NoError(t, player.Volume(ctx, 30, ""))
+	assert.NoError(t, player.Shuffle(ctx, true, ""))
+	assert.NoError(t, player.Repeat(ctx, RepeatTrack, ""))
+	assert.NoError(t, player.Queue(ctx, "spotify:track:track1", ""))
+	assert.NoError(t, player.Transfer(ctx, "device2", true))
+
+	devices, err := player.Devices(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, devices, 1) {
+		assert.Equal(t, "Fake Speaker", devices[0].Name)
+	}
+
+	got := fake.Requests()
+	assert.Len(t, got, 11)
+	assert.Equal(t, "/v1/me/player/play", got[0].Path)
+	assert.Contains(t, got[0].Body, `"context_uri":"spotify:playlist:abc"`)
+	assert.Contains(t, got[0].Body, `"position_ms":1000`)
+	assert.Equal(t, "device1", got[1].Query.Get("device_id"))
+	assert.Equal(t, "/v1/me/player/next", got[2].Path)
+	assert.Equal(t, "/v1/me/player/previous", got[3].Path)
+	assert.Equal(t, "5000", got[4].Query.Get("position_ms"))
+	assert.Equal(t, "30", got[5].Query.Get("volume_percent"))
+	assert.Equal(t, "true", got[6].Query.Get("state"))
+	assert.Equal(t, "track", got[7].Query.Get("state"))
+	assert.Equal(t, "spotify:track:track1", got[8].Query.Get("uri"))
+	assert.Equal(t, http.MethodPut, got[9].Method)
+	assert.Contains(t, got[9].Body, `"device2"`)
+}
+
+func TestPlayerValidation(t *testing.T) {
+	fake, player := newFakePlayer(t)
+	ctx := context.Background()
+
+	assert.ErrorIs(t, player.Volume(ctx, 101, ""), ErrorInvalidPlayerRequest)
+	assert.ErrorIs(t, player.Seek(ctx, -1, ""), ErrorInvalidPlayerRequest)
+	assert.ErrorIs(t, player.Repeat(ctx, "forever", ""), ErrorInvalidPlayerRequest)
+	assert.ErrorIs(t, player.Queue(ctx, "spotify:album:abc", ""), ErrorInvalidPlayerRequest)
+	assert.ErrorIs(t, player.Transfer(ctx, "", false), ErrorInvalidPlayerRequest)
+	assert.Empty(t, fake.Requests())
+
+	assert.NoError(t, player.Queue(ctx, "track1", ""))
+	assert.Equal(t, "spotify:track:track1", fake.Requests()[0].Query.Get("uri"))
+}
+
+func TestPlayerErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		message string
+		want    error
+	}{
+		{http.StatusNotFound, "Player command failed: No active device found", ErrorNoActiveDevice},
+		{http.StatusForbidden, "Insufficient client scope", ErrorMissingScope},
+		{http.StatusUnauthorized, "Permissions missing", ErrorMissingScope},
+		{http.StatusForbidden, "Player command failed: Premium required", ErrorPremiumRequired},
+		{http.StatusUnauthorized, "The access token expired", ErrorUnauthorized},
+		{http.StatusNotFound, "Non existing id", ErrorNotFound},
+		{http.StatusInternalServerError, "Server error", ErrorUpstream},
+	}
+
+	fake, player := newFakePlayer(t)
+	for _, test := range tests {
+		fake.Fail(FakeFailure{Status: test.status, Message: test.message}, FakeFailure{Status: test.status, Message: test.message})
+
+		err := player.Pause(context.Background(), "")
+		assert.ErrorIs(t, err, test.want, test.message)
+
+		_, err = player.Devices(context.Background())
+		assert.ErrorIs(t, err, test.want, test.message)
+	}
+
+	// Other errors are returned as they are
+	fake.Fail(FakeFailure{Status: http.StatusBadRequest, Message: "Bad request"})
+	err := player.Next(context.Background(), "")
+	var apiError zSpotify.Error
+	assert.ErrorAs(t, err, &apiError)
+	assert.NotErrorIs(t, err, ErrorNoActiveDevice)
+}
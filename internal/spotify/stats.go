@@ -0,0 +1,239 @@
+package spotify
+
+import (
+	"errors"
+	"rory-pearson/database"
+	"sort"
+	"time"
+)
+
+// StatsPeriod is the window of listening history the stats are computed over.
+type StatsPeriod string
+
+const (
+	StatsWeek  StatsPeriod = "week"  // The last 7 days
+	StatsMonth StatsPeriod = "month" // The last 30 days
+	StatsAll   StatsPeriod = "all"   // Every recorded play
+)
+
+// DefaultStatsLimit is the number of top tracks, artists and genres returned when no limit is given.
+const DefaultStatsLimit = 10
+
+var ErrorInvalidStatsPeriod = errors.New("period must be week, month or all")
+
+// ParseStatsPeriod validates the period, defaulting to every recorded play.
+func ParseStatsPeriod(period string) (StatsPeriod, error) {
+	switch StatsPeriod(period) {
+	case "":
+		return StatsAll, nil
+	case StatsWeek, StatsMonth, StatsAll:
+		return StatsPeriod(period), nil
+	default:
+		return "", ErrorInvalidStatsPeriod
+	}
+}
+
+// Since returns the start of the period ending now, or a zero time for every play.
+func (p StatsPeriod) Since(now time.Time) time.Time {
+	switch p {
+	case StatsWeek:
+		return now.AddDate(0, 0, -7)
+	case StatsMonth:
+		return now.AddDate(0, 0, -30)
+	default:
+		return time.Time{}
+	}
+}
+
+// TopTrack is a track ranked by how often it was played.
+type TopTrack struct {
+	TrackID string   `json:"track_id"`
+	Title   string   `json:"title"`
+	Artists []string `json:"artists"`
+	Plays   int      `json:"plays"`
+	Minutes int      `json:"minutes"`
+}
+
+// TopItem is an artist or genre ranked by how often it was played.
+type TopItem struct {
+	Name    string `json:"name"`
+	Plays   int    `json:"plays"`
+	Minutes int    `json:"minutes"`
+}
+
+// Streaks are runs of consecutive days with at least one play.
+type Streaks struct {
+	Current int `json:"current"` // Days in the run ending today, or yesterday when nothing is played yet today
+	Longest int `json:"longest"`
+}
+
+// Stats summarise the listening history of a user over a period.
+type Stats struct {
+	Period     StatsPeriod `json:"period"`
+	From       *time.Time  `json:"from,omitempty"` // Start of the period, omitted for every play
+	To         time.Time   `json:"to"`
+	Plays      int         `json:"plays"`
+	Minutes    int         `json:"minutes"` // Track durations added up, Spotify does not report partial plays
+	TopTracks  []TopTrack  `json:"top_tracks"`
+	TopArtists []TopItem   `json:"top_artists"`
+	TopGenres  []TopItem   `json:"top_genres"`
+	Heatmap    [7][24]int  `json:"heatmap"` // Plays by weekday, Sunday first, and hour of the day
+	Streaks    Streaks     `json:"streaks"` // Computed over every play, regardless of the period
+}
+
+// ComputeStats computes the stats of the plays in the period ending now. Days and hours are
+// taken in the location. At most limit top tracks, artists and genres are returned.
+func ComputeStats(plays []database.Play, period StatsPeriod, now time.Time, location *time.Location, limit int) Stats {
+	stats := Stats{Period: period, To: now}
+
+	since := period.Since(now)
+	if !since.IsZero() {
+		stats.From = &since
+	}
+
+	tracks := make(map[string]*TopTrack)
+	artists := make(map[string]*TopItem)
+	genres := make(map[string]*TopItem)
+	var milliseconds int
+
+	for _, play := range plays {
+		if play.PlayedAt.Before(since) || play.PlayedAt.After(now) {
+			continue
+		}
+
+		stats.Plays++
+		milliseconds += play.DurationMs
+
+		local := play.PlayedAt.In(location)
+		stats.Heatmap[local.Weekday()][local.Hour()]++
+
+		track := tracks[play.TrackID]
+		if track == nil {
+			track = &TopTrack{TrackID: play.TrackID, Title: play.Title, Artists: play.Artists}
+			tracks[play.TrackID] = track
+		}
+		track.Plays++
+		track.Minutes += play.DurationMs
+
+		for _, artist := range play.Artists {
+			countItem(artists, artist, play.DurationMs)
+		}
+		for _, genre := range play.Genres {
+			countItem(genres, genre, play.DurationMs)
+		}
+	}
+
+	stats.Minutes = milliseconds / int(time.Minute/time.Millisecond)
+	stats.TopTracks = topTracks(tracks, limit)
+	stats.TopArtists = topItems(artists, limit)
+	stats.TopGenres = topItems(genres, limit)
+	stats.Streaks = listeningStreaks(plays, now, location)
+
+	return stats
+}
+
+// countItem adds a play to the item. Minutes are summed as milliseconds until the items are ranked.
+func countItem(items map[string]*TopItem, name string, durationMs int) {
+	item := items[name]
+	if item == nil {
+		item = &TopItem{Name: name}
+		items[name] = item
+	}
+	item.Plays++
+	item.Minutes += durationMs
+}
+
+// topTracks ranks the tracks by plays, then listening time, then title.
+func topTracks(tracks map[string]*TopTrack, limit int) []TopTrack {
+	ranked := make([]TopTrack, 0, len(tracks))
+	for _, track := range tracks {
+		ranked = append(ranked, *track)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Plays != b.Plays {
+			return a.Plays > b.Plays
+		}
+		if a.Minutes != b.Minutes {
+			return a.Minutes > b.Minutes
+		}
+		return a.Title < b.Title
+	})
+
+	ranked = ranked[:min(limit, len(ranked))]
+	for i := range ranked {
+		ranked[i].Minutes /= int(time.Minute / time.Millisecond)
+	}
+	return ranked
+}
+
+// topItems ranks the items by plays, then listening time, then name.
+func topItems(items map[string]*TopItem, limit int) []TopItem {
+	ranked := make([]TopItem, 0, len(items))
+	for _, item := range items {
+		ranked = append(ranked, *item)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.Plays != b.Plays {
+			return a.Plays > b.Plays
+		}
+		if a.Minutes != b.Minutes {
+			return a.Minutes > b.Minutes
+		}
+		return a.Name < b.Name
+	})
+
+	ranked = ranked[:min(limit, len(ranked))]
+	for i := range ranked {
+		ranked[i].Minutes /= int(time.Minute / time.Millisecond)
+	}
+	return ranked
+}
+
+// listeningStreaks finds the current and longest runs of consecutive days with plays.
+func listeningStreaks(plays []database.Play, now time.Time, location *time.Location) Streaks {
+	days := make(map[time.Time]bool)
+	for _, play := range plays {
+		if !play.PlayedAt.After(now) {
+			days[startOfDay(play.PlayedAt, location)] = true
+		}
+	}
+
+	sorted := make([]time.Time, 0, len(days))
+	for day := range days {
+		sorted = append(sorted, day)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	var streaks Streaks
+	run := 0
+	for i, day := range sorted {
+		// AddDate keeps days consecutive across daylight saving changes
+		if i > 0 && sorted[i-1].AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run = 1
+		}
+		streaks.Longest = max(streaks.Longest, run)
+	}
+
+	// The current streak is not broken until a whole day passes without a play
+	today := startOfDay(now, location)
+	if len(sorted) > 0 {
+		last := sorted[len(sorted)-1]
+		if last.Equal(today) || last.AddDate(0, 0, 1).Equal(today) {
+			streaks.Current = run
+		}
+	}
+
+	return streaks
+}
+
+// startOfDay returns midnight of the day of t in the location.
+func startOfDay(t time.Time, location *time.Location) time.Time {
+	local := t.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+}
@@ -0,0 +1,104 @@
+package spotify
+
+import (
+	"rory-pearson/database"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func statsPlay(id string, artist string, genre string, playedAt time.Time) database.Play {
+	return database.Play{
+		TrackID:    id,
+		Title:      "Track " + id,
+		Artists:    []string{artist},
+		Genres:     []string{genre},
+		DurationMs: 3 * 60000,
+		PlayedAt:   playedAt,
+	}
+}
+
+func TestParseStatsPeriod(t *testing.T) {
+	period, err := ParseStatsPeriod("")
+	assert.NoError(t, err)
+	assert.Equal(t, StatsAll, period)
+
+	period, err = ParseStatsPeriod("week")
+	assert.NoError(t, err)
+	assert.Equal(t, StatsWeek, period)
+
+	_, err = ParseStatsPeriod("year")
+	assert.ErrorIs(t, err, ErrorInvalidStatsPeriod)
+}
+
+func TestComputeStats(t *testing.T) {
+	now := time.Date(2024, 5, 20, 18, 0, 0, 0, time.UTC)
+	plays := []database.Play{
+		statsPlay("old", "Old Band", "jazz", now.AddDate(0, 0, -20)),
+		statsPlay("a", "Band", "rock", now.Add(-3*time.Hour)),
+		statsPlay("a", "Band", "rock", now.Add(-2*time.Hour)),
+		statsPlay("b", "Band", "rock", now.Add(-time.Hour)),
+		statsPlay("c", "Other", "pop", now.Add(-time.Hour)),
+	}
+
+	stats := ComputeStats(plays, StatsWeek, now, time.UTC, 2)
+	assert.Equal(t, 4, stats.Plays)
+	assert.Equal(t, 12, stats.Minutes)
+	if assert.NotNil(t, stats.From) {
+		assert.True(t, stats.From.Equal(now.AddDate(0, 0, -7)))
+	}
+
+	if assert.Len(t, stats.TopTracks, 2) {
+		assert.Equal(t, "a", stats.TopTracks[0].TrackID)
+		assert.Equal(t, 2, stats.TopTracks[0].Plays)
+		assert.Equal(t, 6, stats.TopTracks[0].Minutes)
+		assert.Equal(t, "b", stats.TopTracks[1].TrackID)
+	}
+	assert.Equal(t, []TopItem{{Name: "Band", Plays: 3, Minutes: 9}, {Name: "Other", Plays: 1, Minutes: 3}}, stats.TopArtists)
+	assert.Equal(t, "rock", stats.TopGenres[0].Name)
+
+	// Monday the 20th, 15:00 to 17:00
+	assert.Equal(t, 1, stats.Heatmap[time.Monday][15])
+	assert.Equal(t, 2, stats.Heatmap[time.Monday][17])
+
+	// Every play is counted for all time
+	stats = ComputeStats(plays, StatsAll, now, time.UTC, 10)
+	assert.Equal(t, 5, stats.Plays)
+	assert.Nil(t, stats.From)
+	assert.Len(t, stats.TopArtists, 3)
+}
+
+func TestComputeStatsLocation(t *testing.T) {
+	location := time.FixedZone("UTC+10", 10*60*60)
+	now := time.Date(2024, 5, 20, 18, 0, 0, 0, time.UTC)
+
+	stats := ComputeStats([]database.Play{statsPlay("a", "Band", "rock", now.Add(-time.Hour))}, StatsAll, now, location, 10)
+	assert.Equal(t, 1, stats.Heatmap[time.Tuesday][3])
+}
+
+func TestListeningStreaks(t *testing.T) {
+	now := time.Date(2024, 5, 20, 9, 0, 0, 0, time.UTC)
+	day := func(offset int) time.Time { return now.AddDate(0, 0, offset).Add(-time.Hour) }
+
+	plays := []database.Play{
+		statsPlay("a", "Band", "rock", day(-10)),
+		statsPlay("a", "Band", "rock", day(-9)),
+		statsPlay("a", "Band", "rock", day(-8)),
+		statsPlay("a", "Band", "rock", day(-7)),
+		statsPlay("a", "Band", "rock", day(-3)),
+		statsPlay("a", "Band", "rock", day(-2)),
+		statsPlay("a", "Band", "rock", day(-2).Add(30*time.Minute)),
+		statsPlay("a", "Band", "rock", day(-1)),
+	}
+
+	// Nothing played today yet, the run ending yesterday is still current
+	streaks := listeningStreaks(plays, now, time.UTC)
+	assert.Equal(t, Streaks{Current: 3, Longest: 4}, streaks)
+
+	// A day without plays ends it
+	streaks = listeningStreaks(plays, now.AddDate(0, 0, 1), time.UTC)
+	assert.Equal(t, Streaks{Current: 0, Longest: 4}, streaks)
+
+	assert.Equal(t, Streaks{}, listeningStreaks(nil, now, time.UTC))
+}
@@ -0,0 +1,262 @@
+package spotify
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// sessionKeySize is the AES-256 key size required for the session store.
+	sessionKeySize = 32
+	// sessionFileExtension is the extension of encrypted session files.
+	sessionFileExtension = ".session"
+)
+
+var (
+	ErrorSessionNotFound   = errors.New("session not found")
+	ErrorInvalidSessionKey = errors.New("session key must be 32 bytes, encoded as hex or base64")
+	ErrorCorruptSession    = errors.New("session could not be decrypted")
+)
+
+// SessionRecord is the part of a session that is persisted. The client is rebuilt from the token.
+type SessionRecord struct {
+	State     string        `json:"state"`
+	Token     *oauth2.Token `json:"token,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// SessionStore persists Spotify sessions so they survive restarts.
+type SessionStore interface {
+	// Save creates or replaces the record stored for its state.
+	Save(record SessionRecord) error
+	// Load returns the record stored for the state, or ErrorSessionNotFound.
+	Load(state string) (*SessionRecord, error)
+	// Delete removes the record stored for the state. Deleting a missing record is not an error.
+	Delete(state string) error
+	// List returns every stored record.
+	List() ([]SessionRecord, error)
+}
+
+// ParseSessionKey decodes a 32 byte key given as hex or base64.
+func ParseSessionKey(key string) ([]byte, error) {
+	key = strings.TrimSpace(key)
+
+	if decoded, err := hex.DecodeString(key); err == nil && len(decoded) == sessionKeySize {
+		return decoded, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(key); err == nil && len(decoded) == sessionKeySize {
+		return decoded, nil
+	}
+
+	return nil, ErrorInvalidSessionKey
+}
+
+// MemorySessionStore keeps sessions in memory only. It is used in tests.
+type MemorySessionStore struct {
+	mu      sync.Mutex
+	records map[string]SessionRecord
+}
+
+// NewMemorySessionStore returns an empty in-memory store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{records: make(map[string]SessionRecord)}
+}
+
+func (m *MemorySessionStore) Save(record SessionRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.records[record.State] = record
+	return nil
+}
+
+func (m *MemorySessionStore) Load(state string) (*SessionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	record, ok := m.records[state]
+	if !ok {
+		return nil, ErrorSessionNotFound
+	}
+	return &record, nil
+}
+
+func (m *MemorySessionStore) Delete(state string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, state)
+	return nil
+}
+
+func (m *MemorySessionStore) List() ([]SessionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	records := make([]SessionRecord, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// FileSessionStore stores every session in its own file, encrypted with AES-GCM.
+// File names are hashes of the state, so states never appear on disk.
+type FileSessionStore struct {
+	mu        sync.Mutex
+	directory string
+	aead      cipher.AEAD
+}
+
+// NewFileSessionStore returns a store writing into the directory, which is created if needed.
+func NewFileSessionStore(directory string, key []byte) (*FileSessionStore, error) {
+	if len(key) != sessionKeySize {
+		return nil, ErrorInvalidSessionKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create session cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("could not create session cipher: %v", err)
+	}
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, fmt.Errorf("could not create session directory: %v", err)
+	}
+
+	return &FileSessionStore{
+		directory: directory,
+		aead:      aead,
+	}, nil
+}
+
+func (f *FileSessionStore) Save(record SessionRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not encode session: %v", err)
+	}
+
+	name := sessionFileName(record.State)
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("could not generate nonce: %v", err)
+	}
+
+	// The file name is authenticated so a record can not be moved to another session
+	sealed := f.aead.Seal(nonce, nonce, data, []byte(name))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Write to a temporary file first so a crash never leaves a partial session behind
+	temp, err := os.CreateTemp(f.directory, "*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create session file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(sealed); err != nil {
+		temp.Close()
+		return fmt.Errorf("could not write session file: %v", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("could not write session file: %v", err)
+	}
+
+	return os.Rename(temp.Name(), filepath.Join(f.directory, name))
+}
+
+func (f *FileSessionStore) Load(state string) (*SessionRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.read(sessionFileName(state))
+}
+
+func (f *FileSessionStore) Delete(state string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	err := os.Remove(filepath.Join(f.directory, sessionFileName(state)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List returns every record that can be decrypted. Files written with another key are skipped.
+func (f *FileSessionStore) List() ([]SessionRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := os.ReadDir(f.directory)
+	if err != nil {
+		return nil, fmt.Errorf("could not read session directory: %v", err)
+	}
+
+	var records []SessionRecord
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != sessionFileExtension {
+			continue
+		}
+
+		record, err := f.read(entry.Name())
+		if err != nil {
+			continue
+		}
+		records = append(records, *record)
+	}
+
+	return records, nil
+}
+
+// read decrypts a session file. The caller must hold f.mu.
+func (f *FileSessionStore) read(name string) (*SessionRecord, error) {
+	sealed, err := os.ReadFile(filepath.Join(f.directory, name))
+	if os.IsNotExist(err) {
+		return nil, ErrorSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read session file: %v", err)
+	}
+
+	nonceSize := f.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrorCorruptSession
+	}
+
+	data, err := f.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(name))
+	if err != nil {
+		return nil, ErrorCorruptSession
+	}
+
+	var record SessionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, ErrorCorruptSession
+	}
+	return &record, nil
+}
+
+// sessionFileName returns the file name a session is stored under.
+func sessionFileName(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:]) + sessionFileExtension
+}
@@ -0,0 +1,132 @@
+package spotify
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+)
+
+// testKey returns a fixed 32 byte session key.
+func testKey(b byte) []byte {
+	key := make([]byte, sessionKeySize)
+	for i := range key {
+		key[i] = b
+	}
+	return key
+}
+
+func testRecord(state string) SessionRecord {
+	now := time.Now().UTC().Truncate(time.Second)
+	return SessionRecord{
+		State: state,
+		Token: &oauth2.Token{
+			AccessToken:  "access-" + state,
+			RefreshToken: "refresh-" + state,
+			TokenType:    "Bearer",
+			Expiry:       now.Add(time.Hour),
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+func TestParseSessionKey(t *testing.T) {
+	key := testKey(7)
+
+	parsed, err := ParseSessionKey(hex.EncodeToString(key))
+	assert.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	parsed, err = ParseSessionKey(base64.StdEncoding.EncodeToString(key) + "\n")
+	assert.NoError(t, err)
+	assert.Equal(t, key, parsed)
+
+	_, err = ParseSessionKey("too-short")
+	assert.ErrorIs(t, err, ErrorInvalidSessionKey)
+
+	_, err = ParseSessionKey(hex.EncodeToString(key[:16]))
+	assert.ErrorIs(t, err, ErrorInvalidSessionKey)
+}
+
+func TestFileSessionStore(t *testing.T) {
+	directory := t.TempDir()
+	store, err := NewFileSessionStore(directory, testKey(1))
+	assert.NoError(t, err)
+
+	record := testRecord("state-one")
+	assert.NoError(t, store.Save(record))
+
+	loaded, err := store.Load("state-one")
+	assert.NoError(t, err)
+	assert.Equal(t, record.Token.AccessToken, loaded.Token.AccessToken)
+	assert.Equal(t, record.Token.RefreshToken, loaded.Token.RefreshToken)
+	assert.True(t, record.CreatedAt.Equal(loaded.CreatedAt))
+
+	// Neither the state nor the token may appear on disk
+	entries, err := os.ReadDir(directory)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.NotContains(t, entries[0].Name(), "state-one")
+
+	data, err := os.ReadFile(filepath.Join(directory, entries[0].Name()))
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "access-state-one"))
+
+	_, err = store.Load("missing")
+	assert.ErrorIs(t, err, ErrorSessionNotFound)
+
+	assert.NoError(t, store.Save(testRecord("state-two")))
+	records, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	assert.NoError(t, store.Delete("state-one"))
+	assert.NoError(t, store.Delete("state-one"))
+	_, err = store.Load("state-one")
+	assert.ErrorIs(t, err, ErrorSessionNotFound)
+}
+
+func TestFileSessionStoreWrongKey(t *testing.T) {
+	directory := t.TempDir()
+	store, err := NewFileSessionStore(directory, testKey(1))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(testRecord("state")))
+
+	other, err := NewFileSessionStore(directory, testKey(2))
+	assert.NoError(t, err)
+
+	_, err = other.Load("state")
+	assert.ErrorIs(t, err, ErrorCorruptSession)
+
+	// Records that can not be decrypted are skipped
+	records, err := other.List()
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestFileSessionStoreMovedRecord(t *testing.T) {
+	directory := t.TempDir()
+	store, err := NewFileSessionStore(directory, testKey(1))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save(testRecord("victim")))
+
+	// A record copied to another session's file name must not be accepted
+	data, err := os.ReadFile(filepath.Join(directory, sessionFileName("victim")))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(directory, sessionFileName("attacker")), data, 0600))
+
+	_, err = store.Load("attacker")
+	assert.ErrorIs(t, err, ErrorCorruptSession)
+}
+
+func TestNewFileSessionStoreInvalidKey(t *testing.T) {
+	_, err := NewFileSessionStore(t.TempDir(), []byte("short"))
+	assert.ErrorIs(t, err, ErrorInvalidSessionKey)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SweepReport describes what a single sweep reclaimed.
+type SweepReport struct {
+	Files  int   `json:"files"`  // Files and directories removed
+	Bytes  int64 `json:"bytes"`  // Total size of everything removed
+	Tokens int   `json:"tokens"` // Expired download tokens removed
+}
+
+// Janitor periodically removes stale entries from storage directories.
+// Entries referenced by a live download token are never removed.
+type Janitor struct {
+	Directories []string
+	MaxAge      time.Duration
+
+	tokens *TokenRegistry
+	now    func() time.Time
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewJanitor creates a janitor for the directories. Tokens may be nil.
+func NewJanitor(directories []string, maxAge time.Duration, tokens *TokenRegistry) *Janitor {
+	return &Janitor{
+		Directories: directories,
+		MaxAge:      maxAge,
+		tokens:      tokens,
+		now:         time.Now,
+	}
+}
+
+// Start sweeps on the interval in the background until Stop is called.
+// The result of every sweep is passed to onSweep.
+func (j *Janitor) Start(interval time.Duration, onSweep func(SweepReport, error)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.stop != nil {
+		return
+	}
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				report, err := j.Sweep()
+				if onSweep != nil {
+					onSweep(report, err)
+				}
+			}
+		}
+	}(j.stop, j.done)
+}
+
+// Stop stops the background sweeps and waits for a running sweep to finish.
+func (j *Janitor) Stop() {
+	j.mu.Lock()
+	stop, done := j.stop, j.done
+	j.stop, j.done = nil, nil
+	j.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Sweep removes the files of expired tokens and every top level entry of the
+// directories older than MaxAge. Missing directories are skipped.
+func (j *Janitor) Sweep() (SweepReport, error) {
+	var report SweepReport
+	var errs []error
+
+	if j.tokens != nil {
+		expired, paths := j.tokens.Expire()
+		report.Tokens = expired
+		for _, path := range paths {
+			j.remove(path, &report, &errs)
+		}
+	}
+
+	cutoff := j.now().Add(-j.MaxAge)
+	for _, dir := range j.Directories {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				continue
+			}
+
+			path := filepath.Join(dir, entry.Name())
+			if j.tokens != nil && j.tokens.Referenced(path) {
+				continue
+			}
+
+			j.remove(path, &report, &errs)
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// remove deletes a file or directory and adds it to the report.
+func (j *Janitor) remove(path string, report *SweepReport, errs *[]error) {
+	size, err := diskUsage(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		*errs = append(*errs, err)
+		return
+	}
+
+	report.Files++
+	report.Bytes += size
+}
+
+// diskUsage returns the total size of a file, or of all files in a directory.
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
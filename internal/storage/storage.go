@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"rory-pearson/pkg/log"
+	"rory-pearson/plugins"
+	"time"
+)
+
+const (
+	// DefaultSweepInterval is how often the janitor sweeps when no interval is configured.
+	DefaultSweepInterval = 10 * time.Minute
+	// DefaultMaxAge is how long untracked files are kept when no maximum age is configured.
+	DefaultMaxAge = time.Hour
+)
+
+type Config struct {
+	Log         log.Log
+	Directories []string      // Directories swept by the janitor
+	Interval    time.Duration // Time between sweeps
+	MaxAge      time.Duration // Files older than this, without a live download token, are removed
+}
+
+// Storage manages download tokens for generated files and cleans up files that are no longer needed.
+type Storage struct {
+	Log    log.Log
+	Tokens *TokenRegistry
+
+	janitor *Janitor
+}
+
+var instance *Storage
+
+// Initialize creates the storage singleton, starts the janitor and registers the storage_gc command.
+func Initialize(c Config) (*Storage, error) {
+	if instance != nil {
+		return instance, nil
+	}
+
+	if c.Interval == 0 {
+		c.Interval = DefaultSweepInterval
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = DefaultMaxAge
+	}
+
+	tokens := NewTokenRegistry()
+	instance = &Storage{
+		Log:     c.Log,
+		Tokens:  tokens,
+		janitor: NewJanitor(c.Directories, c.MaxAge, tokens),
+	}
+
+	instance.janitor.Start(c.Interval, func(report SweepReport, err error) {
+		if err != nil {
+			instance.Log.Error().Err(err).Msg("Storage sweep failed")
+			return
+		}
+		if report.Files > 0 || report.Tokens > 0 {
+			instance.logReport(report)
+		}
+	})
+
+	plugins.GetInstance().Commands.RegisterCommand(plugins.Command{
+		ID:          "storage_gc",
+		Name:        "Storage GC",
+		Description: "Remove expired downloads and stale temporary files, reporting the space reclaimed",
+		Function: func(args ...any) error {
+			report, err := instance.janitor.Sweep()
+			if err != nil {
+				return err
+			}
+
+			instance.logReport(report)
+			return nil
+		},
+	})
+
+	instance.Log.Info().Msg("Storage initialized")
+
+	return instance, nil
+}
+
+// GetInstance returns the storage singleton, or nil if it has not been initialized.
+func GetInstance() *Storage {
+	return instance
+}
+
+// Close stops the janitor.
+func (s *Storage) Close() {
+	s.janitor.Stop()
+}
+
+// logReport logs what a sweep reclaimed.
+func (s *Storage) logReport(report SweepReport) {
+	s.Log.Info().
+		Int("files", report.Files).
+		Int64("bytes", report.Bytes).
+		Int("expired_tokens", report.Tokens).
+		Msgf("Storage sweep reclaimed %d files (%d bytes)", report.Files, report.Bytes)
+}
@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a controllable time source for tokens and the janitor.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestRegistry() (*TokenRegistry, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	registry := NewTokenRegistry()
+	registry.now = clock.Now
+	return registry, clock
+}
+
+// writeFile creates a file with the given size and modification time.
+func writeFile(t *testing.T, path string, size int, modTime time.Time) {
+	t.Helper()
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, make([]byte, size), 0644))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestTokenMaxDownloads(t *testing.T) {
+	registry, _ := newTestRegistry()
+
+	token, err := registry.Issue("storage/file.zip", TokenOptions{MaxDownloads: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, token.MaxDownloads)
+
+	download, err := registry.Redeem(token.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "storage/file.zip", download.Path)
+	assert.Equal(t, 1, download.Remaining)
+	assert.False(t, download.Last)
+
+	download, err = registry.Redeem(token.ID)
+	assert.NoError(t, err)
+	assert.True(t, download.Last, "the second download uses the token up")
+
+	_, err = registry.Redeem(token.ID)
+	assert.ErrorIs(t, err, ErrorTokenNotFound)
+}
+
+func TestTokenOneTimeAndDefaults(t *testing.T) {
+	registry, clock := newTestRegistry()
+
+	token, err := registry.Issue("storage/file.zip", TokenOptions{OneTime: true, MaxDownloads: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, token.MaxDownloads, "one time tokens allow a single download")
+
+	token, err = registry.Issue("storage/file.zip", TokenOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, token.MaxDownloads, "downloads are single use by default")
+	assert.Equal(t, clock.now.Add(DefaultTokenTTL), token.ExpiresAt)
+
+	_, err = registry.Issue("storage/file.zip", TokenOptions{TTL: MaxTokenTTL + time.Second})
+	assert.ErrorIs(t, err, ErrorInvalidTTL)
+	_, err = registry.Issue("storage/file.zip", TokenOptions{MaxDownloads: -1})
+	assert.ErrorIs(t, err, ErrorInvalidMax)
+}
+
+func TestTokenExpiry(t *testing.T) {
+	registry, clock := newTestRegistry()
+
+	short, _ := registry.Issue("storage/a.zip", TokenOptions{TTL: time.Minute})
+	long, _ := registry.Issue("storage/a.zip", TokenOptions{TTL: time.Hour})
+	other, _ := registry.Issue("storage/b.zip", TokenOptions{TTL: time.Minute})
+
+	clock.now = clock.now.Add(2 * time.Minute)
+
+	_, err := registry.Redeem(short.ID)
+	assert.ErrorIs(t, err, ErrorTokenNotFound, "expired tokens can not be redeemed")
+
+	expired, paths := registry.Expire()
+	assert.Equal(t, 2, expired)
+	assert.Equal(t, []string{"storage/b.zip"}, paths, "files with a live token are kept")
+
+	download, err := registry.Redeem(long.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "storage/a.zip", download.Path)
+
+	_, err = registry.Redeem(other.ID)
+	assert.ErrorIs(t, err, ErrorTokenNotFound)
+}
+
+func TestJanitorSweep(t *testing.T) {
+	registry, clock := newTestRegistry()
+	dir := t.TempDir()
+	old := clock.now.Add(-2 * time.Hour)
+
+	writeFile(t, filepath.Join(dir, "convert", "old.zip"), 100, old)
+	writeFile(t, filepath.Join(dir, "convert", "new.zip"), 100, clock.now)
+	writeFile(t, filepath.Join(dir, "convert", "kept.zip"), 100, old)
+	writeFile(t, filepath.Join(dir, "temp", "bundle", "a.png"), 30, old)
+	writeFile(t, filepath.Join(dir, "temp", "bundle", "b.png"), 20, old)
+	assert.NoError(t, os.Chtimes(filepath.Join(dir, "temp", "bundle"), old, old))
+	writeFile(t, filepath.Join(dir, "tokens", "expired.zip"), 40, clock.now)
+
+	// Live tokens protect old files, expired ones release new files
+	_, err := registry.Issue(filepath.Join(dir, "convert", "kept.zip"), TokenOptions{})
+	assert.NoError(t, err)
+	_, err = registry.Issue(filepath.Join(dir, "tokens", "expired.zip"), TokenOptions{TTL: time.Second})
+	assert.NoError(t, err)
+
+	janitor := NewJanitor([]string{
+		filepath.Join(dir, "convert"),
+		filepath.Join(dir, "temp"),
+		filepath.Join(dir, "missing"),
+	}, time.Hour, registry)
+	janitor.now = clock.Now
+
+	clock.now = clock.now.Add(time.Minute)
+	report, err := janitor.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, SweepReport{Files: 3, Bytes: 190, Tokens: 1}, report)
+
+	assert.NoFileExists(t, filepath.Join(dir, "convert", "old.zip"))
+	assert.NoDirExists(t, filepath.Join(dir, "temp", "bundle"))
+	assert.NoFileExists(t, filepath.Join(dir, "tokens", "expired.zip"))
+	assert.FileExists(t, filepath.Join(dir, "convert", "new.zip"))
+	assert.FileExists(t, filepath.Join(dir, "convert", "kept.zip"))
+
+	// Nothing is left to reclaim
+	report, err = janitor.Sweep()
+	assert.NoError(t, err)
+	assert.Equal(t, SweepReport{}, report)
+}
+
+func TestJanitorStartStop(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "stale"), 10, time.Now().Add(-time.Hour))
+
+	janitor := NewJanitor([]string{dir}, time.Minute, nil)
+
+	swept := make(chan SweepReport, 1)
+	janitor.Start(10*time.Millisecond, func(report SweepReport, err error) {
+		assert.NoError(t, err)
+		select {
+		case swept <- report:
+		default:
+		}
+	})
+	defer janitor.Stop()
+
+	select {
+	case report := <-swept:
+		assert.Equal(t, 1, report.Files)
+	case <-time.After(time.Second):
+		t.Fatal("janitor did not sweep")
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"rory-pearson/pkg/util"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTokenTTL is how long a download token is valid when no TTL is requested.
+	DefaultTokenTTL = time.Hour
+	// MaxTokenTTL is the longest TTL a download token can be issued with.
+	MaxTokenTTL = 24 * time.Hour
+	// DefaultMaxDownloads is the number of downloads allowed when no maximum is requested.
+	// Downloads are single use unless the caller asks for more.
+	DefaultMaxDownloads = 1
+)
+
+var (
+	ErrorTokenNotFound = errors.New("download not found or expired")
+	ErrorInvalidTTL    = errors.New("ttl must be positive and at most 24 hours")
+	ErrorInvalidMax    = errors.New("max downloads must be positive")
+)
+
+// TokenOptions controls how long and how often a file can be downloaded.
+type TokenOptions struct {
+	TTL          time.Duration // Time until the token expires
+	MaxDownloads int           // Number of downloads before the token is used up
+	OneTime      bool          // Remove the token after the first download, same as a maximum of one
+}
+
+// Token grants access to a stored file until it expires or its downloads are used up.
+type Token struct {
+	ID           string    `json:"id"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	MaxDownloads int       `json:"max_downloads"`
+	Downloads    int       `json:"downloads"`
+
+	path string
+}
+
+// Download is a redeemed token. Last is set when the token has been used up,
+// in which case the file should be removed once it has been served.
+type Download struct {
+	Path      string
+	Remaining int
+	Last      bool
+}
+
+// TokenRegistry tracks the download tokens of stored files.
+type TokenRegistry struct {
+	mu     sync.Mutex
+	tokens map[string]*Token
+	now    func() time.Time
+}
+
+// NewTokenRegistry creates an empty token registry.
+func NewTokenRegistry() *TokenRegistry {
+	return &TokenRegistry{
+		tokens: make(map[string]*Token),
+		now:    time.Now,
+	}
+}
+
+// Validate checks the options. Zero values are valid and fall back to the defaults.
+func (o TokenOptions) Validate() error {
+	if o.TTL < 0 || o.TTL > MaxTokenTTL {
+		return ErrorInvalidTTL
+	}
+	if o.MaxDownloads < 0 {
+		return ErrorInvalidMax
+	}
+	return nil
+}
+
+// Issue creates a token for the file at path. Zero options fall back to the defaults.
+func (r *TokenRegistry) Issue(path string, options TokenOptions) (Token, error) {
+	if err := options.Validate(); err != nil {
+		return Token{}, err
+	}
+
+	if options.TTL == 0 {
+		options.TTL = DefaultTokenTTL
+	}
+	if options.OneTime {
+		options.MaxDownloads = 1
+	}
+	if options.MaxDownloads == 0 {
+		options.MaxDownloads = DefaultMaxDownloads
+	}
+
+	token := &Token{
+		ID:           util.GenerateUUIDv4(),
+		ExpiresAt:    r.now().Add(options.TTL),
+		MaxDownloads: options.MaxDownloads,
+		path:         filepath.Clean(path),
+	}
+
+	r.mu.Lock()
+	r.tokens[token.ID] = token
+	r.mu.Unlock()
+
+	return *token, nil
+}
+
+// Redeem counts a download against the token and returns the file to serve.
+// Used up tokens are removed from the registry.
+func (r *TokenRegistry) Redeem(id string) (Download, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[id]
+	if !ok || !r.now().Before(token.ExpiresAt) {
+		return Download{}, ErrorTokenNotFound
+	}
+
+	token.Downloads++
+	download := Download{
+		Path:      token.path,
+		Remaining: token.MaxDownloads - token.Downloads,
+	}
+
+	if download.Remaining <= 0 {
+		download.Last = !r.referencedLocked(token.path, id)
+		delete(r.tokens, id)
+	}
+
+	return download, nil
+}
+
+// Revoke removes a token without deleting its file.
+func (r *TokenRegistry) Revoke(id string) {
+	r.mu.Lock()
+	delete(r.tokens, id)
+	r.mu.Unlock()
+}
+
+// Expire removes every expired token. It returns the number of expired tokens
+// and the paths of their files that are no longer referenced by a live token.
+func (r *TokenRegistry) Expire() (int, []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	expired := 0
+	paths := make(map[string]bool)
+	for id, token := range r.tokens {
+		if now.Before(token.ExpiresAt) {
+			continue
+		}
+		expired++
+		paths[token.path] = true
+		delete(r.tokens, id)
+	}
+
+	var unreferenced []string
+	for path := range paths {
+		if !r.referencedLocked(path, "") {
+			unreferenced = append(unreferenced, path)
+		}
+	}
+
+	return expired, unreferenced
+}
+
+// Referenced reports whether a live token points to the path.
+func (r *TokenRegistry) Referenced(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.referencedLocked(filepath.Clean(path), "")
+}
+
+// referencedLocked reports whether a token other than except points to the path. r.mu must be held.
+func (r *TokenRegistry) referencedLocked(path string, except string) bool {
+	now := r.now()
+	for id, token := range r.tokens {
+		if id != except && token.path == path && now.Before(token.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,399 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Orientation values of the EXIF Orientation tag.
+const (
+	OrientationNormal         = 1
+	OrientationFlipHorizontal = 2
+	OrientationRotate180      = 3
+	OrientationFlipVertical   = 4
+	OrientationTranspose      = 5
+	OrientationRotate90       = 6
+	OrientationTransverse     = 7
+	OrientationRotate270      = 8
+)
+
+const (
+	// maxIFDEntries guards against corrupt entry counts.
+	maxIFDEntries = 1024
+	// maxBlobSize is the largest UNDEFINED value kept, larger blobs such as thumbnails are dropped.
+	maxBlobSize = 256
+)
+
+var (
+	ErrorNoExif      = errors.New("no exif data found")
+	ErrorInvalidExif = errors.New("invalid exif data")
+)
+
+var (
+	jpegExifHeader = []byte("Exif\x00\x00")
+	pngSignature   = []byte("\x89PNG\r\n\x1a\n")
+	tiffLittle     = []byte("II*\x00")
+	tiffBig        = []byte("MM\x00*")
+)
+
+// Exif holds the parsed tags of the main image, grouped by the IFD they were found in.
+// Tags are keyed by name, or by their hex ID when the tag is unknown.
+type Exif struct {
+	Orientation int            `json:"orientation"`
+	IFD0        map[string]any `json:"ifd0,omitempty"`
+	Exif        map[string]any `json:"exif,omitempty"`
+	GPS         map[string]any `json:"gps,omitempty"`
+}
+
+// Location is a GPS position in decimal degrees.
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Decode reads EXIF data from a JPEG, PNG, WebP or TIFF file.
+func Decode(r io.Reader) (*Exif, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read image: %v", err)
+	}
+
+	tiff, err := findTIFF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTIFF(tiff)
+}
+
+// ReadOrientation returns the orientation of an image, or OrientationNormal
+// when the image has no EXIF data or the tag is missing.
+func ReadOrientation(r io.Reader) int {
+	e, err := Decode(r)
+	if err != nil || e.Orientation < OrientationNormal || e.Orientation > OrientationRotate270 {
+		return OrientationNormal
+	}
+	return e.Orientation
+}
+
+// Location returns the GPS position stored in the image, if any.
+func (e *Exif) Location() (Location, bool) {
+	latitude, ok := degrees(e.GPS["GPSLatitude"], e.GPS["GPSLatitudeRef"], "S")
+	if !ok {
+		return Location{}, false
+	}
+	longitude, ok := degrees(e.GPS["GPSLongitude"], e.GPS["GPSLongitudeRef"], "W")
+	if !ok {
+		return Location{}, false
+	}
+	return Location{Latitude: latitude, Longitude: longitude}, true
+}
+
+// degrees converts a degrees, minutes, seconds triple to decimal degrees,
+// negated when the reference matches negative.
+func degrees(value, ref any, negative string) (float64, bool) {
+	parts, ok := value.([]any)
+	if !ok || len(parts) != 3 {
+		return 0, false
+	}
+
+	var dms [3]float64
+	for i, part := range parts {
+		f, ok := part.(float64)
+		if !ok {
+			return 0, false
+		}
+		dms[i] = f
+	}
+
+	result := dms[0] + dms[1]/60 + dms[2]/3600
+	if ref == negative {
+		result = -result
+	}
+	return result, true
+}
+
+// findTIFF locates the TIFF structure holding the EXIF data inside an image container.
+func findTIFF(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return findJPEG(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return findPNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return findWebP(data)
+	case bytes.HasPrefix(data, tiffLittle), bytes.HasPrefix(data, tiffBig):
+		return data, nil
+	default:
+		return nil, ErrorNoExif
+	}
+}
+
+// findJPEG returns the payload of the APP1 Exif segment.
+func findJPEG(data []byte) ([]byte, error) {
+	for offset := 2; offset+4 <= len(data); {
+		if data[offset] != 0xFF {
+			return nil, ErrorInvalidExif
+		}
+
+		marker := data[offset+1]
+		// Stop at the start of scan, the metadata segments come before the image data
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrorInvalidExif
+		}
+
+		segment := data[offset+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, jpegExifHeader) {
+			return segment[len(jpegExifHeader):], nil
+		}
+		offset = end
+	}
+
+	return nil, ErrorNoExif
+}
+
+// findPNG returns the payload of the eXIf chunk.
+func findPNG(data []byte) ([]byte, error) {
+	for offset := len(pngSignature); offset+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		chunkType := string(data[offset+4 : offset+8])
+		end := offset + 8 + length + 4 // Including the CRC
+		if length < 0 || end > len(data) {
+			return nil, ErrorInvalidExif
+		}
+
+		switch chunkType {
+		case "eXIf":
+			return data[offset+8 : offset+8+length], nil
+		case "IEND":
+			return nil, ErrorNoExif
+		}
+		offset = end
+	}
+
+	return nil, ErrorNoExif
+}
+
+// findWebP returns the payload of the EXIF chunk.
+func findWebP(data []byte) ([]byte, error) {
+	for offset := 12; offset+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[offset+4:]))
+		end := offset + 8 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrorInvalidExif
+		}
+
+		if string(data[offset:offset+4]) == "EXIF" {
+			// Some writers keep the JPEG style header in front of the TIFF data
+			return bytes.TrimPrefix(data[offset+8:end], jpegExifHeader), nil
+		}
+
+		// Chunks are padded to an even size
+		offset = end + length%2
+	}
+
+	return nil, ErrorNoExif
+}
+
+// reader decodes values from a TIFF structure in its byte order.
+type reader struct {
+	data  []byte
+	order binary.ByteOrder
+	seen  map[uint32]bool
+}
+
+// parseTIFF parses IFD0 and the Exif and GPS IFDs it points to.
+func parseTIFF(data []byte) (*Exif, error) {
+	if len(data) < 8 {
+		return nil, ErrorInvalidExif
+	}
+
+	r := &reader{data: data, seen: make(map[uint32]bool)}
+	switch {
+	case bytes.HasPrefix(data, tiffLittle):
+		r.order = binary.LittleEndian
+	case bytes.HasPrefix(data, tiffBig):
+		r.order = binary.BigEndian
+	default:
+		return nil, ErrorInvalidExif
+	}
+
+	e := &Exif{Orientation: OrientationNormal}
+
+	ifd0, pointers, err := r.readIFD(r.order.Uint32(data[4:]), ifd0Tags)
+	if err != nil {
+		return nil, err
+	}
+	e.IFD0 = ifd0
+
+	if orientation, ok := ifd0["Orientation"].(uint32); ok {
+		e.Orientation = int(orientation)
+	}
+
+	// Sub-IFDs are optional, a corrupt one does not invalidate the rest
+	if offset, ok := pointers[tagExifIFD]; ok {
+		e.Exif, _, _ = r.readIFD(offset, exifTags)
+	}
+	if offset, ok := pointers[tagGPSIFD]; ok {
+		e.GPS, _, _ = r.readIFD(offset, gpsTags)
+	}
+
+	return e, nil
+}
+
+// readIFD reads the entries of the IFD at offset. Pointer tags to other IFDs are
+// returned separately instead of as values.
+func (r *reader) readIFD(offset uint32, names map[uint16]string) (map[string]any, map[uint16]uint32, error) {
+	if r.seen[offset] {
+		return nil, nil, ErrorInvalidExif
+	}
+	r.seen[offset] = true
+
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return nil, nil, ErrorInvalidExif
+	}
+
+	count := int(r.order.Uint16(r.data[offset:]))
+	if count > maxIFDEntries || int(offset)+2+count*12 > len(r.data) {
+		return nil, nil, ErrorInvalidExif
+	}
+
+	tags := make(map[string]any)
+	pointers := make(map[uint16]uint32)
+	for i := 0; i < count; i++ {
+		entry := r.data[int(offset)+2+i*12:]
+		tag := r.order.Uint16(entry)
+
+		value, ok := r.readValue(entry)
+		if !ok {
+			continue
+		}
+
+		if tag == tagExifIFD || tag == tagGPSIFD || tag == tagInteropIFD {
+			if pointer, ok := value.(uint32); ok {
+				pointers[tag] = pointer
+			}
+			continue
+		}
+		if tag == tagMakerNote {
+			continue
+		}
+
+		name, ok := names[tag]
+		if !ok {
+			name = fmt.Sprintf("0x%04X", tag)
+		}
+		tags[name] = value
+	}
+
+	return tags, pointers, nil
+}
+
+// typeSizes maps TIFF field types to the size of a single value.
+var typeSizes = map[uint16]int{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	6:  1, // SBYTE
+	7:  1, // UNDEFINED
+	8:  2, // SSHORT
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+// readValue decodes the value of a 12 byte IFD entry. Single values are returned
+// as is, multiple values as a slice. Integers are returned as uint32 or int32 and
+// rationals as float64.
+func (r *reader) readValue(entry []byte) (any, bool) {
+	fieldType := r.order.Uint16(entry[2:])
+	count := r.order.Uint32(entry[4:])
+
+	size, ok := typeSizes[fieldType]
+	if !ok || count == 0 {
+		return nil, false
+	}
+
+	total := uint64(size) * uint64(count)
+	raw := entry[8:12]
+	if total > 4 {
+		// Values that do not fit into the entry are stored at an offset
+		offset := uint64(r.order.Uint32(entry[8:]))
+		if offset+total > uint64(len(r.data)) {
+			return nil, false
+		}
+		raw = r.data[offset : offset+total]
+	}
+	raw = raw[:total]
+
+	switch fieldType {
+	case 2:
+		return strings.TrimRight(string(raw), "\x00 "), true
+	case 7:
+		if total > maxBlobSize {
+			return nil, false
+		}
+		if printable(raw) {
+			return strings.TrimRight(string(raw), "\x00 "), true
+		}
+		return raw, true
+	}
+
+	values := make([]any, count)
+	for i := range values {
+		p := raw[i*size:]
+		switch fieldType {
+		case 1:
+			values[i] = uint32(p[0])
+		case 3:
+			values[i] = uint32(r.order.Uint16(p))
+		case 4:
+			values[i] = r.order.Uint32(p)
+		case 5:
+			values[i] = rational(float64(r.order.Uint32(p)), float64(r.order.Uint32(p[4:])))
+		case 6:
+			values[i] = int32(int8(p[0]))
+		case 8:
+			values[i] = int32(int16(r.order.Uint16(p)))
+		case 9:
+			values[i] = int32(r.order.Uint32(p))
+		case 10:
+			values[i] = rational(float64(int32(r.order.Uint32(p))), float64(int32(r.order.Uint32(p[4:]))))
+		}
+	}
+
+	if len(values) == 1 {
+		return values[0], true
+	}
+	return values, true
+}
+
+// rational divides a rational value, treating a zero denominator as zero.
+func rational(numerator, denominator float64) float64 {
+	if denominator == 0 {
+		return 0
+	}
+	return math.Round(numerator/denominator*1e6) / 1e6
+}
+
+// printable reports whether the bytes are printable ASCII, ignoring trailing NULs.
+func printable(data []byte) bool {
+	for _, b := range bytes.TrimRight(data, "\x00") {
+		if b < 0x20 || b > 0x7E {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testEntry is an IFD entry used to build test TIFF data.
+// Entries with a Pointer are filled with the offset of the IFD at that index.
+type testEntry struct {
+	Tag     uint16
+	Type    uint16
+	Count   uint32
+	Data    []byte
+	Pointer int
+}
+
+// buildTIFF lays out the IFDs one after another, each followed by the values that do not fit into its entries.
+func buildTIFF(order binary.ByteOrder, ifds ...[]testEntry) []byte {
+	offsets := make([]uint32, len(ifds))
+	offset := uint32(8)
+	for i, entries := range ifds {
+		offsets[i] = offset
+		offset += uint32(2 + 12*len(entries) + 4)
+		for _, entry := range entries {
+			if len(entry.Data) > 4 {
+				offset += uint32(len(entry.Data))
+			}
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if order == binary.LittleEndian {
+		buf.Write(tiffLittle)
+	} else {
+		buf.Write(tiffBig)
+	}
+	binary.Write(buf, order, uint32(8))
+
+	for i, entries := range ifds {
+		binary.Write(buf, order, uint16(len(entries)))
+
+		external := new(bytes.Buffer)
+		valuesOffset := offsets[i] + uint32(2+12*len(entries)+4)
+		for _, entry := range entries {
+			binary.Write(buf, order, entry.Tag)
+			binary.Write(buf, order, entry.Type)
+			binary.Write(buf, order, entry.Count)
+
+			value := make([]byte, 4)
+			switch {
+			case entry.Pointer > 0:
+				order.PutUint32(value, offsets[entry.Pointer])
+			case len(entry.Data) > 4:
+				order.PutUint32(value, valuesOffset+uint32(external.Len()))
+				external.Write(entry.Data)
+			default:
+				copy(value, entry.Data)
+			}
+			buf.Write(value)
+		}
+
+		binary.Write(buf, order, uint32(0)) // No next IFD
+		buf.Write(external.Bytes())
+	}
+
+	return buf.Bytes()
+}
+
+func shortValue(order binary.ByteOrder, v uint16) []byte {
+	data := make([]byte, 2)
+	order.PutUint16(data, v)
+	return data
+}
+
+func rationalValues(order binary.ByteOrder, values ...uint32) []byte {
+	data := make([]byte, 4*len(values))
+	for i, v := range values {
+		order.PutUint32(data[i*4:], v)
+	}
+	return data
+}
+
+// testTIFF builds EXIF data with an orientation, camera make, f-number and GPS position.
+func testTIFF(order binary.ByteOrder) []byte {
+	return buildTIFF(order,
+		[]testEntry{
+			{Tag: 0x010F, Type: 2, Count: 6, Data: []byte("Canon\x00")},
+			{Tag: 0x0112, Type: 3, Count: 1, Data: shortValue(order, 6)},
+			{Tag: 0xC000, Type: 3, Count: 1, Data: shortValue(order, 7)},
+			{Tag: tagExifIFD, Type: 4, Count: 1, Pointer: 1},
+			{Tag: tagGPSIFD, Type: 4, Count: 1, Pointer: 2},
+		},
+		[]testEntry{
+			{Tag: 0x829D, Type: 5, Count: 1, Data: rationalValues(order, 28, 10)},
+			{Tag: 0x9000, Type: 7, Count: 4, Data: []byte("0232")},
+			{Tag: tagMakerNote, Type: 7, Count: 8, Data: []byte("private!")},
+		},
+		[]testEntry{
+			{Tag: 0x0001, Type: 2, Count: 2, Data: []byte("N\x00")},
+			{Tag: 0x0002, Type: 5, Count: 3, Data: rationalValues(order, 51, 1, 30, 1, 0, 1)},
+			{Tag: 0x0003, Type: 2, Count: 2, Data: []byte("W\x00")},
+			{Tag: 0x0004, Type: 5, Count: 3, Data: rationalValues(order, 0, 1, 7, 1, 3960, 100)},
+		},
+	)
+}
+
+// jpegWithExif wraps the TIFF data into an APP1 segment of a minimal JPEG stream.
+func jpegWithExif(tiff []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write([]byte{0xFF, 0xD8})
+
+	// An unrelated APP0 segment before the EXIF data
+	buf.Write([]byte{0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00})
+
+	payload := append(append([]byte{}, jpegExifHeader...), tiff...)
+	buf.Write([]byte{0xFF, 0xE1})
+	binary.Write(buf, binary.BigEndian, uint16(len(payload)+2))
+	buf.Write(payload)
+
+	buf.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func TestDecodeJPEG(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		e, err := Decode(bytes.NewReader(jpegWithExif(testTIFF(order))))
+		assert.NoError(t, err, "failed to decode exif")
+
+		assert.Equal(t, OrientationRotate90, e.Orientation)
+		assert.Equal(t, "Canon", e.IFD0["Make"])
+		assert.Equal(t, uint32(7), e.IFD0["0xC000"], "unknown tags are keyed by id")
+		assert.Equal(t, 2.8, e.Exif["FNumber"])
+		assert.Equal(t, "0232", e.Exif["ExifVersion"])
+		assert.NotContains(t, e.Exif, "MakerNote")
+
+		location, ok := e.Location()
+		assert.True(t, ok, "location should be available")
+		assert.InDelta(t, 51.5, location.Latitude, 1e-6)
+		assert.InDelta(t, -0.127667, location.Longitude, 1e-6)
+	}
+}
+
+func TestDecodeContainers(t *testing.T) {
+	tiff := testTIFF(binary.LittleEndian)
+
+	png := new(bytes.Buffer)
+	png.Write(pngSignature)
+	for _, chunk := range []struct {
+		Type string
+		Data []byte
+	}{{"IHDR", make([]byte, 13)}, {"eXIf", tiff}, {"IEND", nil}} {
+		binary.Write(png, binary.BigEndian, uint32(len(chunk.Data)))
+		png.WriteString(chunk.Type)
+		png.Write(chunk.Data)
+		png.Write(make([]byte, 4)) // CRC is not checked
+	}
+
+	webp := new(bytes.Buffer)
+	webp.WriteString("RIFF")
+	binary.Write(webp, binary.LittleEndian, uint32(0))
+	webp.WriteString("WEBPVP8X")
+	binary.Write(webp, binary.LittleEndian, uint32(10))
+	webp.Write(make([]byte, 10))
+	webp.WriteString("EXIF")
+	binary.Write(webp, binary.LittleEndian, uint32(len(tiff)))
+	webp.Write(tiff)
+
+	for name, data := range map[string][]byte{"png": png.Bytes(), "webp": webp.Bytes(), "tiff": tiff} {
+		e, err := Decode(bytes.NewReader(data))
+		assert.NoError(t, err, name)
+		assert.Equal(t, OrientationRotate90, e.Orientation, name)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	_, err := Decode(bytes.NewReader([]byte("GIF89a")))
+	assert.ErrorIs(t, err, ErrorNoExif)
+
+	_, err = Decode(bytes.NewReader([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02}))
+	assert.ErrorIs(t, err, ErrorNoExif)
+
+	// IFD pointing outside the data
+	tiff := testTIFF(binary.LittleEndian)
+	binary.LittleEndian.PutUint32(tiff[4:], uint32(len(tiff)+10))
+	_, err = Decode(bytes.NewReader(tiff))
+	assert.ErrorIs(t, err, ErrorInvalidExif)
+
+	// IFD pointing to itself as its Exif IFD
+	looped := buildTIFF(binary.LittleEndian, []testEntry{{Tag: tagExifIFD, Type: 4, Count: 1, Pointer: 0}})
+	binary.LittleEndian.PutUint32(looped[8+2+8:], 8)
+	e, err := Decode(bytes.NewReader(looped))
+	assert.NoError(t, err)
+	assert.Nil(t, e.Exif)
+
+	assert.Equal(t, OrientationNormal, ReadOrientation(bytes.NewReader(nil)))
+}
+
+func TestExifJSON(t *testing.T) {
+	e, err := Decode(bytes.NewReader(testTIFF(binary.LittleEndian)))
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(e)
+	assert.NoError(t, err)
+
+	var decoded map[string]any
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, float64(6), decoded["orientation"])
+	assert.Equal(t, "N", decoded["gps"].(map[string]any)["GPSLatitudeRef"])
+}
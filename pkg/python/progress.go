@@ -0,0 +1,131 @@
+package python
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Progress describes a single progress update parsed from a tool's output.
+type Progress struct {
+	Stage   string  `json:"stage"`            // Current stage of the tool, e.g. "Downloading model"
+	Percent float64 `json:"percent"`          // Completion of the current stage, 0-100
+	Frame   int     `json:"frame,omitempty"`  // Current frame (or item) being processed
+	Frames  int     `json:"frames,omitempty"` // Total number of frames (or items)
+}
+
+var (
+	// Matches a percentage such as "45%" or "45.5%"
+	percentPattern = regexp.MustCompile(`(\d{1,3}(?:\.\d+)?)\s*%`)
+	// Matches a counter such as "12/100" or "frame 12 of 100"
+	framePattern = regexp.MustCompile(`(\d+)\s*(?:/|of)\s*(\d+)`)
+)
+
+// ProgressWriter is an io.Writer that parses a tool's output into progress events.
+// Lines are split on both '\n' and '\r' so that progress bars that redraw
+// themselves in place (tqdm and friends) produce one event per redraw.
+type ProgressWriter struct {
+	OnProgress func(Progress)
+
+	mu       sync.Mutex
+	buffer   []byte
+	progress Progress
+}
+
+// NewProgressWriter creates a ProgressWriter that calls onProgress for every parsed update.
+func NewProgressWriter(onProgress func(Progress)) *ProgressWriter {
+	return &ProgressWriter{
+		OnProgress: onProgress,
+	}
+}
+
+func (w *ProgressWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buffer = append(w.buffer, p...)
+
+	for {
+		i := strings.IndexAny(string(w.buffer), "\r\n")
+		if i < 0 {
+			break
+		}
+
+		line := string(w.buffer[:i])
+		w.buffer = w.buffer[i+1:]
+		w.handleLine(line)
+	}
+
+	return len(p), nil
+}
+
+// Flush parses any remaining buffered output that was not terminated by a newline.
+func (w *ProgressWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buffer) > 0 {
+		w.handleLine(string(w.buffer))
+		w.buffer = nil
+	}
+}
+
+// handleLine parses a line and emits an event if it changed the progress.
+func (w *ProgressWriter) handleLine(line string) {
+	progress, ok := ParseProgressLine(line, w.progress)
+	if !ok {
+		return
+	}
+
+	w.progress = progress
+	if w.OnProgress != nil {
+		w.OnProgress(progress)
+	}
+}
+
+// ParseProgressLine parses a single line of output, using previous as the current state.
+// Lines containing a percentage or a frame counter update the progress of the current stage,
+// any other non-empty line starts a new stage. It returns false if the line carried no information.
+func ParseProgressLine(line string, previous Progress) (Progress, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return previous, false
+	}
+
+	progress := previous
+	matched := false
+
+	if m := percentPattern.FindStringSubmatchIndex(line); m != nil {
+		percent, err := strconv.ParseFloat(line[m[2]:m[3]], 64)
+		if err == nil && percent <= 100 {
+			progress.Percent = percent
+			matched = true
+
+			// tqdm prefixes the bar with its description, e.g. "Processing:  45%|###"
+			if desc := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line[:m[0]]), ":")); desc != "" {
+				progress.Stage = desc
+			}
+		}
+	}
+
+	if m := framePattern.FindStringSubmatch(line); m != nil {
+		frame, errFrame := strconv.Atoi(m[1])
+		frames, errFrames := strconv.Atoi(m[2])
+		if errFrame == nil && errFrames == nil && frames > 0 && frame <= frames {
+			progress.Frame = frame
+			progress.Frames = frames
+			if !matched {
+				progress.Percent = float64(frame) / float64(frames) * 100
+			}
+			matched = true
+		}
+	}
+
+	if !matched {
+		// A plain line of output marks the start of a new stage
+		return Progress{Stage: line}, true
+	}
+
+	return progress, true
+}
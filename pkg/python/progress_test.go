@@ -0,0 +1,74 @@
+package python
+
+import (
+	"testing"
+)
+
+func TestParseProgressLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		previous Progress
+		expected Progress
+		ok       bool
+	}{
+		{
+			line:     "Downloading model",
+			expected: Progress{Stage: "Downloading model"},
+			ok:       true,
+		},
+		{
+			line:     "Processing:  45%|####5     | 45/100 [00:01<00:02, 30.00it/s]",
+			expected: Progress{Stage: "Processing", Percent: 45, Frame: 45, Frames: 100},
+			ok:       true,
+		},
+		{
+			line:     " 12.5%|#         |",
+			previous: Progress{Stage: "Downloading model"},
+			expected: Progress{Stage: "Downloading model", Percent: 12.5},
+			ok:       true,
+		},
+		{
+			line:     "frame 3 of 4",
+			previous: Progress{Stage: "Removing background"},
+			expected: Progress{Stage: "Removing background", Percent: 75, Frame: 3, Frames: 4},
+			ok:       true,
+		},
+		{
+			line:     "   ",
+			previous: Progress{Stage: "Removing background", Percent: 10},
+			expected: Progress{Stage: "Removing background", Percent: 10},
+			ok:       false,
+		},
+	}
+
+	for _, test := range tests {
+		progress, ok := ParseProgressLine(test.line, test.previous)
+		if ok != test.ok {
+			t.Errorf("%q: expected ok %v, got %v", test.line, test.ok, ok)
+		}
+		if progress != test.expected {
+			t.Errorf("%q: expected %+v, got %+v", test.line, test.expected, progress)
+		}
+	}
+}
+
+func TestProgressWriter(t *testing.T) {
+	var events []Progress
+	writer := NewProgressWriter(func(p Progress) {
+		events = append(events, p)
+	})
+
+	// Progress bars redraw with '\r' and may arrive split across writes
+	writer.Write([]byte("Loading model\n 10%|#"))
+	writer.Write([]byte("  | 1/10\r 50%|#####  | 5/10\r100%|##########| 10/10"))
+	writer.Flush()
+
+	if len(events) != 4 {
+		t.Fatalf("expected 4 events, got %d: %+v", len(events), events)
+	}
+
+	last := events[len(events)-1]
+	if last.Stage != "Loading model" || last.Percent != 100 || last.Frame != 10 || last.Frames != 10 {
+		t.Fatalf("unexpected final progress: %+v", last)
+	}
+}
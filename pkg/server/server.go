@@ -4,6 +4,7 @@ import (
 	"net"
 	"net/http"
 	"rory-pearson/pkg/log"
+	"strings"
 	"sync"
 
 	"github.com/gin-contrib/cors"
@@ -11,10 +12,25 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// DefaultAllowedOrigins are the origins of the UI dev server, which calls the API from another port.
+var DefaultAllowedOrigins = []string{"http://localhost:8080", "http://127.0.0.1:8080"}
+
+// ParseOrigins splits a comma separated list of origins, e.g. from the environment, skipping empty entries.
+func ParseOrigins(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
 // Config holds the configuration options for the server.
 type Config struct {
-	Port string  // The port on which the server will listen.
-	Log  log.Log // Logger instance for logging server activities.
+	Port           string   // The port on which the server will listen.
+	Log            log.Log  // Logger instance for logging server activities.
+	AllowedOrigins []string // Origins allowed to make credentialed cross-origin requests, defaults to DefaultAllowedOrigins.
 }
 
 // Server represents the HTTP server and its configuration.
@@ -26,13 +42,24 @@ type Server struct {
 
 // New initializes a new server instance with the provided configuration.
 func New(cfg Config) (*Server, error) {
+	// Session cookies are sent cross-origin, so origins have to be listed rather than allowing all
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOrigins = cfg.AllowedOrigins
+	if len(corsConfig.AllowOrigins) == 0 {
+		corsConfig.AllowOrigins = DefaultAllowedOrigins
+	}
+	corsConfig.AllowCredentials = true
+	if err := corsConfig.Validate(); err != nil {
+		return nil, err
+	}
+
 	e := gin.Default()
 
 	// Middleware for the server
-	e.Use(gin.Recovery())    // Recover from panics and log the error.
-	e.Use(gin.Logger())      // Logger middleware to log HTTP requests.
-	e.Use(gin.ErrorLogger()) // Logger middleware for errors.
-	e.Use(cors.Default())    // Enable CORS with default settings.
+	e.Use(gin.Recovery())       // Recover from panics and log the error.
+	e.Use(gin.Logger())         // Logger middleware to log HTTP requests.
+	e.Use(gin.ErrorLogger())    // Logger middleware for errors.
+	e.Use(cors.New(corsConfig)) // Enable CORS for the allowed origins, with credentials.
 
 	return &Server{
 		Cfg:    cfg,
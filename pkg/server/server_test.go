@@ -3,6 +3,7 @@ package server
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -73,3 +74,72 @@ func TestServerStart(t *testing.T) {
 	// Stop the server gracefully if implemented
 	srv.Stop()
 }
+
+// TestServerCORS tests that the allowed origins may make credentialed requests.
+func TestServerCORS(t *testing.T) {
+	srv, err := New(Config{Port: "8080", Log: getLogger()})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	srv.HealthCheck()
+
+	request := func(method string, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://localhost:3000/health", nil)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+		recorder := httptest.NewRecorder()
+		srv.Engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// The dev server is allowed to send cookies, which needs the exact origin rather than *
+	for _, method := range []string{http.MethodGet, http.MethodOptions} {
+		recorder := request(method, "http://localhost:8080")
+		if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:8080" {
+			t.Fatalf("Expected %s to allow the dev server origin, got: %q", method, got)
+		}
+		if got := recorder.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Fatalf("Expected %s to allow credentials, got: %q", method, got)
+		}
+	}
+
+	// Requests from the server's own origin are not cross-origin
+	if recorder := request(http.MethodGet, "http://localhost:3000"); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected same origin request to succeed, got: %d", recorder.Code)
+	}
+
+	// Other origins are not allowed
+	if recorder := request(http.MethodGet, "http://example.com"); recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("Expected other origins to be rejected")
+	}
+
+	if _, err := New(Config{Log: getLogger(), AllowedOrigins: []string{"localhost:8080"}}); err == nil {
+		t.Fatalf("Expected an error for an origin without a scheme")
+	}
+
+	// Configured origins replace the dev server origins
+	srv, err = New(Config{Port: "8080", Log: getLogger(), AllowedOrigins: ParseOrigins("https://app.example.com")})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	srv.HealthCheck()
+	if got := request(http.MethodGet, "https://app.example.com").Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("Expected the configured origin to be allowed, got: %q", got)
+	}
+	if got := request(http.MethodGet, "http://localhost:8080").Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("Expected the dev server origin to be rejected, got: %q", got)
+	}
+}
+
+// TestParseOrigins tests splitting the comma separated origins of the environment.
+func TestParseOrigins(t *testing.T) {
+	origins := ParseOrigins(" https://a.example.com,, https://b.example.com ")
+	if len(origins) != 2 || origins[0] != "https://a.example.com" || origins[1] != "https://b.example.com" {
+		t.Fatalf("Expected two trimmed origins, got: %q", origins)
+	}
+	if origins := ParseOrigins(""); len(origins) != 0 {
+		t.Fatalf("Expected no origins for an empty value, got: %q", origins)
+	}
+}
@@ -0,0 +1,154 @@
+package util
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// storedExtensions are file types that are already compressed and are stored without deflating them again.
+var storedExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+	".webp": true,
+	".zip":  true,
+	".icns": true,
+}
+
+// ZipWriter streams files into a zip archive as they are written, without intermediate files.
+// Each file is written through the writer returned by Create, or with WriteFile.
+type ZipWriter struct {
+	zip      *zip.Writer
+	file     *os.File     // Set when writing to a file created by CreateZipFile
+	response *zipResponse // Set when streaming to an HTTP response
+}
+
+// NewZipWriter returns a ZipWriter streaming the archive into w.
+func NewZipWriter(w io.Writer) *ZipWriter {
+	return &ZipWriter{zip: zip.NewWriter(w)}
+}
+
+// CreateZipFile creates the file at path and returns a ZipWriter streaming into it.
+func CreateZipFile(path string) (*ZipWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not create output ZIP file: %v", err)
+	}
+
+	z := NewZipWriter(file)
+	z.file = file
+	return z, nil
+}
+
+// NewZipResponse returns a ZipWriter streaming the archive as an HTTP download.
+// The response has no content length, so it is sent with chunked transfer encoding and
+// flushed after every file. Headers are only set once the first byte is written,
+// so errors before that can still be answered normally.
+func NewZipResponse(w http.ResponseWriter, fileName string) *ZipWriter {
+	response := &zipResponse{
+		ResponseWriter: w,
+		fileName:       fileName,
+	}
+
+	z := NewZipWriter(response)
+	z.response = response
+	return z
+}
+
+// Create adds a file to the archive and returns a writer for its contents.
+// The writer is only valid until the next call to Create, WriteFile or Close.
+func (z *ZipWriter) Create(name string) (io.Writer, error) {
+	z.flush()
+
+	header := &zip.FileHeader{
+		Name:     filepath.ToSlash(name),
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	}
+	if storedExtensions[strings.ToLower(filepath.Ext(name))] {
+		header.Method = zip.Store
+	}
+
+	w, err := z.zip.CreateHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("could not create file header: %v", err)
+	}
+	return w, nil
+}
+
+// WriteFile adds a file to the archive whose contents are written by the write function.
+func (z *ZipWriter) WriteFile(name string, write func(w io.Writer) error) error {
+	w, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if err := write(w); err != nil {
+		return fmt.Errorf("could not write %s: %v", name, err)
+	}
+	return nil
+}
+
+// Close finishes the archive and closes the file it was written to, if any.
+func (z *ZipWriter) Close() error {
+	err := z.zip.Close()
+	if z.response != nil {
+		z.response.Flush()
+	}
+
+	if z.file != nil {
+		if closeErr := z.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Abort stops writing without finishing the archive and removes the partially written file, if any.
+func (z *ZipWriter) Abort() {
+	if z.file != nil {
+		z.file.Close()
+		os.Remove(z.file.Name())
+	}
+}
+
+// flush sends everything written so far to the client when streaming to an HTTP response.
+func (z *ZipWriter) flush() {
+	if z.response == nil {
+		return
+	}
+
+	z.zip.Flush()
+	z.response.Flush()
+}
+
+// zipResponse sets the download headers on the first write and flushes after every file.
+type zipResponse struct {
+	http.ResponseWriter
+	fileName string
+	started  bool
+}
+
+func (r *zipResponse) Write(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		r.Header().Set("Content-Type", "application/zip")
+		r.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", r.fileName))
+		r.WriteHeader(http.StatusOK)
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush pushes the written data to the client as a chunk.
+func (r *zipResponse) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok && r.started {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,241 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFiles returns file names and contents, half of them compressible text and half random bytes.
+func testFiles(count, size int) map[string][]byte {
+	random := rand.New(rand.NewSource(1))
+
+	files := make(map[string][]byte, count)
+	for i := 0; i < count; i++ {
+		data := make([]byte, size)
+		if i%2 == 0 {
+			random.Read(data)
+			files[fmt.Sprintf("image_%d.png", i)] = data
+		} else {
+			copy(data, bytes.Repeat([]byte("favicon "), size/8))
+			files[fmt.Sprintf("dir/file_%d.txt", i)] = data
+		}
+	}
+	return files
+}
+
+// writeTestFiles writes every file into the zip writer.
+func writeTestFiles(z *ZipWriter, files map[string][]byte) error {
+	for name, data := range files {
+		if err := z.WriteFile(name, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assertZipContents checks that the archive holds exactly the files.
+func assertZipContents(t *testing.T, data []byte, files map[string][]byte) {
+	t.Helper()
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if !assert.NoError(t, err, "archive should be readable") {
+		return
+	}
+	assert.Len(t, archive.File, len(files))
+
+	for _, f := range archive.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		contents, err := io.ReadAll(r)
+		r.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, files[f.Name], contents, f.Name)
+
+		// Already compressed formats are stored as is
+		if filepath.Ext(f.Name) == ".png" {
+			assert.Equal(t, zip.Store, f.Method, f.Name)
+		} else {
+			assert.Equal(t, zip.Deflate, f.Method, f.Name)
+		}
+	}
+}
+
+func TestZipWriterFile(t *testing.T) {
+	files := testFiles(4, 1024)
+	path := filepath.Join(t.TempDir(), "archive.zip")
+
+	z, err := CreateZipFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, writeTestFiles(z, files))
+	assert.NoError(t, z.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assertZipContents(t, data, files)
+}
+
+func TestZipWriterAbort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.zip")
+
+	z, err := CreateZipFile(path)
+	assert.NoError(t, err)
+	err = z.WriteFile("broken.png", func(w io.Writer) error { return errors.New("encoder failed") })
+	assert.ErrorContains(t, err, "broken.png")
+
+	z.Abort()
+	assert.NoFileExists(t, path, "partial archive should be removed")
+}
+
+func TestZipResponse(t *testing.T) {
+	files := testFiles(6, 64*1024)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		z := NewZipResponse(w, "icons.zip")
+		assert.NoError(t, writeTestFiles(z, files))
+		assert.NoError(t, z.Close())
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="icons.zip"`, resp.Header.Get("Content-Disposition"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding, "response should be streamed")
+
+	data, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assertZipContents(t, data, files)
+}
+
+func TestZipResponseHeadersDeferred(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	// Nothing is written to the response until the archive sends data
+	NewZipResponse(recorder, "icons.zip")
+	assert.Empty(t, recorder.Header().Get("Content-Type"))
+	assert.False(t, recorder.Flushed)
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// discardResponse is an http.ResponseWriter that drops everything.
+type discardResponse struct {
+	countingWriter
+	header http.Header
+}
+
+func (r *discardResponse) Header() http.Header { return r.header }
+func (r *discardResponse) WriteHeader(int)     {}
+func (r *discardResponse) Flush()              {}
+
+const (
+	benchmarkFiles    = 16
+	benchmarkFileSize = 128 * 1024
+)
+
+// BenchmarkZipDirectory writes every file to disk, compresses the directory and deletes it,
+// the way archives were built before the streaming writer.
+func BenchmarkZipDirectory(b *testing.B) {
+	files := testFiles(benchmarkFiles, benchmarkFileSize)
+	dir := b.TempDir()
+
+	var diskBytes int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sourceDir := filepath.Join(dir, "source")
+		for name, data := range files {
+			path := filepath.Join(sourceDir, name)
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				b.Fatal(err)
+			}
+			diskBytes += int64(len(data))
+		}
+
+		if err := CompressDirectoryAndDelete(sourceDir, dir, "archive.zip"); err != nil {
+			b.Fatal(err)
+		}
+
+		info, _ := os.Stat(filepath.Join(dir, "archive.zip"))
+		diskBytes += info.Size()
+	}
+
+	// Every file is written, read back and the archive written
+	b.ReportMetric(float64(diskBytes)/float64(b.N), "disk-written-B/op")
+}
+
+// BenchmarkZipWriterFile streams every file straight into the archive on disk.
+func BenchmarkZipWriterFile(b *testing.B) {
+	files := testFiles(benchmarkFiles, benchmarkFileSize)
+	path := filepath.Join(b.TempDir(), "archive.zip")
+
+	var diskBytes int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z, err := CreateZipFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := writeTestFiles(z, files); err != nil {
+			b.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			b.Fatal(err)
+		}
+
+		info, _ := os.Stat(path)
+		diskBytes += info.Size()
+	}
+
+	b.ReportMetric(float64(diskBytes)/float64(b.N), "disk-written-B/op")
+}
+
+// BenchmarkZipWriterResponse streams every file straight into an HTTP response without touching the disk.
+func BenchmarkZipWriterResponse(b *testing.B) {
+	files := testFiles(benchmarkFiles, benchmarkFileSize)
+
+	var sent int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		response := &discardResponse{header: make(http.Header)}
+		z := NewZipResponse(response, "archive.zip")
+		if err := writeTestFiles(z, files); err != nil {
+			b.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			b.Fatal(err)
+		}
+		sent += response.n
+	}
+
+	b.ReportMetric(0, "disk-written-B/op")
+	b.ReportMetric(float64(sent)/float64(b.N), "sent-B/op")
+}
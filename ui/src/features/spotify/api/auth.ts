@@ -3,7 +3,6 @@ import { MutationConfig } from "../../../util/react-query";
 import { GetHost } from "../../../util/host";
 
 type LoginResponse = {
-  state: string;
   url: string;
 };
 
@@ -34,21 +33,15 @@ export const openSpotifyLogin = (url: string) => {
 
 async function authenticate(): Promise<Authenticate> {
   try {
-    const login = getLoginState();
+    // The session lives in an HttpOnly cookie, so the server decides whether it is still valid
+    const isValid = await validate();
 
-    if (login) {
-      const isValid = await validate(login.state);
-
-      if (isValid) {
-        const user = await profile();
-        return { isValid: isValid, login, user };
-      }
-
-      setLoginState(null);
+    if (isValid) {
+      const user = await profile();
+      return { isValid: isValid, login: { url: "" }, user };
     }
 
     const newLogin = await llllooooggggiiiinnn();
-    setLoginState(newLogin);
 
     console.log("newLogin", newLogin);
     return { isValid: false, login: newLogin };
@@ -63,6 +56,7 @@ async function llllooooggggiiiinnn(): Promise<LoginResponse> {
     const response = await fetch(`${GetHost()}/api/spotify/login`, {
       method: "GET",
       headers: { "Content-Type": "application/json" },
+      credentials: "include",
     });
 
     if (!response.ok) {
@@ -81,20 +75,16 @@ async function llllooooggggiiiinnn(): Promise<LoginResponse> {
   }
 }
 
-async function validate(state: string): Promise<boolean> {
+async function validate(): Promise<boolean> {
   try {
-    const response = await fetch(
-      `${GetHost()}/api/spotify/validate?state=${state}`,
-      {
-        method: "GET",
-        headers: { "Content-Type": "application/json" },
-      }
-    );
+    const response = await fetch(`${GetHost()}/api/spotify/validate`, {
+      method: "GET",
+      headers: { "Content-Type": "application/json" },
+      credentials: "include",
+    });
 
     if (!response.ok) {
-      console.warn(
-        `Validation failed for state ${state}: ${response.statusText}`
-      );
+      console.warn(`Session validation failed: ${response.statusText}`);
     }
 
     return response.ok;
@@ -106,13 +96,11 @@ async function validate(state: string): Promise<boolean> {
 
 async function profile(): Promise<SpotifyUserData> {
   try {
-    const response = await fetch(
-      `${GetHost()}/api/spotify/profile?state=${state}`,
-      {
-        method: "GET",
-        headers: { "Content-Type": "application/json" },
-      }
-    );
+    const response = await fetch(`${GetHost()}/api/spotify/profile`, {
+      method: "GET",
+      headers: { "Content-Type": "application/json" },
+      credentials: "include",
+    });
 
     if (!response.ok) {
       throw new Error(`Error getting Spotify profile: ${response.statusText}`);
@@ -129,35 +117,3 @@ async function profile(): Promise<SpotifyUserData> {
     throw error;
   }
 }
-
-// LOCAL STORAGE STUFF #################################################
-
-const localStorageKey = "spotify_login";
-let state: string;
-
-export function getLoginState(): LoginResponse | null {
-  const local = localStorage.getItem(localStorageKey);
-  if (!local || local === "null") {
-    localStorage.removeItem(localStorageKey);
-    return null;
-  }
-
-  const data = JSON.parse(local);
-
-  // Check none of the objects are null
-  if (data) {
-    Object.keys(data).forEach((key) => {
-      if (data[key] === null) {
-        return null;
-      }
-    });
-  }
-
-  state = data.state;
-
-  return data as LoginResponse;
-}
-
-function setLoginState(login: LoginResponse) {
-  localStorage.setItem(localStorageKey, JSON.stringify(login));
-}
@@ -1,3 +1,4 @@
+import * as React from "react";
 import { useMutation } from "react-query";
 import { MutationConfig } from "../../../util/react-query";
 import { GetHost } from "../../../util/host";
@@ -16,18 +17,68 @@ export const usePlayer = (config?: MutationConfig<typeof testPlayer>) => {
   });
 };
 
+type NowPlayingEvent = {
+  type: "snapshot" | "track" | "state" | "progress" | "error";
+  now_playing?: SpotifyNowPlayingData;
+  is_playing?: boolean;
+  progress_ms?: number;
+  error?: string;
+};
+
+// Follows the now playing changes pushed by the server instead of polling
+export const useNowPlayingStream = (enabled: boolean) => {
+  const [nowPlaying, setNowPlaying] =
+    React.useState<SpotifyNowPlayingData | null>(null);
+
+  React.useEffect(() => {
+    if (!enabled) return;
+
+    const source = new EventSource(
+      `${GetHost()}/api/spotify/now-playing/stream`,
+      { withCredentials: true }
+    );
+
+    const replace = (event: MessageEvent) => {
+      const data: NowPlayingEvent = JSON.parse(event.data);
+      setNowPlaying(data.now_playing ?? null);
+    };
+    const update = (event: MessageEvent) => {
+      const data: NowPlayingEvent = JSON.parse(event.data);
+      setNowPlaying((previous) =>
+        previous
+          ? {
+              ...previous,
+              is_playing: data.is_playing ?? previous.is_playing,
+              progress_ms: data.progress_ms ?? previous.progress_ms,
+            }
+          : previous
+      );
+    };
+
+    source.addEventListener("snapshot", replace);
+    source.addEventListener("track", replace);
+    source.addEventListener("state", update);
+    source.addEventListener("progress", update);
+    source.addEventListener("error", (event) => {
+      console.error("Error streaming Spotify now playing", event);
+    });
+
+    return () => source.close();
+  }, [enabled]);
+
+  return nowPlaying;
+};
+
 // APIS ###############################################################
 
 const testPlayer = async (
   auth: Authenticate
 ): Promise<SpotifyNowPlayingData> => {
-  const response = await fetch(
-    `${GetHost()}/api/spotify/now-playing?state=${auth.login.state}`,
-    {
-      method: "GET",
-      headers: { "Content-Type": "application/json" },
-    }
-  );
+  const response = await fetch(`${GetHost()}/api/spotify/now-playing`, {
+    method: "GET",
+    headers: { "Content-Type": "application/json" },
+    credentials: "include",
+  });
 
   if (!response.ok) {
     throw new Error(`Error getting Spotify playlists: ${response.statusText}`);
@@ -19,13 +19,11 @@ export const usePlaylists = (config?: MutationConfig<typeof playlists>) => {
 // APIS ###############################################################
 
 const playlists = async (auth: Authenticate): Promise<SpotifyPlaylistData> => {
-  const response = await fetch(
-    `${GetHost()}/api/spotify/playlists?state=${auth.login.state}`,
-    {
-      method: "GET",
-      headers: { "Content-Type": "application/json" },
-    }
-  );
+  const response = await fetch(`${GetHost()}/api/spotify/playlists`, {
+    method: "GET",
+    headers: { "Content-Type": "application/json" },
+    credentials: "include",
+  });
 
   if (!response.ok) {
     throw new Error(`Error getting Spotify playlists: ${response.statusText}`);
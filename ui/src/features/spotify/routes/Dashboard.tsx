@@ -2,14 +2,19 @@ import React, { useEffect, useState } from "react";
 import { ContentLayout } from "../../../components/Layout";
 import { Button, Spinner } from "@components/Elements";
 
-import { useAuth, openSpotifyLogin, usePlaylists, usePlayer } from "../api";
+import {
+  useAuth,
+  openSpotifyLogin,
+  usePlaylists,
+  useNowPlayingStream,
+} from "../api";
 import { Player } from "../components/Player";
 import clsx from "clsx";
 
 export const Dashboard = () => {
   const auth = useAuth();
   const playlists = usePlaylists();
-  const player = usePlayer();
+  const nowPlaying = useNowPlayingStream(!!auth.data?.isValid);
 
   useEffect(() => {
     connect();
@@ -20,7 +25,6 @@ export const Dashboard = () => {
     console.log(data);
     if (data && data.isValid) {
       await playlists.mutateAsync(data);
-      await player.mutateAsync(data);
     }
   };
 
@@ -47,7 +51,14 @@ export const Dashboard = () => {
           {playlists.data && <p>You have {playlists.data.total} playlist/s</p>}
 
           <div className="space-y-2">
-            {player.data && <Player fakeTime data={player.data} size="sm" />}
+            {nowPlaying && (
+              <Player
+                key={`${nowPlaying.item?.id}-${nowPlaying.is_playing}-${nowPlaying.progress_ms}`}
+                fakeTime={nowPlaying.is_playing}
+                data={nowPlaying}
+                size="sm"
+              />
+            )}
           </div>
         </div>
       )}